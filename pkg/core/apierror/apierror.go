@@ -4,7 +4,13 @@
 
 package apierror
 
-import restful "github.com/emicklei/go-restful"
+import (
+	"TFRP/pkg/core/consts"
+	"math"
+	"strconv"
+
+	restful "github.com/emicklei/go-restful"
+)
 
 // New creates an ErrorResponse
 func New(errorCategory ErrorCategory, errorCode ErrorCode, message string) *ErrorResponse {
@@ -17,6 +23,22 @@ func New(errorCategory ErrorCategory, errorCode ErrorCode, message string) *Erro
 	}
 }
 
+// NewWithDetails creates an ErrorResponse which carries one detail entry per offending target
+func NewWithDetails(errorCategory ErrorCategory, errorCode ErrorCode, message string, details []Error) *ErrorResponse {
+	errorResponse := New(errorCategory, errorCode, message)
+	errorResponse.Body.Details = details
+	return errorResponse
+}
+
+// NewDetail creates an Error used as a detail entry of an ErrorResponse
+func NewDetail(errorCode ErrorCode, target string, message string) Error {
+	return Error{
+		Code:    errorCode,
+		Target:  target,
+		Message: message,
+	}
+}
+
 // WriteErrorToResponse writes an ErrorResponse
 func WriteErrorToResponse(resp *restful.Response, httpStatus int, errorCategory ErrorCategory, errorCode ErrorCode, message string) {
 	err := New(errorCategory, errorCode, message)
@@ -27,5 +49,8 @@ func WriteErrorToResponse(resp *restful.Response, httpStatus int, errorCategory
 // WriteErrorToResponseWitAPIError writes an ErrorResponse
 func WriteErrorToResponseWitAPIError(resp *restful.Response, httpStatus int, errorResponse *ErrorResponse) {
 	resp.Header().Set(restful.HEADER_ContentType, restful.MIME_JSON)
+	if errorResponse.RetryAfter > 0 {
+		resp.Header().Set(consts.RetryAfterHeader, strconv.Itoa(int(math.Ceil(errorResponse.RetryAfter.Seconds()))))
+	}
 	resp.WriteError(httpStatus, errorResponse)
 }
@@ -66,6 +66,12 @@ const (
 	NodesNotFound                   ErrorCode = "NodesNotFound"
 	NodesNotReady                   ErrorCode = "NodesNotReady"
 	ControlPlaneProvisioningTimeout ErrorCode = "ControlPlaneProvisioningTimeout"
+	TooManyRequests                 ErrorCode = "TooManyRequests"
+	UpstreamTimeout                 ErrorCode = "UpstreamTimeout"
+	UpstreamServiceError            ErrorCode = "UpstreamServiceError"
+	OperationCanceled               ErrorCode = "OperationCanceled"
+	ProviderCredentialsInvalid      ErrorCode = "ProviderCredentialsInvalid"
+	TerraformImportFailed           ErrorCode = "TerraformImportFailed"
 
 	// Error codes returned by HCP
 	UnderlayNotFound         ErrorCode = "UnderlayNotFound"
@@ -4,7 +4,10 @@
 
 package apierror
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Error is the OData v4 format, used by the RPC and
 // will go into the v2.2 Azure REST API guidelines
@@ -23,6 +26,9 @@ type Error struct {
 // ErrorResponse  defines Resource Provider API 2.0 Error Response Content structure
 type ErrorResponse struct {
 	Body Error `json:"error"`
+
+	// RetryAfter is written as the Retry-After header when the caller should back off before retrying
+	RetryAfter time.Duration `json:"-"`
 }
 
 // Error implements error interface to return error in json
@@ -32,6 +32,19 @@ const (
 	UnderlaysLiteral             = "{un:(?i)underlays}"
 	DefaultLiteral               = "{up:(?i)default}"
 	ListSettingsLiteral          = "{li:(?i)listsettings}"
+	CancelLiteral                = "{ca:(?i)cancel}"
+	TestConnectionLiteral        = "{tc:(?i)testconnection}"
+	ExportTerraformLiteral       = "{et:(?i)exportterraform}"
+	ImportTerraformLiteral       = "{it:(?i)importterraform}"
+	ValidateMoveLiteral          = "{vm:(?i)validatemoveresources}"
+	MoveLiteral                  = "{mv:(?i)moveresources}"
+	DeletedResourcesLiteral      = "{dr:(?i)deletedresources}"
+	RestoreLiteral               = "{re:(?i)restore}"
+	ForceStateLiteral            = "{fs:(?i)forceprovisioningstate}"
+	ClearAcceptedLiteral         = "{cl:(?i)clearaccepted}"
+	RefreshLiteral               = "{rf:(?i)refresh}"
+	UpgradeStatesLiteral         = "{ug:(?i)upgradestates}"
+	ReEncryptSettingsLiteral     = "{rs:(?i)reencryptsettings}"
 )
 
 const (
@@ -45,12 +58,20 @@ const (
 	PathOperationStatusParameter = "operationId"
 	// PathProviderRegistrationParameter is the path parameter name used in routing for the provider registration
 	PathProviderRegistrationParameter = "providerRegistration"
+	// PathDeploymentNameParameter is the path parameter name used in routing for the template deployment name
+	PathDeploymentNameParameter = "deploymentName"
 	// RequestAPIVersionParameterName is the query string parameter name ARM adds for the api version
 	RequestAPIVersionParameterName = "api-version"
 	// TerraformRPNamespace is the ARM namespace for Terraform RP
 	TerraformRPNamespace = "Microsoft.TerraformOSS"
 	// TerraformResourceType is the resource type registerred in ARM manifest.
 	TerraformResourceType = "Microsoft.TerraformOSS/Resources"
+	// TerraformDeletedResourceType is the type of the soft deleted resources.
+	TerraformDeletedResourceType = "Microsoft.TerraformOSS/deletedResources"
+	// TerraformProviderRegistrationType is the provider registration type registerred in ARM manifest.
+	TerraformProviderRegistrationType = "Microsoft.TerraformOSS/providerRegistrations"
+	// TerraformRPDisplayName is the display name of Terraform RP in the operations list
+	TerraformRPDisplayName = "Terraform OSS"
 )
 
 // subscription and common routes.
@@ -64,9 +85,65 @@ const (
 		PathSubscriptionIDParameter + "}"
 )
 
+// provider routes.
+const (
+	// ProvidersURLPrefix is the base route prefix for the operations which are not scoped to a subscription.
+	ProvidersURLPrefix = "/" + ProvidersLiteral + "/" + TerraformRPNamespace
+
+	// OperationsRoute is the route used to perform GET on the operations of the resource provider
+	// /providers/Microsoft.TerraformOSS/operations
+	OperationsRoute = "/" + OperationsLiteral
+
+	// LocationsRoute is the route used to perform GET on the locations of the resource provider
+	// /{subscriptionId}/providers/Microsoft.TerraformOSS/locations
+	LocationsRoute = SubscriptionResourceOperationRoute + "/" + ProvidersLiteral + "/" + TerraformRPNamespace + "/" + LocationsLiteral
+)
+
+// admin routes, the ids of resources follow the admin prefix
+const (
+	// AdminURLPrefix is the base route prefix for the operator operations, which are not exposed to ARM
+	AdminURLPrefix = "/" + InternalLiteral + "/" + AdminLiteral
+
+	// AdminResourcesRoute is the route used to perform GET on the resources filtered by provisioning state or provider registration
+	// /internal/admin/resources
+	AdminResourcesRoute = "/" + ResourcesLiteral
+
+	// AdminResourceRoute is the route used to perform GET on the raw config and state of one resource
+	// /internal/admin/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.TerraformOSS/resources/{resourceName}
+	AdminResourceRoute = SubscriptionsURLPrefix + ResourceOperationRoute
+
+	// AdminForceProvisioningStateRoute is the route used to perform POST to force the provisioning state of one resource
+	// /internal/admin/subscriptions/{subscriptionId}/.../resources/{resourceName}/forceProvisioningState
+	AdminForceProvisioningStateRoute = AdminResourceRoute + "/" + ForceStateLiteral
+
+	// AdminClearAcceptedRoute is the route used to perform POST to fail the stuck Accepted operation of one resource and break its lock
+	// /internal/admin/subscriptions/{subscriptionId}/.../resources/{resourceName}/clearAccepted
+	AdminClearAcceptedRoute = AdminResourceRoute + "/" + ClearAcceptedLiteral
+
+	// AdminRefreshRoute is the route used to perform POST to refresh the state of one resource
+	// /internal/admin/subscriptions/{subscriptionId}/.../resources/{resourceName}/refresh
+	AdminRefreshRoute = AdminResourceRoute + "/" + RefreshLiteral
+
+	// AdminUpgradeStatesRoute is the route used to perform POST to migrate the stored states of all resources
+	// /internal/admin/upgradeStates
+	AdminUpgradeStatesRoute = "/" + UpgradeStatesLiteral
+
+	// AdminReEncryptSettingsRoute is the route used to perform POST to re-encrypt the settings of all provider registrations with the current key
+	// /internal/admin/reEncryptSettings
+	AdminReEncryptSettingsRoute = "/" + ReEncryptSettingsLiteral
+)
+
+// health routes
+const (
+	// HealthRoute is the route of the liveness probe
+	HealthRoute = "/healthz"
+	// ReadinessRoute is the route of the readiness probe
+	ReadinessRoute = "/readyz"
+)
+
 // resource operation routes
 const (
-	// ResourceOperationRoute is the route used to perform PUT/GET/DELETE on one resource
+	// ResourceOperationRoute is the route used to perform PUT/PATCH/GET/DELETE on one resource
 	// /{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.TerraformOSS/resources/{resourceName}
 	ResourceOperationRoute = SubscriptionResourceOperationRoute + "/" + ResourceGroupsLiteral + "/{" +
 		PathResourceGroupNameParameter +
@@ -87,12 +164,63 @@ const (
 		"}/" + ProvidersLiteral + "/" + TerraformRPNamespace + "/" + ProviderRegistrationsLiteral + "/{" +
 		PathProviderRegistrationParameter + "}" + "/" + ListSettingsLiteral
 
+	// ProviderRegistrationTestConnectionRoute is the route used to perform POST to test the connection of one provider registration
+	// /{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.TerraformOSS/providerregistrations/{providerRegistration}/testconnection
+	ProviderRegistrationTestConnectionRoute = ProviderRegistrationOperationRoute + "/" + TestConnectionLiteral
+
+	// ExportTerraformRoute is the route used to perform POST to export the resources of a resource group as terraform configuration and state
+	// /{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.TerraformOSS/exportTerraform
+	ExportTerraformRoute = SubscriptionResourceOperationRoute + "/" + ResourceGroupsLiteral + "/{" +
+		PathResourceGroupNameParameter +
+		"}/" + ProvidersLiteral + "/" + TerraformRPNamespace + "/" + ExportTerraformLiteral
+
+	// ImportTerraformRoute is the route used to perform POST to import the resources of a terraform state into a resource group
+	// /{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.TerraformOSS/importTerraform
+	ImportTerraformRoute = SubscriptionResourceOperationRoute + "/" + ResourceGroupsLiteral + "/{" +
+		PathResourceGroupNameParameter +
+		"}/" + ProvidersLiteral + "/" + TerraformRPNamespace + "/" + ImportTerraformLiteral
+
+	// PreflightRoute is the route used to perform POST to validate the resources of a template deployment
+	// /{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.TerraformOSS/deployments/{deploymentName}/preflight
+	PreflightRoute = SubscriptionResourceOperationRoute + "/" + ResourceGroupsLiteral + "/{" +
+		PathResourceGroupNameParameter +
+		"}/" + ProvidersLiteral + "/" + TerraformRPNamespace + "/" + DeploymentsLiteral + "/{" +
+		PathDeploymentNameParameter + "}/" + PreflightLiteral
+
+	// ValidateMoveResourcesRoute is the route used to perform POST to validate the move of resources to another resource group
+	// /{subscriptionId}/resourceGroups/{resourceGroupName}/validateMoveResources
+	ValidateMoveResourcesRoute = SubscriptionResourceOperationRoute + "/" + ResourceGroupsLiteral + "/{" +
+		PathResourceGroupNameParameter + "}/" + ValidateMoveLiteral
+
+	// MoveResourcesRoute is the route used to perform POST to move resources to another resource group
+	// /{subscriptionId}/resourceGroups/{resourceGroupName}/moveResources
+	MoveResourcesRoute = SubscriptionResourceOperationRoute + "/" + ResourceGroupsLiteral + "/{" +
+		PathResourceGroupNameParameter + "}/" + MoveLiteral
+
+	// DeletedResourcesRoute is the route used to perform GET on the soft deleted resources of a resource group
+	// /{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.TerraformOSS/deletedResources
+	DeletedResourcesRoute = SubscriptionResourceOperationRoute + "/" + ResourceGroupsLiteral + "/{" +
+		PathResourceGroupNameParameter +
+		"}/" + ProvidersLiteral + "/" + TerraformRPNamespace + "/" + DeletedResourcesLiteral
+
+	// DeletedResourceOperationRoute is the route used to perform GET/DELETE on one soft deleted resource
+	// /{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.TerraformOSS/deletedResources/{resourceName}
+	DeletedResourceOperationRoute = DeletedResourcesRoute + "/{" + PathResourceNameParameter + "}"
+
+	// DeletedResourceRestoreRoute is the route used to perform POST to restore one soft deleted resource
+	// /{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.TerraformOSS/deletedResources/{resourceName}/restore
+	DeletedResourceRestoreRoute = DeletedResourceOperationRoute + "/" + RestoreLiteral
+
 	// OperationStatusRoute is the route used to perform GET on an operation
 	// /{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.TerraformOSS/operationstatus/{opeartionId}
 	OperationStatusRoute = SubscriptionResourceOperationRoute + "/" + ResourceGroupsLiteral + "/{" +
 		PathResourceGroupNameParameter +
 		"}/" + ProvidersLiteral + "/" + TerraformRPNamespace + "/" + OperationStatusLiteral + "/{" +
 		PathOperationStatusParameter + "}"
+
+	// OperationCancelRoute is the route used to perform POST to cancel an in-flight operation
+	// /{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.TerraformOSS/operationstatus/{opeartionId}/cancel
+	OperationCancelRoute = OperationStatusRoute + "/" + CancelLiteral
 )
 
 const (
@@ -102,6 +230,26 @@ const (
 	PutResourceControllerName = "PutResourceController"
 	// DeleteResourceControllerName is the constant logged for delete resource calls
 	DeleteResourceControllerName = "DeleteResourceController"
+	// PatchResourceControllerName is the constant logged for patch resource calls
+	PatchResourceControllerName = "PatchResourceController"
+	// PostExportTerraformControllerName is the constant logged for export terraform calls
+	PostExportTerraformControllerName = "PostExportTerraformController"
+	// PostImportTerraformControllerName is the constant logged for import terraform calls
+	PostImportTerraformControllerName = "PostImportTerraformController"
+	// PostPreflightControllerName is the constant logged for template deployment preflight calls
+	PostPreflightControllerName = "PostPreflightController"
+	// PostValidateMoveResourcesControllerName is the constant logged for validate move resources calls
+	PostValidateMoveResourcesControllerName = "PostValidateMoveResourcesController"
+	// PostMoveResourcesControllerName is the constant logged for move resources calls
+	PostMoveResourcesControllerName = "PostMoveResourcesController"
+	// GetDeletedResourcesControllerName is the constant logged for list deleted resources calls
+	GetDeletedResourcesControllerName = "GetDeletedResourcesController"
+	// GetDeletedResourceControllerName is the constant logged for get deleted resource calls
+	GetDeletedResourceControllerName = "GetDeletedResourceController"
+	// PurgeDeletedResourceControllerName is the constant logged for purge deleted resource calls
+	PurgeDeletedResourceControllerName = "PurgeDeletedResourceController"
+	// PostRestoreDeletedResourceControllerName is the constant logged for restore deleted resource calls
+	PostRestoreDeletedResourceControllerName = "PostRestoreDeletedResourceController"
 
 	// GetProviderRegistrationControllerName is the constant logged for get provider registration calls
 	GetProviderRegistrationControllerName = "GetProviderRegistrationController"
@@ -112,6 +260,8 @@ const (
 
 	// PostProviderRegistrationListSettingsControllerName is the constant logged for post provider registration settings calls
 	PostProviderRegistrationListSettingsControllerName = "PostProviderRegistrationListSettingsController"
+	// PostProviderRegistrationTestConnectionControllerName is the constant logged for post provider registration test connection calls
+	PostProviderRegistrationTestConnectionControllerName = "PostProviderRegistrationTestConnectionController"
 
 	// GetSubscriptionControllerName is the constant logged for get subscription calls
 	GetSubscriptionControllerName = "GetSubscriptionController"
@@ -120,6 +270,33 @@ const (
 
 	// GetOperationStatusControllerName is the constant logged for get resource operation status calls
 	GetOperationStatusControllerName = "GetOperationStatus"
+	// CancelOperationControllerName is the constant logged for cancel resource operation calls
+	CancelOperationControllerName = "CancelOperation"
+
+	// GetOperationsControllerName is the constant logged for get operations calls
+	GetOperationsControllerName = "GetOperationsController"
+	// GetLocationsControllerName is the constant logged for get locations calls
+	GetLocationsControllerName = "GetLocationsController"
+
+	// GetAdminResourcesControllerName is the constant logged for admin list resources calls
+	GetAdminResourcesControllerName = "GetAdminResourcesController"
+	// GetAdminResourceControllerName is the constant logged for admin dump resource calls
+	GetAdminResourceControllerName = "GetAdminResourceController"
+	// PostAdminForceProvisioningStateControllerName is the constant logged for admin force provisioning state calls
+	PostAdminForceProvisioningStateControllerName = "PostAdminForceProvisioningStateController"
+	// PostAdminClearAcceptedControllerName is the constant logged for admin clear accepted calls
+	PostAdminClearAcceptedControllerName = "PostAdminClearAcceptedController"
+	// PostAdminRefreshControllerName is the constant logged for admin refresh calls
+	PostAdminRefreshControllerName = "PostAdminRefreshController"
+	// PostAdminUpgradeStatesControllerName is the constant logged for admin upgrade states calls
+	PostAdminUpgradeStatesControllerName = "PostAdminUpgradeStatesController"
+	// PostAdminReEncryptSettingsControllerName is the constant logged for admin re-encrypt settings calls
+	PostAdminReEncryptSettingsControllerName = "PostAdminReEncryptSettingsController"
+
+	// GetHealthControllerName is the constant logged for liveness probe calls
+	GetHealthControllerName = "GetHealthController"
+	// GetReadinessControllerName is the constant logged for readiness probe calls
+	GetReadinessControllerName = "GetReadinessController"
 )
 
 // Long running operation status constants
@@ -129,6 +306,7 @@ const (
 	ProvisioningStateDeleted   = "Deleted"
 	ProvisioningStateFailed    = "Failed"
 	ProvisioningStateSucceeded = "Succeeded"
+	ProvisioningStateCanceled  = "Canceled"
 )
 
 // Headers
@@ -153,6 +331,18 @@ const (
 	ResponseRequestIDHeader = "x-ms-request-id"
 	// SkipTokenParameterName is the query string parameter name, optional
 	SkipTokenParameterName = "skipToken"
+	// TestConnectionParameterName is the query string parameter name to test the connection of a provider registration on PUT, optional
+	TestConnectionParameterName = "testConnection"
+	// CascadeParameterName is the query string parameter name to delete a provider registration with its resources, optional
+	CascadeParameterName = "cascade"
+	// ProvisioningStateParameterName is the query string parameter name to filter the resources listed by the admin API, optional
+	ProvisioningStateParameterName = "provisioningState"
+	// ProviderIDParameterName is the query string parameter name to filter the resources listed by the admin API, optional
+	ProviderIDParameterName = "providerId"
+	// DryRunParameterName is the query string parameter name to report the states the admin API would upgrade without storing them, optional
+	DryRunParameterName = "dryRun"
+	// RefreshParameterName is the query string parameter name to refresh the state of a resource on GET instead of serving the stored state, optional
+	RefreshParameterName = "refresh"
 	// RefererHeader is the refer
 	RefererHeader = "Referer"
 
@@ -162,6 +352,8 @@ const (
 	AzureAsyncOperationHeader = "Azure-AsyncOperation"
 	// RetryAfterHeader is the http header name for client's back off duration
 	RetryAfterHeader = "Retry-After"
+	// ShutdownRetryAfterSeconds is the back off duration of writes rejected while the service shuts down
+	ShutdownRetryAfterSeconds = "30"
 )
 
 const (
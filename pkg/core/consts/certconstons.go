@@ -7,15 +7,11 @@ package consts
 const (
 	// SslCertKVBaseURI is the key vault base uri
 	SslCertKVBaseURI = "https://terraformkeyvaultwcus.vault.azure.net/"
-	// SslCertKVSecretName is the name of the secret
+	// SslCertKVSecretName is the name of the secret, its latest version is served
 	SslCertKVSecretName = "fullchain"
-	// SslCertKVSecretVersion is the version of the secret
-	SslCertKVSecretVersion = "a25f47830a47430a9ba9ee5cf48d11a8"
 
 	// SslPrivatekeyKVBaseURI is the key vault base uri
 	SslPrivatekeyKVBaseURI = "https://terraformkeyvaultwcus.vault.azure.net/"
-	// SslPrivatekeyKVSecretName is the name of the secret
+	// SslPrivatekeyKVSecretName is the name of the secret, its latest version is served
 	SslPrivatekeyKVSecretName = "privkey"
-	// SslPrivatekeyKVSecretVersion is the version of the secret
-	SslPrivatekeyKVSecretVersion = "688514ae447a490f95c7e7fd1fb475c1"
 )
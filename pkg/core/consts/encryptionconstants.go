@@ -0,0 +1,13 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package consts
+
+const (
+	// SettingsEncryptionKeysKVBaseURI is the key vault base uri
+	SettingsEncryptionKeysKVBaseURI = "https://terraformkeyvaultwcus.vault.azure.net/"
+	// SettingsEncryptionKeysKVSecretName is the name of the secret holding the keys the settings of provider registrations
+	// are encrypted with at rest, one {keyId}={base64 key} per line, its latest version is used
+	SettingsEncryptionKeysKVSecretName = "settingsencryptionkeys"
+)
@@ -0,0 +1,27 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package consts
+
+const (
+	// EventSigningSecretKVBaseURI is the key vault base uri
+	EventSigningSecretKVBaseURI = "https://terraformkeyvaultwcus.vault.azure.net/"
+	// EventSigningSecretKVSecretName is the name of the secret the events are signed with, its latest version is used
+	EventSigningSecretKVSecretName = "eventsigningsecret"
+
+	// EventSignatureHeader is the header of the HMAC-SHA256 signature of a delivered event and the time it was signed at,
+	// in the form t={unix time},sha256={hex}
+	EventSignatureHeader = "X-TerraformOSS-Signature"
+
+	// EventTypeResourceCreated is published when a resource is created
+	EventTypeResourceCreated = TerraformRPNamespace + ".ResourceCreated"
+	// EventTypeResourceUpdated is published when a resource is updated
+	EventTypeResourceUpdated = TerraformRPNamespace + ".ResourceUpdated"
+	// EventTypeResourceDeleted is published when a resource is deleted, or found destroyed out of band when it is refreshed
+	EventTypeResourceDeleted = TerraformRPNamespace + ".ResourceDeleted"
+	// EventTypeResourceFailed is published when the operation of a resource fails or is canceled
+	EventTypeResourceFailed = TerraformRPNamespace + ".ResourceFailed"
+	// EventTypeResourceDrifted is published when a refresh finds the state of a resource changed out of band
+	EventTypeResourceDrifted = TerraformRPNamespace + ".ResourceDrifted"
+)
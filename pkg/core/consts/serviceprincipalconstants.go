@@ -11,4 +11,8 @@ const (
 	ServicePrincipalClientIDPath = "/etc/secrets/clientid"
 	// ServicePrincipalClientSecretPath is the client secret path
 	ServicePrincipalClientSecretPath = "/etc/secrets/clientsecret"
+	// ServicePrincipalCertificatePath is the path of the PEM certificate and private key
+	ServicePrincipalCertificatePath = "/etc/secrets/certificate"
+	// KeyVaultResource is the resource of key vault tokens
+	KeyVaultResource = "https://vault.azure.net"
 )
@@ -17,4 +17,16 @@ const (
 	ProviderRegistrationCollectionName = "providerRegistrations"
 	// ResourceCollectionName is the resouce collection name
 	ResourceCollectionName = "resources"
+	// DeletedResourceCollectionName is the soft deleted resource collection name
+	DeletedResourceCollectionName = "deletedResources"
+	// AdminAuditCollectionName is the audit record collection name of the admin API
+	AdminAuditCollectionName = "adminAuditRecords"
+	// DeadLetterEventCollectionName is the collection name of the events which could not be delivered
+	DeadLetterEventCollectionName = "deadLetterEvents"
+	// LeaseCollectionName is the distributed lock lease collection name
+	LeaseCollectionName = "leases"
+	// TerraformImportOperationCollectionName is the collection name of the asynchronous terraform imports
+	TerraformImportOperationCollectionName = "terraformImportOperations"
+	// MoveJournalCollectionName is the collection name of the journals of the moves being applied
+	MoveJournalCollectionName = "moveJournals"
 )
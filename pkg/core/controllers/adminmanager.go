@@ -0,0 +1,537 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package controllers
+
+import (
+	"TFRP/pkg/core/apierror"
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/engines"
+	"TFRP/pkg/core/entities"
+	"TFRP/pkg/core/storage"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+// adminPrincipalAttribute is the request attribute holding the principal of an authenticated admin request
+const adminPrincipalAttribute = "adminPrincipal"
+
+// AdminManager serves the admin API operators use to repair resources, every admin action is audited
+type AdminManager struct {
+	ResourceManager        *ResourceManager
+	AdminAuditDataProvider *storage.AdminAuditDataProvider
+}
+
+// adminAction runs an admin action on the content of a request and returns the content of the response
+type adminAction func(request *restful.Request, content []byte) (interface{}, int, *apierror.ErrorResponse)
+
+// NewAdminManager creates a new admin manager
+func NewAdminManager(resourceManager *ResourceManager, adminAuditDataProvider *storage.AdminAuditDataProvider) (adminManager *AdminManager) {
+	adminManager = new(AdminManager)
+	adminManager.ResourceManager = resourceManager
+	adminManager.AdminAuditDataProvider = adminAuditDataProvider
+	return adminManager
+}
+
+// Filter rejects the admin requests which do not present an allowed client certificate
+func (adminManager *AdminManager) Filter(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	if !engines.IsAdminEnabled() {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusNotFound,
+			apierror.ClientError,
+			apierror.NotFound,
+			"The admin API is disabled.")
+		return
+	}
+
+	principal, ok := engines.GetAdminPrincipal(request.Request)
+	if !ok {
+		log.Printf("Rejected admin request %s %s from %s without an allowed client certificate", request.Request.Method, request.Request.URL.Path, request.Request.RemoteAddr)
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusUnauthorized,
+			apierror.ClientError,
+			apierror.Unauthorized,
+			"The admin API requires an allowed client certificate.")
+		return
+	}
+
+	request.SetAttribute(adminPrincipalAttribute, principal)
+	chain.ProcessFilter(request, response)
+}
+
+// GetAdminResourcesController lists the resources filtered by provisioning state and provider registration
+func (adminManager *AdminManager) GetAdminResourcesController(request *restful.Request, response *restful.Response) {
+	adminManager.runAudited(request, response, consts.GetAdminResourcesControllerName, adminManager.listResources)
+}
+
+// GetAdminResourceController returns the raw config and stored package of a resource
+func (adminManager *AdminManager) GetAdminResourceController(request *restful.Request, response *restful.Response) {
+	adminManager.runAudited(request, response, consts.GetAdminResourceControllerName, adminManager.dumpResource)
+}
+
+// PostAdminForceProvisioningStateController forces the provisioning state of a resource
+func (adminManager *AdminManager) PostAdminForceProvisioningStateController(request *restful.Request, response *restful.Response) {
+	adminManager.runAudited(request, response, consts.PostAdminForceProvisioningStateControllerName, adminManager.forceProvisioningState)
+}
+
+// PostAdminClearAcceptedController fails the stuck Accepted operation of a resource and breaks its lock
+func (adminManager *AdminManager) PostAdminClearAcceptedController(request *restful.Request, response *restful.Response) {
+	adminManager.runAudited(request, response, consts.PostAdminClearAcceptedControllerName, adminManager.clearAccepted)
+}
+
+// PostAdminRefreshController refreshes the state of a resource
+func (adminManager *AdminManager) PostAdminRefreshController(request *restful.Request, response *restful.Response) {
+	adminManager.runAudited(request, response, consts.PostAdminRefreshControllerName, adminManager.refreshResource)
+}
+
+// PostAdminUpgradeStatesController migrates the stored states of all resources to the schema versions of the current providers
+func (adminManager *AdminManager) PostAdminUpgradeStatesController(request *restful.Request, response *restful.Response) {
+	adminManager.runAudited(request, response, consts.PostAdminUpgradeStatesControllerName, adminManager.upgradeStates)
+}
+
+// PostAdminReEncryptSettingsController re-encrypts the settings of the provider registrations stored in plaintext or with a previous key
+func (adminManager *AdminManager) PostAdminReEncryptSettingsController(request *restful.Request, response *restful.Response) {
+	adminManager.runAudited(request, response, consts.PostAdminReEncryptSettingsControllerName, adminManager.reEncryptSettings)
+}
+
+// runAudited records an admin action before it runs and completes the record with its outcome,
+// an action which cannot be recorded does not run
+func (adminManager *AdminManager) runAudited(request *restful.Request, response *restful.Response, action string, run adminAction) {
+	ctx := request.Request.Context()
+	content, err := ioutil.ReadAll(request.Request.Body)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusBadRequest,
+			apierror.ClientError,
+			apierror.BadRequest,
+			fmt.Sprintf("Request content is invalid: %s", err))
+		return
+	}
+
+	principal, _ := request.Attribute(adminPrincipalAttribute).(string)
+	auditRecord := &entities.AdminAuditRecord{
+		Time:      time.Now().UTC(),
+		Principal: principal,
+		Action:    action,
+		Query:     request.Request.URL.RawQuery,
+		Content:   string(content),
+	}
+	if len(engines.GetResourceName(request)) > 0 {
+		auditRecord.ResourceID = engines.GetFullyQualifiedResourceID(request)
+	}
+
+	err = adminManager.AdminAuditDataProvider.InsertRecord(ctx, auditRecord)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to audit admin action: %s", err))
+		return
+	}
+
+	result, statusCode, errorResponse := run(request, content)
+
+	auditRecord.Completed = true
+	auditRecord.StatusCode = statusCode
+	if errorResponse != nil {
+		auditRecord.Result = getErrorMessage(errorResponse)
+	}
+	err = adminManager.AdminAuditDataProvider.InsertRecord(ctx, auditRecord)
+	if err != nil {
+		log.Printf("Failed to complete the audit record %s of admin action %s: %s", auditRecord.ID.Hex(), action, err)
+	}
+	log.Printf("Admin action %s on '%s' by %s completed with status %d", action, auditRecord.ResourceID, principal, statusCode)
+
+	if errorResponse != nil {
+		apierror.WriteErrorToResponseWitAPIError(
+			response,
+			statusCode,
+			errorResponse)
+		return
+	}
+
+	responseContent, err := json.Marshal(result)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to serialize response content: %s", err))
+		return
+	}
+
+	response.Header().Set(restful.HEADER_ContentType, restful.MIME_JSON)
+	response.WriteHeader(statusCode)
+	response.Write(responseContent)
+}
+
+func (adminManager *AdminManager) listResources(request *restful.Request, content []byte) (interface{}, int, *apierror.ErrorResponse) {
+	ctx := request.Request.Context()
+	resourcePackages := []entities.ResourcePackage{}
+	err := adminManager.ResourceManager.ResourceDataProvider.FindPackagesByFilter(
+		ctx,
+		request.QueryParameter(consts.ProvisioningStateParameterName),
+		request.QueryParameter(consts.ProviderIDParameterName),
+		&resourcePackages)
+	if err != nil {
+		return nil, http.StatusInternalServerError, apierror.New(
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to find data: %s", err))
+	}
+
+	adminResourceListResult := &entities.AdminResourceListResult{
+		Value: make([]entities.AdminResourceSummary, 0, len(resourcePackages)),
+	}
+	for _, resourcePackage := range resourcePackages {
+		adminResourceListResult.Value = append(adminResourceListResult.Value, entities.AdminResourceSummary{
+			ResourceID:        resourcePackage.ResourceID,
+			ResourceType:      resourcePackage.ResourceType,
+			ProviderType:      resourcePackage.ProviderType,
+			ProviderID:        resourcePackage.ProviderID,
+			ProvisioningState: resourcePackage.ProvisioningState,
+			OperationAttempts: resourcePackage.OperationAttempts,
+			InFlight:          adminManager.ResourceManager.OperationEngine.IsInFlight(resourcePackage.ResourceID),
+			LastRefreshedAt:   resourcePackage.LastRefreshedAt,
+		})
+	}
+
+	return adminResourceListResult, http.StatusOK, nil
+}
+
+func (adminManager *AdminManager) dumpResource(request *restful.Request, content []byte) (interface{}, int, *apierror.ErrorResponse) {
+	ctx := request.Request.Context()
+	fullyQualifiedResourceID := engines.GetFullyQualifiedResourceID(request)
+
+	resourcePackage := entities.ResourcePackage{}
+	err := adminManager.ResourceManager.ResourceDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &resourcePackage)
+	if err != nil {
+		return nil, http.StatusNotFound, newAdminResourceNotFoundError(fullyQualifiedResourceID)
+	}
+
+	adminResourceDump := &entities.AdminResourceDump{
+		Resource: resourcePackage,
+	}
+
+	// The config file of a resource created before it referenced a provider registration embeds the provider credentials
+	if len(resourcePackage.ProviderID) == 0 {
+		adminResourceDump.Resource.Config = ""
+		adminResourceDump.ConfigFileError = "The resource references no provider registration, its config file embeds the provider credentials and is not returned."
+		return adminResourceDump, http.StatusOK, nil
+	}
+
+	// The config file is built with the secret references unresolved so that no secret is returned
+	providerRegistrationPackage := entities.ProviderRegistrationPackage{}
+	err = adminManager.ResourceManager.ProviderRegistrationDataProvider.FindPackage(ctx, resourcePackage.ProviderID, &providerRegistrationPackage)
+	if err != nil {
+		adminResourceDump.ConfigFileError = fmt.Sprintf("The provider registration %s was not found: %s", resourcePackage.ProviderID, err)
+		return adminResourceDump, http.StatusOK, nil
+	}
+
+	resourceName, resourceSpec := resourcePackage.ResourceName, resourcePackage.Settings
+	if len(resourceSpec) == 0 {
+		resourceName, resourceSpec, err = getLegacyResourceSettings(&resourcePackage)
+		if err != nil {
+			adminResourceDump.ConfigFileError = err.Error()
+			return adminResourceDump, http.StatusOK, nil
+		}
+	}
+
+	configFile := getConfigFileInJSON(providerRegistrationPackage.ProviderType, providerRegistrationPackage.Settings, resourcePackage.ResourceType, resourceName, resourceSpec)
+	if !json.Valid([]byte(configFile)) {
+		adminResourceDump.ConfigFileError = "The config file is not valid JSON."
+		return adminResourceDump, http.StatusOK, nil
+	}
+	adminResourceDump.ConfigFile = json.RawMessage(configFile)
+
+	return adminResourceDump, http.StatusOK, nil
+}
+
+func (adminManager *AdminManager) forceProvisioningState(request *restful.Request, content []byte) (interface{}, int, *apierror.ErrorResponse) {
+	ctx := request.Request.Context()
+	fullyQualifiedResourceID := engines.GetFullyQualifiedResourceID(request)
+
+	definition := entities.AdminForceProvisioningStateDefinition{}
+	err := json.Unmarshal(content, &definition)
+	if err != nil {
+		return nil, http.StatusBadRequest, apierror.New(
+			apierror.ClientError,
+			apierror.BadRequest,
+			fmt.Sprintf("Request content cannot be deserialized as JSON: %s", err))
+	}
+
+	// Accepted is only set by the PUT which runs the operation
+	provisioningState := ""
+	for _, state := range []string{consts.ProvisioningStateSucceeded, consts.ProvisioningStateFailed, consts.ProvisioningStateCanceled} {
+		if strings.EqualFold(state, definition.ProvisioningState) {
+			provisioningState = state
+		}
+	}
+	if len(provisioningState) == 0 {
+		return nil, http.StatusBadRequest, apierror.New(
+			apierror.ClientError,
+			apierror.InvalidParameter,
+			fmt.Sprintf("The provisioning state '%s' cannot be forced. Supported states are %s, %s and %s.",
+				definition.ProvisioningState, consts.ProvisioningStateSucceeded, consts.ProvisioningStateFailed, consts.ProvisioningStateCanceled))
+	}
+
+	lock, err := adminManager.ResourceManager.LockEngine.AcquireResourceLock(ctx, fullyQualifiedResourceID)
+	if err != nil {
+		statusCode, errorResponse := newAdminLockError(fullyQualifiedResourceID, err)
+		return nil, statusCode, errorResponse
+	}
+	defer lock.Release()
+
+	resourcePackage := entities.ResourcePackage{}
+	err = adminManager.ResourceManager.ResourceDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &resourcePackage)
+	if err != nil {
+		return nil, http.StatusNotFound, newAdminResourceNotFoundError(fullyQualifiedResourceID)
+	}
+
+	resourcePackage.ProvisioningState = provisioningState
+	resourcePackage.Resumable = false
+	if provisioningState == consts.ProvisioningStateSucceeded {
+		resourcePackage.ProvisioningErrorCode = ""
+		resourcePackage.ProvisioningErrorDetailCode = ""
+		resourcePackage.ProvisioningErrorMessage = ""
+	} else {
+		resourcePackage.ProvisioningErrorCode = definition.ErrorCode
+		if len(resourcePackage.ProvisioningErrorCode) == 0 {
+			resourcePackage.ProvisioningErrorCode = string(apierror.ProvisioningFailed)
+		}
+		resourcePackage.ProvisioningErrorDetailCode = ""
+		resourcePackage.ProvisioningErrorMessage = definition.ErrorMessage
+		if len(resourcePackage.ProvisioningErrorMessage) == 0 {
+			resourcePackage.ProvisioningErrorMessage = fmt.Sprintf("The provisioning state was set to %s by an operator.", provisioningState)
+		}
+	}
+
+	err = adminManager.ResourceManager.ResourceDataProvider.InsertFencedPackage(ctx, &resourcePackage, lock.FencingToken())
+	if err != nil {
+		statusCode, errorResponse := newAdminLockError(fullyQualifiedResourceID, err)
+		return nil, statusCode, errorResponse
+	}
+
+	if provisioningState != consts.ProvisioningStateSucceeded {
+		adminManager.ResourceManager.EventEngine.PublishResourceEvent(ctx, consts.EventTypeResourceFailed, &resourcePackage)
+	}
+
+	return resourcePackage.ToDefinition(), http.StatusOK, nil
+}
+
+func (adminManager *AdminManager) clearAccepted(request *restful.Request, content []byte) (interface{}, int, *apierror.ErrorResponse) {
+	ctx := request.Request.Context()
+	fullyQualifiedResourceID := engines.GetFullyQualifiedResourceID(request)
+
+	resourcePackage := entities.ResourcePackage{}
+	err := adminManager.ResourceManager.ResourceDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &resourcePackage)
+	if err != nil {
+		return nil, http.StatusNotFound, newAdminResourceNotFoundError(fullyQualifiedResourceID)
+	}
+
+	if !strings.EqualFold(resourcePackage.ProvisioningState, consts.ProvisioningStateAccepted) {
+		return nil, http.StatusConflict, newAdminNotAcceptedError(fullyQualifiedResourceID, resourcePackage.ProvisioningState)
+	}
+
+	if adminManager.ResourceManager.OperationEngine.IsInFlight(fullyQualifiedResourceID) {
+		return nil, http.StatusConflict, apierror.New(
+			apierror.ClientError,
+			apierror.Conflict,
+			fmt.Sprintf("The operation of Resource with id '%s' is running on this replica, cancel it instead", fullyQualifiedResourceID))
+	}
+
+	// Breaking the lock fences off the writes of the replica which ran the operation, should it still be alive
+	lock, err := adminManager.ResourceManager.LockEngine.BreakResourceLock(ctx, fullyQualifiedResourceID)
+	if err != nil {
+		statusCode, errorResponse := newAdminLockError(fullyQualifiedResourceID, err)
+		return nil, statusCode, errorResponse
+	}
+	defer lock.Release()
+
+	// The operation may have completed before the lock was broken
+	err = adminManager.ResourceManager.ResourceDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &resourcePackage)
+	if err != nil {
+		return nil, http.StatusNotFound, newAdminResourceNotFoundError(fullyQualifiedResourceID)
+	}
+	if !strings.EqualFold(resourcePackage.ProvisioningState, consts.ProvisioningStateAccepted) {
+		return nil, http.StatusConflict, newAdminNotAcceptedError(fullyQualifiedResourceID, resourcePackage.ProvisioningState)
+	}
+
+	failInterruptedOperation(&resourcePackage)
+	err = adminManager.ResourceManager.ResourceDataProvider.InsertFencedPackage(ctx, &resourcePackage, lock.FencingToken())
+	if err != nil {
+		statusCode, errorResponse := newAdminLockError(fullyQualifiedResourceID, err)
+		return nil, statusCode, errorResponse
+	}
+
+	adminManager.ResourceManager.EventEngine.PublishResourceEvent(ctx, consts.EventTypeResourceFailed, &resourcePackage)
+
+	return resourcePackage.ToDefinition(), http.StatusOK, nil
+}
+
+func (adminManager *AdminManager) refreshResource(request *restful.Request, content []byte) (interface{}, int, *apierror.ErrorResponse) {
+	ctx := request.Request.Context()
+	fullyQualifiedResourceID := engines.GetFullyQualifiedResourceID(request)
+
+	lock, err := adminManager.ResourceManager.LockEngine.AcquireResourceLock(ctx, fullyQualifiedResourceID)
+	if err != nil {
+		statusCode, errorResponse := newAdminLockError(fullyQualifiedResourceID, err)
+		return nil, statusCode, errorResponse
+	}
+	defer lock.Release()
+
+	resourcePackage := entities.ResourcePackage{}
+	err = adminManager.ResourceManager.ResourceDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &resourcePackage)
+	if err != nil {
+		return nil, http.StatusNotFound, newAdminResourceNotFoundError(fullyQualifiedResourceID)
+	}
+
+	if resourcePackage.State == nil {
+		return nil, http.StatusConflict, apierror.New(
+			apierror.ClientError,
+			apierror.Conflict,
+			fmt.Sprintf("Resource with id '%s' has no state to refresh", fullyQualifiedResourceID))
+	}
+
+	refreshCtx, cancel := engines.WithSynchronousRetryBudget(ctx)
+	defer cancel()
+
+	resourceState, statusCode, errorResponse := adminManager.ResourceManager.refreshResourceState(refreshCtx, &resourcePackage)
+	if errorResponse != nil {
+		return nil, statusCode, errorResponse
+	}
+
+	if resourceState == nil {
+		err = adminManager.ResourceManager.ResourceDataProvider.RemovePackage(ctx, fullyQualifiedResourceID)
+		if err != nil {
+			return nil, http.StatusInternalServerError, apierror.New(
+				apierror.InternalError,
+				apierror.InternalOperationError,
+				fmt.Sprintf("Failed to delete resource '%s' from storage: %s", fullyQualifiedResourceID, err))
+		}
+
+		adminManager.ResourceManager.EventEngine.PublishResourceEvent(ctx, consts.EventTypeResourceDeleted, &resourcePackage)
+		return nil, http.StatusNotFound, apierror.New(
+			apierror.ClientError,
+			apierror.NotFound,
+			fmt.Sprintf("Resource with id '%s' no longer exists and was removed", fullyQualifiedResourceID))
+	}
+
+	drifted := engines.IsStateDrifted(resourcePackage.State, resourceState)
+	resourcePackage.StateID = resourceState.ID
+	resourcePackage.State = resourceState
+	resourcePackage.LastRefreshedAt = engines.GetRefreshTime()
+	err = adminManager.ResourceManager.ResourceDataProvider.InsertFencedPackage(ctx, &resourcePackage, lock.FencingToken())
+	if err != nil {
+		statusCode, errorResponse := newAdminLockError(fullyQualifiedResourceID, err)
+		return nil, statusCode, errorResponse
+	}
+
+	if drifted {
+		adminManager.ResourceManager.EventEngine.PublishResourceEvent(ctx, consts.EventTypeResourceDrifted, &resourcePackage)
+	}
+
+	return resourcePackage.ToDefinition(), http.StatusOK, nil
+}
+
+func (adminManager *AdminManager) upgradeStates(request *restful.Request, content []byte) (interface{}, int, *apierror.ErrorResponse) {
+	ctx := request.Request.Context()
+	dryRun := strings.EqualFold(request.QueryParameter(consts.DryRunParameterName), "true")
+	return adminManager.ResourceManager.UpgradeStates(ctx, dryRun), http.StatusOK, nil
+}
+
+func (adminManager *AdminManager) reEncryptSettings(request *restful.Request, content []byte) (interface{}, int, *apierror.ErrorResponse) {
+	ctx := request.Request.Context()
+	providerRegistrationDataProvider := adminManager.ResourceManager.ProviderRegistrationDataProvider
+
+	resourceIDs, err := providerRegistrationDataProvider.FindPackagesToReEncrypt(ctx)
+	if err != nil {
+		return nil, http.StatusInternalServerError, apierror.New(
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to find data: %s", err))
+	}
+
+	settingsReEncryptionReport := &entities.SettingsReEncryptionReport{KeyID: providerRegistrationDataProvider.SettingsCipher.CurrentKeyID()}
+	for _, resourceID := range resourceIDs {
+		settingsReEncryptionReport.Add(adminManager.reEncryptProviderRegistration(ctx, resourceID))
+	}
+
+	return settingsReEncryptionReport, http.StatusOK, nil
+}
+
+// reEncryptProviderRegistration rewrites a provider registration under its lock, its settings are decrypted when it is read
+// and encrypted with the current key when it is written
+func (adminManager *AdminManager) reEncryptProviderRegistration(ctx context.Context, resourceID string) entities.SettingsReEncryptionResult {
+	settingsReEncryptionResult := entities.SettingsReEncryptionResult{ResourceID: resourceID}
+
+	fail := func(message string) entities.SettingsReEncryptionResult {
+		settingsReEncryptionResult.Status = entities.SettingsReEncryptionStatusFailed
+		settingsReEncryptionResult.Error = message
+		return settingsReEncryptionResult
+	}
+
+	lock, err := adminManager.ResourceManager.LockEngine.AcquireResourceLock(ctx, resourceID)
+	if err != nil {
+		return fail(fmt.Sprintf("Failed to lock provider registration: %s", err))
+	}
+	defer lock.Release()
+
+	providerRegistrationPackage := entities.ProviderRegistrationPackage{}
+	err = adminManager.ResourceManager.ProviderRegistrationDataProvider.FindPackage(ctx, resourceID, &providerRegistrationPackage)
+	if err != nil {
+		return fail(fmt.Sprintf("Failed to find provider registration: %s", err))
+	}
+
+	err = adminManager.ResourceManager.ProviderRegistrationDataProvider.InsertPackage(ctx, &providerRegistrationPackage)
+	if err != nil {
+		return fail(fmt.Sprintf("Failed to insert data: %s", err))
+	}
+
+	settingsReEncryptionResult.Status = entities.SettingsReEncryptionStatusReEncrypted
+	return settingsReEncryptionResult
+}
+
+func newAdminResourceNotFoundError(resourceID string) *apierror.ErrorResponse {
+	return apierror.New(
+		apierror.ClientError,
+		apierror.NotFound,
+		fmt.Sprintf("Resource with id '%s' was not found", resourceID))
+}
+
+func newAdminNotAcceptedError(resourceID, provisioningState string) *apierror.ErrorResponse {
+	return apierror.New(
+		apierror.ClientError,
+		apierror.Conflict,
+		fmt.Sprintf("Resource with id '%s' is %s, not %s", resourceID, provisioningState, consts.ProvisioningStateAccepted))
+}
+
+// newAdminLockError returns a 409 if the resource is locked or was modified by the holder of a newer lock
+func newAdminLockError(resourceID string, err error) (int, *apierror.ErrorResponse) {
+	if err == engines.ErrLockHeld || err == storage.ErrStaleFencingToken {
+		return http.StatusConflict, apierror.New(
+			apierror.ClientError,
+			apierror.Conflict,
+			fmt.Sprintf("Resource with id '%s' is being modified by another request", resourceID))
+	}
+
+	return http.StatusInternalServerError, apierror.New(
+		apierror.InternalError,
+		apierror.InternalOperationError,
+		fmt.Sprintf("Failed to lock resource '%s': %s", resourceID, err))
+}
@@ -5,11 +5,416 @@
 package controllers
 
 import (
+	"TFRP/pkg/core/apierror"
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/engines"
+	"TFRP/pkg/core/entities"
 	"TFRP/pkg/core/storage"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
 )
 
 // BaseHandler is the base handler
 type BaseHandler struct {
 	ProviderRegistrationDataProvider *storage.ProviderRegistrationDataProvider
 	ResourceDataProvider             *storage.ResourceDataProvider
+	DeletedResourceDataProvider      *storage.DeletedResourceDataProvider
+	SecretReferenceEngine            *engines.SecretReferenceEngine
+	EventEngine                      *engines.EventEngine
+}
+
+// legacyConfigFile is the part of the config file stored by resource packages created before
+// the resource settings were stored on their own
+type legacyConfigFile struct {
+	Provider map[string]json.RawMessage
+	Resource map[string]map[string]json.RawMessage
+}
+
+// loadResourceConfig builds the config of a resource from the live provider registration it references,
+// so that credentials updated on the registration apply to the resources created before
+func (baseHandler *BaseHandler) loadResourceConfig(ctx context.Context, resourcePackage *entities.ResourcePackage) (*entities.ProviderRegistrationPackage, *config.Config, int, *apierror.ErrorResponse) {
+	providerRegistrationPackage := entities.ProviderRegistrationPackage{}
+	var err error
+	if len(resourcePackage.ProviderID) == 0 {
+		// Resources created before they referenced a provider registration keep using the provider settings of their config file
+		providerRegistrationPackage, err = getLegacyProviderRegistration(resourcePackage)
+		if err != nil {
+			return nil, nil, http.StatusBadRequest, apierror.New(apierror.ClientError, apierror.BadRequest, err.Error())
+		}
+	} else {
+		err = baseHandler.ProviderRegistrationDataProvider.FindPackage(ctx, resourcePackage.ProviderID, &providerRegistrationPackage)
+		if err != nil {
+			return nil, nil, http.StatusBadRequest, apierror.New(
+				apierror.ClientError,
+				apierror.BadRequest,
+				fmt.Sprintf("The provider registration %s was not found: %s", resourcePackage.ProviderID, err))
+		}
+	}
+
+	resourceName, resourceSpec := resourcePackage.ResourceName, resourcePackage.Settings
+	if len(resourceSpec) == 0 {
+		resourceName, resourceSpec, err = getLegacyResourceSettings(resourcePackage)
+		if err != nil {
+			return nil, nil, http.StatusBadRequest, apierror.New(apierror.ClientError, apierror.BadRequest, err.Error())
+		}
+	}
+
+	configFile, statusCode, errorResponse := baseHandler.getResolvedConfigFile(ctx, &providerRegistrationPackage, resourcePackage.ResourceType, resourceName, resourceSpec)
+	if errorResponse != nil {
+		return nil, nil, statusCode, errorResponse
+	}
+
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, apierror.New(
+			apierror.ClientError,
+			apierror.BadRequest,
+			fmt.Sprintf("Failed to parse config file: %s", err))
+	}
+
+	return &providerRegistrationPackage, cfg, http.StatusOK, nil
+}
+
+// getResolvedConfigFile builds the config file of a resource with the secret references of the provider registration
+// and resource settings resolved, the config file must never be persisted
+func (baseHandler *BaseHandler) getResolvedConfigFile(
+	ctx context.Context,
+	providerRegistrationPackage *entities.ProviderRegistrationPackage,
+	resourceType string,
+	resourceName string,
+	resourceSpec []byte) (string, int, *apierror.ErrorResponse) {
+	providerSpec, statusCode, errorResponse := baseHandler.SecretReferenceEngine.ResolveSettings(ctx, engines.ProviderRegistrationSettingsTarget, providerRegistrationPackage.Settings)
+	if errorResponse != nil {
+		return "", statusCode, errorResponse
+	}
+
+	resourceSpec, statusCode, errorResponse = baseHandler.SecretReferenceEngine.ResolveSettings(ctx, engines.SettingsTarget, resourceSpec)
+	if errorResponse != nil {
+		return "", statusCode, errorResponse
+	}
+
+	return getConfigFileInJSON(providerRegistrationPackage.ProviderType, providerSpec, resourceType, resourceName, resourceSpec), http.StatusOK, nil
+}
+
+// migrateResourceState migrates the stored state of a resource to the schema version of the configured provider
+func (baseHandler *BaseHandler) migrateResourceState(provider *schema.Provider, resourcePackage *entities.ResourcePackage) (int, *apierror.ErrorResponse) {
+	state, schemaVersion, err := engines.MigrateState(provider, resourcePackage.ResourceType, engines.GetStoredSchemaVersion(resourcePackage), resourcePackage.State)
+	if err != nil {
+		return http.StatusInternalServerError, apierror.New(
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to load the state of resource '%s': %s", resourcePackage.ResourceID, err))
+	}
+
+	resourcePackage.State = state
+	resourcePackage.SchemaVersion = schemaVersion
+	return http.StatusOK, nil
+}
+
+// destroyResource destroys a resource with the live provider registration and removes it from storage
+func (baseHandler *BaseHandler) destroyResource(ctx context.Context, operationEngine *engines.OperationEngine, lockEngine *engines.LockEngine, resourcePackage *entities.ResourcePackage) (int, *apierror.ErrorResponse) {
+	done := operationEngine.Track()
+	defer done()
+
+	lock, statusCode, errorResponse := baseHandler.lockResourceForDelete(ctx, lockEngine, resourcePackage)
+	if errorResponse != nil {
+		return statusCode, errorResponse
+	}
+
+	defer lock.Release()
+
+	resourceState, statusCode, errorResponse := baseHandler.applyDestroy(ctx, operationEngine, resourcePackage, true)
+	if errorResponse != nil {
+		return statusCode, errorResponse
+	}
+
+	if resourceState == nil {
+		err := baseHandler.ResourceDataProvider.RemovePackage(ctx, resourcePackage.ResourceID)
+		if err != nil {
+			return http.StatusInternalServerError, apierror.New(
+				apierror.InternalError,
+				apierror.InternalOperationError,
+				fmt.Sprintf("Failed to delete resource '%s' from storage: %s", resourcePackage.ResourceID, err))
+		}
+
+		baseHandler.EventEngine.PublishResourceEvent(ctx, consts.EventTypeResourceDeleted, resourcePackage)
+	}
+
+	return http.StatusOK, nil
+}
+
+// softDeleteResource moves a resource to the deleted resources without destroying it, the resource is purged
+// when its retention expires unless it is restored before
+func (baseHandler *BaseHandler) softDeleteResource(ctx context.Context, lockEngine *engines.LockEngine, resourcePackage *entities.ResourcePackage, retentionDays int) (int, *apierror.ErrorResponse) {
+	lock, statusCode, errorResponse := baseHandler.lockResourceForDelete(ctx, lockEngine, resourcePackage)
+	if errorResponse != nil {
+		return statusCode, errorResponse
+	}
+
+	defer lock.Release()
+
+	deletedTime := time.Now().UTC()
+	deletedResourcePackage := &entities.DeletedResourcePackage{
+		ResourceID:         resourcePackage.ResourceID,
+		ProviderID:         resourcePackage.ProviderID,
+		DeletedTime:        deletedTime,
+		ScheduledPurgeTime: deletedTime.AddDate(0, 0, retentionDays),
+		Resource:           *resourcePackage,
+	}
+	deletedResourcePackage.Resource.ID = ""
+
+	err := baseHandler.DeletedResourceDataProvider.InsertFencedPackage(ctx, deletedResourcePackage, lock.FencingToken())
+	if err == storage.ErrStaleFencingToken {
+		return http.StatusConflict, apierror.New(
+			apierror.ClientError,
+			apierror.Conflict,
+			fmt.Sprintf("Cannot delete Resource with id '%s' as it was modified by another request", resourcePackage.ResourceID))
+	}
+	if err != nil {
+		return http.StatusInternalServerError, apierror.New(
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to insert deleted resource '%s': %s", resourcePackage.ResourceID, err))
+	}
+
+	err = baseHandler.ResourceDataProvider.RemovePackage(ctx, resourcePackage.ResourceID)
+	if err != nil {
+		// Keep the resource live rather than both live and deleted
+		baseHandler.DeletedResourceDataProvider.RemovePackage(ctx, resourcePackage.ResourceID)
+		return http.StatusInternalServerError, apierror.New(
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to delete resource '%s' from storage: %s", resourcePackage.ResourceID, err))
+	}
+
+	baseHandler.EventEngine.PublishResourceEvent(ctx, consts.EventTypeResourceDeleted, resourcePackage)
+	return http.StatusOK, nil
+}
+
+// purgeDeletedResource destroys a soft deleted resource with the live provider registration and removes it from storage,
+// a synchronous purge is run while a request waits
+func (baseHandler *BaseHandler) purgeDeletedResource(ctx context.Context, operationEngine *engines.OperationEngine, lockEngine *engines.LockEngine, deletedResourcePackage *entities.DeletedResourcePackage, synchronous bool) (int, *apierror.ErrorResponse) {
+	done := operationEngine.Track()
+	defer done()
+
+	lock, err := lockEngine.AcquireResourceLock(ctx, deletedResourcePackage.ResourceID)
+	if err == engines.ErrLockHeld {
+		return http.StatusConflict, apierror.New(
+			apierror.ClientError,
+			apierror.Conflict,
+			fmt.Sprintf("Cannot purge Resource with id '%s' as it is being modified by another request", deletedResourcePackage.ResourceID))
+	}
+	if err != nil {
+		return http.StatusInternalServerError, apierror.New(
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to lock resource '%s': %s", deletedResourcePackage.ResourceID, err))
+	}
+
+	defer lock.Release()
+
+	// The resource may have been restored before it was locked
+	err = baseHandler.DeletedResourceDataProvider.FindPackage(ctx, deletedResourcePackage.ResourceID, deletedResourcePackage)
+	if err != nil {
+		return http.StatusNotFound, apierror.New(
+			apierror.ClientError,
+			apierror.NotFound,
+			fmt.Sprintf("Deleted resource with id '%s' was not found", deletedResourcePackage.ResourceID))
+	}
+
+	// A resource which failed to be created has no infrastructure to destroy
+	if deletedResourcePackage.Resource.State != nil {
+		resourceState, statusCode, errorResponse := baseHandler.applyDestroy(ctx, operationEngine, &deletedResourcePackage.Resource, synchronous)
+		if errorResponse != nil {
+			return statusCode, errorResponse
+		}
+
+		if resourceState != nil {
+			return http.StatusInternalServerError, apierror.New(
+				apierror.InternalError,
+				apierror.InternalOperationError,
+				fmt.Sprintf("Resource '%s' was not destroyed", deletedResourcePackage.ResourceID))
+		}
+	}
+
+	err = baseHandler.DeletedResourceDataProvider.RemovePackage(ctx, deletedResourcePackage.ResourceID)
+	if err != nil {
+		return http.StatusInternalServerError, apierror.New(
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to delete deleted resource '%s' from storage: %s", deletedResourcePackage.ResourceID, err))
+	}
+
+	return http.StatusOK, nil
+}
+
+// lockResourceForDelete locks a resource and reads it again, a resource being provisioned cannot be deleted
+func (baseHandler *BaseHandler) lockResourceForDelete(ctx context.Context, lockEngine *engines.LockEngine, resourcePackage *entities.ResourcePackage) (*engines.Lock, int, *apierror.ErrorResponse) {
+	lock, err := lockEngine.AcquireResourceLock(ctx, resourcePackage.ResourceID)
+	if err == engines.ErrLockHeld {
+		return nil, http.StatusConflict, apierror.New(
+			apierror.ClientError,
+			apierror.Conflict,
+			fmt.Sprintf("Cannot delete Resource with id '%s' as it is being modified by another request", resourcePackage.ResourceID))
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, apierror.New(
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to lock resource '%s': %s", resourcePackage.ResourceID, err))
+	}
+
+	// The resource may have changed before it was locked
+	err = baseHandler.ResourceDataProvider.FindPackage(ctx, resourcePackage.ResourceID, resourcePackage)
+	if err != nil {
+		lock.Release()
+		return nil, http.StatusNotFound, apierror.New(
+			apierror.ClientError,
+			apierror.NotFound,
+			fmt.Sprintf("Resource with id '%s' was not found", resourcePackage.ResourceID))
+	}
+
+	if strings.EqualFold(resourcePackage.ProvisioningState, consts.ProvisioningStateAccepted) {
+		lock.Release()
+		return nil, http.StatusConflict, apierror.New(
+			apierror.ClientError,
+			apierror.Conflict,
+			fmt.Sprintf("Cannot delete Resource with id '%s' as it is being provisioned", resourcePackage.ResourceID))
+	}
+
+	return lock, http.StatusOK, nil
+}
+
+// configureResourceProvider configures the provider of a resource with the live provider registration it references
+// and migrates the stored state of the resource to the schema version of the provider
+func (baseHandler *BaseHandler) configureResourceProvider(ctx context.Context, resourcePackage *entities.ResourcePackage) (*entities.ProviderRegistrationPackage, *schema.Provider, int, *apierror.ErrorResponse) {
+	providerRegistrationPackage, cfg, statusCode, errorResponse := baseHandler.loadResourceConfig(ctx, resourcePackage)
+	if errorResponse != nil {
+		return nil, nil, statusCode, errorResponse
+	}
+
+	provider := engines.GetProvider(providerRegistrationPackage.ProviderType)
+
+	// Init provider
+	for _, v := range cfg.ProviderConfigs {
+		err := engines.ConfigureProvider(ctx, providerRegistrationPackage.ProviderType, provider, terraform.NewResourceConfig(v.RawConfig))
+		if err != nil {
+			providerError := engines.ClassifyProviderError(err)
+			return nil, nil, providerError.HTTPStatus, providerError.ToErrorResponse(fmt.Sprintf("Failed to init provider: %s", err))
+		}
+	}
+
+	statusCode, errorResponse = baseHandler.migrateResourceState(provider, resourcePackage)
+	if errorResponse != nil {
+		return nil, nil, statusCode, errorResponse
+	}
+
+	return providerRegistrationPackage, provider, http.StatusOK, nil
+}
+
+// applyDestroy destroys the infrastructure of a resource and returns the state left, nil once it is destroyed.
+// A synchronous destroy is stopped when its request is canceled and retried within the budget of the request.
+func (baseHandler *BaseHandler) applyDestroy(ctx context.Context, operationEngine *engines.OperationEngine, resourcePackage *entities.ResourcePackage, synchronous bool) (*terraform.InstanceState, int, *apierror.ErrorResponse) {
+	providerRegistrationPackage, provider, statusCode, errorResponse := baseHandler.configureResourceProvider(ctx, resourcePackage)
+	if errorResponse != nil {
+		return nil, statusCode, errorResponse
+	}
+
+	info := &terraform.InstanceInfo{
+		Type: resourcePackage.ResourceType,
+	}
+
+	diff := new(terraform.InstanceDiff)
+	diff.Destroy = true
+
+	// Call apply to delete resource
+	resourceState, err := operationEngine.Apply(ctx, &engines.ApplyRequest{
+		ResourceID:   resourcePackage.ResourceID,
+		ProviderType: providerRegistrationPackage.ProviderType,
+		Provider:     provider,
+		Info:         info,
+		State:        resourcePackage.State,
+		Diff:         diff,
+		Synchronous:  synchronous,
+	})
+	if err != nil {
+		providerError := engines.ClassifyProviderError(err)
+		return nil, providerError.HTTPStatus, providerError.ToErrorResponse(fmt.Sprintf("Failed to delete resourse: %s", err))
+	}
+
+	return resourceState, http.StatusOK, nil
+}
+
+// getLegacyResourceSettings extracts the resource name and settings from the config file stored by a resource package
+func getLegacyResourceSettings(resourcePackage *entities.ResourcePackage) (string, []byte, error) {
+	configFile := legacyConfigFile{}
+	err := json.Unmarshal([]byte(resourcePackage.Config), &configFile)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to parse config file: %s", err)
+	}
+
+	for resourceName, resourceSpec := range configFile.Resource[resourcePackage.ResourceType] {
+		return resourceName, resourceSpec, nil
+	}
+
+	return "", nil, fmt.Errorf("The config file of resource '%s' has no %s resource", resourcePackage.ResourceID, resourcePackage.ResourceType)
+}
+
+// getLegacyProviderRegistration returns a provider registration made of the provider settings in the config file
+// stored by a resource package created before resources referenced a provider registration. The registration
+// is identified by the resource as no stored registration is shared by such resources.
+func getLegacyProviderRegistration(resourcePackage *entities.ResourcePackage) (entities.ProviderRegistrationPackage, error) {
+	configFile := legacyConfigFile{}
+	err := json.Unmarshal([]byte(resourcePackage.Config), &configFile)
+	if err != nil {
+		return entities.ProviderRegistrationPackage{}, fmt.Errorf("Failed to parse config file: %s", err)
+	}
+
+	providerSpec, ok := configFile.Provider[resourcePackage.ProviderType]
+	if !ok {
+		return entities.ProviderRegistrationPackage{}, fmt.Errorf("The config file of resource '%s' has no %s provider", resourcePackage.ResourceID, resourcePackage.ProviderType)
+	}
+
+	return entities.ProviderRegistrationPackage{
+		ResourceID:   resourcePackage.ResourceID,
+		ProviderType: resourcePackage.ProviderType,
+		Settings:     providerSpec,
+	}, nil
+}
+
+// getErrorMessage returns the message of an error response followed by the messages of its details
+func getErrorMessage(errorResponse *apierror.ErrorResponse) string {
+	messages := []string{errorResponse.Body.Message}
+	for _, detail := range errorResponse.Body.Details {
+		if len(detail.Target) > 0 {
+			messages = append(messages, fmt.Sprintf("%s: %s", detail.Target, detail.Message))
+		} else {
+			messages = append(messages, detail.Message)
+		}
+	}
+
+	return strings.Join(messages, " ")
+}
+
+func getConfigFileInJSON(providerType string, providerSpec []byte, resourceType string, resourceName string, resourceSpec []byte) string {
+	return fmt.Sprintf(`
+		{
+			"provider": {
+				"%s": %s
+			},
+			"resource": {
+				"%s": {
+					"%s": %s
+				}
+			}
+		}
+`, providerType, string(providerSpec), resourceType, resourceName, string(resourceSpec))
 }
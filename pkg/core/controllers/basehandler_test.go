@@ -0,0 +1,40 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package controllers
+
+import (
+	"TFRP/pkg/core/entities"
+	"testing"
+)
+
+func TestGetLegacyProviderRegistration(t *testing.T) {
+	resourcePackage := &entities.ResourcePackage{
+		ResourceID:   "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.TerraformOSS/resources/dns",
+		ResourceType: "cloudflare_record",
+		ProviderType: "cloudflare",
+		Config:       getConfigFileInJSON("cloudflare", []byte(`{"email":"admin@contoso.com","token":"secret"}`), "cloudflare_record", "dns", []byte(`{"name":"www"}`)),
+	}
+
+	providerRegistrationPackage, err := getLegacyProviderRegistration(resourcePackage)
+	if err != nil {
+		t.Fatalf("expected the legacy provider registration to be found, actual error %s", err)
+	}
+	if providerRegistrationPackage.ProviderType != "cloudflare" || providerRegistrationPackage.ResourceID != resourcePackage.ResourceID {
+		t.Fatalf("expected a cloudflare registration identified by the resource, actual %s %s", providerRegistrationPackage.ProviderType, providerRegistrationPackage.ResourceID)
+	}
+	if string(providerRegistrationPackage.Settings) != `{"email":"admin@contoso.com","token":"secret"}` {
+		t.Fatalf("expected the provider settings of the config file, actual %s", providerRegistrationPackage.Settings)
+	}
+
+	resourceName, resourceSpec, err := getLegacyResourceSettings(resourcePackage)
+	if err != nil || resourceName != "dns" || string(resourceSpec) != `{"name":"www"}` {
+		t.Fatalf("expected the resource settings of the config file, actual %s %s %v", resourceName, resourceSpec, err)
+	}
+
+	resourcePackage.ProviderType = "datadog"
+	if _, err := getLegacyProviderRegistration(resourcePackage); err == nil {
+		t.Fatalf("expected a config file without a provider of the resource to fail")
+	}
+}
@@ -0,0 +1,286 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package controllers
+
+import (
+	"TFRP/pkg/core/apierror"
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/engines"
+	"TFRP/pkg/core/entities"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+// GetDeletedResourcesController returns the soft deleted resources of a resource group
+func (resourceManager *ResourceManager) GetDeletedResourcesController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	deletedResourcePackages := []entities.DeletedResourcePackage{}
+	err := resourceManager.DeletedResourceDataProvider.FindPackagesByResourceIDPrefix(ctx, engines.GetFullyQualifiedResourceIDPrefix(request), &deletedResourcePackages)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to find data: %s", err))
+		return
+	}
+
+	deletedResourcePackageListDefinition := entities.DeletedResourcePackageListDefinition{
+		Value: make([]entities.DeletedResourcePackageDefinition, 0, len(deletedResourcePackages)),
+	}
+	for i := range deletedResourcePackages {
+		deletedResourcePackageListDefinition.Value = append(deletedResourcePackageListDefinition.Value, *deletedResourcePackages[i].ToDefinition())
+	}
+
+	writeDeletedResourceResponse(response, &deletedResourcePackageListDefinition)
+}
+
+// GetDeletedResourceController returns a soft deleted resource
+func (resourceManager *ResourceManager) GetDeletedResourceController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	fullyQualifiedResourceID := engines.GetFullyQualifiedResourceID(request)
+
+	deletedResourcePackage := entities.DeletedResourcePackage{}
+	err := resourceManager.DeletedResourceDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &deletedResourcePackage)
+	if err != nil {
+		writeDeletedResourceNotFound(response, fullyQualifiedResourceID)
+		return
+	}
+
+	writeDeletedResourceResponse(response, deletedResourcePackage.ToDefinition())
+}
+
+// PurgeDeletedResourceController destroys a soft deleted resource before its retention expires
+func (resourceManager *ResourceManager) PurgeDeletedResourceController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	fullyQualifiedResourceID := engines.GetFullyQualifiedResourceID(request)
+
+	deletedResourcePackage := entities.DeletedResourcePackage{}
+	err := resourceManager.DeletedResourceDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &deletedResourcePackage)
+	if err != nil {
+		writeDeletedResourceNotFound(response, fullyQualifiedResourceID)
+		return
+	}
+
+	if retryAfter, throttled := resourceManager.ThrottlingEngine.TakeResourceProviderRegistration(deletedResourcePackage.ProviderID, deletedResourcePackage.ResourceID, engines.ThrottlingKindWrite); throttled {
+		engines.WriteThrottledResponse(response, engines.ThrottlingScopeProviderRegistration, retryAfter)
+		return
+	}
+
+	statusCode, errorResponse := resourceManager.purgeDeletedResource(ctx, resourceManager.OperationEngine, resourceManager.LockEngine, &deletedResourcePackage, true)
+	if errorResponse != nil {
+		apierror.WriteErrorToResponseWitAPIError(
+			response,
+			statusCode,
+			errorResponse)
+		return
+	}
+
+	response.WriteHeader(http.StatusOK)
+}
+
+// PostRestoreDeletedResourceController restores a soft deleted resource, the resource is adopted again if it still
+// exists and recreated from its stored definition otherwise
+func (resourceManager *ResourceManager) PostRestoreDeletedResourceController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	fullyQualifiedResourceID := engines.GetFullyQualifiedResourceID(request)
+
+	deletedResourcePackage := entities.DeletedResourcePackage{}
+	err := resourceManager.DeletedResourceDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &deletedResourcePackage)
+	if err != nil {
+		writeDeletedResourceNotFound(response, fullyQualifiedResourceID)
+		return
+	}
+
+	if resourceManager.ResourceDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &entities.ResourcePackage{}) == nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusConflict,
+			apierror.ClientError,
+			apierror.Conflict,
+			fmt.Sprintf("Cannot restore Resource with id '%s' as it already exists", fullyQualifiedResourceID))
+		return
+	}
+
+	// A recreated resource is throttled when it is put
+	if deletedResourcePackage.Resource.State != nil {
+		if retryAfter, throttled := resourceManager.ThrottlingEngine.TakeResourceProviderRegistration(deletedResourcePackage.ProviderID, deletedResourcePackage.ResourceID, engines.ThrottlingKindWrite); throttled {
+			engines.WriteThrottledResponse(response, engines.ThrottlingScopeProviderRegistration, retryAfter)
+			return
+		}
+
+		resourcePackage, statusCode, errorResponse := resourceManager.adoptDeletedResource(ctx, &deletedResourcePackage)
+		if errorResponse != nil {
+			apierror.WriteErrorToResponseWitAPIError(
+				response,
+				statusCode,
+				errorResponse)
+			return
+		}
+
+		if resourcePackage != nil {
+			writeDeletedResourceResponse(response, resourcePackage.ToDefinition())
+			return
+		}
+
+		// The resource was destroyed out of band while it was deleted
+	}
+
+	resourceSettings := deletedResourcePackage.Resource.Settings
+	if len(resourceSettings) == 0 {
+		_, resourceSettings, err = getLegacyResourceSettings(&deletedResourcePackage.Resource)
+		if err != nil {
+			apierror.WriteErrorToResponse(
+				response,
+				http.StatusBadRequest,
+				apierror.ClientError,
+				apierror.BadRequest,
+				err.Error())
+			return
+		}
+	}
+
+	// The restore is an action on the resource, the recreation is accepted rather than created
+	resourceManager.putResource(request, response, &entities.ResourceDefinition{
+		Location: deletedResourcePackage.Resource.Location,
+		Tags:     deletedResourcePackage.Resource.Tags,
+		Properties: &entities.ResourceDefinitionProperties{
+			ProviderID:   deletedResourcePackage.Resource.ProviderID,
+			ResourceType: deletedResourcePackage.Resource.ResourceType,
+			Settings:     json.RawMessage(resourceSettings),
+		},
+	}, http.StatusAccepted, nil)
+
+	// The recreated resource keeps its definition, the deleted resource has no infrastructure left to purge
+	if response.StatusCode() < http.StatusMultipleChoices {
+		err = resourceManager.DeletedResourceDataProvider.RemovePackage(ctx, fullyQualifiedResourceID)
+		if err != nil {
+			fmt.Printf("Failed to delete deleted resource '%s' from storage: %s", fullyQualifiedResourceID, err)
+		}
+	}
+}
+
+// adoptDeletedResource refreshes the state of a soft deleted resource and stores it as a resource again,
+// it returns nil if the infrastructure of the resource no longer exists
+func (resourceManager *ResourceManager) adoptDeletedResource(ctx context.Context, deletedResourcePackage *entities.DeletedResourcePackage) (*entities.ResourcePackage, int, *apierror.ErrorResponse) {
+	lock, err := resourceManager.LockEngine.AcquireResourceLock(ctx, deletedResourcePackage.ResourceID)
+	if err == engines.ErrLockHeld {
+		return nil, http.StatusConflict, apierror.New(
+			apierror.ClientError,
+			apierror.Conflict,
+			fmt.Sprintf("Cannot restore Resource with id '%s' as it is being modified by another request", deletedResourcePackage.ResourceID))
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, apierror.New(
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to lock resource '%s': %s", deletedResourcePackage.ResourceID, err))
+	}
+
+	defer lock.Release()
+
+	// The resource may have been purged or restored before it was locked
+	err = resourceManager.DeletedResourceDataProvider.FindPackage(ctx, deletedResourcePackage.ResourceID, deletedResourcePackage)
+	if err != nil {
+		return nil, http.StatusNotFound, apierror.New(
+			apierror.ClientError,
+			apierror.NotFound,
+			fmt.Sprintf("Deleted resource with id '%s' was not found", deletedResourcePackage.ResourceID))
+	}
+
+	refreshCtx, cancel := engines.WithSynchronousRetryBudget(ctx)
+	defer cancel()
+
+	resourcePackage := deletedResourcePackage.Resource
+	resourceState, statusCode, errorResponse := resourceManager.refreshResourceState(refreshCtx, &resourcePackage)
+	if errorResponse != nil {
+		return nil, statusCode, errorResponse
+	}
+
+	if resourceState == nil {
+		return nil, http.StatusOK, nil
+	}
+
+	resourcePackage.StateID = resourceState.ID
+	resourcePackage.State = resourceState
+	resourcePackage.LastRefreshedAt = engines.GetRefreshTime()
+	resourcePackage.ProvisioningState = consts.ProvisioningStateSucceeded
+	resourcePackage.ProvisioningErrorCode = ""
+	resourcePackage.ProvisioningErrorDetailCode = ""
+	resourcePackage.ProvisioningErrorMessage = ""
+	resourcePackage.Resumable = false
+	err = resourceManager.ResourceDataProvider.InsertFencedPackage(ctx, &resourcePackage, lock.FencingToken())
+	if err != nil {
+		return nil, http.StatusInternalServerError, apierror.New(
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to insert data: %s", err))
+	}
+
+	err = resourceManager.DeletedResourceDataProvider.RemovePackage(ctx, deletedResourcePackage.ResourceID)
+	if err != nil {
+		// Keep the resource deleted rather than both live and deleted
+		resourceManager.ResourceDataProvider.RemovePackage(ctx, resourcePackage.ResourceID)
+		return nil, http.StatusInternalServerError, apierror.New(
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to delete deleted resource '%s' from storage: %s", deletedResourcePackage.ResourceID, err))
+	}
+
+	resourceManager.EventEngine.PublishResourceEvent(ctx, consts.EventTypeResourceCreated, &resourcePackage)
+	return &resourcePackage, http.StatusOK, nil
+}
+
+// PurgeDeletedResources destroys the soft deleted resources whose retention expired
+func (resourceManager *ResourceManager) PurgeDeletedResources(ctx context.Context) {
+	deletedResourcePackages := []entities.DeletedResourcePackage{}
+	err := resourceManager.DeletedResourceDataProvider.FindExpiredPackages(ctx, time.Now().UTC(), &deletedResourcePackages)
+	if err != nil {
+		log.Printf("Failed to find expired deleted resources: %s", err)
+		return
+	}
+
+	for i := range deletedResourcePackages {
+		// A resource which fails to be purged is purged again on the next run
+		_, errorResponse := resourceManager.purgeDeletedResource(ctx, resourceManager.OperationEngine, resourceManager.LockEngine, &deletedResourcePackages[i], false)
+		if errorResponse != nil {
+			log.Printf("Failed to purge deleted resource '%s': %s", deletedResourcePackages[i].ResourceID, getErrorMessage(errorResponse))
+		}
+	}
+}
+
+// writeDeletedResourceNotFound writes a 404 for a soft deleted resource
+func writeDeletedResourceNotFound(response *restful.Response, resourceID string) {
+	apierror.WriteErrorToResponse(
+		response,
+		http.StatusNotFound,
+		apierror.ClientError,
+		apierror.NotFound,
+		fmt.Sprintf("Deleted resource with id '%s' was not found", resourceID))
+}
+
+// writeDeletedResourceResponse writes a definition as the content of a response
+func writeDeletedResourceResponse(response *restful.Response, definition interface{}) {
+	responseContent, err := json.Marshal(definition)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to serialize response content: %s", err))
+		return
+	}
+
+	response.Header().Set(restful.HEADER_ContentType, restful.MIME_JSON)
+	response.Write(responseContent)
+}
@@ -0,0 +1,49 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package controllers
+
+import (
+	"TFRP/pkg/core/apierror"
+	"TFRP/pkg/core/engines"
+	"TFRP/pkg/core/entities"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+// GetOperationsController returns the operations of the resource provider, built from the registered routes
+func GetOperationsController(request *restful.Request, response *restful.Response) {
+	writeDiscoveryResponse(response, &entities.OperationListResult{
+		Value: engines.GetOperations(restful.RegisteredWebServices()),
+	})
+}
+
+// GetLocationsController returns the locations resources can be created in, an empty list allows any location
+func GetLocationsController(request *restful.Request, response *restful.Response) {
+	locations := []string{}
+	locations = append(locations, engines.AllowedLocations...)
+
+	writeDiscoveryResponse(response, &entities.LocationListResult{
+		Value: locations,
+	})
+}
+
+func writeDiscoveryResponse(response *restful.Response, result interface{}) {
+	responseContent, err := json.Marshal(result)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to serialize response content: %s", err))
+		return
+	}
+
+	response.Header().Set(restful.HEADER_ContentType, restful.MIME_JSON)
+	response.Write(responseContent)
+}
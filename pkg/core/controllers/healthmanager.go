@@ -0,0 +1,122 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package controllers
+
+import (
+	"TFRP/pkg/core/apierror"
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/engines"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+// HealthManager serves the health and readiness probes and rejects writes while the service shuts down
+type HealthManager struct {
+	lock            sync.Mutex
+	readinessChecks []readinessCheck
+	draining        int32
+}
+
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// ReadinessResult is the result of the readiness probe
+type ReadinessResult struct {
+	Ready  bool
+	Checks map[string]string
+}
+
+// NewHealthManager creates a new health manager
+func NewHealthManager() (healthManager *HealthManager) {
+	healthManager = new(HealthManager)
+	return healthManager
+}
+
+// AddReadinessCheck adds a check which must pass for the service to be ready
+func (healthManager *HealthManager) AddReadinessCheck(name string, check func() error) {
+	healthManager.lock.Lock()
+	defer healthManager.lock.Unlock()
+
+	healthManager.readinessChecks = append(healthManager.readinessChecks, readinessCheck{name: name, check: check})
+}
+
+// StartDraining makes the service reject writes and report not ready
+func (healthManager *HealthManager) StartDraining() {
+	atomic.StoreInt32(&healthManager.draining, 1)
+}
+
+// IsDraining returns whether the service is shutting down
+func (healthManager *HealthManager) IsDraining() bool {
+	return atomic.LoadInt32(&healthManager.draining) == 1
+}
+
+// Filter is the go-restful filter rejecting writes while the service shuts down
+func (healthManager *HealthManager) Filter(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	if healthManager.IsDraining() && engines.GetThrottlingKind(request) == engines.ThrottlingKindWrite {
+		response.Header().Set(consts.RetryAfterHeader, consts.ShutdownRetryAfterSeconds)
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusServiceUnavailable,
+			apierror.InternalError,
+			apierror.OperationNotAllowed,
+			"The service is shutting down and does not accept writes.")
+		return
+	}
+
+	chain.ProcessFilter(request, response)
+}
+
+// GetHealthController returns a 200 while the process is alive
+func (healthManager *HealthManager) GetHealthController(request *restful.Request, response *restful.Response) {
+	response.WriteHeader(http.StatusOK)
+}
+
+// GetReadinessController returns a 200 when the service can serve requests, otherwise a 503
+func (healthManager *HealthManager) GetReadinessController(request *restful.Request, response *restful.Response) {
+	healthManager.lock.Lock()
+	readinessChecks := healthManager.readinessChecks
+	healthManager.lock.Unlock()
+
+	readinessResult := ReadinessResult{
+		Ready:  !healthManager.IsDraining(),
+		Checks: make(map[string]string),
+	}
+	if healthManager.IsDraining() {
+		readinessResult.Checks["shutdown"] = "The service is shutting down"
+	}
+
+	for _, readinessCheck := range readinessChecks {
+		if err := readinessCheck.check(); err != nil {
+			readinessResult.Ready = false
+			readinessResult.Checks[readinessCheck.name] = err.Error()
+		} else {
+			readinessResult.Checks[readinessCheck.name] = "OK"
+		}
+	}
+
+	responseContent, err := json.Marshal(readinessResult)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to serialize response content: %s", err))
+		return
+	}
+
+	response.Header().Set(restful.HEADER_ContentType, restful.MIME_JSON)
+	if !readinessResult.Ready {
+		response.WriteHeader(http.StatusServiceUnavailable)
+	}
+	response.Write(responseContent)
+}
@@ -0,0 +1,515 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package controllers
+
+import (
+	"TFRP/pkg/core/apierror"
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/engines"
+	"TFRP/pkg/core/entities"
+	"TFRP/pkg/core/storage"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// movePlan is the documents a move rewrites, the resources referencing a moved provider registration
+// are rewritten to its new id even if they do not move
+type movePlan struct {
+	resourceMoves             []entities.ResourcePackageMove
+	providerRegistrationMoves []entities.ProviderRegistrationPackageMove
+	referencingResources      []entities.ResourcePackage
+	providerIDs               map[string]string
+}
+
+// PostValidateMoveResourcesController validates that resources can be moved to another resource group
+func (resourceManager *ResourceManager) PostValidateMoveResourcesController(request *restful.Request, response *restful.Response) {
+	moveResourcesDefinition, ok := readMoveResourcesDefinition(request, response)
+	if !ok {
+		return
+	}
+
+	_, statusCode, errorResponse := resourceManager.planMove(request, moveResourcesDefinition)
+	if errorResponse != nil {
+		apierror.WriteErrorToResponseWitAPIError(
+			response,
+			statusCode,
+			errorResponse)
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// PostMoveResourcesController moves resources to another resource group, the stored documents are rewritten to the new ids
+// under the locks of the old and new ids and the rewrite is undone if it fails
+func (resourceManager *ResourceManager) PostMoveResourcesController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	moveResourcesDefinition, ok := readMoveResourcesDefinition(request, response)
+	if !ok {
+		return
+	}
+
+	plan, statusCode, errorResponse := resourceManager.planMove(request, moveResourcesDefinition)
+	if errorResponse != nil {
+		apierror.WriteErrorToResponseWitAPIError(
+			response,
+			statusCode,
+			errorResponse)
+		return
+	}
+
+	locks := make(map[string]*engines.Lock)
+	defer func() {
+		for _, lock := range locks {
+			lock.Release()
+		}
+	}()
+
+	// Locks are taken in order so that concurrent moves of overlapping resources do not lock each other out in turns
+	lockIDs := plan.getLockIDs()
+	for _, lockID := range lockIDs {
+		lock, err := resourceManager.LockEngine.AcquireResourceLock(ctx, lockID)
+		if err != nil {
+			writeLockErrorToResponse(response, lockID, err)
+			return
+		}
+		locks[lockID] = lock
+	}
+
+	// The resources may have changed before the locks were acquired
+	plan, statusCode, errorResponse = resourceManager.planMove(request, moveResourcesDefinition)
+	if errorResponse != nil {
+		apierror.WriteErrorToResponseWitAPIError(
+			response,
+			statusCode,
+			errorResponse)
+		return
+	}
+	for _, lockID := range plan.getLockIDs() {
+		if _, ok := locks[lockID]; !ok {
+			apierror.WriteErrorToResponse(
+				response,
+				http.StatusConflict,
+				apierror.ClientError,
+				apierror.Conflict,
+				fmt.Sprintf("Cannot move the resources as Resource with id '%s' was modified by another request", lockID))
+			return
+		}
+	}
+
+	err := resourceManager.moveResources(ctx, plan, locks)
+	if err == storage.ErrStaleFencingToken {
+		writeLockErrorToResponse(response, moveResourcesDefinition.TargetResourceGroup, err)
+		return
+	}
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to move resources: %s", err))
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// planMove validates a move and returns the documents it rewrites
+func (resourceManager *ResourceManager) planMove(request *restful.Request, moveResourcesDefinition *entities.MoveResourcesDefinition) (*movePlan, int, *apierror.ErrorResponse) {
+	ctx := request.Request.Context()
+	sourceResourceGroupID := engines.GetFullyQualifiedResourceGroupID(request)
+	resourceMoves, validationError := engines.GetResourceMoves(sourceResourceGroupID, moveResourcesDefinition)
+	if validationError != nil {
+		return nil, http.StatusBadRequest, validationError
+	}
+
+	crossSubscription := engines.IsCrossSubscriptionMove(sourceResourceGroupID, moveResourcesDefinition.TargetResourceGroup)
+	plan := &movePlan{
+		providerIDs: make(map[string]string),
+	}
+	movedResourceIDs := make(map[string]bool)
+	details := []apierror.Error{}
+
+	for i, resourceMove := range resourceMoves {
+		target := fmt.Sprintf("resources[%d]", i)
+		if resourceMove.IsProviderRegistration {
+			providerRegistrationPackage := entities.ProviderRegistrationPackage{}
+			err := resourceManager.ProviderRegistrationDataProvider.FindPackage(ctx, resourceMove.SourceID, &providerRegistrationPackage)
+			if err != nil {
+				details = append(details, apierror.NewDetail(apierror.NotFound, target, fmt.Sprintf("The provider registration '%s' was not found.", resourceMove.SourceID)))
+				continue
+			}
+			if resourceManager.ProviderRegistrationDataProvider.FindPackage(ctx, resourceMove.TargetID, &entities.ProviderRegistrationPackage{}) == nil {
+				details = append(details, apierror.NewDetail(apierror.Conflict, target, fmt.Sprintf("The provider registration '%s' already exists.", resourceMove.TargetID)))
+				continue
+			}
+
+			// The deleted resources are purged with the provider registration they reference
+			deletedResourcePackages := []entities.DeletedResourcePackage{}
+			err = resourceManager.DeletedResourceDataProvider.FindPackagesByProviderID(ctx, providerRegistrationPackage.ResourceID, &deletedResourcePackages)
+			if err != nil {
+				return nil, http.StatusInternalServerError, apierror.New(
+					apierror.InternalError,
+					apierror.InternalOperationError,
+					fmt.Sprintf("Failed to find data: %s", err))
+			}
+			if len(deletedResourcePackages) > 0 {
+				details = append(details, apierror.NewDetail(apierror.Conflict, target, fmt.Sprintf("The provider registration '%s' is referenced by %d deleted resources, e.g. '%s'.",
+					resourceMove.SourceID, len(deletedResourcePackages), deletedResourcePackages[0].ResourceID)))
+				continue
+			}
+
+			// The journal keeps the settings encrypted as they are stored
+			journaledPackage, err := resourceManager.ProviderRegistrationDataProvider.EncryptPackage(&providerRegistrationPackage)
+			if err != nil {
+				return nil, http.StatusInternalServerError, apierror.New(
+					apierror.InternalError,
+					apierror.InternalOperationError,
+					err.Error())
+			}
+
+			plan.providerRegistrationMoves = append(plan.providerRegistrationMoves, entities.ProviderRegistrationPackageMove{
+				ProviderRegistrationPackage: *journaledPackage,
+				TargetID:                    resourceMove.TargetID,
+			})
+			plan.providerIDs[strings.ToLower(providerRegistrationPackage.ResourceID)] = resourceMove.TargetID
+			continue
+		}
+
+		resourcePackage := entities.ResourcePackage{}
+		err := resourceManager.ResourceDataProvider.FindPackage(ctx, resourceMove.SourceID, &resourcePackage)
+		if err != nil {
+			details = append(details, apierror.NewDetail(apierror.NotFound, target, fmt.Sprintf("The resource '%s' was not found.", resourceMove.SourceID)))
+			continue
+		}
+		if strings.EqualFold(resourcePackage.ProvisioningState, consts.ProvisioningStateAccepted) || resourceManager.OperationEngine.IsInFlight(resourcePackage.ResourceID) {
+			details = append(details, apierror.NewDetail(apierror.Conflict, target, fmt.Sprintf("The resource '%s' is being provisioned.", resourceMove.SourceID)))
+			continue
+		}
+		if resourceManager.ResourceDataProvider.FindPackage(ctx, resourceMove.TargetID, &entities.ResourcePackage{}) == nil {
+			details = append(details, apierror.NewDetail(apierror.Conflict, target, fmt.Sprintf("The resource '%s' already exists.", resourceMove.TargetID)))
+			continue
+		}
+		if resourceManager.DeletedResourceDataProvider.FindPackage(ctx, resourceMove.TargetID, &entities.DeletedResourcePackage{}) == nil {
+			details = append(details, apierror.NewDetail(apierror.Conflict, target, fmt.Sprintf("The resource '%s' was deleted and is retained.", resourceMove.TargetID)))
+			continue
+		}
+
+		plan.resourceMoves = append(plan.resourceMoves, entities.ResourcePackageMove{
+			ResourcePackage: resourcePackage,
+			TargetID:        resourceMove.TargetID,
+		})
+		movedResourceIDs[strings.ToLower(resourcePackage.ResourceID)] = true
+	}
+
+	// The provider registrations of the moved resources must move along or stay reachable, a registration of
+	// another subscription is not
+	for _, resourceMove := range plan.resourceMoves {
+		providerID := resourceMove.ResourcePackage.ProviderID
+		// Resources created before they referenced a provider registration carry the provider settings of their config file
+		if len(providerID) == 0 {
+			continue
+		}
+		if _, ok := plan.providerIDs[strings.ToLower(providerID)]; ok {
+			continue
+		}
+		if crossSubscription {
+			details = append(details, apierror.NewDetail(
+				apierror.Conflict,
+				resourceMove.ResourcePackage.ResourceID,
+				fmt.Sprintf("The resource '%s' references the provider registration '%s', which must be moved to the other subscription too.", resourceMove.ResourcePackage.ResourceID, providerID)))
+			continue
+		}
+		if resourceManager.ProviderRegistrationDataProvider.FindPackage(ctx, providerID, &entities.ProviderRegistrationPackage{}) != nil {
+			details = append(details, apierror.NewDetail(
+				apierror.NotFound,
+				resourceMove.ResourcePackage.ResourceID,
+				fmt.Sprintf("The resource '%s' references the provider registration '%s', which was not found.", resourceMove.ResourcePackage.ResourceID, providerID)))
+		}
+	}
+
+	// The resources which reference a moved provider registration but do not move are rewritten to its new id
+	for _, providerRegistrationMove := range plan.providerRegistrationMoves {
+		resourcePackages := []entities.ResourcePackage{}
+		err := resourceManager.ResourceDataProvider.FindPackagesByProviderID(ctx, providerRegistrationMove.ProviderRegistrationPackage.ResourceID, &resourcePackages)
+		if err != nil {
+			return nil, http.StatusInternalServerError, apierror.New(
+				apierror.InternalError,
+				apierror.InternalOperationError,
+				fmt.Sprintf("Failed to find data: %s", err))
+		}
+
+		for _, resourcePackage := range resourcePackages {
+			if movedResourceIDs[strings.ToLower(resourcePackage.ResourceID)] {
+				continue
+			}
+			if crossSubscription {
+				details = append(details, apierror.NewDetail(
+					apierror.Conflict,
+					resourcePackage.ResourceID,
+					fmt.Sprintf("The provider registration '%s' is referenced by the resource '%s', which must be moved to the other subscription too.",
+						providerRegistrationMove.ProviderRegistrationPackage.ResourceID, resourcePackage.ResourceID)))
+				continue
+			}
+			if strings.EqualFold(resourcePackage.ProvisioningState, consts.ProvisioningStateAccepted) || resourceManager.OperationEngine.IsInFlight(resourcePackage.ResourceID) {
+				details = append(details, apierror.NewDetail(
+					apierror.Conflict,
+					resourcePackage.ResourceID,
+					fmt.Sprintf("The provider registration '%s' is referenced by the resource '%s', which is being provisioned.",
+						providerRegistrationMove.ProviderRegistrationPackage.ResourceID, resourcePackage.ResourceID)))
+				continue
+			}
+			plan.referencingResources = append(plan.referencingResources, resourcePackage)
+		}
+	}
+
+	if len(details) > 0 {
+		return nil, http.StatusConflict, engines.NewMoveResourcesError(details)
+	}
+
+	return plan, http.StatusOK, nil
+}
+
+// moveResources rewrites the documents of a move, the steps which were applied are undone in reverse order
+// if a step fails so that the resources are either all moved or all left in place. The move is journaled before
+// the documents are rewritten, a move which could not be undone is undone from its journal by the leader.
+func (resourceManager *ResourceManager) moveResources(ctx context.Context, plan *movePlan, locks map[string]*engines.Lock) (err error) {
+	moveJournalPackage := &entities.MoveJournalPackage{
+		LockIDs:                   plan.getLockIDs(),
+		ProviderRegistrationMoves: plan.providerRegistrationMoves,
+		ResourceMoves:             plan.resourceMoves,
+		ReferencingResources:      plan.referencingResources,
+		CreatedTime:               time.Now().UTC(),
+	}
+	if err = resourceManager.MoveJournalDataProvider.InsertPackage(ctx, moveJournalPackage); err != nil {
+		return err
+	}
+
+	undos := []func() error{}
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(undos) - 1; i >= 0; i-- {
+			if undoErr := undos[i](); undoErr != nil {
+				log.Printf("Failed to undo the move of resources, the move is undone from journal '%s' later: %s", moveJournalPackage.ID.Hex(), undoErr)
+				return
+			}
+		}
+		if removeErr := resourceManager.MoveJournalDataProvider.RemovePackage(ctx, moveJournalPackage.ID); removeErr != nil {
+			log.Printf("Failed to delete move journal '%s' from storage: %s", moveJournalPackage.ID.Hex(), removeErr)
+		}
+	}()
+
+	for _, providerRegistrationMove := range plan.providerRegistrationMoves {
+		movedPackage := providerRegistrationMove.ProviderRegistrationPackage
+		movedPackage.ID = ""
+		movedPackage.ResourceID = providerRegistrationMove.TargetID
+		if err = resourceManager.ProviderRegistrationDataProvider.InsertPackage(ctx, &movedPackage); err != nil {
+			return err
+		}
+		targetID := providerRegistrationMove.TargetID
+		undos = append(undos, func() error {
+			return resourceManager.ProviderRegistrationDataProvider.RemovePackage(ctx, targetID)
+		})
+	}
+
+	for _, resourceMove := range plan.resourceMoves {
+		movedPackage := resourceMove.ResourcePackage
+		movedPackage.ID = ""
+		movedPackage.ResourceID = resourceMove.TargetID
+		if providerID, ok := plan.providerIDs[strings.ToLower(movedPackage.ProviderID)]; ok {
+			movedPackage.ProviderID = providerID
+		}
+		if err = resourceManager.ResourceDataProvider.InsertFencedPackage(ctx, &movedPackage, locks[strings.ToLower(resourceMove.TargetID)].FencingToken()); err != nil {
+			return err
+		}
+		targetID := resourceMove.TargetID
+		undos = append(undos, func() error {
+			return resourceManager.ResourceDataProvider.RemovePackage(ctx, targetID)
+		})
+	}
+
+	for _, resourcePackage := range plan.referencingResources {
+		originalPackage := resourcePackage
+		fencingToken := locks[strings.ToLower(resourcePackage.ResourceID)].FencingToken()
+		resourcePackage.ProviderID = plan.providerIDs[strings.ToLower(resourcePackage.ProviderID)]
+		if err = resourceManager.ResourceDataProvider.InsertFencedPackage(ctx, &resourcePackage, fencingToken); err != nil {
+			return err
+		}
+		undos = append(undos, func() error {
+			return resourceManager.ResourceDataProvider.InsertFencedPackage(ctx, &originalPackage, fencingToken)
+		})
+	}
+
+	// The old documents are removed last, the operation status of a resource is looked up by its id
+	for _, resourceMove := range plan.resourceMoves {
+		if err = resourceManager.ResourceDataProvider.RemovePackage(ctx, resourceMove.ResourcePackage.ResourceID); err != nil {
+			return err
+		}
+		originalPackage := resourceMove.ResourcePackage
+		fencingToken := locks[strings.ToLower(originalPackage.ResourceID)].FencingToken()
+		undos = append(undos, func() error {
+			return resourceManager.ResourceDataProvider.InsertFencedPackage(ctx, &originalPackage, fencingToken)
+		})
+	}
+
+	for _, providerRegistrationMove := range plan.providerRegistrationMoves {
+		if err = resourceManager.ProviderRegistrationDataProvider.RemovePackage(ctx, providerRegistrationMove.ProviderRegistrationPackage.ResourceID); err != nil {
+			return err
+		}
+		originalPackage := providerRegistrationMove.ProviderRegistrationPackage
+		undos = append(undos, func() error {
+			return resourceManager.ProviderRegistrationDataProvider.InsertPackage(ctx, &originalPackage)
+		})
+	}
+
+	// The move is complete once its journal is removed, a journal left behind would undo the move
+	return resourceManager.MoveJournalDataProvider.RemovePackage(ctx, moveJournalPackage.ID)
+}
+
+// RecoverInterruptedMoves undoes the moves left journaled by a replica which stopped before completing or undoing them,
+// a move is interrupted when nobody holds the locks it took
+func (resourceManager *ResourceManager) RecoverInterruptedMoves(ctx context.Context) {
+	moveJournalPackages := []entities.MoveJournalPackage{}
+	err := resourceManager.MoveJournalDataProvider.FindAllPackages(ctx, &moveJournalPackages)
+	if err != nil {
+		log.Printf("Failed to find move journals: %s", err)
+		return
+	}
+
+	for _, moveJournalPackage := range moveJournalPackages {
+		resourceManager.recoverInterruptedMove(ctx, moveJournalPackage.ID, moveJournalPackage.LockIDs)
+	}
+}
+
+func (resourceManager *ResourceManager) recoverInterruptedMove(ctx context.Context, moveJournalID bson.ObjectId, lockIDs []string) {
+	locks := make(map[string]*engines.Lock)
+	defer func() {
+		for _, lock := range locks {
+			lock.Release()
+		}
+	}()
+
+	for _, lockID := range lockIDs {
+		lock, err := resourceManager.LockEngine.AcquireResourceLock(ctx, lockID)
+		if err != nil {
+			// The move is still running
+			return
+		}
+		locks[lockID] = lock
+	}
+
+	// The move may have completed since the journals were listed
+	moveJournalPackage := entities.MoveJournalPackage{}
+	if err := resourceManager.MoveJournalDataProvider.FindPackage(ctx, moveJournalID, &moveJournalPackage); err != nil {
+		return
+	}
+
+	if err := resourceManager.undoMove(ctx, &moveJournalPackage, locks); err != nil {
+		log.Printf("Failed to undo the move of journal '%s': %s", moveJournalID.Hex(), err)
+		return
+	}
+
+	if err := resourceManager.MoveJournalDataProvider.RemovePackage(ctx, moveJournalID); err != nil {
+		log.Printf("Failed to delete move journal '%s' from storage: %s", moveJournalID.Hex(), err)
+	}
+}
+
+// undoMove puts the documents of a journaled move back as they were before the move, whichever steps of the move
+// were applied, the original documents are restored before the moved documents are removed
+func (resourceManager *ResourceManager) undoMove(ctx context.Context, moveJournalPackage *entities.MoveJournalPackage, locks map[string]*engines.Lock) error {
+	for _, providerRegistrationMove := range moveJournalPackage.ProviderRegistrationMoves {
+		originalPackage := providerRegistrationMove.ProviderRegistrationPackage
+		if err := resourceManager.ProviderRegistrationDataProvider.InsertPackage(ctx, &originalPackage); err != nil {
+			return err
+		}
+	}
+
+	for _, resourcePackage := range moveJournalPackage.ReferencingResources {
+		originalPackage := resourcePackage
+		if err := resourceManager.ResourceDataProvider.InsertFencedPackage(ctx, &originalPackage, locks[strings.ToLower(originalPackage.ResourceID)].FencingToken()); err != nil {
+			return err
+		}
+	}
+
+	for _, resourceMove := range moveJournalPackage.ResourceMoves {
+		originalPackage := resourceMove.ResourcePackage
+		if err := resourceManager.ResourceDataProvider.InsertFencedPackage(ctx, &originalPackage, locks[strings.ToLower(originalPackage.ResourceID)].FencingToken()); err != nil {
+			return err
+		}
+	}
+
+	for _, resourceMove := range moveJournalPackage.ResourceMoves {
+		if err := resourceManager.ResourceDataProvider.RemovePackage(ctx, resourceMove.TargetID); err != nil && !storage.IsNotFound(err) {
+			return err
+		}
+	}
+
+	for _, providerRegistrationMove := range moveJournalPackage.ProviderRegistrationMoves {
+		if err := resourceManager.ProviderRegistrationDataProvider.RemovePackage(ctx, providerRegistrationMove.TargetID); err != nil && !storage.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getLockIDs returns the sorted ids a move locks, the old and new ids of the moved documents and the ids of the resources it rewrites
+func (plan *movePlan) getLockIDs() []string {
+	lockIDs := []string{}
+	for _, providerRegistrationMove := range plan.providerRegistrationMoves {
+		lockIDs = append(lockIDs, strings.ToLower(providerRegistrationMove.ProviderRegistrationPackage.ResourceID), strings.ToLower(providerRegistrationMove.TargetID))
+	}
+	for _, resourceMove := range plan.resourceMoves {
+		lockIDs = append(lockIDs, strings.ToLower(resourceMove.ResourcePackage.ResourceID), strings.ToLower(resourceMove.TargetID))
+	}
+	for _, resourcePackage := range plan.referencingResources {
+		lockIDs = append(lockIDs, strings.ToLower(resourcePackage.ResourceID))
+	}
+
+	sort.Strings(lockIDs)
+	return lockIDs
+}
+
+// readMoveResourcesDefinition reads the move content, it writes the error to the response if the content is invalid
+func readMoveResourcesDefinition(request *restful.Request, response *restful.Response) (*entities.MoveResourcesDefinition, bool) {
+	moveResourcesDefinition := entities.MoveResourcesDefinition{}
+
+	rawBody, err := ioutil.ReadAll(request.Request.Body)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusBadRequest,
+			apierror.ClientError,
+			apierror.BadRequest,
+			fmt.Sprintf("Request content is invalid: %s", err))
+		return nil, false
+	}
+
+	err = json.Unmarshal(rawBody, &moveResourcesDefinition)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusBadRequest,
+			apierror.ClientError,
+			apierror.BadRequest,
+			fmt.Sprintf("Request content cannot be deserialized as JSON: %s", err))
+		return nil, false
+	}
+
+	return &moveResourcesDefinition, true
+}
@@ -0,0 +1,43 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package controllers
+
+import (
+	"TFRP/pkg/core/entities"
+	"reflect"
+	"testing"
+)
+
+func TestGetLockIDs(t *testing.T) {
+	plan := &movePlan{
+		resourceMoves: []entities.ResourcePackageMove{
+			{
+				ResourcePackage: entities.ResourcePackage{ResourceID: "/subscriptions/sub/resourceGroups/Source/providers/Microsoft.TerraformOSS/resources/www"},
+				TargetID:        "/subscriptions/sub/resourceGroups/target/providers/Microsoft.TerraformOSS/resources/www",
+			},
+		},
+		providerRegistrationMoves: []entities.ProviderRegistrationPackageMove{
+			{
+				ProviderRegistrationPackage: entities.ProviderRegistrationPackage{ResourceID: "/subscriptions/sub/resourceGroups/source/providers/Microsoft.TerraformOSS/providerregistrations/dns"},
+				TargetID:                    "/subscriptions/sub/resourceGroups/target/providers/Microsoft.TerraformOSS/providerregistrations/dns",
+			},
+		},
+		referencingResources: []entities.ResourcePackage{
+			{ResourceID: "/subscriptions/sub/resourceGroups/source/providers/Microsoft.TerraformOSS/resources/api"},
+		},
+	}
+
+	// The locks are taken in the same order by every move, whatever the case of the ids
+	expectedLockIDs := []string{
+		"/subscriptions/sub/resourcegroups/source/providers/microsoft.terraformoss/providerregistrations/dns",
+		"/subscriptions/sub/resourcegroups/source/providers/microsoft.terraformoss/resources/api",
+		"/subscriptions/sub/resourcegroups/source/providers/microsoft.terraformoss/resources/www",
+		"/subscriptions/sub/resourcegroups/target/providers/microsoft.terraformoss/providerregistrations/dns",
+		"/subscriptions/sub/resourcegroups/target/providers/microsoft.terraformoss/resources/www",
+	}
+	if lockIDs := plan.getLockIDs(); !reflect.DeepEqual(lockIDs, expectedLockIDs) {
+		t.Fatalf("expected the lock ids %v, actual %v", expectedLockIDs, lockIDs)
+	}
+}
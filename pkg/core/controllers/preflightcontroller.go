@@ -0,0 +1,323 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package controllers
+
+import (
+	"TFRP/pkg/core/apierror"
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/engines"
+	"TFRP/pkg/core/entities"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// providerIDTarget is the json path of the provider registration a resource references
+const providerIDTarget = engines.PropertiesTarget + ".providerId"
+
+// PostPreflightController validates the resources of a template deployment before ARM runs it, nothing is provisioned
+// and the errors of all resources are returned at once
+func (resourceManager *ResourceManager) PostPreflightController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	preflightDefinition := entities.PreflightDefinition{}
+
+	rawBody, err := ioutil.ReadAll(request.Request.Body)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusBadRequest,
+			apierror.ClientError,
+			apierror.BadRequest,
+			fmt.Sprintf("Request content is invalid: %s", err))
+		return
+	}
+
+	err = json.Unmarshal(rawBody, &preflightDefinition)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusBadRequest,
+			apierror.ClientError,
+			apierror.BadRequest,
+			fmt.Sprintf("Request content cannot be deserialized as JSON: %s", err))
+		return
+	}
+
+	details := []apierror.Error{}
+
+	// The provider registrations of the template are validated first, as the resources of the template can reference them
+	// before they exist. An invalid registration is nil so that the resources referencing it are not reported again.
+	templateProviderRegistrations := make(map[string]*entities.ProviderRegistrationPackage)
+	providerRegistrationIDPrefix := engines.GetFullyQualifiedProviderRegistrationIDPrefix(request)
+	for i, preflightResource := range preflightDefinition.Resources {
+		if !strings.EqualFold(preflightResource.Type, consts.TerraformProviderRegistrationType) {
+			continue
+		}
+
+		providerRegistrationPackage, statusCode, errorResponse := resourceManager.preflightProviderRegistration(ctx, providerRegistrationIDPrefix, &preflightResource)
+		if errorResponse != nil && statusCode >= http.StatusInternalServerError {
+			apierror.WriteErrorToResponseWitAPIError(response, statusCode, errorResponse)
+			return
+		}
+		if errorResponse != nil {
+			details = append(details, engines.PrefixErrorDetails(fmt.Sprintf("resources[%d]", i), errorResponse)...)
+		}
+		templateProviderRegistrations[strings.ToLower(providerRegistrationIDPrefix+preflightResource.Name)] = providerRegistrationPackage
+	}
+
+	resourceIDPrefix := engines.GetFullyQualifiedResourceIDPrefix(request)
+	for i, preflightResource := range preflightDefinition.Resources {
+		target := fmt.Sprintf("resources[%d]", i)
+		if strings.EqualFold(preflightResource.Type, consts.TerraformProviderRegistrationType) {
+			continue
+		}
+		if !strings.EqualFold(preflightResource.Type, consts.TerraformResourceType) {
+			details = append(details, apierror.NewDetail(
+				apierror.InvalidParameter,
+				target+".type",
+				fmt.Sprintf("The resource type %s is not supported.", preflightResource.Type)))
+			continue
+		}
+
+		statusCode, errorResponse := resourceManager.preflightResource(ctx, resourceIDPrefix, &preflightResource, templateProviderRegistrations)
+		if errorResponse != nil && statusCode >= http.StatusInternalServerError {
+			apierror.WriteErrorToResponseWitAPIError(response, statusCode, errorResponse)
+			return
+		}
+		if errorResponse != nil {
+			details = append(details, engines.PrefixErrorDetails(target, errorResponse)...)
+		}
+	}
+
+	if len(details) > 0 {
+		apierror.WriteErrorToResponseWitAPIError(
+			response,
+			http.StatusBadRequest,
+			engines.NewPreflightError(details))
+		return
+	}
+
+	response.WriteHeader(http.StatusOK)
+}
+
+// preflightProviderRegistration validates a provider registration of a template deployment and checks its credentials
+func (resourceManager *ResourceManager) preflightProviderRegistration(
+	ctx context.Context,
+	providerRegistrationIDPrefix string,
+	preflightResource *entities.PreflightResource) (*entities.ProviderRegistrationPackage, int, *apierror.ErrorResponse) {
+	providerRegistrationDefinition := entities.ProviderRegistrationDefinition{
+		Location: preflightResource.Location,
+		Tags:     preflightResource.Tags,
+	}
+	if len(preflightResource.Properties) > 0 {
+		err := json.Unmarshal(preflightResource.Properties, &providerRegistrationDefinition.Properties)
+		if err != nil {
+			return nil, http.StatusBadRequest, apierror.New(
+				apierror.ClientError,
+				apierror.InvalidParameter,
+				fmt.Sprintf("The properties cannot be deserialized: %s", err))
+		}
+	}
+
+	validationError := engines.ValidateProviderRegistrationDefinition(&providerRegistrationDefinition)
+	if validationError != nil {
+		return nil, http.StatusBadRequest, validationError
+	}
+
+	settings, err := json.Marshal(providerRegistrationDefinition.Properties.Settings)
+	if err != nil {
+		return nil, http.StatusInternalServerError, apierror.New(
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to serialize provider registration settings: %s", err))
+	}
+
+	resolvedSettings, statusCode, settingsError := resourceManager.SecretReferenceEngine.ResolveSettings(ctx, engines.SettingsTarget, settings)
+	if settingsError != nil {
+		return nil, statusCode, settingsError
+	}
+
+	providerType := strings.ToLower(providerRegistrationDefinition.Properties.ProviderType)
+	statusCode, settingsError = engines.CheckProviderSettings(ctx, providerType, resolvedSettings, true)
+	if settingsError != nil {
+		return nil, statusCode, settingsError
+	}
+
+	return &entities.ProviderRegistrationPackage{
+		Location:     providerRegistrationDefinition.Location,
+		Tags:         providerRegistrationDefinition.Tags,
+		ResourceID:   providerRegistrationIDPrefix + preflightResource.Name,
+		ProviderType: providerType,
+		Settings:     settings,
+	}, http.StatusOK, nil
+}
+
+// preflightResource validates a resource of a template deployment against the schema of its provider and computes
+// the diff its deployment would apply, the provider registration is either stored or one of the template
+func (resourceManager *ResourceManager) preflightResource(
+	ctx context.Context,
+	resourceIDPrefix string,
+	preflightResource *entities.PreflightResource,
+	templateProviderRegistrations map[string]*entities.ProviderRegistrationPackage) (int, *apierror.ErrorResponse) {
+	resourceDefinition := entities.ResourceDefinition{
+		Location: preflightResource.Location,
+		Tags:     preflightResource.Tags,
+	}
+	if len(preflightResource.Properties) > 0 {
+		err := json.Unmarshal(preflightResource.Properties, &resourceDefinition.Properties)
+		if err != nil {
+			return http.StatusBadRequest, apierror.New(
+				apierror.ClientError,
+				apierror.InvalidParameter,
+				fmt.Sprintf("The properties cannot be deserialized: %s", err))
+		}
+	}
+
+	validationError := engines.ValidateLocation(resourceDefinition.Location)
+	if validationError != nil {
+		return http.StatusBadRequest, validationError
+	}
+
+	validationError = engines.ValidateResourceDefinition(&resourceDefinition)
+	if validationError != nil {
+		return http.StatusBadRequest, validationError
+	}
+
+	// The provider registration is only known once ARM evaluates the expression during the deployment
+	providerID := resourceDefinition.Properties.ProviderID
+	if engines.IsTemplateExpression(providerID) {
+		return http.StatusOK, nil
+	}
+
+	providerRegistrationPackage, isTemplateProviderRegistration := templateProviderRegistrations[strings.ToLower(providerID)]
+	if isTemplateProviderRegistration && providerRegistrationPackage == nil {
+		return http.StatusOK, nil
+	}
+	if !isTemplateProviderRegistration {
+		providerRegistrationPackage = new(entities.ProviderRegistrationPackage)
+		err := resourceManager.ProviderRegistrationDataProvider.FindPackage(ctx, providerID, providerRegistrationPackage)
+		if err != nil {
+			return http.StatusBadRequest, apierror.NewWithDetails(
+				apierror.ClientError,
+				apierror.InvalidParameter,
+				fmt.Sprintf("The provider registration %s was not found.", providerID),
+				[]apierror.Error{apierror.NewDetail(apierror.InvalidParameter, providerIDTarget, fmt.Sprintf("The provider registration %s was not found.", providerID))})
+		}
+
+		// The credentials of a stored registration may have expired since it was created
+		statusCode, errorResponse := resourceManager.checkProviderRegistrationCredentials(ctx, providerRegistrationPackage)
+		if errorResponse != nil {
+			return statusCode, errorResponse
+		}
+	}
+
+	resourceSpec, err := json.Marshal(resourceDefinition.Properties.Settings)
+	if err != nil {
+		return http.StatusInternalServerError, apierror.New(
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to serialize resource property settings: %s", err))
+	}
+
+	configFile, statusCode, errorResponse := resourceManager.getResolvedConfigFile(
+		ctx,
+		providerRegistrationPackage,
+		resourceDefinition.Properties.ResourceType,
+		preflightResource.Name, resourceSpec)
+	if errorResponse != nil {
+		return statusCode, errorResponse
+	}
+
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return http.StatusBadRequest, apierror.New(
+			apierror.ClientError,
+			apierror.BadRequest,
+			fmt.Sprintf("Failed to parse config file: %s", err))
+	}
+
+	validationError = engines.ValidateConfig(cfg)
+	if validationError != nil {
+		return http.StatusBadRequest, validationError
+	}
+
+	provider := engines.GetProvider(providerRegistrationPackage.ProviderType)
+	for _, v := range cfg.ProviderConfigs {
+		err = engines.ConfigureProvider(ctx, providerRegistrationPackage.ProviderType, provider, terraform.NewResourceConfig(v.RawConfig))
+		if err != nil {
+			providerError := engines.ClassifyProviderError(err)
+			return providerError.HTTPStatus, providerError.ToErrorResponse(fmt.Sprintf("Failed to init provider: %s", err))
+		}
+	}
+
+	info := &terraform.InstanceInfo{
+		Type: resourceDefinition.Properties.ResourceType,
+	}
+
+	for _, v := range cfg.Resources {
+		resourceConfig := terraform.NewResourceConfig(v.RawConfig)
+		validationError := engines.ValidateResourceSettings(provider, resourceDefinition.Properties.ResourceType, resourceConfig)
+		if validationError != nil {
+			return http.StatusBadRequest, validationError
+		}
+
+		state := new(terraform.InstanceState)
+		state.Init()
+
+		// The diff of an existing resource is computed against its stored state, it is not refreshed to keep preflight fast
+		resourcePackage := entities.ResourcePackage{}
+		resourceID := resourceIDPrefix + preflightResource.Name
+		if err := resourceManager.ResourceDataProvider.FindPackage(ctx, resourceID, &resourcePackage); err == nil {
+			if strings.EqualFold(resourcePackage.ProvisioningState, consts.ProvisioningStateAccepted) {
+				return http.StatusConflict, apierror.New(
+					apierror.ClientError,
+					apierror.Conflict,
+					fmt.Sprintf("Cannot update Resource with id '%s' as it is being provisioned", resourceID))
+			}
+			if resourcePackage.State != nil {
+				statusCode, errorResponse := resourceManager.migrateResourceState(provider, &resourcePackage)
+				if errorResponse != nil {
+					return statusCode, errorResponse
+				}
+				state = resourcePackage.State
+			}
+		}
+
+		_, err = engines.DiffResource(ctx, providerRegistrationPackage.ProviderType, provider, info, state, resourceConfig)
+		if err != nil {
+			providerError := engines.ClassifyProviderError(err)
+			return providerError.HTTPStatus, providerError.ToErrorResponse(fmt.Sprintf("Failed to call provider diff: %s", err))
+		}
+	}
+
+	return http.StatusOK, nil
+}
+
+// checkProviderRegistrationCredentials makes a lightweight call against the upstream API with the settings of a stored provider registration
+func (resourceManager *ResourceManager) checkProviderRegistrationCredentials(ctx context.Context, providerRegistrationPackage *entities.ProviderRegistrationPackage) (int, *apierror.ErrorResponse) {
+	resolvedSettings, statusCode, errorResponse := resourceManager.SecretReferenceEngine.ResolveSettings(ctx, engines.ProviderRegistrationSettingsTarget, providerRegistrationPackage.Settings)
+	if errorResponse != nil {
+		return statusCode, errorResponse
+	}
+
+	statusCode, errorResponse = engines.CheckProviderSettings(ctx, providerRegistrationPackage.ProviderType, resolvedSettings, true)
+	if errorResponse != nil && statusCode < http.StatusInternalServerError {
+		message := fmt.Sprintf("The settings of provider registration %s are invalid: %s", providerRegistrationPackage.ResourceID, getErrorMessage(errorResponse))
+		return statusCode, apierror.NewWithDetails(
+			apierror.ClientError,
+			apierror.InvalidParameter,
+			message,
+			[]apierror.Error{apierror.NewDetail(errorResponse.Body.Code, providerIDTarget, message)})
+	}
+
+	return statusCode, errorResponse
+}
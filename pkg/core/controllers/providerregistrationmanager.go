@@ -9,6 +9,7 @@ package controllers
 
 import (
 	"TFRP/pkg/core/apierror"
+	"TFRP/pkg/core/consts"
 	"TFRP/pkg/core/engines"
 	"TFRP/pkg/core/entities"
 	"TFRP/pkg/core/storage"
@@ -24,22 +25,38 @@ import (
 // ProviderRegistrationManager is the provider registeration manager
 type ProviderRegistrationManager struct {
 	BaseHandler
+	OperationEngine *engines.OperationEngine
+	LockEngine      *engines.LockEngine
 }
 
 // NewProviderRegistrationManager create a new provider registration manager
-func NewProviderRegistrationManager(providerRegistrationDataProvider *storage.ProviderRegistrationDataProvider) (providerRegistrationManager *ProviderRegistrationManager) {
+func NewProviderRegistrationManager(
+	providerRegistrationDataProvider *storage.ProviderRegistrationDataProvider,
+	resourceDataProvider *storage.ResourceDataProvider,
+	deletedResourceDataProvider *storage.DeletedResourceDataProvider,
+	operationEngine *engines.OperationEngine,
+	lockEngine *engines.LockEngine,
+	secretReferenceEngine *engines.SecretReferenceEngine,
+	eventEngine *engines.EventEngine) (providerRegistrationManager *ProviderRegistrationManager) {
 	providerRegistrationManager = new(ProviderRegistrationManager)
 	providerRegistrationManager.ProviderRegistrationDataProvider = providerRegistrationDataProvider
+	providerRegistrationManager.ResourceDataProvider = resourceDataProvider
+	providerRegistrationManager.DeletedResourceDataProvider = deletedResourceDataProvider
+	providerRegistrationManager.SecretReferenceEngine = secretReferenceEngine
+	providerRegistrationManager.EventEngine = eventEngine
+	providerRegistrationManager.OperationEngine = operationEngine
+	providerRegistrationManager.LockEngine = lockEngine
 	return providerRegistrationManager
 }
 
 // GetProviderRegistrationController returns a provider registration
 func (providerRegistrationManager *ProviderRegistrationManager) GetProviderRegistrationController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
 	fullyQualifiedResourceID := engines.GetFullyQualifiedProviderRegistrationID(request)
 
 	// Get Document from collection
 	providerRegistrationPackage := entities.ProviderRegistrationPackage{}
-	err := providerRegistrationManager.ProviderRegistrationDataProvider.FindPackage(fullyQualifiedResourceID, &providerRegistrationPackage)
+	err := providerRegistrationManager.ProviderRegistrationDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &providerRegistrationPackage)
 	if err != nil {
 		apierror.WriteErrorToResponse(
 			response,
@@ -66,6 +83,7 @@ func (providerRegistrationManager *ProviderRegistrationManager) GetProviderRegis
 
 // PutProviderRegistrationController create a new provider registration
 func (providerRegistrationManager *ProviderRegistrationManager) PutProviderRegistrationController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
 	fullyQualifiedResourceID := engines.GetFullyQualifiedProviderRegistrationID(request)
 
 	providerRegistrationDefinition := entities.ProviderRegistrationDefinition{}
@@ -111,12 +129,43 @@ func (providerRegistrationManager *ProviderRegistrationManager) PutProviderRegis
 		return
 	}
 
-	fmt.Printf("%s", string(settings))
+	providerType := strings.ToLower(providerRegistrationDefinition.Properties.ProviderType)
+	testConnection := strings.EqualFold(request.QueryParameter(consts.TestConnectionParameterName), "true")
+	// Only the settings with the secret references are persisted
+	resolvedSettings, statusCode, settingsError := providerRegistrationManager.SecretReferenceEngine.ResolveSettings(ctx, engines.SettingsTarget, settings)
+	if settingsError != nil {
+		apierror.WriteErrorToResponseWitAPIError(
+			response,
+			statusCode,
+			settingsError)
+		return
+	}
+
+	statusCode, settingsError = engines.CheckProviderSettings(ctx, providerType, resolvedSettings, testConnection)
+	if settingsError != nil {
+		apierror.WriteErrorToResponseWitAPIError(
+			response,
+			statusCode,
+			settingsError)
+		return
+	}
+
+	// A provider registration being moved is locked by the move
+	lock, err := providerRegistrationManager.LockEngine.AcquireResourceLock(ctx, fullyQualifiedResourceID)
+	if err != nil {
+		writeLockErrorToResponse(response, fullyQualifiedResourceID, err)
+		return
+	}
+	defer lock.Release()
+
 	// insert Document in collection
-	err = providerRegistrationManager.ProviderRegistrationDataProvider.InsertPackage(&entities.ProviderRegistrationPackage{
-		ResourceID:   fullyQualifiedResourceID,
-		ProviderType: strings.ToLower(providerRegistrationDefinition.Properties.ProviderType),
-		Settings:     settings,
+	err = providerRegistrationManager.ProviderRegistrationDataProvider.InsertPackage(ctx, &entities.ProviderRegistrationPackage{
+		Location:                providerRegistrationDefinition.Location,
+		Tags:                    providerRegistrationDefinition.Tags,
+		ResourceID:              fullyQualifiedResourceID,
+		ProviderType:            providerType,
+		Settings:                settings,
+		SoftDeleteRetentionDays: providerRegistrationDefinition.Properties.SoftDeleteRetentionDays,
 	})
 
 	if err != nil {
@@ -131,7 +180,7 @@ func (providerRegistrationManager *ProviderRegistrationManager) PutProviderRegis
 
 	// Get Document from collection
 	providerRegistrationPackage := entities.ProviderRegistrationPackage{}
-	err = providerRegistrationManager.ProviderRegistrationDataProvider.FindPackage(fullyQualifiedResourceID, &providerRegistrationPackage)
+	err = providerRegistrationManager.ProviderRegistrationDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &providerRegistrationPackage)
 	if err != nil {
 		apierror.WriteErrorToResponse(
 			response,
@@ -159,11 +208,20 @@ func (providerRegistrationManager *ProviderRegistrationManager) PutProviderRegis
 
 // DeleteProviderRegistrationController removes a provider registration
 func (providerRegistrationManager *ProviderRegistrationManager) DeleteProviderRegistrationController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
 	fullyQualifiedResourceID := engines.GetFullyQualifiedProviderRegistrationID(request)
 
+	// Lock the provider registration before reading it, so that it is not deleted while it is moved or written
+	lock, err := providerRegistrationManager.LockEngine.AcquireResourceLock(ctx, fullyQualifiedResourceID)
+	if err != nil {
+		writeLockErrorToResponse(response, fullyQualifiedResourceID, err)
+		return
+	}
+	defer lock.Release()
+
 	// Get Document from collection
 	providerRegistrationPackage := entities.ProviderRegistrationPackage{}
-	err := providerRegistrationManager.ProviderRegistrationDataProvider.FindPackage(fullyQualifiedResourceID, &providerRegistrationPackage)
+	err = providerRegistrationManager.ProviderRegistrationDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &providerRegistrationPackage)
 	if err != nil {
 		apierror.WriteErrorToResponse(
 			response,
@@ -174,7 +232,91 @@ func (providerRegistrationManager *ProviderRegistrationManager) DeleteProviderRe
 		return
 	}
 
-	err = providerRegistrationManager.ProviderRegistrationDataProvider.RemovePackage(fullyQualifiedResourceID)
+	// Find the resources referencing the provider registration
+	resourcePackages := []entities.ResourcePackage{}
+	err = providerRegistrationManager.ResourceDataProvider.FindPackagesByProviderID(ctx, providerRegistrationPackage.ResourceID, &resourcePackages)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to find data: %s", err.Error()))
+		return
+	}
+
+	// The deleted resources are purged with the provider registration as they cannot be purged without it
+	deletedResourcePackages := []entities.DeletedResourcePackage{}
+	err = providerRegistrationManager.DeletedResourceDataProvider.FindPackagesByProviderID(ctx, providerRegistrationPackage.ResourceID, &deletedResourcePackages)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to find data: %s", err.Error()))
+		return
+	}
+
+	if len(deletedResourcePackages) > 0 && !strings.EqualFold(request.QueryParameter(consts.CascadeParameterName), "true") {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusConflict,
+			apierror.ClientError,
+			apierror.Conflict,
+			fmt.Sprintf("Cannot delete provider '%s' as it is referenced by %d deleted resources, e.g. '%s'. Purge the deleted resources first or set '%s=true' to purge them with the provider.",
+				fullyQualifiedResourceID, len(deletedResourcePackages), deletedResourcePackages[0].ResourceID, consts.CascadeParameterName))
+		return
+	}
+
+	if len(resourcePackages) > 0 {
+		if !strings.EqualFold(request.QueryParameter(consts.CascadeParameterName), "true") {
+			apierror.WriteErrorToResponse(
+				response,
+				http.StatusConflict,
+				apierror.ClientError,
+				apierror.Conflict,
+				fmt.Sprintf("Cannot delete provider '%s' as it is referenced by %d resources, e.g. '%s'. Delete the resources first or set '%s=true' to delete them with the provider.",
+					fullyQualifiedResourceID, len(resourcePackages), resourcePackages[0].ResourceID, consts.CascadeParameterName))
+			return
+		}
+
+		for _, resourcePackage := range resourcePackages {
+			if strings.EqualFold(resourcePackage.ProvisioningState, consts.ProvisioningStateAccepted) {
+				apierror.WriteErrorToResponse(
+					response,
+					http.StatusConflict,
+					apierror.ClientError,
+					apierror.Conflict,
+					fmt.Sprintf("Cannot delete provider '%s' as its resource '%s' is being provisioned", fullyQualifiedResourceID, resourcePackage.ResourceID))
+				return
+			}
+		}
+
+		for i := range resourcePackages {
+			statusCode, errorResponse := providerRegistrationManager.destroyResource(ctx, providerRegistrationManager.OperationEngine, providerRegistrationManager.LockEngine, &resourcePackages[i])
+			if errorResponse != nil {
+				apierror.WriteErrorToResponseWitAPIError(
+					response,
+					statusCode,
+					errorResponse)
+				return
+			}
+		}
+	}
+
+	for i := range deletedResourcePackages {
+		statusCode, errorResponse := providerRegistrationManager.purgeDeletedResource(ctx, providerRegistrationManager.OperationEngine, providerRegistrationManager.LockEngine, &deletedResourcePackages[i], true)
+		if errorResponse != nil {
+			apierror.WriteErrorToResponseWitAPIError(
+				response,
+				statusCode,
+				errorResponse)
+			return
+		}
+	}
+
+	err = providerRegistrationManager.ProviderRegistrationDataProvider.RemovePackage(ctx, fullyQualifiedResourceID)
 	if err != nil {
 		apierror.WriteErrorToResponse(
 			response,
@@ -190,11 +332,12 @@ func (providerRegistrationManager *ProviderRegistrationManager) DeleteProviderRe
 
 // PostProviderRegistrationListSettings returns settings of a provider registration
 func (providerRegistrationManager *ProviderRegistrationManager) PostProviderRegistrationListSettings(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
 	fullyQualifiedResourceID := engines.GetFullyQualifiedProviderRegistrationID(request)
 
 	// Get Document from collection
 	providerRegistrationPackage := entities.ProviderRegistrationPackage{}
-	err := providerRegistrationManager.ProviderRegistrationDataProvider.FindPackage(fullyQualifiedResourceID, &providerRegistrationPackage)
+	err := providerRegistrationManager.ProviderRegistrationDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &providerRegistrationPackage)
 	if err != nil {
 		apierror.WriteErrorToResponse(
 			response,
@@ -218,3 +361,42 @@ func (providerRegistrationManager *ProviderRegistrationManager) PostProviderRegi
 	response.Header().Set(restful.HEADER_ContentType, restful.MIME_JSON)
 	response.Write(responseContent)
 }
+
+// PostProviderRegistrationTestConnection validates the settings of a provider registration and tests its connection
+func (providerRegistrationManager *ProviderRegistrationManager) PostProviderRegistrationTestConnection(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	fullyQualifiedResourceID := engines.GetFullyQualifiedProviderRegistrationID(request)
+
+	// Get Document from collection
+	providerRegistrationPackage := entities.ProviderRegistrationPackage{}
+	err := providerRegistrationManager.ProviderRegistrationDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &providerRegistrationPackage)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusNotFound,
+			apierror.ClientError,
+			apierror.NotFound,
+			err.Error())
+		return
+	}
+
+	resolvedSettings, statusCode, settingsError := providerRegistrationManager.SecretReferenceEngine.ResolveSettings(ctx, engines.SettingsTarget, providerRegistrationPackage.Settings)
+	if settingsError != nil {
+		apierror.WriteErrorToResponseWitAPIError(
+			response,
+			statusCode,
+			settingsError)
+		return
+	}
+
+	statusCode, settingsError = engines.CheckProviderSettings(ctx, providerRegistrationPackage.ProviderType, resolvedSettings, true)
+	if settingsError != nil {
+		apierror.WriteErrorToResponseWitAPIError(
+			response,
+			statusCode,
+			settingsError)
+		return
+	}
+
+	response.WriteHeader(http.StatusOK)
+}
@@ -10,39 +10,75 @@ import (
 	"TFRP/pkg/core/engines"
 	"TFRP/pkg/core/entities"
 	"TFRP/pkg/core/storage"
+	"TFRP/pkg/core/tracing"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	restful "github.com/emicklei/go-restful"
 	"github.com/hashicorp/terraform/config"
 	"github.com/hashicorp/terraform/terraform"
+	"gopkg.in/mgo.v2/bson"
 )
 
+// terraformImportOperationPrefix prefixes the operation status ids of terraform imports
+const terraformImportOperationPrefix = "importTerraform-"
+
 // ResourceManager is the resource manager
 type ResourceManager struct {
 	BaseHandler
+	OperationEngine  *engines.OperationEngine
+	ThrottlingEngine *engines.ThrottlingEngine
+	LockEngine       *engines.LockEngine
+
+	TerraformImportOperationDataProvider *storage.TerraformImportOperationDataProvider
+	MoveJournalDataProvider              *storage.MoveJournalDataProvider
+
+	// backgroundRefreshes are the ids of the resources being refreshed in the background
+	backgroundRefreshes sync.Map
 }
 
 // NewResourceManager create a new resource manager
 func NewResourceManager(
 	providerRegistrationDataProvider *storage.ProviderRegistrationDataProvider,
-	resourceDataProvider *storage.ResourceDataProvider) (resourceManager *ResourceManager) {
+	resourceDataProvider *storage.ResourceDataProvider,
+	deletedResourceDataProvider *storage.DeletedResourceDataProvider,
+	terraformImportOperationDataProvider *storage.TerraformImportOperationDataProvider,
+	moveJournalDataProvider *storage.MoveJournalDataProvider,
+	operationEngine *engines.OperationEngine,
+	throttlingEngine *engines.ThrottlingEngine,
+	lockEngine *engines.LockEngine,
+	secretReferenceEngine *engines.SecretReferenceEngine,
+	eventEngine *engines.EventEngine) (resourceManager *ResourceManager) {
 	resourceManager = new(ResourceManager)
 	resourceManager.ProviderRegistrationDataProvider = providerRegistrationDataProvider
 	resourceManager.ResourceDataProvider = resourceDataProvider
+	resourceManager.DeletedResourceDataProvider = deletedResourceDataProvider
+	resourceManager.TerraformImportOperationDataProvider = terraformImportOperationDataProvider
+	resourceManager.MoveJournalDataProvider = moveJournalDataProvider
+	resourceManager.SecretReferenceEngine = secretReferenceEngine
+	resourceManager.EventEngine = eventEngine
+	resourceManager.OperationEngine = operationEngine
+	resourceManager.ThrottlingEngine = throttlingEngine
+	resourceManager.LockEngine = lockEngine
 	return resourceManager
 }
 
-// GetResourceController returns a resource
+// GetResourceController returns a resource, the stored state is served while it is within the freshness window
+// unless the refresh query parameter is set, a state older than the maximum staleness is refreshed before it is served
 func (resourceManager *ResourceManager) GetResourceController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
 	fullyQualifiedResourceID := engines.GetFullyQualifiedResourceID(request)
 
 	// Get Document from collection
 	resourcePackage := entities.ResourcePackage{}
-	err := resourceManager.ResourceDataProvider.FindPackage(fullyQualifiedResourceID, &resourcePackage)
+	err := resourceManager.ResourceDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &resourcePackage)
 	if err != nil {
 		apierror.WriteErrorToResponse(
 			response,
@@ -53,95 +89,262 @@ func (resourceManager *ResourceManager) GetResourceController(request *restful.R
 		return
 	}
 
-	provider := engines.GetProvider(resourcePackage.ProviderType)
+	if retryAfter, throttled := resourceManager.ThrottlingEngine.TakeResourceProviderRegistration(resourcePackage.ProviderID, resourcePackage.ResourceID, engines.ThrottlingKindRead); throttled {
+		engines.WriteThrottledResponse(response, engines.ThrottlingScopeProviderRegistration, retryAfter)
+		return
+	}
+
+	forceRefresh := strings.EqualFold(request.QueryParameter(consts.RefreshParameterName), "true")
+	if strings.EqualFold(resourcePackage.ProvisioningState, consts.ProvisioningStateAccepted) {
+		// The state of a resource being provisioned is the state its operation started from, the operation records the new one
+	} else if resourcePackage.State != nil && !forceRefresh && engines.IsStateCacheable(resourcePackage.LastRefreshedAt) {
+		// A stale state is served while it is refreshed in the background
+		if !engines.IsStateFresh(resourcePackage.LastRefreshedAt) {
+			resourceManager.refreshResourceInBackground(ctx, fullyQualifiedResourceID)
+		}
+	} else if resourcePackage.State != nil {
+		statusCode, errorResponse := resourceManager.refreshResourceInRequest(ctx, fullyQualifiedResourceID, &resourcePackage)
+		if errorResponse != nil {
+			apierror.WriteErrorToResponseWitAPIError(
+				response,
+				statusCode,
+				errorResponse)
+			return
+		}
+	}
 
-	cfg, err := config.Load(resourcePackage.Config)
+	responseContent, err := json.Marshal(resourcePackage.ToDefinition())
 	if err != nil {
 		apierror.WriteErrorToResponse(
 			response,
-			http.StatusBadRequest,
-			apierror.ClientError,
-			apierror.BadRequest,
-			err.Error())
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to serialize response content: %s", err))
 		return
 	}
 
-	// Init provider
-	for _, v := range cfg.ProviderConfigs {
-		err = provider.Configure(terraform.NewResourceConfig(v.RawConfig))
-		if err != nil {
-			apierror.WriteErrorToResponse(
-				response,
-				http.StatusBadRequest,
-				apierror.ClientError,
-				apierror.BadRequest,
-				err.Error())
-			return
-		}
+	response.Header().Set(restful.HEADER_ContentType, restful.MIME_JSON)
+	response.Write(responseContent)
+}
+
+// refreshResourceState refreshes the stored state of a resource with the live provider registration,
+// it returns nil if the resource no longer exists
+func (resourceManager *ResourceManager) refreshResourceState(ctx context.Context, resourcePackage *entities.ResourcePackage) (*terraform.InstanceState, int, *apierror.ErrorResponse) {
+	providerRegistrationPackage, provider, statusCode, errorResponse := resourceManager.configureResourceProvider(ctx, resourcePackage)
+	if errorResponse != nil {
+		return nil, statusCode, errorResponse
 	}
 
 	info := &terraform.InstanceInfo{
 		Type: resourcePackage.ResourceType,
 	}
 
-	if resourcePackage.State != nil {
-		// Call refresh
-		resourceState, err := provider.Refresh(info, resourcePackage.State)
+	// Call refresh
+	resourceState, err := resourceManager.OperationEngine.Refresh(ctx, providerRegistrationPackage.ProviderType, provider, info, resourcePackage.State)
+	if err != nil {
+		providerError := engines.ClassifyProviderError(err)
+		return nil, providerError.HTTPStatus, providerError.ToErrorResponse(err.Error())
+	}
+
+	return resourceState, http.StatusOK, nil
+}
+
+// refreshResourceInRequest refreshes the state of a resource within a request, the refresh is fenced by the lock
+// of the resource so that it cannot revert a concurrent write. While another request holds the lock, the state is
+// refreshed without being stored rather than failing the read. It returns 404 once the resource no longer exists.
+func (resourceManager *ResourceManager) refreshResourceInRequest(ctx context.Context, resourceID string, resourcePackage *entities.ResourcePackage) (int, *apierror.ErrorResponse) {
+	lock, err := resourceManager.LockEngine.AcquireResourceLock(ctx, resourceID)
+	if err != nil && err != engines.ErrLockHeld {
+		return http.StatusInternalServerError, apierror.New(
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to lock resource '%s': %s", resourceID, err))
+	}
+
+	if err == nil {
+		defer lock.Release()
+
+		// The resource may have changed before it was locked
+		err = resourceManager.ResourceDataProvider.FindPackage(ctx, resourceID, resourcePackage)
 		if err != nil {
-			apierror.WriteErrorToResponse(
-				response,
-				http.StatusBadRequest,
+			return http.StatusNotFound, apierror.New(
 				apierror.ClientError,
-				apierror.BadRequest,
-				err.Error())
+				apierror.NotFound,
+				fmt.Sprintf("Resource with id '%s' was not found", resourceID))
+		}
+		if resourcePackage.State == nil || strings.EqualFold(resourcePackage.ProvisioningState, consts.ProvisioningStateAccepted) {
+			return http.StatusOK, nil
+		}
+	} else {
+		lock = nil
+	}
+
+	refreshCtx, cancel := engines.WithSynchronousRetryBudget(ctx)
+	defer cancel()
+
+	resourceState, statusCode, errorResponse := resourceManager.refreshResourceState(refreshCtx, resourcePackage)
+	if errorResponse != nil {
+		return statusCode, errorResponse
+	}
+
+	if resourceState == nil {
+		if lock != nil {
+			err = resourceManager.ResourceDataProvider.RemovePackage(ctx, resourceID)
+			if err == nil {
+				resourceManager.EventEngine.PublishResourceEvent(ctx, consts.EventTypeResourceDeleted, resourcePackage)
+			}
+		}
+		return http.StatusNotFound, apierror.New(
+			apierror.ClientError,
+			apierror.NotFound,
+			fmt.Sprintf("Resource with id '%s' was not found", resourceID))
+	}
+
+	drifted := engines.IsStateDrifted(resourcePackage.State, resourceState)
+	resourcePackage.State = resourceState
+	resourcePackage.LastRefreshedAt = engines.GetRefreshTime()
+
+	// The holder of the lock records the state it writes, the refreshed state is only returned
+	if lock == nil {
+		return http.StatusOK, nil
+	}
+
+	err = resourceManager.ResourceDataProvider.InsertFencedPackage(ctx, resourcePackage, lock.FencingToken())
+	if err == storage.ErrStaleFencingToken {
+		return http.StatusOK, nil
+	}
+	if err != nil {
+		return http.StatusInternalServerError, apierror.New(
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to insert data: %s", err))
+	}
+
+	if drifted {
+		resourceManager.EventEngine.PublishResourceEvent(ctx, consts.EventTypeResourceDrifted, resourcePackage)
+	}
+
+	return http.StatusOK, nil
+}
+
+// refreshResourceInBackground refreshes the stale state of a resource once, a resource locked by a write
+// is skipped as the write records a fresh state
+func (resourceManager *ResourceManager) refreshResourceInBackground(ctx context.Context, resourceID string) {
+	refreshKey := strings.ToLower(resourceID)
+	if _, refreshing := resourceManager.backgroundRefreshes.LoadOrStore(refreshKey, true); refreshing {
+		return
+	}
+
+	// The refresh outlives the request, it continues the trace of the request in the background
+	ctx = tracing.Detach(ctx)
+	resourceManager.OperationEngine.Go(func() {
+		defer resourceManager.backgroundRefreshes.Delete(refreshKey)
+
+		lock, err := resourceManager.LockEngine.AcquireResourceLock(ctx, resourceID)
+		if err != nil {
+			return
+		}
+		defer lock.Release()
+
+		// The resource may have changed before it was locked
+		resourcePackage := entities.ResourcePackage{}
+		err = resourceManager.ResourceDataProvider.FindPackage(ctx, resourceID, &resourcePackage)
+		if err != nil ||
+			resourcePackage.State == nil ||
+			strings.EqualFold(resourcePackage.ProvisioningState, consts.ProvisioningStateAccepted) ||
+			engines.IsStateFresh(resourcePackage.LastRefreshedAt) {
+			return
+		}
+
+		resourceState, _, errorResponse := resourceManager.refreshResourceState(ctx, &resourcePackage)
+		if errorResponse != nil {
+			log.Printf("Failed to refresh resource '%s': %s", resourceID, getErrorMessage(errorResponse))
 			return
 		}
 
 		if resourceState == nil {
-			err = resourceManager.ResourceDataProvider.RemovePackage(fullyQualifiedResourceID)
-			apierror.WriteErrorToResponse(
-				response,
-				http.StatusNotFound,
-				apierror.ClientError,
-				apierror.NotFound,
-				fmt.Sprintf("Resource with id '%s' was not found", fullyQualifiedResourceID))
+			err = resourceManager.ResourceDataProvider.RemovePackage(ctx, resourceID)
+			if err != nil {
+				log.Printf("Failed to delete resource '%s' from storage: %s", resourceID, err)
+				return
+			}
+			resourceManager.EventEngine.PublishResourceEvent(ctx, consts.EventTypeResourceDeleted, &resourcePackage)
 			return
 		}
 
+		drifted := engines.IsStateDrifted(resourcePackage.State, resourceState)
 		resourcePackage.State = resourceState
-
-		// insert Document in collection
-		err = resourceManager.ResourceDataProvider.InsertPackage(&resourcePackage)
+		resourcePackage.LastRefreshedAt = engines.GetRefreshTime()
+		err = resourceManager.ResourceDataProvider.InsertFencedPackage(ctx, &resourcePackage, lock.FencingToken())
 		if err != nil {
-			apierror.WriteErrorToResponse(
-				response,
-				http.StatusInternalServerError,
-				apierror.InternalError,
-				apierror.InternalOperationError,
-				fmt.Sprintf("Failed to insert data: %s", err))
+			log.Printf("Failed to insert data: %s", err)
 			return
 		}
+
+		if drifted {
+			resourceManager.EventEngine.PublishResourceEvent(ctx, consts.EventTypeResourceDrifted, &resourcePackage)
+		}
+	})
+}
+
+// PutResourceController creates/updates a resource
+func (resourceManager *ResourceManager) PutResourceController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	resourceDefinition := entities.ResourceDefinition{}
+
+	rawBody, err := ioutil.ReadAll(request.Request.Body)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusBadRequest,
+			apierror.ClientError,
+			apierror.BadRequest,
+			fmt.Sprintf("Request content is invalid: %s", err))
+		return
 	}
 
-	responseContent, err := json.Marshal(resourcePackage.ToDefinition())
+	err = json.Unmarshal(rawBody, &resourceDefinition)
 	if err != nil {
 		apierror.WriteErrorToResponse(
 			response,
-			http.StatusInternalServerError,
-			apierror.InternalError,
-			apierror.InternalOperationError,
-			fmt.Sprintf("Failed to serialize response content: %s", err))
+			http.StatusBadRequest,
+			apierror.ClientError,
+			apierror.BadRequest,
+			fmt.Sprintf("Request content cannot be deserialized as JSON: %s", err))
 		return
 	}
 
-	response.Header().Set(restful.HEADER_ContentType, restful.MIME_JSON)
-	response.Write(responseContent)
+	validationError := engines.ValidateLocation(resourceDefinition.Location)
+	if validationError != nil {
+		apierror.WriteErrorToResponseWitAPIError(
+			response,
+			http.StatusBadRequest,
+			validationError)
+		return
+	}
+
+	// A soft deleted resource keeps its infrastructure, creating the resource again would orphan it
+	fullyQualifiedResourceID := engines.GetFullyQualifiedResourceID(request)
+	if resourceManager.DeletedResourceDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &entities.DeletedResourcePackage{}) == nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusConflict,
+			apierror.ClientError,
+			apierror.Conflict,
+			fmt.Sprintf("Cannot create Resource with id '%s' as it was deleted and is retained. Restore or purge the deleted resource first.", fullyQualifiedResourceID))
+		return
+	}
+
+	resourceManager.putResource(request, response, &resourceDefinition, http.StatusCreated, nil)
 }
 
-// PutResourceController creates/updates a resource
-func (resourceManager *ResourceManager) PutResourceController(request *restful.Request, response *restful.Response) {
+// PatchResourceController updates the tags of a resource and merges settings into its stored settings,
+// a patch of only tags does not call the provider
+func (resourceManager *ResourceManager) PatchResourceController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
 	fullyQualifiedResourceID := engines.GetFullyQualifiedResourceID(request)
-	resourceDefinition := entities.ResourceDefinition{}
+	resourcePatchDefinition := entities.ResourcePatchDefinition{}
 
 	rawBody, err := ioutil.ReadAll(request.Request.Body)
 	if err != nil {
@@ -154,7 +357,7 @@ func (resourceManager *ResourceManager) PutResourceController(request *restful.R
 		return
 	}
 
-	err = json.Unmarshal(rawBody, &resourceDefinition)
+	err = json.Unmarshal(rawBody, &resourcePatchDefinition)
 	if err != nil {
 		apierror.WriteErrorToResponse(
 			response,
@@ -165,7 +368,138 @@ func (resourceManager *ResourceManager) PutResourceController(request *restful.R
 		return
 	}
 
-	validationError := engines.ValidateResourceDefinition(&resourceDefinition)
+	// Lock the resource before reading it, so that the patch is merged into the settings it replaces
+	lock, err := resourceManager.LockEngine.AcquireResourceLock(ctx, fullyQualifiedResourceID)
+	if err != nil {
+		writeLockErrorToResponse(response, fullyQualifiedResourceID, err)
+		return
+	}
+
+	releaseLock := true
+	defer func() {
+		if releaseLock {
+			lock.Release()
+		}
+	}()
+
+	// Get Document from collection
+	resourcePackage := entities.ResourcePackage{}
+	err = resourceManager.ResourceDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &resourcePackage)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusNotFound,
+			apierror.ClientError,
+			apierror.NotFound,
+			err.Error())
+		return
+	}
+
+	if strings.EqualFold(resourcePackage.ProvisioningState, consts.ProvisioningStateAccepted) {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusConflict,
+			apierror.ClientError,
+			apierror.Conflict,
+			fmt.Sprintf("Cannot update Resource with id '%s' as it is being provisioned", fullyQualifiedResourceID))
+		return
+	}
+
+	if resourcePatchDefinition.Properties == nil || resourcePatchDefinition.Properties.Settings == nil {
+		resourceManager.patchResourceTags(ctx, response, lock, &resourcePackage, resourcePatchDefinition.Tags)
+		return
+	}
+
+	resourceSettings := resourcePackage.Settings
+	if len(resourceSettings) == 0 {
+		_, resourceSettings, err = getLegacyResourceSettings(&resourcePackage)
+		if err != nil {
+			apierror.WriteErrorToResponse(
+				response,
+				http.StatusBadRequest,
+				apierror.ClientError,
+				apierror.BadRequest,
+				err.Error())
+			return
+		}
+	}
+
+	mergedSettings, err := engines.MergeSettings(resourceSettings, resourcePatchDefinition.Properties.Settings)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusBadRequest,
+			apierror.ClientError,
+			apierror.BadRequest,
+			fmt.Sprintf("Failed to merge resource settings: %s", err))
+		return
+	}
+
+	tags := resourcePackage.Tags
+	if resourcePatchDefinition.Tags != nil {
+		tags = resourcePatchDefinition.Tags
+	}
+
+	// The update keeps the lock, an update applied in the background is accepted rather than created
+	releaseLock = false
+	resourceManager.putResource(request, response, &entities.ResourceDefinition{
+		Location: resourcePackage.Location,
+		Tags:     tags,
+		Properties: &entities.ResourceDefinitionProperties{
+			ProviderID:   resourcePackage.ProviderID,
+			ResourceType: resourcePackage.ResourceType,
+			Settings:     json.RawMessage(mergedSettings),
+		},
+	}, http.StatusAccepted, lock)
+}
+
+// patchResourceTags replaces the tags of a resource read under its lock, nil tags leave the tags unchanged
+func (resourceManager *ResourceManager) patchResourceTags(ctx context.Context, response *restful.Response, lock *engines.Lock, resourcePackage *entities.ResourcePackage, tags map[string]string) {
+	if tags != nil {
+		resourcePackage.Tags = tags
+		err := resourceManager.ResourceDataProvider.InsertFencedPackage(ctx, resourcePackage, lock.FencingToken())
+		if err != nil {
+			writeLockErrorToResponse(response, resourcePackage.ResourceID, err)
+			return
+		}
+	}
+
+	responseContent, err := json.Marshal(resourcePackage.ToDefinition())
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to serialize response content: %s", err))
+		return
+	}
+
+	response.Header().Set(restful.HEADER_ContentType, restful.MIME_JSON)
+	response.Write(responseContent)
+}
+
+// putResource creates/updates a resource from its definition, acceptedStatusCode is returned when the resource
+// is applied in the background. The lock of the resource is acquired unless heldLock is given, putResource
+// releases heldLock.
+func (resourceManager *ResourceManager) putResource(
+	request *restful.Request,
+	response *restful.Response,
+	resourceDefinition *entities.ResourceDefinition,
+	acceptedStatusCode int,
+	heldLock *engines.Lock) {
+	ctx := request.Request.Context()
+	fullyQualifiedResourceID := engines.GetFullyQualifiedResourceID(request)
+
+	lock := heldLock
+	releaseLock := true
+	defer func() {
+		if releaseLock && lock != nil {
+			lock.Release()
+		}
+	}()
+
+	validationError := engines.ValidateResourceDefinition(resourceDefinition)
 	if validationError != nil {
 		apierror.WriteErrorToResponseWitAPIError(
 			response,
@@ -174,9 +508,14 @@ func (resourceManager *ResourceManager) PutResourceController(request *restful.R
 		return
 	}
 
+	if retryAfter, throttled := resourceManager.ThrottlingEngine.TakeResourceProviderRegistration(resourceDefinition.Properties.ProviderID, fullyQualifiedResourceID, engines.ThrottlingKindWrite); throttled {
+		engines.WriteThrottledResponse(response, engines.ThrottlingScopeProviderRegistration, retryAfter)
+		return
+	}
+
 	// Try to get provider registartion document from collection
 	providerRegistrationPackage := entities.ProviderRegistrationPackage{}
-	err = resourceManager.ProviderRegistrationDataProvider.FindPackage(resourceDefinition.Properties.ProviderID, &providerRegistrationPackage)
+	err := resourceManager.ProviderRegistrationDataProvider.FindPackage(ctx, resourceDefinition.Properties.ProviderID, &providerRegistrationPackage)
 	if err != nil {
 		apierror.WriteErrorToResponse(
 			response,
@@ -198,11 +537,19 @@ func (resourceManager *ResourceManager) PutResourceController(request *restful.R
 		return
 	}
 
-	configFile := getConfigFileInJSON(
-		providerRegistrationPackage.ProviderType,
-		providerRegistrationPackage.Settings,
-		resourceDefinition,
-		engines.GetResourceName(request), resourceSpec)
+	resourceName := engines.GetResourceName(request)
+	configFile, statusCode, errorResponse := resourceManager.getResolvedConfigFile(
+		ctx,
+		&providerRegistrationPackage,
+		resourceDefinition.Properties.ResourceType,
+		resourceName, resourceSpec)
+	if errorResponse != nil {
+		apierror.WriteErrorToResponseWitAPIError(
+			response,
+			statusCode,
+			errorResponse)
+		return
+	}
 
 	provider := engines.GetProvider(providerRegistrationPackage.ProviderType)
 
@@ -217,27 +564,24 @@ func (resourceManager *ResourceManager) PutResourceController(request *restful.R
 		return
 	}
 
-	err = cfg.Validate()
-	if err != nil {
-		apierror.WriteErrorToResponse(
+	validationError = engines.ValidateConfig(cfg)
+	if validationError != nil {
+		apierror.WriteErrorToResponseWitAPIError(
 			response,
 			http.StatusBadRequest,
-			apierror.ClientError,
-			apierror.BadRequest,
-			fmt.Sprintf("Invalid config file: %s", err))
+			validationError)
 		return
 	}
 
 	// Init provider
 	for _, v := range cfg.ProviderConfigs {
-		err = provider.Configure(terraform.NewResourceConfig(v.RawConfig))
+		err = engines.ConfigureProvider(ctx, providerRegistrationPackage.ProviderType, provider, terraform.NewResourceConfig(v.RawConfig))
 		if err != nil {
-			apierror.WriteErrorToResponse(
+			providerError := engines.ClassifyProviderError(err)
+			apierror.WriteErrorToResponseWitAPIError(
 				response,
-				http.StatusBadRequest,
-				apierror.ClientError,
-				apierror.BadRequest,
-				fmt.Sprintf("Failed to init provider: %s", err))
+				providerError.HTTPStatus,
+				providerError.ToErrorResponse(fmt.Sprintf("Failed to init provider: %s", err)))
 			return
 		}
 	}
@@ -246,16 +590,26 @@ func (resourceManager *ResourceManager) PutResourceController(request *restful.R
 		Type: resourceDefinition.Properties.ResourceType,
 	}
 
+	// States written by the apply are recorded with the current schema version of the resource type
+	schemaVersion := engines.GetSchemaVersion(provider, resourceDefinition.Properties.ResourceType)
+
+	// Lock the resource from the diff until the apply completes in the background
+	if lock == nil {
+		lock, err = resourceManager.LockEngine.AcquireResourceLock(ctx, fullyQualifiedResourceID)
+		if err != nil {
+			writeLockErrorToResponse(response, fullyQualifiedResourceID, err)
+			return
+		}
+	}
+
 	resourcePackage := entities.ResourcePackage{}
 	for _, v := range cfg.Resources {
-		_, errs := provider.ValidateResource(resourceDefinition.Properties.ResourceType, terraform.NewResourceConfig(v.RawConfig))
-		if errs != nil {
-			apierror.WriteErrorToResponse(
+		validationError := engines.ValidateResourceSettings(provider, resourceDefinition.Properties.ResourceType, terraform.NewResourceConfig(v.RawConfig))
+		if validationError != nil {
+			apierror.WriteErrorToResponseWitAPIError(
 				response,
 				http.StatusBadRequest,
-				apierror.ClientError,
-				apierror.BadRequest,
-				fmt.Sprintf("The resource settings are invalid: %s", errs))
+				validationError)
 			return
 		}
 
@@ -263,7 +617,7 @@ func (resourceManager *ResourceManager) PutResourceController(request *restful.R
 		state.Init()
 
 		// Get Document from collection
-		err := resourceManager.ResourceDataProvider.FindPackage(fullyQualifiedResourceID, &resourcePackage)
+		err := resourceManager.ResourceDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &resourcePackage)
 		if err == nil {
 			if strings.EqualFold(resourcePackage.ProvisioningState, consts.ProvisioningStateAccepted) {
 				apierror.WriteErrorToResponse(
@@ -274,34 +628,59 @@ func (resourceManager *ResourceManager) PutResourceController(request *restful.R
 					fmt.Sprintf("Cannot create Resource with id '%s' as it is being provisioned", fullyQualifiedResourceID))
 				return
 			} else if resourcePackage.State != nil {
+				statusCode, errorResponse := resourceManager.migrateResourceState(provider, &resourcePackage)
+				if errorResponse != nil {
+					apierror.WriteErrorToResponseWitAPIError(
+						response,
+						statusCode,
+						errorResponse)
+					return
+				}
+
 				// Call refresh
-				state, err = provider.Refresh(info, resourcePackage.State)
+				refreshCtx, cancel := engines.WithSynchronousRetryBudget(ctx)
+				state, err = resourceManager.OperationEngine.Refresh(refreshCtx, providerRegistrationPackage.ProviderType, provider, info, resourcePackage.State)
+				cancel()
 				if err != nil {
-					apierror.WriteErrorToResponse(
+					providerError := engines.ClassifyProviderError(err)
+					apierror.WriteErrorToResponseWitAPIError(
 						response,
-						http.StatusBadRequest,
-						apierror.ClientError,
-						apierror.BadRequest,
-						err.Error())
+						providerError.HTTPStatus,
+						providerError.ToErrorResponse(err.Error()))
 					return
 				}
 			}
 		}
 
-		diff, err := provider.Diff(info, state, terraform.NewResourceConfig(v.RawConfig))
+		diff, err := engines.DiffResource(ctx, providerRegistrationPackage.ProviderType, provider, info, state, terraform.NewResourceConfig(v.RawConfig))
 		if err != nil {
-			apierror.WriteErrorToResponse(
+			providerError := engines.ClassifyProviderError(err)
+			apierror.WriteErrorToResponseWitAPIError(
 				response,
-				http.StatusBadRequest,
-				apierror.ClientError,
-				apierror.BadRequest,
-				fmt.Sprintf("Failed to call provider diff: %s", err))
+				providerError.HTTPStatus,
+				providerError.ToErrorResponse(fmt.Sprintf("Failed to call provider diff: %s", err)))
 			return
 		}
 
-		// If we have no diff, we have nothing to do!
+		// If we have no diff, we have nothing to do but to store the definition, e.g. new tags
 		if diff.Empty() {
-			responseContent, err := json.Marshal(resourceDefinition)
+			var responseContent []byte
+			if len(resourcePackage.ResourceID) > 0 {
+				resourcePackage.Location = resourceDefinition.Location
+				resourcePackage.Tags = resourceDefinition.Tags
+				resourcePackage.ResourceName = resourceName
+				resourcePackage.Settings = resourceSpec
+				resourcePackage.ProviderType = providerRegistrationPackage.ProviderType
+				resourcePackage.ProviderID = providerRegistrationPackage.ResourceID
+				err = resourceManager.ResourceDataProvider.InsertFencedPackage(ctx, &resourcePackage, lock.FencingToken())
+				if err != nil {
+					writeLockErrorToResponse(response, fullyQualifiedResourceID, err)
+					return
+				}
+				responseContent, err = json.Marshal(resourcePackage.ToDefinition())
+			} else {
+				responseContent, err = json.Marshal(resourceDefinition)
+			}
 			if err != nil {
 				apierror.WriteErrorToResponse(
 					response,
@@ -317,58 +696,139 @@ func (resourceManager *ResourceManager) PutResourceController(request *restful.R
 			return
 		}
 
+		// A resource is updated when it has infrastructure to apply the diff to
+		succeededEventType := consts.EventTypeResourceCreated
+		if len(resourcePackage.ResourceID) > 0 && resourcePackage.State != nil {
+			succeededEventType = consts.EventTypeResourceUpdated
+		}
+
 		// insert Document in collection
 		resourcePackage = entities.ResourcePackage{
 			Location:          resourceDefinition.Location,
+			Tags:              resourceDefinition.Tags,
 			ResourceID:        fullyQualifiedResourceID,
 			ProvisioningState: consts.ProvisioningStateAccepted,
-			Config:            configFile,
+			ResourceName:      resourceName,
+			Settings:          resourceSpec,
 			ResourceType:      resourceDefinition.Properties.ResourceType,
 			ProviderType:      providerRegistrationPackage.ProviderType,
+			ProviderID:        providerRegistrationPackage.ResourceID,
+		}
+
+		// Keep the state the update starts from, so an operation interrupted before it returns a state is resumed from it
+		if state != nil && len(state.ID) > 0 {
+			resourcePackage.StateID = state.ID
+			resourcePackage.State = state
+			resourcePackage.SchemaVersion = schemaVersion
 		}
-		err = resourceManager.ResourceDataProvider.InsertPackage(&resourcePackage)
+
+		err = resourceManager.ResourceDataProvider.InsertFencedPackage(ctx, &resourcePackage, lock.FencingToken())
 		if err != nil {
-			apierror.WriteErrorToResponse(
-				response,
-				http.StatusInternalServerError,
-				apierror.InternalError,
-				apierror.InternalOperationError,
-				fmt.Sprintf("Failed to insert data: %s", err))
+			writeLockErrorToResponse(response, fullyQualifiedResourceID, err)
 			return
 		}
 
-		go func() {
+		// The apply outlives the request, it continues the trace of the request in the background
+		backgroundCtx := tracing.Detach(ctx)
+		acceptedResourcePackage := resourcePackage
+		applyRequest := &engines.ApplyRequest{
+			ResourceID:     fullyQualifiedResourceID,
+			ProviderType:   providerRegistrationPackage.ProviderType,
+			Provider:       provider,
+			Info:           info,
+			State:          state,
+			Diff:           diff,
+			ResourceConfig: terraform.NewResourceConfig(v.RawConfig),
+			OnRetry: func(attempt int, err error) {
+				acceptedResourcePackage.OperationAttempts = attempt
+				acceptedResourcePackage.OperationLastError = err.Error()
+				resourceManager.ResourceDataProvider.InsertFencedPackage(backgroundCtx, &acceptedResourcePackage, lock.FencingToken())
+			},
+			Lock: lock,
+		}
+
+		// The background operation releases the lock when the apply completes
+		releaseLock = false
+		resourceManager.OperationEngine.Go(func() {
+			defer lock.Release()
+
 			// Call apply to create resource
-			resourceState, err := provider.Apply(info, state, diff)
+			resourceState, err := resourceManager.OperationEngine.Apply(backgroundCtx, applyRequest)
 			if err != nil {
-				resourceManager.ResourceDataProvider.InsertPackage(&entities.ResourcePackage{
-					Location:                 resourceDefinition.Location,
-					ResourceID:               fullyQualifiedResourceID,
-					ProvisioningState:        consts.ProvisioningStateFailed,
-					ProvisioningErrorCode:    string(apierror.BadRequest),
-					ProvisioningErrorMessage: err.Error(),
-					Config:       configFile,
-					ResourceType: resourceDefinition.Properties.ResourceType,
-					ProviderType: providerRegistrationPackage.ProviderType,
-				})
+				providerError := engines.ClassifyProviderError(err)
+				failedResourcePackage := &entities.ResourcePackage{
+					Location:                    resourceDefinition.Location,
+					Tags:                        resourceDefinition.Tags,
+					ResourceID:                  fullyQualifiedResourceID,
+					ProvisioningState:           consts.ProvisioningStateFailed,
+					ProvisioningErrorCode:       string(providerError.AsyncErrorCode()),
+					ProvisioningErrorDetailCode: string(providerError.Code),
+					ProvisioningErrorMessage:    err.Error(),
+					OperationAttempts:           applyRequest.Attempts,
+					OperationLastError:          err.Error(),
+					ResourceName:                resourceName,
+					Settings:                    resourceSpec,
+					ResourceType:                resourceDefinition.Properties.ResourceType,
+					ProviderType:                providerRegistrationPackage.ProviderType,
+					ProviderID:                  providerRegistrationPackage.ResourceID,
+				}
+				if err == engines.ErrOperationCanceled {
+					failedResourcePackage.ProvisioningState = consts.ProvisioningStateCanceled
+				}
+
+				// An operation interrupted by a shutdown is resumed by repeating the PUT, which refreshes and diffs again
+				failedResourcePackage.Resumable = err == engines.ErrOperationInterrupted
+
+				// Keep the partial state of an interrupted apply so the resource can be refreshed later,
+				// or the state the apply started from if it returned none
+				if resourceState == nil || len(resourceState.ID) == 0 {
+					resourceState = applyRequest.State
+				}
+				if resourceState != nil && len(resourceState.ID) > 0 {
+					failedResourcePackage.StateID = resourceState.ID
+					failedResourcePackage.State = resourceState
+					failedResourcePackage.SchemaVersion = schemaVersion
+				}
+
+				err = resourceManager.ResourceDataProvider.InsertFencedPackage(backgroundCtx, failedResourcePackage, lock.FencingToken())
+				if err != nil {
+					fmt.Printf("Failed to insert data: %s", err)
+					return
+				}
+
+				resourceManager.EventEngine.PublishResourceEvent(backgroundCtx, consts.EventTypeResourceFailed, failedResourcePackage)
 				return
 			}
 
-			// insert Document in collection
-			err = resourceManager.ResourceDataProvider.InsertPackage(&entities.ResourcePackage{
+			succeededResourcePackage := &entities.ResourcePackage{
 				Location:          resourceDefinition.Location,
+				Tags:              resourceDefinition.Tags,
 				ResourceID:        fullyQualifiedResourceID,
 				StateID:           resourceState.ID,
 				State:             resourceState,
+				SchemaVersion:     schemaVersion,
 				ProvisioningState: consts.ProvisioningStateSucceeded,
-				Config:            configFile,
+				OperationAttempts: applyRequest.Attempts,
+				LastRefreshedAt:   engines.GetRefreshTime(),
+				ResourceName:      resourceName,
+				Settings:          resourceSpec,
 				ResourceType:      resourceDefinition.Properties.ResourceType,
 				ProviderType:      providerRegistrationPackage.ProviderType,
-			})
+				ProviderID:        providerRegistrationPackage.ResourceID,
+			}
+			if applyRequest.LastError != nil {
+				succeededResourcePackage.OperationLastError = applyRequest.LastError.Error()
+			}
+
+			// insert Document in collection
+			err = resourceManager.ResourceDataProvider.InsertFencedPackage(backgroundCtx, succeededResourcePackage, lock.FencingToken())
 			if err != nil {
 				fmt.Printf("Failed to insert data: %s", err)
+				return
 			}
-		}()
+
+			resourceManager.EventEngine.PublishResourceEvent(backgroundCtx, succeededEventType, succeededResourcePackage)
+		})
 	}
 
 	responseContent, err := json.Marshal(resourcePackage.ToDefinition())
@@ -384,17 +844,18 @@ func (resourceManager *ResourceManager) PutResourceController(request *restful.R
 
 	response.Header().Set(restful.HEADER_ContentType, restful.MIME_JSON)
 	response.Header().Set(consts.AzureAsyncOperationHeader, getAsyncOperationURI(request.HeaderParameter(consts.RefererHeader), engines.GetAzureAsyncOperationID(request)))
-	response.WriteHeader(http.StatusCreated)
+	response.WriteHeader(acceptedStatusCode)
 	response.Write(responseContent)
 }
 
 // DeleteResourceController deletes a resource
 func (resourceManager *ResourceManager) DeleteResourceController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
 	fullyQualifiedResourceID := engines.GetFullyQualifiedResourceID(request)
 
 	// Get Document from collection
 	resourcePackage := entities.ResourcePackage{}
-	err := resourceManager.ResourceDataProvider.FindPackage(fullyQualifiedResourceID, &resourcePackage)
+	err := resourceManager.ResourceDataProvider.FindPackage(ctx, fullyQualifiedResourceID, &resourcePackage)
 	if err != nil {
 		apierror.WriteErrorToResponse(
 			response,
@@ -415,113 +876,721 @@ func (resourceManager *ResourceManager) DeleteResourceController(request *restfu
 		return
 	}
 
-	provider := engines.GetProvider(resourcePackage.ProviderType)
+	if retryAfter, throttled := resourceManager.ThrottlingEngine.TakeResourceProviderRegistration(resourcePackage.ProviderID, resourcePackage.ResourceID, engines.ThrottlingKindWrite); throttled {
+		engines.WriteThrottledResponse(response, engines.ThrottlingScopeProviderRegistration, retryAfter)
+		return
+	}
 
-	cfg, err := config.Load(resourcePackage.Config)
+	// The resources of a provider registration with a soft delete retention are kept until they are purged
+	statusCode, errorResponse := http.StatusOK, (*apierror.ErrorResponse)(nil)
+	providerRegistrationPackage := entities.ProviderRegistrationPackage{}
+	err = resourceManager.ProviderRegistrationDataProvider.FindPackage(ctx, resourcePackage.ProviderID, &providerRegistrationPackage)
+	if err == nil && providerRegistrationPackage.SoftDeleteRetentionDays > 0 {
+		statusCode, errorResponse = resourceManager.softDeleteResource(ctx, resourceManager.LockEngine, &resourcePackage, providerRegistrationPackage.SoftDeleteRetentionDays)
+	} else {
+		statusCode, errorResponse = resourceManager.destroyResource(ctx, resourceManager.OperationEngine, resourceManager.LockEngine, &resourcePackage)
+	}
+	if errorResponse != nil {
+		apierror.WriteErrorToResponseWitAPIError(
+			response,
+			statusCode,
+			errorResponse)
+		return
+	}
+
+	response.WriteHeader(http.StatusOK)
+}
+
+// GetOperationStatusController returns an opeartion status
+func (resourceManager *ResourceManager) GetOperationStatusController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	fullyQualifiedOperationStatusID := engines.GetFullyQualifiedOperationStatusID(request)
+
+	// Get Document from collection
+	var asyncOperationResult *entities.AsyncOperationResult
+	resourcePackage := entities.ResourcePackage{}
+	err := resourceManager.ResourceDataProvider.FindPackage(ctx, fullyQualifiedOperationStatusID, &resourcePackage)
+	if err == nil {
+		asyncOperationResult = resourcePackage.ToAsyncOperationResult()
+	} else {
+		// Operations which are not the operation of a resource are terraform imports
+		terraformImportOperationPackage := entities.TerraformImportOperationPackage{}
+		if resourceManager.TerraformImportOperationDataProvider.FindPackage(ctx, fullyQualifiedOperationStatusID, &terraformImportOperationPackage) != nil {
+			apierror.WriteErrorToResponse(
+				response,
+				http.StatusNotFound,
+				apierror.ClientError,
+				apierror.NotFound,
+				err.Error())
+			return
+		}
+		asyncOperationResult = terraformImportOperationPackage.ToAsyncOperationResult()
+	}
+
+	responseContent, err := json.Marshal(asyncOperationResult)
 	if err != nil {
 		apierror.WriteErrorToResponse(
 			response,
-			http.StatusBadRequest,
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to serialize response content: %s", err))
+		return
+	}
+
+	response.Header().Set(restful.HEADER_ContentType, restful.MIME_JSON)
+	response.Write(responseContent)
+}
+
+// CancelOperationController cancels an in-flight operation, the cancellation is requested on the lock of the resource
+// so that the replica running the operation cancels it
+func (resourceManager *ResourceManager) CancelOperationController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	fullyQualifiedOperationStatusID := engines.GetFullyQualifiedOperationStatusID(request)
+
+	resourcePackage := entities.ResourcePackage{}
+	err := resourceManager.ResourceDataProvider.FindPackage(ctx, fullyQualifiedOperationStatusID, &resourcePackage)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusNotFound,
 			apierror.ClientError,
-			apierror.BadRequest,
+			apierror.NotFound,
 			err.Error())
 		return
 	}
 
-	// Init provider
-	for _, v := range cfg.ProviderConfigs {
-		err = provider.Configure(terraform.NewResourceConfig(v.RawConfig))
+	if !strings.EqualFold(resourcePackage.ProvisioningState, consts.ProvisioningStateAccepted) {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusConflict,
+			apierror.ClientError,
+			apierror.Conflict,
+			fmt.Sprintf("There is no in-flight operation for resource with id '%s'", fullyQualifiedOperationStatusID))
+		return
+	}
+
+	err = resourceManager.LockEngine.RequestResourceCancel(ctx, fullyQualifiedOperationStatusID)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to cancel the operation: %s", err))
+		return
+	}
+
+	// The operation is canceled at once if it runs on this replica
+	resourceManager.OperationEngine.Cancel(fullyQualifiedOperationStatusID)
+
+	response.WriteHeader(http.StatusAccepted)
+}
+
+// PostExportTerraformController returns the terraform configuration and state managing the resources of a resource group
+func (resourceManager *ResourceManager) PostExportTerraformController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	resourceIDPrefix := engines.GetFullyQualifiedResourceIDPrefix(request)
+
+	resourcePackages := []entities.ResourcePackage{}
+	err := resourceManager.ResourceDataProvider.FindPackagesByResourceIDPrefix(ctx, resourceIDPrefix, &resourcePackages)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to find resources: %s", err))
+		return
+	}
+
+	providerRegistrationPackages := []entities.ProviderRegistrationPackage{}
+	providerIDs := make(map[string]bool)
+	for i := range resourcePackages {
+		resourcePackage := &resourcePackages[i]
+		if strings.EqualFold(resourcePackage.ProvisioningState, consts.ProvisioningStateAccepted) {
+			apierror.WriteErrorToResponse(
+				response,
+				http.StatusConflict,
+				apierror.ClientError,
+				apierror.Conflict,
+				fmt.Sprintf("Cannot export Resource with id '%s' as it is being provisioned", resourcePackage.ResourceID))
+			return
+		}
+
+		if len(resourcePackage.Settings) == 0 {
+			resourcePackage.ResourceName, resourcePackage.Settings, err = getLegacyResourceSettings(resourcePackage)
+			if err != nil {
+				apierror.WriteErrorToResponse(
+					response,
+					http.StatusBadRequest,
+					apierror.ClientError,
+					apierror.BadRequest,
+					err.Error())
+				return
+			}
+		}
+
+		// Resources created before they referenced a provider registration export the provider settings of their config file
+		if len(resourcePackage.ProviderID) == 0 {
+			providerRegistrationPackage, err := getLegacyProviderRegistration(resourcePackage)
+			if err != nil {
+				apierror.WriteErrorToResponse(
+					response,
+					http.StatusBadRequest,
+					apierror.ClientError,
+					apierror.BadRequest,
+					err.Error())
+				return
+			}
+			resourcePackage.ProviderID = providerRegistrationPackage.ResourceID
+			providerRegistrationPackages = append(providerRegistrationPackages, providerRegistrationPackage)
+			continue
+		}
+
+		if providerIDs[strings.ToLower(resourcePackage.ProviderID)] {
+			continue
+		}
+		providerIDs[strings.ToLower(resourcePackage.ProviderID)] = true
+
+		providerRegistrationPackage := entities.ProviderRegistrationPackage{}
+		err = resourceManager.ProviderRegistrationDataProvider.FindPackage(ctx, resourcePackage.ProviderID, &providerRegistrationPackage)
 		if err != nil {
 			apierror.WriteErrorToResponse(
 				response,
 				http.StatusBadRequest,
 				apierror.ClientError,
 				apierror.BadRequest,
-				fmt.Sprintf("Failed to init provider: %s", err))
+				fmt.Sprintf("The provider registration %s was not found: %s", resourcePackage.ProviderID, err))
 			return
 		}
+		providerRegistrationPackages = append(providerRegistrationPackages, providerRegistrationPackage)
 	}
 
-	info := &terraform.InstanceInfo{
-		Type: resourcePackage.ResourceType,
+	terraformExport, err := engines.ExportTerraform(providerRegistrationPackages, resourcePackages)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to export resources: %s", err))
+		return
+	}
+
+	responseContent, err := json.Marshal(terraformExport)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to serialize response content: %s", err))
+		return
 	}
 
-	diff := new(terraform.InstanceDiff)
-	diff.Destroy = true
+	response.Header().Set(restful.HEADER_ContentType, restful.MIME_JSON)
+	response.Write(responseContent)
+}
+
+// PostImportTerraformController imports the resources of a terraform state into a resource group, every resource
+// is refreshed to confirm it still exists before it is stored. The import is asynchronous and one import runs
+// at a time in a resource group.
+func (resourceManager *ResourceManager) PostImportTerraformController(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	terraformImportDefinition := entities.TerraformImportDefinition{}
 
-	// Call apply to delete resource
-	resourceState, err := provider.Apply(info, resourcePackage.State, diff)
+	rawBody, err := ioutil.ReadAll(request.Request.Body)
 	if err != nil {
 		apierror.WriteErrorToResponse(
 			response,
 			http.StatusBadRequest,
 			apierror.ClientError,
 			apierror.BadRequest,
-			fmt.Sprintf("Failed to delete resourse: %s", err))
+			fmt.Sprintf("Request content is invalid: %s", err))
 		return
 	}
 
-	if resourceState == nil {
-		err := resourceManager.ResourceDataProvider.RemovePackage(fullyQualifiedResourceID)
-		if err != nil {
-			apierror.WriteErrorToResponse(
-				response,
-				http.StatusInternalServerError,
-				apierror.InternalError,
-				apierror.InternalOperationError,
-				fmt.Sprintf("Failed to delete resource '%s' from storage: %s", fullyQualifiedResourceID, err))
-			return
-		}
+	err = json.Unmarshal(rawBody, &terraformImportDefinition)
+	if err != nil {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusBadRequest,
+			apierror.ClientError,
+			apierror.BadRequest,
+			fmt.Sprintf("Request content cannot be deserialized as JSON: %s", err))
+		return
 	}
 
-	response.WriteHeader(http.StatusOK)
-}
-
-// GetOperationStatusController returns an opeartion status
-func (resourceManager *ResourceManager) GetOperationStatusController(request *restful.Request, response *restful.Response) {
-	fullyQualifiedOperationStatusID := engines.GetFullyQualifiedOperationStatusID(request)
+	validationError := engines.ValidateTerraformImportDefinition(&terraformImportDefinition)
+	if validationError != nil {
+		apierror.WriteErrorToResponseWitAPIError(
+			response,
+			http.StatusBadRequest,
+			validationError)
+		return
+	}
 
-	// Get Document from collection
-	resourcePackage := entities.ResourcePackage{}
-	err := resourceManager.ResourceDataProvider.FindPackage(fullyQualifiedOperationStatusID, &resourcePackage)
+	state, err := engines.ParseTerraformState(terraformImportDefinition.State)
 	if err != nil {
 		apierror.WriteErrorToResponse(
 			response,
-			http.StatusNotFound,
+			http.StatusBadRequest,
 			apierror.ClientError,
-			apierror.NotFound,
-			err.Error())
+			apierror.BadRequest,
+			fmt.Sprintf("Failed to parse terraform state: %s", err))
+		return
+	}
+
+	// The import is admitted with one write of the provider registration of its first resource, the refreshes
+	// of the resources are paced by the budgets of their provider registrations in the background
+	if retryAfter, throttled := resourceManager.ThrottlingEngine.TakeProviderRegistration(terraformImportDefinition.Resources[0].ProviderID, engines.ThrottlingKindWrite); throttled {
+		engines.WriteThrottledResponse(response, engines.ThrottlingScopeProviderRegistration, retryAfter)
 		return
 	}
 
-	responseContent, err := json.Marshal(resourcePackage.ToAsyncOperationResult())
+	resourceIDPrefix := engines.GetFullyQualifiedResourceIDPrefix(request)
+	lock, err := resourceManager.LockEngine.Acquire(ctx, getTerraformImportLockName(resourceIDPrefix))
+	if err == engines.ErrLockHeld {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusConflict,
+			apierror.ClientError,
+			apierror.Conflict,
+			"Another terraform state is being imported into the resource group.")
+		return
+	}
 	if err != nil {
 		apierror.WriteErrorToResponse(
 			response,
 			http.StatusInternalServerError,
 			apierror.InternalError,
 			apierror.InternalOperationError,
-			fmt.Sprintf("Failed to serialize response content: %s", err))
+			fmt.Sprintf("Failed to lock the resource group: %s", err))
 		return
 	}
 
-	response.Header().Set(restful.HEADER_ContentType, restful.MIME_JSON)
-	response.Write(responseContent)
+	operationStatusID := terraformImportOperationPrefix + bson.NewObjectId().Hex()
+	now := time.Now().UTC()
+	terraformImportOperationPackage := &entities.TerraformImportOperationPackage{
+		OperationID:      resourceIDPrefix + operationStatusID,
+		Status:           consts.ProvisioningStateAccepted,
+		CreatedTime:      now,
+		LastModifiedTime: now,
+	}
+	err = resourceManager.TerraformImportOperationDataProvider.InsertPackage(ctx, terraformImportOperationPackage)
+	if err != nil {
+		lock.Release()
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusInternalServerError,
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to insert data: %s", err))
+		return
+	}
+
+	// The import outlives the request, it continues the trace of the request in the background
+	backgroundCtx := tracing.Detach(ctx)
+	resourceManager.OperationEngine.Go(func() {
+		defer lock.Release()
+		resourceManager.importTerraformResources(backgroundCtx, state, resourceIDPrefix, terraformImportDefinition.Resources, terraformImportOperationPackage)
+	})
+
+	response.Header().Set(consts.AzureAsyncOperationHeader, getAsyncOperationURI(request.HeaderParameter(consts.RefererHeader), engines.GetAzureAsyncOperationIDOfOperation(request, operationStatusID)))
+	response.WriteHeader(http.StatusAccepted)
 }
 
-func getConfigFileInJSON(providerType string, providerSpec []byte, resource entities.ResourceDefinition, resourceName string, resourceSpec []byte) string {
-	return fmt.Sprintf(`
-		{
-			"provider": {
-				"%s": %s
-			},
-			"resource": {
-				"%s": {
-					"%s": %s
-				}
+// importTerraformResources imports the resources one after the other, the results are stored as they are imported
+// so the operation status reports the progress of the import
+func (resourceManager *ResourceManager) importTerraformResources(
+	ctx context.Context,
+	state *terraform.State,
+	resourceIDPrefix string,
+	terraformImportResources []entities.TerraformImportResource,
+	terraformImportOperationPackage *entities.TerraformImportOperationPackage) {
+	for i := range terraformImportResources {
+		terraformImportOperationPackage.Result.Add(resourceManager.importTerraformResource(ctx, state, resourceIDPrefix, &terraformImportResources[i]))
+		terraformImportOperationPackage.LastModifiedTime = time.Now().UTC()
+		if err := resourceManager.TerraformImportOperationDataProvider.InsertPackage(ctx, terraformImportOperationPackage); err != nil {
+			log.Printf("Failed to insert data: %s", err)
+		}
+	}
+
+	terraformImportOperationPackage.Status = consts.ProvisioningStateSucceeded
+	if imported := terraformImportOperationPackage.Result.Counts[entities.TerraformImportStatusImported]; imported < len(terraformImportResources) {
+		terraformImportOperationPackage.Status = consts.ProvisioningStateFailed
+		terraformImportOperationPackage.ErrorCode = string(apierror.TerraformImportFailed)
+		terraformImportOperationPackage.ErrorMessage = fmt.Sprintf("%d of %d resources were not imported.", len(terraformImportResources)-imported, len(terraformImportResources))
+	}
+	terraformImportOperationPackage.LastModifiedTime = time.Now().UTC()
+	if err := resourceManager.TerraformImportOperationDataProvider.InsertPackage(ctx, terraformImportOperationPackage); err != nil {
+		log.Printf("Failed to insert data: %s", err)
+	}
+}
+
+func (resourceManager *ResourceManager) importTerraformResource(
+	ctx context.Context,
+	state *terraform.State,
+	resourceIDPrefix string,
+	terraformImportResource *entities.TerraformImportResource) entities.TerraformImportResourceResult {
+	result := entities.TerraformImportResourceResult{
+		Address:    terraformImportResource.Address,
+		ResourceID: resourceIDPrefix + terraformImportResource.ResourceName,
+	}
+
+	fail := func(status string, message string) entities.TerraformImportResourceResult {
+		result.Status = status
+		result.Error = message
+		return result
+	}
+
+	resourceType, instanceState, err := engines.FindInstanceState(state, terraformImportResource.Address)
+	if err != nil {
+		return fail(entities.TerraformImportStatusFailed, err.Error())
+	}
+
+	providerRegistrationPackage := entities.ProviderRegistrationPackage{}
+	err = resourceManager.ProviderRegistrationDataProvider.FindPackage(ctx, terraformImportResource.ProviderID, &providerRegistrationPackage)
+	if err != nil {
+		return fail(entities.TerraformImportStatusFailed, fmt.Sprintf("The provider registration %s was not found.", terraformImportResource.ProviderID))
+	}
+
+	provider := engines.GetProvider(providerRegistrationPackage.ProviderType)
+	if _, ok := provider.ResourcesMap[resourceType]; !ok {
+		return fail(entities.TerraformImportStatusFailed, fmt.Sprintf("The resource type %s is not supported by provider %s.", resourceType, providerRegistrationPackage.ProviderType))
+	}
+
+	// The resource is refreshed by its provider, the refresh waits for the write budget of its provider registration
+	if err := resourceManager.ThrottlingEngine.WaitProviderRegistration(ctx, terraformImportResource.ProviderID, engines.ThrottlingKindWrite); err != nil {
+		return fail(entities.TerraformImportStatusFailed, fmt.Sprintf("The import was interrupted: %s", err))
+	}
+
+	lock, err := resourceManager.LockEngine.AcquireResourceLock(ctx, result.ResourceID)
+	if err == engines.ErrLockHeld {
+		return fail(entities.TerraformImportStatusConflict, "The resource is being modified by another request.")
+	}
+	if err != nil {
+		return fail(entities.TerraformImportStatusFailed, fmt.Sprintf("Failed to lock resource: %s", err))
+	}
+	defer lock.Release()
+
+	existingResourcePackage := entities.ResourcePackage{}
+	if err := resourceManager.ResourceDataProvider.FindPackage(ctx, result.ResourceID, &existingResourcePackage); err == nil {
+		return fail(entities.TerraformImportStatusConflict, "The resource already exists.")
+	}
+	if err := resourceManager.DeletedResourceDataProvider.FindPackage(ctx, result.ResourceID, &entities.DeletedResourcePackage{}); err == nil {
+		return fail(entities.TerraformImportStatusConflict, "The resource was deleted and is retained.")
+	}
+
+	// Two resources managing the same infrastructure would overwrite each other
+	managingResourcePackages := []entities.ResourcePackage{}
+	err = resourceManager.ResourceDataProvider.FindPackagesByStateID(ctx, providerRegistrationPackage.ResourceID, resourceType, instanceState.ID, &managingResourcePackages)
+	if err != nil {
+		return fail(entities.TerraformImportStatusFailed, fmt.Sprintf("Failed to find the resources managing state id %s: %s", instanceState.ID, err))
+	}
+	if len(managingResourcePackages) > 0 {
+		return fail(entities.TerraformImportStatusConflict, fmt.Sprintf("The infrastructure is already managed by resource %s.", managingResourcePackages[0].ResourceID))
+	}
+
+	var settings []byte
+	if terraformImportResource.Settings != nil {
+		settings, err = json.Marshal(terraformImportResource.Settings)
+		if err != nil {
+			return fail(entities.TerraformImportStatusFailed, fmt.Sprintf("Failed to serialize resource settings: %s", err))
+		}
+	}
+
+	// Configure the provider with the resource settings when they are given, so that they are validated
+	configFile := ""
+	if settings != nil {
+		var errorResponse *apierror.ErrorResponse
+		configFile, _, errorResponse = resourceManager.getResolvedConfigFile(ctx, &providerRegistrationPackage, resourceType, terraformImportResource.ResourceName, settings)
+		if errorResponse != nil {
+			return fail(entities.TerraformImportStatusFailed, getErrorMessage(errorResponse))
+		}
+	} else {
+		providerSpec, _, errorResponse := resourceManager.SecretReferenceEngine.ResolveSettings(ctx, engines.ProviderRegistrationSettingsTarget, providerRegistrationPackage.Settings)
+		if errorResponse != nil {
+			return fail(entities.TerraformImportStatusFailed, getErrorMessage(errorResponse))
+		}
+		configFile = engines.GetProviderConfigFileInJSON(providerRegistrationPackage.ProviderType, providerSpec)
+	}
+
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return fail(entities.TerraformImportStatusFailed, fmt.Sprintf("Failed to parse config file: %s", err))
+	}
+	for _, v := range cfg.Resources {
+		if validationError := engines.ValidateResourceSettings(provider, resourceType, terraform.NewResourceConfig(v.RawConfig)); validationError != nil {
+			return fail(entities.TerraformImportStatusFailed, getErrorMessage(validationError))
+		}
+	}
+	for _, v := range cfg.ProviderConfigs {
+		err = engines.ConfigureProvider(ctx, providerRegistrationPackage.ProviderType, provider, terraform.NewResourceConfig(v.RawConfig))
+		if err != nil {
+			return fail(entities.TerraformImportStatusFailed, fmt.Sprintf("Failed to init provider: %s", err))
+		}
+	}
+
+	resourcePackage := entities.ResourcePackage{
+		Location:          terraformImportResource.Location,
+		Tags:              terraformImportResource.Tags,
+		ResourceID:        result.ResourceID,
+		State:             instanceState,
+		ProvisioningState: consts.ProvisioningStateSucceeded,
+		ResourceName:      terraformImportResource.ResourceName,
+		Settings:          settings,
+		ResourceType:      resourceType,
+		ProviderType:      providerRegistrationPackage.ProviderType,
+		ProviderID:        providerRegistrationPackage.ResourceID,
+	}
+
+	_, errorResponse := resourceManager.migrateResourceState(provider, &resourcePackage)
+	if errorResponse != nil {
+		return fail(entities.TerraformImportStatusFailed, getErrorMessage(errorResponse))
+	}
+
+	info := &terraform.InstanceInfo{
+		Type: resourceType,
+	}
+	refreshCtx, cancel := engines.WithSynchronousRetryBudget(ctx)
+	defer cancel()
+
+	resourcePackage.State, err = resourceManager.OperationEngine.Refresh(refreshCtx, providerRegistrationPackage.ProviderType, provider, info, resourcePackage.State)
+	if err != nil {
+		return fail(entities.TerraformImportStatusFailed, fmt.Sprintf("Failed to refresh resource: %s", err))
+	}
+	if resourcePackage.State == nil {
+		return fail(entities.TerraformImportStatusNotFound, "The resource no longer exists.")
+	}
+	resourcePackage.StateID = resourcePackage.State.ID
+	resourcePackage.LastRefreshedAt = engines.GetRefreshTime()
+
+	if resourcePackage.Settings == nil {
+		resourcePackage.Settings, err = engines.GetResourceSettings(provider, resourceType, resourcePackage.State)
+		if err != nil {
+			return fail(entities.TerraformImportStatusFailed, fmt.Sprintf("Failed to read resource settings from state: %s", err))
+		}
+	}
+
+	err = resourceManager.ResourceDataProvider.InsertFencedPackage(ctx, &resourcePackage, lock.FencingToken())
+	if err != nil {
+		return fail(entities.TerraformImportStatusFailed, fmt.Sprintf("Failed to insert data: %s", err))
+	}
+
+	resourceManager.EventEngine.PublishResourceEvent(ctx, consts.EventTypeResourceCreated, &resourcePackage)
+
+	result.Status = entities.TerraformImportStatusImported
+	return result
+}
+
+// RecoverOrphanedOperations fails the operations and terraform imports left accepted by a replica which stopped
+// before completing them, an operation is orphaned when nobody holds the lock of its resource
+func (resourceManager *ResourceManager) RecoverOrphanedOperations(ctx context.Context) {
+	resourcePackages := []entities.ResourcePackage{}
+	err := resourceManager.ResourceDataProvider.FindPackagesByProvisioningState(ctx, consts.ProvisioningStateAccepted, &resourcePackages)
+	if err != nil {
+		log.Printf("Failed to find accepted resources: %s", err)
+		return
+	}
+
+	for _, resourcePackage := range resourcePackages {
+		lock, err := resourceManager.LockEngine.AcquireResourceLock(ctx, resourcePackage.ResourceID)
+		if err != nil {
+			// The operation is still running
+			continue
+		}
+
+		// The operation may have completed since the resources were listed
+		orphanedResourcePackage := entities.ResourcePackage{}
+		err = resourceManager.ResourceDataProvider.FindPackage(ctx, resourcePackage.ResourceID, &orphanedResourcePackage)
+		if err == nil && strings.EqualFold(orphanedResourcePackage.ProvisioningState, consts.ProvisioningStateAccepted) {
+			failInterruptedOperation(&orphanedResourcePackage)
+			err = resourceManager.ResourceDataProvider.InsertFencedPackage(ctx, &orphanedResourcePackage, lock.FencingToken())
+			if err != nil {
+				log.Printf("Failed to insert data: %s", err)
+			} else {
+				resourceManager.EventEngine.PublishResourceEvent(ctx, consts.EventTypeResourceFailed, &orphanedResourcePackage)
+			}
+		}
+
+		lock.Release()
+	}
+
+	resourceManager.recoverOrphanedTerraformImports(ctx)
+}
+
+// recoverOrphanedTerraformImports fails the terraform imports left accepted by a replica which stopped before
+// completing them, an import is orphaned when nobody holds the import lock of its resource group
+func (resourceManager *ResourceManager) recoverOrphanedTerraformImports(ctx context.Context) {
+	terraformImportOperationPackages := []entities.TerraformImportOperationPackage{}
+	err := resourceManager.TerraformImportOperationDataProvider.FindPackagesByStatus(ctx, consts.ProvisioningStateAccepted, &terraformImportOperationPackages)
+	if err != nil {
+		log.Printf("Failed to find accepted terraform imports: %s", err)
+		return
+	}
+
+	for _, terraformImportOperationPackage := range terraformImportOperationPackages {
+		resourceIDPrefix := terraformImportOperationPackage.OperationID[:strings.LastIndex(terraformImportOperationPackage.OperationID, "/")+1]
+		lock, err := resourceManager.LockEngine.Acquire(ctx, getTerraformImportLockName(resourceIDPrefix))
+		if err != nil {
+			// The import is still running
+			continue
+		}
+
+		// The import may have completed since the imports were listed
+		orphanedTerraformImportOperationPackage := entities.TerraformImportOperationPackage{}
+		err = resourceManager.TerraformImportOperationDataProvider.FindPackage(ctx, terraformImportOperationPackage.OperationID, &orphanedTerraformImportOperationPackage)
+		if err == nil && strings.EqualFold(orphanedTerraformImportOperationPackage.Status, consts.ProvisioningStateAccepted) {
+			providerError := engines.ClassifyProviderError(engines.ErrOperationInterrupted)
+			orphanedTerraformImportOperationPackage.Status = consts.ProvisioningStateFailed
+			orphanedTerraformImportOperationPackage.ErrorCode = string(providerError.AsyncErrorCode())
+			orphanedTerraformImportOperationPackage.ErrorMessage = engines.ErrOperationInterrupted.Error()
+			orphanedTerraformImportOperationPackage.LastModifiedTime = time.Now().UTC()
+			err = resourceManager.TerraformImportOperationDataProvider.InsertPackage(ctx, &orphanedTerraformImportOperationPackage)
+			if err != nil {
+				log.Printf("Failed to insert data: %s", err)
 			}
 		}
-`, providerType, string(providerSpec), resource.Properties.ResourceType, resourceName, string(resourceSpec))
+
+		lock.Release()
+	}
+}
+
+// getTerraformImportLockName returns the name of the lock held by the terraform import of a resource group
+func getTerraformImportLockName(resourceIDPrefix string) string {
+	return resourceIDPrefix + terraformImportOperationPrefix
+}
+
+// failInterruptedOperation fails the accepted operation of a resource as interrupted, the operation is resumed
+// by repeating the PUT
+func failInterruptedOperation(resourcePackage *entities.ResourcePackage) {
+	providerError := engines.ClassifyProviderError(engines.ErrOperationInterrupted)
+	resourcePackage.ProvisioningState = consts.ProvisioningStateFailed
+	resourcePackage.ProvisioningErrorCode = string(providerError.AsyncErrorCode())
+	resourcePackage.ProvisioningErrorDetailCode = string(providerError.Code)
+	resourcePackage.ProvisioningErrorMessage = engines.ErrOperationInterrupted.Error()
+	resourcePackage.Resumable = true
+}
+
+// UpgradeStates migrates the stored states of all resources to the schema versions of the current providers,
+// a dry run migrates the states without storing them to report what would be upgraded
+func (resourceManager *ResourceManager) UpgradeStates(ctx context.Context, dryRun bool) *entities.StateUpgradeReport {
+	stateUpgradeReport := &entities.StateUpgradeReport{DryRun: dryRun}
+
+	resourcePackages := []entities.ResourcePackage{}
+	err := resourceManager.ResourceDataProvider.FindAllPackages(ctx, &resourcePackages)
+	if err != nil {
+		stateUpgradeReport.Add(entities.StateUpgradeResult{
+			Status: entities.StateUpgradeStatusFailed,
+			Error:  fmt.Sprintf("Failed to find resources: %s", err),
+		})
+		return stateUpgradeReport
+	}
+
+	for i := range resourcePackages {
+		stateUpgradeReport.Add(resourceManager.upgradeState(ctx, &resourcePackages[i], dryRun))
+	}
+
+	return stateUpgradeReport
+}
+
+func (resourceManager *ResourceManager) upgradeState(ctx context.Context, resourcePackage *entities.ResourcePackage, dryRun bool) entities.StateUpgradeResult {
+	stateUpgradeResult := entities.StateUpgradeResult{
+		ResourceID:        resourcePackage.ResourceID,
+		ResourceType:      resourcePackage.ResourceType,
+		ProviderType:      resourcePackage.ProviderType,
+		FromSchemaVersion: engines.GetStoredSchemaVersion(resourcePackage),
+	}
+
+	fail := func(message string) entities.StateUpgradeResult {
+		stateUpgradeResult.Status = entities.StateUpgradeStatusFailed
+		stateUpgradeResult.Error = message
+		return stateUpgradeResult
+	}
+
+	var lock *engines.Lock
+	if !dryRun {
+		var err error
+		lock, err = resourceManager.LockEngine.AcquireResourceLock(ctx, resourcePackage.ResourceID)
+		if err != nil {
+			return fail(fmt.Sprintf("Failed to lock resource: %s", err))
+		}
+		defer lock.Release()
+
+		// The resource may have changed since the resources were listed
+		err = resourceManager.ResourceDataProvider.FindPackage(ctx, resourcePackage.ResourceID, resourcePackage)
+		if err != nil {
+			return fail(fmt.Sprintf("Failed to find resource: %s", err))
+		}
+		stateUpgradeResult.FromSchemaVersion = engines.GetStoredSchemaVersion(resourcePackage)
+	}
+
+	// Accepted resources are upgraded by the apply which writes their new state
+	if resourcePackage.State == nil || strings.EqualFold(resourcePackage.ProvisioningState, consts.ProvisioningStateAccepted) {
+		stateUpgradeResult.ToSchemaVersion = stateUpgradeResult.FromSchemaVersion
+		stateUpgradeResult.Status = entities.StateUpgradeStatusSkipped
+		return stateUpgradeResult
+	}
+
+	providerRegistrationPackage, cfg, _, errorResponse := resourceManager.loadResourceConfig(ctx, resourcePackage)
+	if errorResponse != nil {
+		return fail(getErrorMessage(errorResponse))
+	}
+
+	provider := engines.GetProvider(providerRegistrationPackage.ProviderType)
+	stateUpgradeResult.ToSchemaVersion = engines.GetSchemaVersion(provider, resourcePackage.ResourceType)
+	if stateUpgradeResult.FromSchemaVersion == stateUpgradeResult.ToSchemaVersion {
+		stateUpgradeResult.Status = entities.StateUpgradeStatusUpToDate
+		return stateUpgradeResult
+	}
+
+	// Migrations may call the provider, e.g. to look up ids
+	for _, v := range cfg.ProviderConfigs {
+		err := engines.ConfigureProvider(ctx, providerRegistrationPackage.ProviderType, provider, terraform.NewResourceConfig(v.RawConfig))
+		if err != nil {
+			return fail(fmt.Sprintf("Failed to init provider: %s", err))
+		}
+	}
+
+	_, errorResponse = resourceManager.migrateResourceState(provider, resourcePackage)
+	if errorResponse != nil {
+		return fail(getErrorMessage(errorResponse))
+	}
+
+	if dryRun {
+		stateUpgradeResult.Status = entities.StateUpgradeStatusWouldUpgrade
+		return stateUpgradeResult
+	}
+
+	err := resourceManager.ResourceDataProvider.InsertFencedPackage(ctx, resourcePackage, lock.FencingToken())
+	if err != nil {
+		return fail(fmt.Sprintf("Failed to insert data: %s", err))
+	}
+
+	stateUpgradeResult.Status = entities.StateUpgradeStatusUpgraded
+	return stateUpgradeResult
+}
+
+// writeLockErrorToResponse writes a 409 if the resource is locked or was modified by the holder of a newer lock
+func writeLockErrorToResponse(response *restful.Response, resourceID string, err error) {
+	if err == engines.ErrLockHeld || err == storage.ErrStaleFencingToken {
+		apierror.WriteErrorToResponse(
+			response,
+			http.StatusConflict,
+			apierror.ClientError,
+			apierror.Conflict,
+			fmt.Sprintf("Cannot modify Resource with id '%s' as it is being modified by another request", resourceID))
+		return
+	}
+
+	apierror.WriteErrorToResponse(
+		response,
+		http.StatusInternalServerError,
+		apierror.InternalError,
+		apierror.InternalOperationError,
+		fmt.Sprintf("Failed to lock resource '%s': %s", resourceID, err))
 }
 
 func getAsyncOperationURI(baseURI string, resourceID string) string {
@@ -0,0 +1,48 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AdminCertificateThumbprints are the SHA-1 thumbprints of the client certificates allowed to call the admin API,
+// the admin API is disabled if empty
+var AdminCertificateThumbprints []string
+
+// IsAdminEnabled returns whether client certificates are allowed to call the admin API
+func IsAdminEnabled() bool {
+	return len(AdminCertificateThumbprints) > 0
+}
+
+// GetAdminPrincipal returns the principal of an admin request authenticated by its client certificate,
+// it returns false if the request presented no allowed certificate. TLS proves the client holds the key
+// of the certificate, which is pinned by its thumbprint rather than verified against a chain.
+func GetAdminPrincipal(request *http.Request) (string, bool) {
+	if request.TLS == nil || len(request.TLS.PeerCertificates) == 0 {
+		return "", false
+	}
+
+	certificate := request.TLS.PeerCertificates[0]
+	now := time.Now()
+	if now.Before(certificate.NotBefore) || now.After(certificate.NotAfter) {
+		return "", false
+	}
+
+	thumbprint := sha1.Sum(certificate.Raw)
+	encodedThumbprint := strings.ToUpper(hex.EncodeToString(thumbprint[:]))
+	for _, allowedThumbprint := range AdminCertificateThumbprints {
+		if strings.EqualFold(strings.TrimSpace(allowedThumbprint), encodedThumbprint) {
+			return fmt.Sprintf("%s (%s)", certificate.Subject.String(), encodedThumbprint), true
+		}
+	}
+
+	return "", false
+}
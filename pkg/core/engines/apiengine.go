@@ -64,6 +64,22 @@ func GetFullyQualifiedResourceID(request *restful.Request) string {
 		"/" + Resources + "/" + GetResourceName(request)
 }
 
+// GetFullyQualifiedResourceGroupID returns the fully qualified id of the resource group
+// /subscriptions/{subscriptionId}/resourceGroups/{resourceGroup}
+func GetFullyQualifiedResourceGroupID(request *restful.Request) string {
+	return "/" + Subscriptions + "/" + GetSubscriptionID(request) +
+		"/" + ResourceGroups + "/" + GetResourceGroupName(request)
+}
+
+// GetFullyQualifiedResourceIDPrefix returns the prefix of the fully qualified ids of the resources of a resource group
+// /subscriptions/{subscriptionId}/resourceGroups/{resourceGroup}/providers/Microsoft.TerraformOSS/resources/
+func GetFullyQualifiedResourceIDPrefix(request *restful.Request) string {
+	return "/" + Subscriptions + "/" + GetSubscriptionID(request) +
+		"/" + ResourceGroups + "/" + GetResourceGroupName(request) +
+		"/" + Providers + "/" + consts.TerraformRPNamespace +
+		"/" + Resources + "/"
+}
+
 // GetFullyQualifiedProviderRegistrationID returns the fully qualified id of provider registration
 // /subscriptions/{subscriptionId}/resourceGroups/{resourceGroup}/providers/Microsoft.TerraformOSS/providerregistrations/{providerRegistration}
 func GetFullyQualifiedProviderRegistrationID(request *restful.Request) string {
@@ -73,6 +89,15 @@ func GetFullyQualifiedProviderRegistrationID(request *restful.Request) string {
 		"/" + ProviderRegistrations + "/" + GetProviderRegistrationName(request)
 }
 
+// GetFullyQualifiedProviderRegistrationIDPrefix returns the prefix of the fully qualified ids of the provider registrations of a resource group
+// /subscriptions/{subscriptionId}/resourceGroups/{resourceGroup}/providers/Microsoft.TerraformOSS/providerregistrations/
+func GetFullyQualifiedProviderRegistrationIDPrefix(request *restful.Request) string {
+	return "/" + Subscriptions + "/" + GetSubscriptionID(request) +
+		"/" + ResourceGroups + "/" + GetResourceGroupName(request) +
+		"/" + Providers + "/" + consts.TerraformRPNamespace +
+		"/" + ProviderRegistrations + "/"
+}
+
 // GetFullyQualifiedOperationStatusID returns the fully qualified resource operation status id
 // /subscriptions/{subscriptionId}/resourceGroups/{resourceGroup}/providers/Microsoft.TerraformOSS/resources/{resource}
 func GetFullyQualifiedOperationStatusID(request *restful.Request) string {
@@ -85,10 +110,15 @@ func GetFullyQualifiedOperationStatusID(request *restful.Request) string {
 // GetAzureAsyncOperationID returns the operation status id
 // /subscriptions/{subscriptionId}/resourceGroups/{resourceGroup}/providers/Microsoft.TerraformOSS/operationstatus/{operationstatusId}
 func GetAzureAsyncOperationID(request *restful.Request) string {
+	return GetAzureAsyncOperationIDOfOperation(request, GetResourceName(request))
+}
+
+// GetAzureAsyncOperationIDOfOperation returns the operation status id of an operation which is not named after
+// the resource of the request
+func GetAzureAsyncOperationIDOfOperation(request *restful.Request, operationStatusID string) string {
 	return Subscriptions + "/" + GetSubscriptionID(request) +
 		"/" + ResourceGroups + "/" + GetResourceGroupName(request) +
 		"/" + Providers + "/" + consts.TerraformRPNamespace +
-		"/" + OperationStatus + "/" + GetResourceName(request) +
+		"/" + OperationStatus + "/" + operationStatusID +
 		"?" + consts.RequestAPIVersionParameterName + "=" + consts.OperationStatusAPIVersion
-
 }
@@ -0,0 +1,241 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/metrics"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tlsVersions are the TLS versions which can be configured
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// CertificateSource returns the PEM certificate chain and private key served over TLS
+type CertificateSource interface {
+	GetCertificate() (certPEM []byte, keyPEM []byte, err error)
+}
+
+// KeyVaultCertificateSource reads the latest versions of the base64 encoded PEM certificate chain and private key secrets from key vault
+type KeyVaultCertificateSource struct {
+	SecretEngine      *SecretEngine
+	CertVaultBaseURI  string
+	CertSecretName    string
+	KeyVaultBaseURI   string
+	KeySecretName     string
+	CertSecretVersion string
+	KeySecretVersion  string
+}
+
+// FileCertificateSource reads the PEM certificate chain and private key from files, e.g. mounted by a secret store driver
+type FileCertificateSource struct {
+	CertPath string
+	KeyPath  string
+}
+
+// CertificateEngine serves the TLS certificate of the server and swaps it when the certificate source is rotated,
+// a rotated certificate is only swapped in once its chain and validity period are verified
+type CertificateEngine struct {
+	CertificateSource CertificateSource
+	// Roots verify the chain of certificates, the system roots are used if nil
+	Roots *x509.CertPool
+	// VerifyChain verifies the chain of certificates against Roots
+	VerifyChain bool
+
+	lock        sync.RWMutex
+	certificate *tls.Certificate
+	certPEM     []byte
+	keyPEM      []byte
+}
+
+// NewCertificateEngine creates a certificate engine and loads its certificate
+func NewCertificateEngine(certificateSource CertificateSource, verifyChain bool) (*CertificateEngine, error) {
+	certificateEngine := new(CertificateEngine)
+	certificateEngine.CertificateSource = certificateSource
+	certificateEngine.VerifyChain = verifyChain
+
+	if _, err := certificateEngine.Reload(); err != nil {
+		return nil, err
+	}
+
+	return certificateEngine, nil
+}
+
+// Reload reads the certificate source again, the served certificate is swapped if the certificate was rotated
+// and the rotated certificate is valid
+func (certificateEngine *CertificateEngine) Reload() (bool, error) {
+	certPEM, keyPEM, err := certificateEngine.CertificateSource.GetCertificate()
+	if err != nil {
+		return false, fmt.Errorf("Failed to get certificate: %s", err)
+	}
+
+	certificateEngine.lock.RLock()
+	unchanged := bytes.Equal(certPEM, certificateEngine.certPEM) && bytes.Equal(keyPEM, certificateEngine.keyPEM)
+	certificateEngine.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	certificate, err := certificateEngine.validate(certPEM, keyPEM)
+	if err != nil {
+		return false, err
+	}
+
+	certificateEngine.lock.Lock()
+	defer certificateEngine.lock.Unlock()
+
+	certificateEngine.certificate = certificate
+	certificateEngine.certPEM = certPEM
+	certificateEngine.keyPEM = keyPEM
+	metrics.SetTLSCertificateExpiry(certificate.Leaf.NotAfter)
+
+	return true, nil
+}
+
+// Watch reloads the certificate every interval until stop is closed, so that rotated certificates are served without a restart
+func (certificateEngine *CertificateEngine) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		reloaded, err := certificateEngine.Reload()
+		if err != nil {
+			// Keep serving the loaded certificate, it is valid until the rotated certificate is
+			log.Printf("Failed to reload TLS certificate: %s", err)
+			continue
+		}
+		if reloaded {
+			log.Printf("Reloaded rotated TLS certificate")
+		}
+	}
+}
+
+// GetCertificate returns the served certificate, it is the tls.Config GetCertificate callback
+func (certificateEngine *CertificateEngine) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certificateEngine.lock.RLock()
+	defer certificateEngine.lock.RUnlock()
+
+	return certificateEngine.certificate, nil
+}
+
+// validate parses a certificate and verifies its chain and validity period
+func (certificateEngine *CertificateEngine) validate(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot load X509 key pair: %s", err)
+	}
+
+	chain := make([]*x509.Certificate, 0, len(certificate.Certificate))
+	for _, rawCertificate := range certificate.Certificate {
+		parsedCertificate, err := x509.ParseCertificate(rawCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse certificate: %s", err)
+		}
+		chain = append(chain, parsedCertificate)
+	}
+	certificate.Leaf = chain[0]
+
+	now := time.Now()
+	if now.Before(certificate.Leaf.NotBefore) || now.After(certificate.Leaf.NotAfter) {
+		return nil, fmt.Errorf("The certificate %s is only valid from %s to %s", certificate.Leaf.Subject, certificate.Leaf.NotBefore, certificate.Leaf.NotAfter)
+	}
+
+	if certificateEngine.VerifyChain {
+		intermediates := x509.NewCertPool()
+		for _, intermediate := range chain[1:] {
+			intermediates.AddCert(intermediate)
+		}
+
+		_, err = certificate.Leaf.Verify(x509.VerifyOptions{
+			Roots:         certificateEngine.Roots,
+			Intermediates: intermediates,
+			CurrentTime:   now,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to verify the chain of certificate %s: %s", certificate.Leaf.Subject, err)
+		}
+	}
+
+	return &certificate, nil
+}
+
+// GetCertificate returns the certificate from key vault
+func (keyVaultCertificateSource *KeyVaultCertificateSource) GetCertificate() ([]byte, []byte, error) {
+	certPEM, err := keyVaultCertificateSource.getPEM(keyVaultCertificateSource.CertVaultBaseURI, keyVaultCertificateSource.CertSecretName, keyVaultCertificateSource.CertSecretVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM, err := keyVaultCertificateSource.getPEM(keyVaultCertificateSource.KeyVaultBaseURI, keyVaultCertificateSource.KeySecretName, keyVaultCertificateSource.KeySecretVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return certPEM, keyPEM, nil
+}
+
+func (keyVaultCertificateSource *KeyVaultCertificateSource) getPEM(vaultBaseURI, secretName, secretVersion string) ([]byte, error) {
+	secret, err := keyVaultCertificateSource.SecretEngine.GetSecret(context.Background(), vaultBaseURI, secretName, secretVersion)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get secret %s: %s", secretName, err)
+	}
+
+	content, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode secret %s: %s", secretName, err)
+	}
+
+	return content, nil
+}
+
+// GetCertificate returns the certificate from files
+func (fileCertificateSource *FileCertificateSource) GetCertificate() ([]byte, []byte, error) {
+	certPEM, err := ioutil.ReadFile(fileCertificateSource.CertPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read certificate file %s: %s", fileCertificateSource.CertPath, err)
+	}
+
+	keyPEM, err := ioutil.ReadFile(fileCertificateSource.KeyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read private key file %s: %s", fileCertificateSource.KeyPath, err)
+	}
+
+	return certPEM, keyPEM, nil
+}
+
+// ParseTLSVersion parses a TLS version such as 1.2, an empty version is 0, which leaves the version to the TLS defaults
+func ParseTLSVersion(version string) (uint16, error) {
+	if len(version) == 0 {
+		return 0, nil
+	}
+
+	tlsVersion, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(version), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("The TLS version %s is not supported, the versions are 1.0, 1.1, 1.2 and 1.3", version)
+	}
+
+	return tlsVersion, nil
+}
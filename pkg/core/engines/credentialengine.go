@@ -0,0 +1,129 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/apierror"
+	"TFRP/pkg/core/consts"
+	"context"
+	"fmt"
+	"net/http"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+	datadog "gopkg.in/zorkian/go-datadog-api.v2"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubernetes "k8s.io/kubernetes/pkg/client/clientset_generated/clientset"
+)
+
+// GetProviderConfigFileInJSON returns a config file which only contains the provider block
+func GetProviderConfigFileInJSON(providerType string, providerSpec []byte) string {
+	return fmt.Sprintf(`
+		{
+			"provider": {
+				"%s": %s
+			}
+		}
+`, providerType, string(providerSpec))
+}
+
+// CheckProviderSettings validates the provider registration settings against the provider schema, configures the provider
+// with them and, if testConnection is set, makes a lightweight call against the upstream API
+func CheckProviderSettings(ctx context.Context, providerType string, settings []byte, testConnection bool) (int, *apierror.ErrorResponse) {
+	provider := GetProvider(providerType)
+	if provider == nil {
+		return http.StatusBadRequest, newInvalidParameterError(
+			apierror.NewDetail(apierror.InvalidParameter, ProviderTypeTarget, fmt.Sprintf("The provider type %s is not supported.", providerType)))
+	}
+
+	if TenantIsolatedProviders {
+		if validationError := ValidateIsolatedProviderSettings(providerType, settings); validationError != nil {
+			return http.StatusBadRequest, validationError
+		}
+	}
+
+	cfg, err := config.Load(GetProviderConfigFileInJSON(providerType, settings))
+	if err != nil {
+		return http.StatusBadRequest, newInvalidParameterError(
+			apierror.NewDetail(apierror.InvalidParameter, SettingsTarget, fmt.Sprintf("Failed to parse provider settings: %s", err)))
+	}
+
+	for _, v := range cfg.ProviderConfigs {
+		resourceConfig := terraform.NewResourceConfig(v.RawConfig)
+
+		_, errs := provider.Validate(resourceConfig)
+		if len(errs) > 0 {
+			return http.StatusBadRequest, newInvalidParameterError(ToErrorDetails(SettingsTarget, errs)...)
+		}
+
+		err = ConfigureProvider(ctx, providerType, provider, resourceConfig)
+		if err != nil {
+			return getProviderSettingsError(fmt.Sprintf("Failed to init provider: %s", err), err)
+		}
+	}
+
+	if testConnection {
+		err = TestProviderConnection(ctx, providerType, provider)
+		if err != nil {
+			return getProviderSettingsError(fmt.Sprintf("Failed to connect with the provider settings: %s", err), err)
+		}
+	}
+
+	return http.StatusOK, nil
+}
+
+// TestProviderConnection makes a lightweight call against the upstream API of a configured provider
+func TestProviderConnection(ctx context.Context, providerType string, provider *schema.Provider) (err error) {
+	_, span := StartProviderSpan(ctx, "TestConnection", providerType)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	switch providerType {
+	case consts.KubernetesProvider:
+		conn, ok := provider.Meta().(*kubernetes.Clientset)
+		if !ok {
+			return fmt.Errorf("The kubernetes provider is not configured")
+		}
+		_, err := conn.CoreV1().Namespaces().List(meta_v1.ListOptions{})
+		return err
+	case consts.DatadogProvider:
+		client, ok := provider.Meta().(*datadog.Client)
+		if !ok {
+			return fmt.Errorf("The datadog provider is not configured")
+		}
+		valid, err := client.Validate()
+		if err != nil {
+			return err
+		}
+		if !valid {
+			return fmt.Errorf("The datadog api key is invalid")
+		}
+		return nil
+	case consts.CloudflareProvider:
+		client, ok := provider.Meta().(*cloudflare.API)
+		if !ok {
+			return fmt.Errorf("The cloudflare provider is not configured")
+		}
+		_, err := client.UserDetails()
+		return err
+	}
+
+	return nil
+}
+
+// getProviderSettingsError reports the provider errors caused by the settings as field-level errors
+func getProviderSettingsError(message string, err error) (int, *apierror.ErrorResponse) {
+	providerError := ClassifyProviderError(err)
+	if providerError.Category != apierror.ClientError {
+		return providerError.HTTPStatus, providerError.ToErrorResponse(message)
+	}
+
+	return http.StatusBadRequest, newInvalidParameterError(
+		apierror.NewDetail(providerError.Code, GetErrorTarget(SettingsTarget, err), message))
+}
@@ -0,0 +1,153 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/entities"
+	"net/http"
+	"regexp"
+	"strings"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+// routeLiteralRegexp matches the case insensitive literals of routes, e.g. {rs:(?i)resources}
+var routeLiteralRegexp = regexp.MustCompile(`^\{[a-z]+:\(\?i\)([a-z]+)\}$`)
+
+// operationSegmentNames are the ARM casing of the route segments following the namespace
+var operationSegmentNames = map[string]string{
+	"resources":             "resources",
+	"providerregistrations": "providerRegistrations",
+	"operationstatus":       "operationStatus",
+	"operations":            "operations",
+	"locations":             "locations",
+	"listsettings":          "listSettings",
+	"testconnection":        "testConnection",
+	"cancel":                "cancel",
+	"exportterraform":       "exportTerraform",
+	"importterraform":       "importTerraform",
+	"deployments":           "deployments",
+	"preflight":             "preflight",
+	"deletedresources":      "deletedResources",
+	"restore":               "restore",
+}
+
+// operationResourceDisplayNames are the display names of the resource types of operations
+var operationResourceDisplayNames = map[string]string{
+	"resources":             "Terraform Resources",
+	"providerRegistrations": "Provider Registrations",
+	"operationStatus":       "Operation Statuses",
+	"operations":            "Operations",
+	"locations":             "Locations",
+	"exportTerraform":       "Terraform Configurations",
+	"importTerraform":       "Terraform States",
+	"deployments":           "Template Deployments",
+	"deletedResources":      "Deleted Terraform Resources",
+}
+
+// GetOperations returns the ARM operations of the routes registered under the namespace of the resource provider,
+// PUT and PATCH routes are one write operation and POST routes are actions
+func GetOperations(webServices []*restful.WebService) []entities.OperationDefinition {
+	operations := []entities.OperationDefinition{}
+	operationNames := make(map[string]bool)
+	for _, webService := range webServices {
+		// The admin routes are not exposed to ARM
+		if webService.RootPath() == consts.AdminURLPrefix {
+			continue
+		}
+
+		for _, route := range webService.Routes() {
+			segments := getOperationSegments(route.Path)
+			verb := getOperationVerb(route.Method)
+			if len(segments) == 0 || len(verb) == 0 {
+				continue
+			}
+
+			name := consts.TerraformRPNamespace + "/" + strings.Join(segments, "/") + "/" + verb
+			if operationNames[strings.ToLower(name)] {
+				continue
+			}
+			operationNames[strings.ToLower(name)] = true
+
+			operations = append(operations, entities.OperationDefinition{
+				Name:    name,
+				Display: getOperationDisplay(segments, verb, route.Doc),
+				Origin:  "user,system",
+			})
+		}
+	}
+
+	return operations
+}
+
+// getOperationSegments returns the literal segments of a route following the namespace of the resource provider,
+// e.g. providerRegistrations and listSettings for .../Microsoft.TerraformOSS/providerregistrations/{providerRegistration}/listsettings
+func getOperationSegments(path string) []string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if part != consts.TerraformRPNamespace {
+			continue
+		}
+
+		segments := []string{}
+		for _, segment := range parts[i+1:] {
+			if match := routeLiteralRegexp.FindStringSubmatch(segment); match != nil {
+				segment = match[1]
+			} else if strings.HasPrefix(segment, "{") {
+				// Path parameters name an instance of the preceding resource type
+				continue
+			}
+
+			if name, ok := operationSegmentNames[strings.ToLower(segment)]; ok {
+				segment = name
+			}
+			segments = append(segments, segment)
+		}
+
+		return segments
+	}
+
+	return nil
+}
+
+func getOperationVerb(method string) string {
+	switch method {
+	case http.MethodGet:
+		return "read"
+	case http.MethodPut, http.MethodPatch:
+		return "write"
+	case http.MethodDelete:
+		return "delete"
+	case http.MethodPost:
+		return "action"
+	}
+
+	return ""
+}
+
+func getOperationDisplay(segments []string, verb string, doc string) entities.OperationDisplay {
+	resource, ok := operationResourceDisplayNames[segments[0]]
+	if !ok {
+		resource = segments[0]
+	}
+
+	operation := doc
+	switch verb {
+	case "read":
+		operation = "Read " + resource
+	case "write":
+		operation = "Create or Update " + resource
+	case "delete":
+		operation = "Delete " + resource
+	}
+
+	return entities.OperationDisplay{
+		Provider:    consts.TerraformRPDisplayName,
+		Resource:    resource,
+		Operation:   operation,
+		Description: doc,
+	}
+}
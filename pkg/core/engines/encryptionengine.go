@@ -0,0 +1,107 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// EncryptionEngine encrypts the settings of provider registrations at rest with AES-256-GCM. Its keys are given
+// as lines in the form {keyId}={base64 key}, the first key encrypts and every key decrypts so that the documents
+// encrypted with a rotated key stay readable until they are re-encrypted
+type EncryptionEngine struct {
+	currentKeyID string
+	ciphers      map[string]cipher.AEAD
+}
+
+// NewEncryptionEngine creates an encryption engine from its keys
+func NewEncryptionEngine(keys string) (*EncryptionEngine, error) {
+	encryptionEngine := &EncryptionEngine{ciphers: make(map[string]cipher.AEAD)}
+
+	for _, line := range strings.Split(keys, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		keyID := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || len(keyID) == 0 {
+			return nil, fmt.Errorf("invalid key '%s', expected {keyId}={base64 key}", keyID)
+		}
+		if _, ok := encryptionEngine.ciphers[keyID]; ok {
+			return nil, fmt.Errorf("duplicate key '%s'", keyID)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key '%s': %s", keyID, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid key '%s': expected 32 bytes, actual %d", keyID, len(key))
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key '%s': %s", keyID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key '%s': %s", keyID, err)
+		}
+
+		encryptionEngine.ciphers[keyID] = aead
+		if len(encryptionEngine.currentKeyID) == 0 {
+			encryptionEngine.currentKeyID = keyID
+		}
+	}
+
+	if len(encryptionEngine.currentKeyID) == 0 {
+		return nil, fmt.Errorf("no key is configured")
+	}
+
+	return encryptionEngine, nil
+}
+
+// CurrentKeyID returns the id of the key data is encrypted with
+func (encryptionEngine *EncryptionEngine) CurrentKeyID() string {
+	return encryptionEngine.currentKeyID
+}
+
+// Encrypt encrypts data with the current key and returns the id of the key, the nonce prefixes the ciphertext
+func (encryptionEngine *EncryptionEngine) Encrypt(plaintext []byte) (string, []byte, error) {
+	aead := encryptionEngine.ciphers[encryptionEngine.currentKeyID]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", nil, fmt.Errorf("failed to generate nonce: %s", err)
+	}
+
+	return encryptionEngine.currentKeyID, aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt decrypts data encrypted with the key keyID
+func (encryptionEngine *EncryptionEngine) Decrypt(keyID string, ciphertext []byte) ([]byte, error) {
+	aead, ok := encryptionEngine.ciphers[keyID]
+	if !ok {
+		return nil, fmt.Errorf("the key '%s' is not configured", keyID)
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("the ciphertext is too short")
+	}
+
+	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt with key '%s': %s", keyID, err)
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,81 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func newTestEncryptionKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestNewEncryptionEngine(t *testing.T) {
+	testCases := []struct {
+		keys         string
+		valid        bool
+		currentKeyID string
+	}{
+		{"k2=" + newTestEncryptionKey(2) + "\nk1=" + newTestEncryptionKey(1), true, "k2"},
+		{"\n k1 = " + newTestEncryptionKey(1) + " \n", true, "k1"},
+		{"", false, ""},
+		{"k1", false, ""},
+		{"=" + newTestEncryptionKey(1), false, ""},
+		{"k1=not base64", false, ""},
+		{"k1=" + base64.StdEncoding.EncodeToString([]byte("short")), false, ""},
+		{"k1=" + newTestEncryptionKey(1) + "\nk1=" + newTestEncryptionKey(2), false, ""},
+	}
+
+	for _, testCase := range testCases {
+		encryptionEngine, err := NewEncryptionEngine(testCase.keys)
+		if (err == nil) != testCase.valid {
+			t.Fatalf("expected valid %v for keys '%s', actual error %v", testCase.valid, testCase.keys, err)
+		}
+		if err == nil && encryptionEngine.CurrentKeyID() != testCase.currentKeyID {
+			t.Fatalf("expected current key %s, actual %s", testCase.currentKeyID, encryptionEngine.CurrentKeyID())
+		}
+	}
+}
+
+func TestEncryptionSurvivesKeyRotation(t *testing.T) {
+	oldEngine, err := NewEncryptionEngine("k1=" + newTestEncryptionKey(1))
+	if err != nil {
+		t.Fatalf("failed to create the encryption engine: %s", err)
+	}
+	rotatedEngine, err := NewEncryptionEngine("k2=" + newTestEncryptionKey(2) + "\nk1=" + newTestEncryptionKey(1))
+	if err != nil {
+		t.Fatalf("failed to create the encryption engine: %s", err)
+	}
+
+	plaintext := []byte(`{"token":"s3cret"}`)
+	keyID, ciphertext, err := oldEngine.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("expected no error, actual %s", err)
+	}
+	if keyID != "k1" || bytes.Contains(ciphertext, []byte("s3cret")) {
+		t.Fatalf("expected a ciphertext of key k1, actual key %s and ciphertext %q", keyID, ciphertext)
+	}
+
+	decrypted, err := rotatedEngine.Decrypt(keyID, ciphertext)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("expected %s, actual %s and error %v", plaintext, decrypted, err)
+	}
+
+	keyID, ciphertext, err = rotatedEngine.Encrypt(decrypted)
+	if err != nil || keyID != "k2" {
+		t.Fatalf("expected a ciphertext of key k2, actual key %s and error %v", keyID, err)
+	}
+	if _, err = oldEngine.Decrypt(keyID, ciphertext); err == nil || !strings.Contains(err.Error(), "k2") {
+		t.Fatalf("expected an error for the unknown key k2, actual %v", err)
+	}
+
+	ciphertext[len(ciphertext)-1] ^= 1
+	if _, err = rotatedEngine.Decrypt(keyID, ciphertext); err == nil {
+		t.Fatalf("expected an error for a tampered ciphertext, actual nil")
+	}
+}
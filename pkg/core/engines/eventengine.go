@@ -0,0 +1,514 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/entities"
+	"TFRP/pkg/core/storage"
+	"TFRP/pkg/core/tracing"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// EventSchemaCloudEvents delivers every event on its own in the structured JSON format of CloudEvents 1.0
+	EventSchemaCloudEvents = "cloudevents"
+	// EventSchemaEventGrid delivers every event in an array in the Event Grid schema
+	EventSchemaEventGrid = "eventgrid"
+
+	// eventQueueSize is how many events wait for delivery before new events are dead lettered
+	eventQueueSize = 1000
+	// eventDeliveryConcurrency is how many events are delivered at the same time, the events of a resource
+	// are always delivered by the same worker
+	eventDeliveryConcurrency = 8
+	// eventPartitionSize is how many events wait for a worker, or for the retry of an earlier event of their resource,
+	// before the new events of the worker are dead lettered
+	eventPartitionSize = eventQueueSize / eventDeliveryConcurrency
+	// eventDeliveryTimeout is the timeout of a single delivery attempt
+	eventDeliveryTimeout = 30 * time.Second
+	// eventSignatureTimestampPrefix prefixes the unix time the delivery was signed at in the signature header
+	eventSignatureTimestampPrefix = "t="
+	// eventSignaturePrefix prefixes the hex HMAC-SHA256 of the timestamp and delivered content in the signature header
+	eventSignaturePrefix = "sha256="
+
+	// EventSignatureTolerance is how far the timestamp of a signature may be from the time it is verified at,
+	// an older delivery is rejected as a replay
+	EventSignatureTolerance = 5 * time.Minute
+)
+
+// DefaultEventRetryPolicy is the retry policy of the delivery of events to a webhook
+var DefaultEventRetryPolicy = RetryPolicy{
+	MaxAttempts:     5,
+	InitialInterval: 2 * time.Second,
+	MaxInterval:     2 * time.Minute,
+}
+
+// EventEngine publishes the state changes of resources to webhooks, the content of every delivery is signed
+// with HMAC-SHA256 and the deliveries which fail after their retries are stored as dead letters
+type EventEngine struct {
+	Webhooks    []string
+	Schema      string
+	RetryPolicy RetryPolicy
+	// ShutdownTimeout is how long the deliveries still running on shutdown are waited for before they are canceled
+	ShutdownTimeout time.Duration
+
+	signingSecret               []byte
+	deadLetterEventDataProvider *storage.DeadLetterEventDataProvider
+	client                      *http.Client
+	queue                       chan *queuedEvent
+}
+
+// queuedEvent is an event waiting for delivery, its deliveries continue the trace it was published in
+type queuedEvent struct {
+	ctx   context.Context
+	event *entities.ResourceEvent
+}
+
+// NewEventEngine creates an event engine, events are only published if webhooks are configured
+func NewEventEngine(webhooks []string, schema string, signingSecret string, retryPolicy RetryPolicy, deadLetterEventDataProvider *storage.DeadLetterEventDataProvider) (*EventEngine, error) {
+	for _, webhook := range webhooks {
+		webhookURL, err := url.Parse(webhook)
+		if err != nil || (webhookURL.Scheme != "https" && webhookURL.Scheme != "http") || len(webhookURL.Host) == 0 {
+			return nil, fmt.Errorf("The webhook '%s' is not an absolute http or https url", webhook)
+		}
+	}
+
+	if schema != EventSchemaCloudEvents && schema != EventSchemaEventGrid {
+		return nil, fmt.Errorf("The event schema '%s' is not %s or %s", schema, EventSchemaCloudEvents, EventSchemaEventGrid)
+	}
+
+	if len(webhooks) > 0 && len(signingSecret) == 0 {
+		return nil, fmt.Errorf("The events cannot be delivered without a signing secret")
+	}
+
+	eventEngine := new(EventEngine)
+	eventEngine.Webhooks = webhooks
+	eventEngine.Schema = schema
+	eventEngine.RetryPolicy = retryPolicy
+	eventEngine.ShutdownTimeout = eventDeliveryTimeout
+	eventEngine.signingSecret = []byte(signingSecret)
+	eventEngine.deadLetterEventDataProvider = deadLetterEventDataProvider
+	eventEngine.client = &http.Client{Timeout: eventDeliveryTimeout}
+	eventEngine.queue = make(chan *queuedEvent, eventQueueSize)
+	return eventEngine, nil
+}
+
+// IsEnabled returns whether events are published
+func (eventEngine *EventEngine) IsEnabled() bool {
+	return eventEngine != nil && len(eventEngine.Webhooks) > 0
+}
+
+// PublishResourceEvent queues an event on the state change of a resource, it never blocks the caller
+func (eventEngine *EventEngine) PublishResourceEvent(ctx context.Context, eventType string, resourcePackage *entities.ResourcePackage) {
+	if !eventEngine.IsEnabled() {
+		return
+	}
+
+	event := &entities.ResourceEvent{
+		ID:        uuid.NewV4().String(),
+		EventType: eventType,
+		Time:      time.Now().UTC(),
+		Data: entities.ResourceEventData{
+			ResourceID:        resourcePackage.ResourceID,
+			ResourceType:      resourcePackage.ResourceType,
+			ProviderID:        resourcePackage.ProviderID,
+			ProvisioningState: resourcePackage.ProvisioningState,
+			ErrorCode:         resourcePackage.ProvisioningErrorCode,
+			ErrorMessage:      resourcePackage.ProvisioningErrorMessage,
+		},
+	}
+
+	queued := &queuedEvent{ctx: tracing.Detach(ctx), event: event}
+	select {
+	case eventEngine.queue <- queued:
+	default:
+		for _, webhook := range eventEngine.Webhooks {
+			eventEngine.deadLetter(queued, webhook, nil, 0, fmt.Errorf("The event queue is full"))
+		}
+	}
+}
+
+// Run delivers the queued events until stop is closed. The events of a resource are delivered in the order
+// they were published, by the same worker. The events of a worker which cannot keep up are dead lettered rather than
+// holding up the other workers. The deliveries still running on stop are waited for up to the shutdown timeout,
+// they are canceled and dead lettered after it.
+func (eventEngine *EventEngine) Run(stop <-chan struct{}) {
+	deliveryCtx, cancelDeliveries := context.WithCancel(context.Background())
+	defer cancelDeliveries()
+
+	workers := sync.WaitGroup{}
+	partitions := make([]chan *queuedEvent, eventDeliveryConcurrency)
+	for i := range partitions {
+		partitions[i] = make(chan *queuedEvent, eventPartitionSize)
+		workers.Add(1)
+		go func(partition <-chan *queuedEvent) {
+			defer workers.Done()
+			eventEngine.runPartition(deliveryCtx, partition)
+		}(partitions[i])
+	}
+
+	for {
+		select {
+		case <-stop:
+			for _, partition := range partitions {
+				close(partition)
+			}
+			eventEngine.waitForDeliveries(&workers, cancelDeliveries)
+			eventEngine.deadLetterQueue()
+			return
+		case queued := <-eventEngine.queue:
+			select {
+			case partitions[getEventPartition(queued.event.Data.ResourceID, len(partitions))] <- queued:
+			default:
+				for _, webhook := range eventEngine.Webhooks {
+					eventEngine.deadLetter(queued, webhook, nil, 0, fmt.Errorf("The delivery queue of the resource is full"))
+				}
+			}
+		}
+	}
+}
+
+// eventDelivery is the delivery of an event to a webhook
+type eventDelivery struct {
+	queued      *queuedEvent
+	webhook     string
+	content     []byte
+	contentType string
+	attempts    int
+	backOff     backoff.BackOff
+}
+
+// runPartition delivers the events of a partition until it is closed. A delivery which fails transiently is retried
+// after a back off without holding up the partition, the later events of its resource to the same webhook wait
+// behind it so that they are still delivered in order. The deliveries waiting on close are dead lettered.
+func (eventEngine *EventEngine) runPartition(ctx context.Context, partition <-chan *queuedEvent) {
+	retries := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+
+	// The deliveries waiting for a retry by resource and webhook, the first one is the one retried
+	waiting := make(map[string][]*eventDelivery)
+	waitingCount := 0
+
+	// deliverWaiting delivers the waiting deliveries of a resource and webhook in order until one has to be retried
+	deliverWaiting := func(key string) {
+		for len(waiting[key]) > 0 {
+			delivery := waiting[key][0]
+			if retryAfter, retry := eventEngine.attempt(ctx, delivery); retry {
+				time.AfterFunc(retryAfter, func() {
+					select {
+					case retries <- key:
+					case <-done:
+					}
+				})
+				return
+			}
+
+			waiting[key] = waiting[key][1:]
+			waitingCount--
+		}
+		delete(waiting, key)
+	}
+
+	for {
+		select {
+		case queued, ok := <-partition:
+			if !ok {
+				for _, deliveries := range waiting {
+					for _, delivery := range deliveries {
+						eventEngine.deadLetter(delivery.queued, delivery.webhook, delivery.content, delivery.attempts, fmt.Errorf("The delivery was interrupted by a service shutdown"))
+					}
+				}
+				return
+			}
+
+			content, contentType, err := eventEngine.marshalEvent(queued.event)
+			for _, webhook := range eventEngine.Webhooks {
+				if err != nil {
+					eventEngine.deadLetter(queued, webhook, nil, 0, err)
+					continue
+				}
+
+				key := webhook + "|" + strings.ToLower(queued.event.Data.ResourceID)
+				if len(waiting[key]) > 0 && waitingCount >= eventPartitionSize {
+					eventEngine.deadLetter(queued, webhook, content, 0, fmt.Errorf("Too many deliveries of the partition are waiting for a retry"))
+					continue
+				}
+
+				waiting[key] = append(waiting[key], &eventDelivery{
+					queued:      queued,
+					webhook:     webhook,
+					content:     content,
+					contentType: contentType,
+					backOff:     eventEngine.RetryPolicy.NewBackOff(),
+				})
+				waitingCount++
+				if len(waiting[key]) == 1 {
+					deliverWaiting(key)
+				}
+			}
+		case key := <-retries:
+			deliverWaiting(key)
+		}
+	}
+}
+
+// waitForDeliveries waits for the workers to deliver the events they were given up to the shutdown timeout,
+// the deliveries still running after it are canceled
+func (eventEngine *EventEngine) waitForDeliveries(workers *sync.WaitGroup, cancelDeliveries context.CancelFunc) {
+	delivered := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(delivered)
+	}()
+
+	select {
+	case <-delivered:
+	case <-time.After(eventEngine.ShutdownTimeout):
+		log.Printf("Event deliveries did not complete within %s and were dead lettered", eventEngine.ShutdownTimeout)
+		cancelDeliveries()
+		<-delivered
+	}
+}
+
+// getEventPartition returns the worker delivering the events of a resource
+func getEventPartition(resourceID string, partitions int) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(strings.ToLower(resourceID)))
+	return int(hash.Sum32() % uint32(partitions))
+}
+
+// deadLetterQueue dead letters the events still queued on shutdown
+func (eventEngine *EventEngine) deadLetterQueue() {
+	for {
+		select {
+		case queued := <-eventEngine.queue:
+			for _, webhook := range eventEngine.Webhooks {
+				eventEngine.deadLetter(queued, webhook, nil, 0, fmt.Errorf("The delivery was interrupted by a service shutdown"))
+			}
+		default:
+			return
+		}
+	}
+}
+
+// attempt posts an event to a webhook, it returns true and the back off when a transient failure is retried.
+// The event is dead lettered once the attempts are exhausted.
+func (eventEngine *EventEngine) attempt(ctx context.Context, delivery *eventDelivery) (time.Duration, bool) {
+	delivery.attempts++
+	transient, err := eventEngine.post(ctx, delivery.queued, delivery.webhook, delivery.content, delivery.contentType)
+	if err == nil {
+		return 0, false
+	}
+
+	if !transient || delivery.attempts >= eventEngine.RetryPolicy.MaxAttempts {
+		eventEngine.deadLetter(delivery.queued, delivery.webhook, delivery.content, delivery.attempts, err)
+		return 0, false
+	}
+
+	retryAfter := delivery.backOff.NextBackOff()
+	if retryAfter == backoff.Stop {
+		eventEngine.deadLetter(delivery.queued, delivery.webhook, delivery.content, delivery.attempts, err)
+		return 0, false
+	}
+
+	return retryAfter, true
+}
+
+// post posts the content of an event to a webhook, it returns whether a failure is transient
+func (eventEngine *EventEngine) post(ctx context.Context, queued *queuedEvent, webhook string, content []byte, contentType string) (transient bool, err error) {
+	_, span := tracing.Start(queued.ctx, "deliver "+queued.event.EventType, tracing.SpanKindClient)
+	span.SetAttribute("event.id", queued.event.ID)
+	span.SetAttribute("server.address", webhook)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	request, err := http.NewRequest(http.MethodPost, webhook, bytes.NewReader(content))
+	if err != nil {
+		return false, err
+	}
+	request = request.WithContext(ctx)
+
+	request.Header.Set("Content-Type", contentType)
+	if traceParent := span.TraceParent(); len(traceParent) > 0 {
+		request.Header.Set(tracing.TraceParentHeader, traceParent)
+	}
+	request.Header.Set(consts.EventSignatureHeader, SignEventContent(eventEngine.signingSecret, time.Now(), content))
+	if eventEngine.Schema == EventSchemaEventGrid {
+		request.Header.Set("aeg-event-type", "Notification")
+	}
+
+	response, err := eventEngine.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	ioutil.ReadAll(response.Body)
+
+	if response.StatusCode >= http.StatusOK && response.StatusCode < http.StatusMultipleChoices {
+		return false, nil
+	}
+
+	transient = response.StatusCode == http.StatusRequestTimeout ||
+		response.StatusCode == http.StatusTooManyRequests ||
+		response.StatusCode >= http.StatusInternalServerError
+	return transient, fmt.Errorf("The webhook responded with status %d", response.StatusCode)
+}
+
+// marshalEvent returns the content and the content type of an event in the schema of the engine
+func (eventEngine *EventEngine) marshalEvent(event *entities.ResourceEvent) ([]byte, string, error) {
+	source := getEventSource(event.Data.ResourceID)
+	if eventEngine.Schema == EventSchemaEventGrid {
+		content, err := json.Marshal([]entities.EventGridEvent{{
+			ID:              event.ID,
+			Topic:           source,
+			Subject:         event.Data.ResourceID,
+			EventType:       event.EventType,
+			EventTime:       event.Time,
+			Data:            event.Data,
+			DataVersion:     "1.0",
+			MetadataVersion: "1",
+		}})
+		return content, "application/json", err
+	}
+
+	content, err := json.Marshal(entities.CloudEvent{
+		SpecVersion:     "1.0",
+		Type:            event.EventType,
+		Source:          source,
+		ID:              event.ID,
+		Time:            event.Time,
+		Subject:         event.Data.ResourceID,
+		DataContentType: "application/json",
+		Data:            event.Data,
+	})
+	return content, "application/cloudevents+json", err
+}
+
+// deadLetter stores an event which could not be delivered to a webhook
+func (eventEngine *EventEngine) deadLetter(queued *queuedEvent, webhook string, content []byte, attempts int, deliveryError error) {
+	event := queued.event
+	log.Printf("Failed to deliver event '%s' of resource '%s' to webhook '%s': %s", event.ID, event.Data.ResourceID, webhook, deliveryError)
+
+	if eventEngine.deadLetterEventDataProvider == nil {
+		return
+	}
+
+	err := eventEngine.deadLetterEventDataProvider.InsertPackage(queued.ctx, &entities.DeadLetterEventPackage{
+		EventID:    event.ID,
+		EventType:  event.EventType,
+		ResourceID: event.Data.ResourceID,
+		Webhook:    webhook,
+		Content:    string(content),
+		Attempts:   attempts,
+		LastError:  deliveryError.Error(),
+		Time:       time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("Failed to dead letter event '%s': %s", event.ID, err)
+	}
+}
+
+// getEventSource returns the subscription of a resource as the source of its events, /subscriptions/{subscriptionId}
+func getEventSource(resourceID string) string {
+	segments := strings.SplitN(strings.TrimPrefix(resourceID, "/"), "/", 3)
+	if len(segments) < 2 {
+		return "/" + consts.TerraformRPNamespace
+	}
+
+	return "/" + segments[0] + "/" + segments[1]
+}
+
+// SignEventContent returns the signature header value of the content of an event delivered at a time,
+// t={unix time},sha256={hex} where the HMAC is of the unix time and the content joined by a dot
+func SignEventContent(signingSecret []byte, timestamp time.Time, content []byte) string {
+	unixTime := strconv.FormatInt(timestamp.Unix(), 10)
+	return eventSignatureTimestampPrefix + unixTime + "," + eventSignaturePrefix + getEventContentMAC(signingSecret, unixTime, content)
+}
+
+// VerifyEventSignature returns whether a signature header value matches the content of an event and was signed
+// within the tolerance of now, so that a captured delivery cannot be replayed later
+func VerifyEventSignature(signingSecret []byte, content []byte, signature string, tolerance time.Duration, now time.Time) bool {
+	unixTime, mac := "", ""
+	for _, part := range strings.Split(signature, ",") {
+		if strings.HasPrefix(part, eventSignatureTimestampPrefix) {
+			unixTime = strings.TrimPrefix(part, eventSignatureTimestampPrefix)
+		} else if strings.HasPrefix(part, eventSignaturePrefix) {
+			mac = strings.TrimPrefix(part, eventSignaturePrefix)
+		}
+	}
+
+	seconds, err := strconv.ParseInt(unixTime, 10, 64)
+	if err != nil || len(mac) == 0 {
+		return false
+	}
+
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > tolerance || age < -tolerance {
+		return false
+	}
+
+	return hmac.Equal([]byte(getEventContentMAC(signingSecret, unixTime, content)), []byte(mac))
+}
+
+func getEventContentMAC(signingSecret []byte, unixTime string, content []byte) string {
+	mac := hmac.New(sha256.New, signingSecret)
+	mac.Write([]byte(unixTime + "."))
+	mac.Write(content)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// EventSink is a local webhook for development which verifies the signatures of the delivered events and logs them
+type EventSink struct {
+	signingSecret []byte
+}
+
+// NewEventSink creates an event sink verifying signatures with a signing secret
+func NewEventSink(signingSecret string) *EventSink {
+	return &EventSink{signingSecret: []byte(signingSecret)}
+}
+
+// ServeHTTP accepts an event delivery, a delivery with an invalid or expired signature is rejected with 401
+func (eventSink *EventSink) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		response.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	content, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !VerifyEventSignature(eventSink.signingSecret, content, request.Header.Get(consts.EventSignatureHeader), EventSignatureTolerance, time.Now()) {
+		log.Printf("Event sink rejected a delivery with an invalid signature")
+		response.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	log.Printf("Event sink received %s: %s", request.Header.Get("Content-Type"), content)
+	response.WriteHeader(http.StatusOK)
+}
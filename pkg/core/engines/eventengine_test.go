@@ -0,0 +1,252 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/entities"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVerifyEventSignature(t *testing.T) {
+	secret := []byte("secret")
+	content := []byte(`{"id":"1"}`)
+	signedAt := time.Unix(1700000000, 0)
+	signature := SignEventContent(secret, signedAt, content)
+
+	testCases := []struct {
+		secret    []byte
+		content   []byte
+		signature string
+		now       time.Time
+		expected  bool
+	}{
+		{secret, content, signature, signedAt.Add(time.Minute), true},
+		{secret, content, signature, signedAt.Add(-time.Minute), true},
+		// A delivery replayed after the tolerance is rejected
+		{secret, content, signature, signedAt.Add(EventSignatureTolerance + time.Second), false},
+		{secret, []byte(`{"id":"2"}`), signature, signedAt, false},
+		{[]byte("other"), content, signature, signedAt, false},
+		// The timestamp is signed with the content
+		{secret, content, "t=1700000100," + signature[len("t=1700000000,"):], signedAt, false},
+		{secret, content, signature[len("t=1700000000,"):], signedAt, false},
+		{secret, content, "", signedAt, false},
+	}
+
+	for i, testCase := range testCases {
+		if actual := VerifyEventSignature(testCase.secret, testCase.content, testCase.signature, EventSignatureTolerance, testCase.now); actual != testCase.expected {
+			t.Fatalf("expected the verification of case %d to be %t, actual %t", i, testCase.expected, actual)
+		}
+	}
+}
+
+func TestEventSink(t *testing.T) {
+	secret := "secret"
+	content := []byte(`{"id":"1"}`)
+	eventSink := NewEventSink(secret)
+
+	testCases := []struct {
+		method             string
+		signature          string
+		expectedStatusCode int
+	}{
+		{http.MethodPost, SignEventContent([]byte(secret), time.Now(), content), http.StatusOK},
+		{http.MethodPost, SignEventContent([]byte("other"), time.Now(), content), http.StatusUnauthorized},
+		{http.MethodPost, SignEventContent([]byte(secret), time.Now().Add(-time.Hour), content), http.StatusUnauthorized},
+		{http.MethodPost, "", http.StatusUnauthorized},
+		{http.MethodGet, SignEventContent([]byte(secret), time.Now(), content), http.StatusMethodNotAllowed},
+	}
+
+	for _, testCase := range testCases {
+		request := httptest.NewRequest(testCase.method, "/", bytes.NewReader(content))
+		request.Header.Set(consts.EventSignatureHeader, testCase.signature)
+		recorder := httptest.NewRecorder()
+
+		eventSink.ServeHTTP(recorder, request)
+		if recorder.Code != testCase.expectedStatusCode {
+			t.Fatalf("expected the sink to respond %d to a %s signed '%s', actual %d", testCase.expectedStatusCode, testCase.method, testCase.signature, recorder.Code)
+		}
+	}
+}
+
+func TestRunDeliversTheEventsOfAResourceInOrder(t *testing.T) {
+	lock := sync.Mutex{}
+	received := make(map[string][]int)
+	delivered := sync.WaitGroup{}
+	webhook := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		defer delivered.Done()
+
+		// Deliveries of different resources overtake each other
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+
+		content, _ := ioutil.ReadAll(request.Body)
+		cloudEvent := entities.CloudEvent{}
+		if err := json.Unmarshal(content, &cloudEvent); err != nil {
+			response.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sequence, _ := strconv.Atoi(cloudEvent.Data.ErrorMessage)
+
+		lock.Lock()
+		received[cloudEvent.Subject] = append(received[cloudEvent.Subject], sequence)
+		lock.Unlock()
+	}))
+	defer webhook.Close()
+
+	eventEngine, err := NewEventEngine([]string{webhook.URL}, EventSchemaCloudEvents, "secret", RetryPolicy{MaxAttempts: 1}, nil)
+	if err != nil {
+		t.Fatalf("expected the event engine to be created, actual %s", err)
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		eventEngine.Run(stop)
+		close(stopped)
+	}()
+
+	resourceIDs := []string{"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.TerraformOSS/resources/a", "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.TerraformOSS/resources/b"}
+	events := 20
+	delivered.Add(events * len(resourceIDs))
+	for i := 0; i < events; i++ {
+		for _, resourceID := range resourceIDs {
+			eventEngine.PublishResourceEvent(context.Background(), consts.EventTypeResourceUpdated, &entities.ResourcePackage{
+				ResourceID:               resourceID,
+				ProvisioningErrorMessage: strconv.Itoa(i),
+			})
+		}
+	}
+
+	delivered.Wait()
+	close(stop)
+	<-stopped
+
+	for _, resourceID := range resourceIDs {
+		sequences := received[resourceID]
+		if len(sequences) != events {
+			t.Fatalf("expected %d events of %s, actual %d", events, resourceID, len(sequences))
+		}
+		for i, sequence := range sequences {
+			if sequence != i {
+				t.Fatalf("expected the events of %s to be delivered in order, actual %v", resourceID, sequences)
+			}
+		}
+	}
+}
+
+func TestRunBoundsTheDeliveriesOnShutdown(t *testing.T) {
+	started := make(chan struct{}, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		// The connection is watched for the cancellation of the delivery once the content is read
+		ioutil.ReadAll(request.Body)
+		started <- struct{}{}
+		select {
+		case <-request.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer webhook.Close()
+
+	eventEngine, err := NewEventEngine([]string{webhook.URL}, EventSchemaCloudEvents, "secret", RetryPolicy{MaxAttempts: 1}, nil)
+	if err != nil {
+		t.Fatalf("expected the event engine to be created, actual %s", err)
+	}
+	eventEngine.ShutdownTimeout = 100 * time.Millisecond
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		eventEngine.Run(stop)
+		close(stopped)
+	}()
+
+	eventEngine.PublishResourceEvent(context.Background(), consts.EventTypeResourceCreated, &entities.ResourcePackage{ResourceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.TerraformOSS/resources/a"})
+	<-started
+
+	start := time.Now()
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the event engine to stop once the shutdown timeout elapsed")
+	}
+	if elapsed := time.Since(start); elapsed < eventEngine.ShutdownTimeout {
+		t.Fatalf("expected the event engine to wait for the delivery up to the shutdown timeout, it stopped after %s", elapsed)
+	}
+}
+
+func TestRunRetriesWithoutHoldingUpThePartition(t *testing.T) {
+	// Two resources delivered by the same worker
+	failing := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.TerraformOSS/resources/failing"
+	healthy := ""
+	for i := 0; len(healthy) == 0; i++ {
+		resourceID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.TerraformOSS/resources/healthy" + strconv.Itoa(i)
+		if getEventPartition(resourceID, eventDeliveryConcurrency) == getEventPartition(failing, eventDeliveryConcurrency) {
+			healthy = resourceID
+		}
+	}
+
+	lock := sync.Mutex{}
+	received := []string{}
+	failed := false
+	delivered := sync.WaitGroup{}
+	webhook := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		content, _ := ioutil.ReadAll(request.Body)
+		cloudEvent := entities.CloudEvent{}
+		json.Unmarshal(content, &cloudEvent)
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		// The first delivery of the failing resource fails transiently
+		if cloudEvent.Subject == failing && !failed {
+			failed = true
+			response.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		received = append(received, cloudEvent.Subject+"/"+cloudEvent.Data.ErrorMessage)
+		delivered.Done()
+	}))
+	defer webhook.Close()
+
+	eventEngine, err := NewEventEngine([]string{webhook.URL}, EventSchemaCloudEvents, "secret", RetryPolicy{MaxAttempts: 3, InitialInterval: 200 * time.Millisecond, MaxInterval: time.Second}, nil)
+	if err != nil {
+		t.Fatalf("expected the event engine to be created, actual %s", err)
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		eventEngine.Run(stop)
+		close(stopped)
+	}()
+
+	delivered.Add(3)
+	eventEngine.PublishResourceEvent(context.Background(), consts.EventTypeResourceUpdated, &entities.ResourcePackage{ResourceID: failing, ProvisioningErrorMessage: "0"})
+	eventEngine.PublishResourceEvent(context.Background(), consts.EventTypeResourceUpdated, &entities.ResourcePackage{ResourceID: failing, ProvisioningErrorMessage: "1"})
+	eventEngine.PublishResourceEvent(context.Background(), consts.EventTypeResourceUpdated, &entities.ResourcePackage{ResourceID: healthy, ProvisioningErrorMessage: "0"})
+
+	delivered.Wait()
+	close(stop)
+	<-stopped
+
+	expected := []string{healthy + "/0", failing + "/0", failing + "/1"}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("expected the deliveries %v, actual %v", expected, received)
+		}
+	}
+}
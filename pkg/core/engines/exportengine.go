@@ -0,0 +1,275 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/entities"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// secretProviderSettings are the provider settings which are exported as variables rather than values
+var secretProviderSettings = map[string][]string{
+	consts.KubernetesProvider: {"password", "client_key", "token", "inline_config"},
+	consts.DatadogProvider:    {"api_key", "app_key"},
+	consts.CloudflareProvider: {"token"},
+}
+
+// terraformNameRegexp matches the characters which are invalid in terraform names
+var terraformNameRegexp = regexp.MustCompile(`[^0-9A-Za-z_-]`)
+
+// terraformExport builds the configuration and state of an export
+type terraformExport struct {
+	variables        map[string]interface{}
+	providers        []interface{}
+	resources        map[string]map[string]interface{}
+	state            *terraform.State
+	providerNames    map[string]string
+	resourceNames    map[string]bool
+	variableNames    map[string]bool
+	skippedResources []string
+}
+
+// ExportTerraform builds the terraform configuration and state managing the resources of the given resource packages,
+// the secret settings of provider registrations and the secret references of settings are exported as variables
+func ExportTerraform(providerRegistrationPackages []entities.ProviderRegistrationPackage, resourcePackages []entities.ResourcePackage) (*entities.TerraformExport, error) {
+	export := &terraformExport{
+		variables:     make(map[string]interface{}),
+		resources:     make(map[string]map[string]interface{}),
+		state:         terraform.NewState(),
+		providerNames: make(map[string]string),
+		resourceNames: make(map[string]bool),
+		variableNames: make(map[string]bool),
+	}
+	export.state.TFVersion = terraform.VersionString()
+	export.state.Serial = 1
+
+	sort.Slice(providerRegistrationPackages, func(i, j int) bool {
+		return providerRegistrationPackages[i].ResourceID < providerRegistrationPackages[j].ResourceID
+	})
+	providerCounts := make(map[string]int)
+	for _, providerRegistrationPackage := range providerRegistrationPackages {
+		providerCounts[providerRegistrationPackage.ProviderType]++
+	}
+	for _, providerRegistrationPackage := range providerRegistrationPackages {
+		err := export.addProvider(&providerRegistrationPackage, providerCounts[providerRegistrationPackage.ProviderType] > 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(resourcePackages, func(i, j int) bool {
+		return resourcePackages[i].ResourceID < resourcePackages[j].ResourceID
+	})
+	for _, resourcePackage := range resourcePackages {
+		err := export.addResource(&resourcePackage)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return export.build()
+}
+
+func (export *terraformExport) addProvider(providerRegistrationPackage *entities.ProviderRegistrationPackage, aliased bool) error {
+	providerSettings := map[string]interface{}{}
+	if err := unmarshalSettings(providerRegistrationPackage.Settings, &providerSettings); err != nil {
+		return fmt.Errorf("Failed to parse the settings of provider registration '%s': %s", providerRegistrationPackage.ResourceID, err)
+	}
+
+	providerType := providerRegistrationPackage.ProviderType
+	providerName := providerType
+	alias := ""
+	if aliased {
+		alias = getTerraformName(providerRegistrationPackage.ResourceID[strings.LastIndex(providerRegistrationPackage.ResourceID, "/")+1:])
+		providerName = providerType + "." + alias
+		providerSettings["alias"] = alias
+	}
+
+	// Keep the behavior of tenant isolated providers instead of falling back to the environment
+	if TenantIsolatedProviders {
+		for key, value := range isolatedProviderDefaults[providerType] {
+			if _, ok := providerSettings[key]; !ok && !isLocalPathSetting(providerType, key) {
+				providerSettings[key] = value
+			}
+		}
+		if _, ok := providerSettings["load_config_file"]; !ok && providerType == consts.KubernetesProvider {
+			_, hasInlineConfig := providerSettings["inline_config"]
+			providerSettings["load_config_file"] = hasInlineConfig
+		}
+	}
+
+	variablePrefix := providerType
+	if aliased {
+		variablePrefix = variablePrefix + "_" + alias
+	}
+	for _, key := range secretProviderSettings[providerType] {
+		if _, ok := providerSettings[key]; ok {
+			providerSettings[key] = export.addVariable(variablePrefix + "_" + key)
+		}
+	}
+	for key, value := range providerSettings {
+		providerSettings[key] = export.replaceSecretReferences(variablePrefix+"_"+key, value)
+	}
+
+	// A list of provider blocks allows several blocks of a provider type
+	export.providers = append(export.providers, map[string]interface{}{providerType: providerSettings})
+	export.providerNames[strings.ToLower(providerRegistrationPackage.ResourceID)] = providerName
+	return nil
+}
+
+func (export *terraformExport) addResource(resourcePackage *entities.ResourcePackage) error {
+	providerName, ok := export.providerNames[strings.ToLower(resourcePackage.ProviderID)]
+	if !ok {
+		return fmt.Errorf("The provider registration '%s' of resource '%s' was not found", resourcePackage.ProviderID, resourcePackage.ResourceID)
+	}
+
+	// Resources which were never created have nothing for terraform to manage
+	if resourcePackage.State == nil || len(resourcePackage.State.ID) == 0 {
+		export.skippedResources = append(export.skippedResources, resourcePackage.ResourceID)
+		return nil
+	}
+
+	resourceSettings := map[string]interface{}{}
+	if err := unmarshalSettings(resourcePackage.Settings, &resourceSettings); err != nil {
+		return fmt.Errorf("Failed to parse the settings of resource '%s': %s", resourcePackage.ResourceID, err)
+	}
+
+	resourceName := getTerraformName(resourcePackage.ResourceName)
+	for i := 2; export.resourceNames[resourcePackage.ResourceType+"."+resourceName]; i++ {
+		resourceName = getTerraformName(resourcePackage.ResourceName) + "_" + strconv.Itoa(i)
+	}
+	export.resourceNames[resourcePackage.ResourceType+"."+resourceName] = true
+
+	for key, value := range resourceSettings {
+		resourceSettings[key] = export.replaceSecretReferences(resourcePackage.ResourceType+"_"+resourceName+"_"+key, value)
+	}
+	if strings.Contains(providerName, ".") {
+		resourceSettings["provider"] = providerName
+	}
+
+	if export.resources[resourcePackage.ResourceType] == nil {
+		export.resources[resourcePackage.ResourceType] = make(map[string]interface{})
+	}
+	export.resources[resourcePackage.ResourceType][resourceName] = resourceSettings
+
+	state := resourcePackage.State.DeepCopy()
+	if resourcePackage.SchemaVersion > 0 {
+		if state.Meta == nil {
+			state.Meta = make(map[string]interface{})
+		}
+		state.Meta[schemaVersionMetaKey] = strconv.Itoa(resourcePackage.SchemaVersion)
+	}
+	export.state.RootModule().Resources[resourcePackage.ResourceType+"."+resourceName] = &terraform.ResourceState{
+		Type:     resourcePackage.ResourceType,
+		Provider: "provider." + providerName,
+		Primary:  state,
+	}
+
+	return nil
+}
+
+// addVariable declares a variable and returns its interpolation
+func (export *terraformExport) addVariable(name string) string {
+	baseName := getTerraformName(strings.ToLower(name))
+	name = baseName
+	for i := 2; export.variableNames[name]; i++ {
+		name = baseName + "_" + strconv.Itoa(i)
+	}
+	export.variableNames[name] = true
+
+	export.variables[name] = map[string]interface{}{
+		"type": "string",
+	}
+	return "${var." + name + "}"
+}
+
+// replaceSecretReferences replaces the secret references of settings with variables
+func (export *terraformExport) replaceSecretReferences(name string, value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		if _, ok := typedValue[SecretReferenceKey]; ok {
+			return export.addVariable(name)
+		}
+		for key, item := range typedValue {
+			typedValue[key] = export.replaceSecretReferences(name+"_"+key, item)
+		}
+	case []interface{}:
+		for i, item := range typedValue {
+			typedValue[i] = export.replaceSecretReferences(name+"_"+strconv.Itoa(i), item)
+		}
+	}
+
+	return value
+}
+
+func (export *terraformExport) build() (*entities.TerraformExport, error) {
+	configuration := map[string]interface{}{}
+	if len(export.providers) > 0 {
+		configuration["provider"] = export.providers
+	}
+	if len(export.resources) > 0 {
+		configuration["resource"] = export.resources
+	}
+	if len(export.variables) > 0 {
+		configuration["variable"] = export.variables
+	}
+
+	rawConfiguration, err := json.MarshalIndent(configuration, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to serialize configuration: %s", err)
+	}
+
+	rawState := bytes.Buffer{}
+	if err := terraform.WriteState(export.state, &rawState); err != nil {
+		return nil, err
+	}
+
+	return &entities.TerraformExport{
+		Configuration:    rawConfiguration,
+		State:            rawState.Bytes(),
+		SkippedResources: export.skippedResources,
+	}, nil
+}
+
+// isLocalPathSetting returns whether a provider setting references a local path of the server
+func isLocalPathSetting(providerType, key string) bool {
+	for _, localPathSetting := range localPathSettings[providerType] {
+		if key == localPathSetting {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getTerraformName returns a name which is valid in terraform configurations
+func getTerraformName(name string) string {
+	name = terraformNameRegexp.ReplaceAllString(name, "_")
+	if len(name) == 0 || (name[0] >= '0' && name[0] <= '9') || name[0] == '-' {
+		name = "_" + name
+	}
+
+	return name
+}
+
+// unmarshalSettings parses settings keeping their numbers as written
+func unmarshalSettings(settings []byte, result interface{}) error {
+	if len(settings) == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(settings))
+	decoder.UseNumber()
+	return decoder.Decode(result)
+}
@@ -0,0 +1,55 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// ResourceFreshnessWindow is how long the stored state of a resource is served by GET without refreshing it,
+// a stale state is served while it is refreshed in the background, 0 refreshes the state on every GET
+var ResourceFreshnessWindow time.Duration
+
+// ResourceMaxStaleness is how old the stored state of a resource can be for GET to serve it while it is refreshed
+// in the background, an older state is refreshed before it is served, 0 serves a stale state of any age
+var ResourceMaxStaleness time.Duration
+
+// IsStateCacheable returns whether GET may serve the stored state of a resource refreshed at a time,
+// a state which was never refreshed or is older than the maximum staleness is not
+func IsStateCacheable(lastRefreshedAt *time.Time) bool {
+	if ResourceFreshnessWindow <= 0 || lastRefreshedAt == nil {
+		return false
+	}
+
+	return ResourceMaxStaleness <= 0 || time.Since(*lastRefreshedAt) < ResourceMaxStaleness
+}
+
+// IsStateFresh returns whether the state of a resource refreshed at a time is within the freshness window
+func IsStateFresh(lastRefreshedAt *time.Time) bool {
+	return IsStateCacheable(lastRefreshedAt) && time.Since(*lastRefreshedAt) < ResourceFreshnessWindow
+}
+
+// GetRefreshTime returns the time a state is refreshed at
+func GetRefreshTime() *time.Time {
+	refreshTime := time.Now().UTC()
+	return &refreshTime
+}
+
+// IsStateDrifted returns whether a refreshed state differs from the stored state it was refreshed from,
+// i.e. the resource was changed out of band
+func IsStateDrifted(storedState *terraform.InstanceState, refreshedState *terraform.InstanceState) bool {
+	if storedState == nil || refreshedState == nil {
+		return storedState != refreshedState
+	}
+
+	if storedState.ID != refreshedState.ID || len(storedState.Attributes) != len(refreshedState.Attributes) {
+		return true
+	}
+
+	return len(storedState.Attributes) > 0 && !reflect.DeepEqual(storedState.Attributes, refreshedState.Attributes)
+}
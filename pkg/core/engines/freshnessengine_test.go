@@ -0,0 +1,54 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsStateCacheable(t *testing.T) {
+	defer func(freshnessWindow, maxStaleness time.Duration) {
+		ResourceFreshnessWindow, ResourceMaxStaleness = freshnessWindow, maxStaleness
+	}(ResourceFreshnessWindow, ResourceMaxStaleness)
+
+	testCases := []struct {
+		freshnessWindow time.Duration
+		maxStaleness    time.Duration
+		age             time.Duration
+		neverRefreshed  bool
+		expectedCached  bool
+		expectedFresh   bool
+	}{
+		{time.Minute, 15 * time.Minute, 10 * time.Second, false, true, true},
+		// A stale state is served while it is refreshed in the background
+		{time.Minute, 15 * time.Minute, 5 * time.Minute, false, true, false},
+		// A state older than the maximum staleness is refreshed before it is served
+		{time.Minute, 15 * time.Minute, time.Hour, false, false, false},
+		// Without a maximum staleness a stale state of any age is served
+		{time.Minute, 0, 24 * time.Hour, false, true, false},
+		{0, 15 * time.Minute, 10 * time.Second, false, false, false},
+		{time.Minute, 15 * time.Minute, 0, true, false, false},
+	}
+
+	for _, testCase := range testCases {
+		ResourceFreshnessWindow = testCase.freshnessWindow
+		ResourceMaxStaleness = testCase.maxStaleness
+
+		var lastRefreshedAt *time.Time
+		if !testCase.neverRefreshed {
+			refreshTime := time.Now().Add(-testCase.age)
+			lastRefreshedAt = &refreshTime
+		}
+
+		if cached := IsStateCacheable(lastRefreshedAt); cached != testCase.expectedCached {
+			t.Fatalf("expected a state refreshed %s ago to be cacheable %t with a window of %s and a maximum staleness of %s, actual %t",
+				testCase.age, testCase.expectedCached, testCase.freshnessWindow, testCase.maxStaleness, cached)
+		}
+		if fresh := IsStateFresh(lastRefreshedAt); fresh != testCase.expectedFresh {
+			t.Fatalf("expected a state refreshed %s ago to be fresh %t with a window of %s, actual %t", testCase.age, testCase.expectedFresh, testCase.freshnessWindow, fresh)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// MaxTerraformImportResources is the number of resources which can be imported by one request
+const MaxTerraformImportResources = 100
+
+// ParseTerraformState parses a terraform state file, older state versions are upgraded to version 3
+func ParseTerraformState(rawState []byte) (*terraform.State, error) {
+	state, err := terraform.ReadState(bytes.NewReader(rawState))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := state.Validate(); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// FindInstanceState returns the resource type and primary instance state of a managed resource address of a terraform state,
+// e.g. cloudflare_record.www, datadog_monitor.cpu[1] or module.dns.cloudflare_record.www
+func FindInstanceState(state *terraform.State, address string) (string, *terraform.InstanceState, error) {
+	resourceAddress, err := terraform.ParseResourceAddress(address)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if resourceAddress.Mode != config.ManagedResourceMode {
+		return "", nil, fmt.Errorf("The address %s is not a managed resource", address)
+	}
+	if len(resourceAddress.Type) == 0 || len(resourceAddress.Name) == 0 {
+		return "", nil, fmt.Errorf("The address %s does not identify a resource", address)
+	}
+
+	moduleState := state.ModuleByPath(append([]string{"root"}, resourceAddress.Path...))
+	if moduleState == nil {
+		return "", nil, fmt.Errorf("The module of address %s was not found in the state", address)
+	}
+
+	key := resourceAddress.Type + "." + resourceAddress.Name
+	if resourceAddress.Index >= 0 {
+		key = key + "." + strconv.Itoa(resourceAddress.Index)
+	}
+
+	resourceState, ok := moduleState.Resources[key]
+	if !ok && resourceAddress.Index == 0 {
+		// The first instance of a resource with a count of one is stored without an index
+		resourceState, ok = moduleState.Resources[resourceAddress.Type+"."+resourceAddress.Name]
+	}
+	if !ok || resourceState.Primary == nil || len(resourceState.Primary.ID) == 0 {
+		return "", nil, fmt.Errorf("The address %s was not found in the state", address)
+	}
+
+	return resourceAddress.Type, resourceState.Primary.DeepCopy(), nil
+}
+
+// GetResourceSettings returns the settings of a resource read from its state, computed attributes are left out
+func GetResourceSettings(provider *schema.Provider, resourceType string, state *terraform.InstanceState) ([]byte, error) {
+	resource, ok := provider.ResourcesMap[resourceType]
+	if !ok {
+		return nil, fmt.Errorf("The resource type %s is not supported", resourceType)
+	}
+
+	resourceData := resource.Data(state)
+	values := make(map[string]interface{}, len(resource.Schema))
+	for key := range resource.Schema {
+		values[key] = resourceData.Get(key)
+	}
+
+	return json.Marshal(getSettings(resource.Schema, values))
+}
+
+func getSettings(schemaMap map[string]*schema.Schema, values map[string]interface{}) map[string]interface{} {
+	settings := make(map[string]interface{})
+	for key, attribute := range schemaMap {
+		if !attribute.Optional && !attribute.Required {
+			continue
+		}
+		if len(attribute.Removed) > 0 || len(attribute.Deprecated) > 0 {
+			continue
+		}
+
+		value := getSettingsValue(attribute, values[key])
+		if isEmptySettingsValue(value) {
+			continue
+		}
+		settings[key] = value
+	}
+
+	return settings
+}
+
+func getSettingsValue(attribute *schema.Schema, value interface{}) interface{} {
+	if set, ok := value.(*schema.Set); ok {
+		value = set.List()
+	}
+
+	elem, ok := attribute.Elem.(*schema.Resource)
+	items, isList := value.([]interface{})
+	if !ok || !isList {
+		return value
+	}
+
+	blocks := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if block, ok := item.(map[string]interface{}); ok {
+			blocks = append(blocks, getSettings(elem.Schema, block))
+		}
+	}
+
+	return blocks
+}
+
+func isEmptySettingsValue(value interface{}) bool {
+	switch typedValue := value.(type) {
+	case nil:
+		return true
+	case string:
+		return len(typedValue) == 0
+	case []interface{}:
+		return len(typedValue) == 0
+	case map[string]interface{}:
+		return len(typedValue) == 0
+	}
+
+	return false
+}
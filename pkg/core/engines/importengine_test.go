@@ -0,0 +1,123 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/entities"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	providerID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.TerraformOSS/providerregistrations/dns"
+	providerRegistrationPackages := []entities.ProviderRegistrationPackage{
+		{
+			ResourceID:   providerID,
+			ProviderType: consts.CloudflareProvider,
+			Settings:     []byte(`{"email": "dns@example.com", "token": "secret"}`),
+		},
+	}
+	resourcePackages := []entities.ResourcePackage{
+		{
+			ResourceID:   "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.TerraformOSS/resources/www",
+			ResourceName: "www",
+			ResourceType: "cloudflare_record",
+			ProviderType: consts.CloudflareProvider,
+			ProviderID:   providerID,
+			Settings:     []byte(`{"domain": "example.com", "name": "www", "type": "A", "value": "10.0.0.1"}`),
+			State: &terraform.InstanceState{
+				ID:         "372e67954025e0ba6aaa6d586b9e0b59",
+				Attributes: map[string]string{"id": "372e67954025e0ba6aaa6d586b9e0b59", "name": "www", "value": "10.0.0.1"},
+			},
+		},
+		{
+			// Resources which were never created are skipped
+			ResourceID:   "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.TerraformOSS/resources/failed",
+			ResourceName: "failed",
+			ResourceType: "cloudflare_record",
+			ProviderType: consts.CloudflareProvider,
+			ProviderID:   providerID,
+		},
+	}
+
+	terraformExport, err := ExportTerraform(providerRegistrationPackages, resourcePackages)
+	if err != nil {
+		t.Fatalf("expected the resources to be exported, actual %s", err)
+	}
+	if len(terraformExport.SkippedResources) != 1 || !strings.HasSuffix(terraformExport.SkippedResources[0], "/failed") {
+		t.Fatalf("expected the resource without a state to be skipped, actual %v", terraformExport.SkippedResources)
+	}
+
+	configuration := map[string]interface{}{}
+	if err := json.Unmarshal(terraformExport.Configuration, &configuration); err != nil {
+		t.Fatalf("expected the configuration to be JSON, actual %s", err)
+	}
+	if strings.Contains(string(terraformExport.Configuration), "secret") {
+		t.Fatalf("expected the provider token to be exported as a variable, actual %s", terraformExport.Configuration)
+	}
+
+	state, err := ParseTerraformState(terraformExport.State)
+	if err != nil {
+		t.Fatalf("expected the exported state to be parsed, actual %s", err)
+	}
+
+	resourceType, instanceState, err := FindInstanceState(state, "cloudflare_record.www")
+	if err != nil {
+		t.Fatalf("expected the exported resource to be found in the state, actual %s", err)
+	}
+	if resourceType != "cloudflare_record" {
+		t.Fatalf("expected the resource type cloudflare_record, actual %s", resourceType)
+	}
+	if instanceState.ID != "372e67954025e0ba6aaa6d586b9e0b59" || instanceState.Attributes["value"] != "10.0.0.1" {
+		t.Fatalf("expected the imported state to equal the exported state, actual %v", instanceState)
+	}
+
+	if _, _, err := FindInstanceState(state, "cloudflare_record.failed"); err == nil {
+		t.Fatalf("expected the skipped resource not to be found in the state")
+	}
+}
+
+func TestFindInstanceState(t *testing.T) {
+	state := terraform.NewState()
+	state.RootModule().Resources["cloudflare_record.www"] = &terraform.ResourceState{
+		Type:    "cloudflare_record",
+		Primary: &terraform.InstanceState{ID: "www"},
+	}
+	state.RootModule().Resources["cloudflare_record.api.1"] = &terraform.ResourceState{
+		Type:    "cloudflare_record",
+		Primary: &terraform.InstanceState{ID: "api1"},
+	}
+
+	testCases := []struct {
+		address    string
+		expectedID string
+	}{
+		{"cloudflare_record.www", "www"},
+		// The first instance of a resource with a count of one is stored without an index
+		{"cloudflare_record.www[0]", "www"},
+		{"cloudflare_record.api[1]", "api1"},
+		{"cloudflare_record.api[2]", ""},
+		{"cloudflare_record.missing", ""},
+		{"data.cloudflare_zones.all", ""},
+		{"module.dns.cloudflare_record.www", ""},
+	}
+
+	for _, testCase := range testCases {
+		_, instanceState, err := FindInstanceState(state, testCase.address)
+		if len(testCase.expectedID) == 0 {
+			if err == nil {
+				t.Fatalf("expected the address %s not to be found", testCase.address)
+			}
+			continue
+		}
+		if err != nil || instanceState.ID != testCase.expectedID {
+			t.Fatalf("expected the address %s to find %s, actual %v %v", testCase.address, testCase.expectedID, instanceState, err)
+		}
+	}
+}
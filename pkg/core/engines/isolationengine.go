@@ -0,0 +1,165 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/apierror"
+	"TFRP/pkg/core/consts"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// TenantIsolatedProviders makes providers ignore the environment and the home directory of the server,
+// so that nothing but the settings of a provider registration configures a provider
+var TenantIsolatedProviders = true
+
+// isolatedKubernetesConfigPath labels the inline kube config, the kubernetes provider only reads inline_config
+// but needs a config path to load it
+const isolatedKubernetesConfigPath = "inline_config"
+
+// isolatedProviderDefaults are the defaults replacing the env defaults of tenant isolated providers,
+// settings which are not listed default to their zero value
+var isolatedProviderDefaults = map[string]map[string]interface{}{
+	consts.KubernetesProvider: {
+		"insecure":    false,
+		"config_path": isolatedKubernetesConfigPath,
+	},
+	consts.CloudflareProvider: {
+		"rps":                4,
+		"retries":            3,
+		"min_backoff":        1,
+		"max_backoff":        30,
+		"api_client_logging": false,
+	},
+}
+
+// localPathSettings are the settings of a provider which reference local paths of the server
+var localPathSettings = map[string][]string{
+	consts.KubernetesProvider: {"config_path"},
+}
+
+// isolateProvider strips the env defaults from the provider schema
+func isolateProvider(providerType string, provider *schema.Provider) *schema.Provider {
+	for key, attribute := range provider.Schema {
+		if attribute.DefaultFunc == nil {
+			continue
+		}
+
+		attribute.DefaultFunc = nil
+		attribute.Default = isolatedProviderDefaults[providerType][key]
+	}
+
+	if providerType == consts.KubernetesProvider {
+		provider.ConfigureFunc = isolateKubernetesConfigure(provider.ConfigureFunc)
+	}
+
+	return provider
+}
+
+// isolateKubernetesConfigure defaults load_config_file to whether an inline kube config is set, registrations
+// which only set inline_config keep loading it while the others never load a kube config of the server
+func isolateKubernetesConfigure(configure schema.ConfigureFunc) schema.ConfigureFunc {
+	return func(d *schema.ResourceData) (interface{}, error) {
+		if _, ok := d.GetOkExists("load_config_file"); !ok {
+			_, hasInlineConfig := d.GetOk("inline_config")
+			if err := d.Set("load_config_file", hasInlineConfig); err != nil {
+				return nil, err
+			}
+		}
+
+		return configure(d)
+	}
+}
+
+// ValidateIsolatedProviderSettings rejects provider registration settings which reference local paths of the server
+func ValidateIsolatedProviderSettings(providerType string, settings []byte) *apierror.ErrorResponse {
+	providerSettings := map[string]interface{}{}
+	if err := json.Unmarshal(settings, &providerSettings); err != nil {
+		return newInvalidParameterError(
+			apierror.NewDetail(apierror.InvalidParameter, SettingsTarget, fmt.Sprintf("Failed to parse provider settings: %s", err)))
+	}
+
+	details := []apierror.Error{}
+	for _, key := range localPathSettings[providerType] {
+		if _, ok := providerSettings[key]; ok {
+			details = append(details, apierror.NewDetail(
+				apierror.InvalidParameter,
+				SettingsTarget+"."+key,
+				fmt.Sprintf("The setting '%s' references a local path, which is not allowed.", key)))
+		}
+	}
+
+	if providerType == consts.KubernetesProvider {
+		details = append(details, validateIsolatedKubernetesSettings(providerSettings)...)
+	}
+
+	if len(details) > 0 {
+		return newInvalidParameterError(details...)
+	}
+
+	return nil
+}
+
+// validateIsolatedKubernetesSettings rejects kube configs which reference local files or commands
+func validateIsolatedKubernetesSettings(providerSettings map[string]interface{}) []apierror.Error {
+	inlineConfigTarget := SettingsTarget + ".inline_config"
+
+	inlineConfig, _ := providerSettings["inline_config"].(string)
+	loadConfigFile := fmt.Sprint(providerSettings["load_config_file"])
+	if (loadConfigFile == "true" || loadConfigFile == "1") && len(inlineConfig) == 0 {
+		return []apierror.Error{apierror.NewDetail(
+			apierror.InvalidParameter,
+			SettingsTarget+".load_config_file",
+			"The setting 'load_config_file' requires 'inline_config', kube config files are not loaded from the local file system.")}
+	}
+
+	if len(inlineConfig) == 0 {
+		return nil
+	}
+
+	kubeConfig, err := clientcmd.Load([]byte(inlineConfig))
+	if err != nil {
+		return []apierror.Error{apierror.NewDetail(
+			apierror.InvalidParameter,
+			inlineConfigTarget,
+			fmt.Sprintf("Failed to parse the kube config: %s", err))}
+	}
+
+	localPaths := []string{}
+	for name, cluster := range kubeConfig.Clusters {
+		if len(cluster.CertificateAuthority) > 0 {
+			localPaths = append(localPaths, fmt.Sprintf("clusters.%s.certificate-authority", name))
+		}
+	}
+	for name, authInfo := range kubeConfig.AuthInfos {
+		if len(authInfo.ClientCertificate) > 0 {
+			localPaths = append(localPaths, fmt.Sprintf("users.%s.client-certificate", name))
+		}
+		if len(authInfo.ClientKey) > 0 {
+			localPaths = append(localPaths, fmt.Sprintf("users.%s.client-key", name))
+		}
+		if len(authInfo.TokenFile) > 0 {
+			localPaths = append(localPaths, fmt.Sprintf("users.%s.tokenFile", name))
+		}
+		if authInfo.AuthProvider != nil && len(authInfo.AuthProvider.Config["cmd-path"]) > 0 {
+			localPaths = append(localPaths, fmt.Sprintf("users.%s.auth-provider.config.cmd-path", name))
+		}
+	}
+	sort.Strings(localPaths)
+
+	details := make([]apierror.Error, 0, len(localPaths))
+	for _, localPath := range localPaths {
+		details = append(details, apierror.NewDetail(
+			apierror.InvalidParameter,
+			inlineConfigTarget,
+			fmt.Sprintf("The kube config setting '%s' references a local path, which is not allowed. Use the inline data settings instead.", localPath)))
+	}
+
+	return details
+}
@@ -0,0 +1,54 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/consts"
+	"testing"
+
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestIsolatedKubernetesLoadConfigFile(t *testing.T) {
+	testCases := []struct {
+		settings               map[string]interface{}
+		expectedLoadConfigFile bool
+	}{
+		{
+			map[string]interface{}{"inline_config": "apiVersion: v1"},
+			true,
+		},
+		{
+			map[string]interface{}{"inline_config": "apiVersion: v1", "load_config_file": false},
+			false,
+		},
+		{
+			map[string]interface{}{"host": "https://cluster.contoso.com"},
+			false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		provider := GetProvider(consts.KubernetesProvider)
+		loadConfigFile := false
+		provider.ConfigureFunc = isolateKubernetesConfigure(func(d *schema.ResourceData) (interface{}, error) {
+			loadConfigFile = d.Get("load_config_file").(bool)
+			return nil, nil
+		})
+
+		rawConfig, err := config.NewRawConfig(testCase.settings)
+		if err != nil {
+			t.Fatalf("failed to create config: %s", err)
+		}
+		if err := provider.Configure(terraform.NewResourceConfig(rawConfig)); err != nil {
+			t.Fatalf("failed to configure the provider: %s", err)
+		}
+		if loadConfigFile != testCase.expectedLoadConfigFile {
+			t.Fatalf("expected load_config_file of %v to equal %v, actual %v", testCase.settings, testCase.expectedLoadConfigFile, loadConfigFile)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/entities"
+	"TFRP/pkg/core/storage"
+	"TFRP/pkg/core/tracing"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// resourceLockPrefix prefixes the lease ids of resource locks
+	resourceLockPrefix = "resource:"
+	// leaderLockPrefix prefixes the lease ids of periodic job leader locks
+	leaderLockPrefix = "leader:"
+)
+
+// ErrLockHeld is returned when a lock is held by another request or replica
+var ErrLockHeld = storage.ErrLeaseHeld
+
+// LockEngine takes distributed locks backed by leases in storage, a lock is renewed in the background
+// until it is released and carries a fencing token which increases every time the lock changes hands
+type LockEngine struct {
+	TTL time.Duration
+
+	leaseDataProvider *storage.LeaseDataProvider
+	replicaID         string
+}
+
+// Lock is a distributed lock held by this replica
+type Lock struct {
+	lockEngine *LockEngine
+	lock       sync.Mutex
+	lease      *entities.LeasePackage
+	lost       bool
+	stop       chan struct{}
+	stopped    chan struct{}
+	ctx        context.Context // the context the lock was acquired in, the release continues its trace
+}
+
+// NewLockEngine creates a lock engine
+func NewLockEngine(leaseDataProvider *storage.LeaseDataProvider, ttl time.Duration) (lockEngine *LockEngine) {
+	hostname, _ := os.Hostname()
+
+	lockEngine = new(LockEngine)
+	lockEngine.TTL = ttl
+	lockEngine.leaseDataProvider = leaseDataProvider
+	lockEngine.replicaID = fmt.Sprintf("%s/%d", hostname, os.Getpid())
+	return lockEngine
+}
+
+// AcquireResourceLock takes the lock of a resource, it returns ErrLockHeld if the resource is locked
+func (lockEngine *LockEngine) AcquireResourceLock(ctx context.Context, resourceID string) (*Lock, error) {
+	return lockEngine.Acquire(ctx, resourceLockPrefix+strings.ToLower(resourceID))
+}
+
+// BreakResourceLock takes the lock of a resource even if it is held, the previous holder loses the lock
+func (lockEngine *LockEngine) BreakResourceLock(ctx context.Context, resourceID string) (*Lock, error) {
+	holder, err := lockEngine.newHolder()
+	if err != nil {
+		return nil, err
+	}
+
+	lease, err := lockEngine.leaseDataProvider.BreakLease(ctx, resourceLockPrefix+strings.ToLower(resourceID), holder, lockEngine.TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return lockEngine.newLock(ctx, lease), nil
+}
+
+// RequestResourceCancel asks the replica holding the lock of a resource to cancel the operation it runs on the resource
+func (lockEngine *LockEngine) RequestResourceCancel(ctx context.Context, resourceID string) error {
+	return lockEngine.leaseDataProvider.RequestCancel(ctx, resourceLockPrefix+strings.ToLower(resourceID))
+}
+
+// Acquire takes a lock, it returns ErrLockHeld if the lock is held
+func (lockEngine *LockEngine) Acquire(ctx context.Context, name string) (*Lock, error) {
+	holder, err := lockEngine.newHolder()
+	if err != nil {
+		return nil, err
+	}
+
+	lease, err := lockEngine.leaseDataProvider.AcquireLease(ctx, name, holder, lockEngine.TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return lockEngine.newLock(ctx, lease), nil
+}
+
+// newHolder returns a new holder of this replica, every acquisition has its own holder so that requests
+// of the same replica exclude each other
+func (lockEngine *LockEngine) newHolder() (string, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return lockEngine.replicaID + "/" + hex.EncodeToString(nonce), nil
+}
+
+// newLock returns the lock of a lease and renews it in the background
+func (lockEngine *LockEngine) newLock(ctx context.Context, lease *entities.LeasePackage) *Lock {
+	lock := &Lock{
+		lockEngine: lockEngine,
+		ctx:        tracing.Detach(ctx),
+		lease:      lease,
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	go lock.renew()
+
+	return lock
+}
+
+// RunLeaderElection runs a periodic job on the replica holding the leader lock of the job until stop is closed,
+// every run of the job is the root of its own trace
+func (lockEngine *LockEngine) RunLeaderElection(jobName string, interval time.Duration, job func(ctx context.Context), stop <-chan struct{}) {
+	var leaderLock *Lock
+	defer func() {
+		if leaderLock != nil {
+			leaderLock.Release()
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if leaderLock != nil && leaderLock.IsLost() {
+			log.Printf("Lost the leadership of job %s", jobName)
+			leaderLock = nil
+		}
+
+		if leaderLock == nil {
+			// The elections of the replicas which are not the leader are not traced
+			lock, err := lockEngine.Acquire(tracing.WithoutSpans(context.Background()), leaderLockPrefix+jobName)
+			if err != nil {
+				if err != ErrLockHeld {
+					log.Printf("Failed to elect the leader of job %s: %s", jobName, err)
+				}
+				continue
+			}
+
+			log.Printf("Became the leader of job %s", jobName)
+			leaderLock = lock
+		}
+
+		ctx, span := tracing.Start(context.Background(), "job "+jobName, tracing.SpanKindInternal)
+		job(ctx)
+		span.End()
+	}
+}
+
+// FencingToken returns the fencing token of the lock, writes guarded by the lock must be rejected
+// once storage has seen a greater token
+func (lock *Lock) FencingToken() int64 {
+	lock.lock.Lock()
+	defer lock.lock.Unlock()
+
+	return lock.lease.FencingToken
+}
+
+// IsLost returns whether the lock expired before it could be renewed
+func (lock *Lock) IsLost() bool {
+	lock.lock.Lock()
+	defer lock.lock.Unlock()
+
+	return lock.lost
+}
+
+// IsCancelRequested returns whether the operation run under the lock was canceled, a cancellation is seen
+// when the lock is renewed
+func (lock *Lock) IsCancelRequested() bool {
+	lock.lock.Lock()
+	defer lock.lock.Unlock()
+
+	return lock.lease.CancelRequested
+}
+
+// Release stops renewing the lock and releases it
+func (lock *Lock) Release() {
+	close(lock.stop)
+	<-lock.stopped
+
+	lock.lock.Lock()
+	defer lock.lock.Unlock()
+
+	if lock.lost {
+		return
+	}
+
+	if err := lock.lockEngine.leaseDataProvider.ReleaseLease(lock.ctx, lock.lease); err != nil {
+		log.Printf("Failed to release lock %s: %s", lock.lease.LeaseID, err)
+	}
+}
+
+func (lock *Lock) renew() {
+	defer close(lock.stopped)
+
+	// The renewals are heartbeats rather than part of the trace the lock was acquired in
+	ctx := tracing.WithoutSpans(context.Background())
+
+	ticker := time.NewTicker(lock.lockEngine.TTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lock.stop:
+			return
+		case <-ticker.C:
+		}
+
+		lock.lock.Lock()
+		err := lock.lockEngine.leaseDataProvider.RenewLease(ctx, lock.lease, lock.lockEngine.TTL)
+		if err == storage.ErrLeaseLost {
+			lock.lost = true
+		}
+		lock.lock.Unlock()
+
+		if err == storage.ErrLeaseLost {
+			log.Printf("Lost lock %s", lock.lease.LeaseID)
+			return
+		}
+		if err != nil {
+			// The lock is held until the lease expires, the next renewal may succeed
+			log.Printf("Failed to renew lock %s: %s", lock.lease.LeaseID, err)
+		}
+	}
+}
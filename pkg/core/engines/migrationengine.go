@@ -0,0 +1,78 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/entities"
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// schemaVersionMetaKey is the key of the schema version in the meta of an instance state
+const schemaVersionMetaKey = "schema_version"
+
+// GetSchemaVersion returns the current schema version of a resource type
+func GetSchemaVersion(provider *schema.Provider, resourceType string) int {
+	resource, ok := provider.ResourcesMap[resourceType]
+	if !ok {
+		return 0
+	}
+
+	return resource.SchemaVersion
+}
+
+// GetStoredSchemaVersion returns the schema version of the stored state of a resource, the schema version
+// of packages stored before it was persisted is read from the state meta
+func GetStoredSchemaVersion(resourcePackage *entities.ResourcePackage) int {
+	if resourcePackage.SchemaVersion > 0 || resourcePackage.State == nil {
+		return resourcePackage.SchemaVersion
+	}
+
+	rawSchemaVersion, _ := resourcePackage.State.Meta[schemaVersionMetaKey].(string)
+	schemaVersion, _ := strconv.Atoi(rawSchemaVersion)
+	return schemaVersion
+}
+
+// MigrateState runs the state migration of a resource type on a state stored with an older schema version,
+// the provider must be configured. It returns the migrated state and its schema version.
+func MigrateState(provider *schema.Provider, resourceType string, storedSchemaVersion int, state *terraform.InstanceState) (*terraform.InstanceState, int, error) {
+	resource, ok := provider.ResourcesMap[resourceType]
+	if !ok {
+		return nil, 0, fmt.Errorf("The resource type %s is not supported", resourceType)
+	}
+
+	if state == nil {
+		return nil, resource.SchemaVersion, nil
+	}
+
+	if storedSchemaVersion > resource.SchemaVersion {
+		return nil, 0, fmt.Errorf(
+			"The state of resource type %s has schema version %d, which is newer than the schema version %d of the provider",
+			resourceType, storedSchemaVersion, resource.SchemaVersion)
+	}
+
+	migratedState := state.DeepCopy()
+	if storedSchemaVersion < resource.SchemaVersion && resource.MigrateState != nil {
+		var err error
+		migratedState, err = resource.MigrateState(storedSchemaVersion, migratedState, provider.Meta())
+		if err != nil {
+			return nil, 0, fmt.Errorf("Failed to migrate the state of resource type %s from schema version %d to %d: %s",
+				resourceType, storedSchemaVersion, resource.SchemaVersion, err)
+		}
+	}
+
+	// Record the schema version the way the provider does, so that the provider does not migrate the state again on refresh
+	if migratedState != nil && resource.SchemaVersion > 0 {
+		if migratedState.Meta == nil {
+			migratedState.Meta = make(map[string]interface{})
+		}
+		migratedState.Meta[schemaVersionMetaKey] = strconv.Itoa(resource.SchemaVersion)
+	}
+
+	return migratedState, resource.SchemaVersion, nil
+}
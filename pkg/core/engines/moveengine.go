@@ -0,0 +1,111 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/apierror"
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/entities"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// resourceGroupIDRegexp matches the id of a resource group and captures its subscription id and name
+var resourceGroupIDRegexp = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourcegroups/([^/]+)$`)
+
+// movableResourceIDRegexp matches the ids of the resources which can be moved and captures their resource group id, type and name
+var movableResourceIDRegexp = regexp.MustCompile(`(?i)^(/subscriptions/[^/]+/resourcegroups/[^/]+)/providers/` +
+	regexp.QuoteMeta(consts.TerraformRPNamespace) + `/(resources|providerregistrations)/([^/]+)$`)
+
+// GetResourceMoves validates the move of resources from a resource group and returns the ids they move to,
+// resources and provider registrations can be moved
+func GetResourceMoves(sourceResourceGroupID string, moveResourcesDefinition *entities.MoveResourcesDefinition) ([]entities.ResourceMove, *apierror.ErrorResponse) {
+	match := resourceGroupIDRegexp.FindStringSubmatch(moveResourcesDefinition.TargetResourceGroup)
+	if match == nil {
+		return nil, newInvalidParameterError(apierror.NewDetail(
+			apierror.InvalidParameter,
+			"targetResourceGroup",
+			fmt.Sprintf("The target resource group '%s' is not a resource group id.", moveResourcesDefinition.TargetResourceGroup)))
+	}
+	if strings.EqualFold(moveResourcesDefinition.TargetResourceGroup, sourceResourceGroupID) {
+		return nil, newInvalidParameterError(apierror.NewDetail(
+			apierror.InvalidParameter,
+			"targetResourceGroup",
+			"The target resource group is the resource group of the resources."))
+	}
+	if len(moveResourcesDefinition.Resources) == 0 {
+		return nil, newInvalidParameterError(apierror.NewDetail(
+			apierror.InvalidParameter,
+			"resources",
+			"Request content is missing property 'Resources'."))
+	}
+
+	targetResourceGroupID := "/" + Subscriptions + "/" + match[1] + "/" + ResourceGroups + "/" + match[2]
+
+	details := []apierror.Error{}
+	resourceMoves := make([]entities.ResourceMove, 0, len(moveResourcesDefinition.Resources))
+	resourceIDs := make(map[string]bool)
+	for i, resourceID := range moveResourcesDefinition.Resources {
+		target := fmt.Sprintf("resources[%d]", i)
+		match := movableResourceIDRegexp.FindStringSubmatch(resourceID)
+		if match == nil {
+			details = append(details, apierror.NewDetail(apierror.InvalidParameter, target, fmt.Sprintf("The resource '%s' cannot be moved.", resourceID)))
+			continue
+		}
+		if !strings.EqualFold(match[1], sourceResourceGroupID) {
+			details = append(details, apierror.NewDetail(apierror.InvalidParameter, target, fmt.Sprintf("The resource '%s' is not in the resource group.", resourceID)))
+			continue
+		}
+		if resourceIDs[strings.ToLower(resourceID)] {
+			details = append(details, apierror.NewDetail(apierror.InvalidParameter, target, fmt.Sprintf("The resource '%s' is moved more than once.", resourceID)))
+			continue
+		}
+		resourceIDs[strings.ToLower(resourceID)] = true
+
+		isProviderRegistration := strings.EqualFold(match[2], ProviderRegistrations)
+		resourceType := Resources
+		if isProviderRegistration {
+			resourceType = ProviderRegistrations
+		}
+
+		resourceMoves = append(resourceMoves, entities.ResourceMove{
+			SourceID:               resourceID,
+			TargetID:               targetResourceGroupID + "/" + Providers + "/" + consts.TerraformRPNamespace + "/" + resourceType + "/" + match[3],
+			IsProviderRegistration: isProviderRegistration,
+		})
+	}
+
+	if len(details) > 0 {
+		return nil, newInvalidParameterError(details...)
+	}
+
+	return resourceMoves, nil
+}
+
+// IsCrossSubscriptionMove returns whether resources move from a resource group to a resource group of another subscription
+func IsCrossSubscriptionMove(sourceResourceGroupID, targetResourceGroupID string) bool {
+	sourceMatch := resourceGroupIDRegexp.FindStringSubmatch(sourceResourceGroupID)
+	targetMatch := resourceGroupIDRegexp.FindStringSubmatch(targetResourceGroupID)
+	if sourceMatch == nil || targetMatch == nil {
+		return true
+	}
+
+	return !strings.EqualFold(sourceMatch[1], targetMatch[1])
+}
+
+// NewMoveResourcesError returns the error of a move with the reasons each resource cannot be moved
+func NewMoveResourcesError(details []apierror.Error) *apierror.ErrorResponse {
+	messages := make([]string, 0, len(details))
+	for _, detail := range details {
+		messages = append(messages, detail.Message)
+	}
+
+	return apierror.NewWithDetails(
+		apierror.ClientError,
+		apierror.Conflict,
+		fmt.Sprintf("The resources cannot be moved: %s", strings.Join(messages, " ")),
+		details)
+}
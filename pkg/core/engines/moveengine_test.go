@@ -0,0 +1,122 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/entities"
+	"testing"
+)
+
+const (
+	testSourceResourceGroupID = "/subscriptions/sub/resourceGroups/source"
+	testProvidersPath         = "/providers/Microsoft.TerraformOSS"
+)
+
+func TestGetResourceMoves(t *testing.T) {
+	resourceMoves, errorResponse := GetResourceMoves(testSourceResourceGroupID, &entities.MoveResourcesDefinition{
+		TargetResourceGroup: "/subscriptions/sub/resourcegroups/target",
+		Resources: []string{
+			testSourceResourceGroupID + testProvidersPath + "/resources/www",
+			"/subscriptions/sub/resourcegroups/SOURCE" + testProvidersPath + "/providerRegistrations/dns",
+		},
+	})
+	if errorResponse != nil {
+		t.Fatalf("expected the move to be valid, actual %s", errorResponse.Body.Message)
+	}
+
+	expectedResourceMoves := []entities.ResourceMove{
+		{
+			SourceID: testSourceResourceGroupID + testProvidersPath + "/resources/www",
+			TargetID: "/subscriptions/sub/resourceGroups/target" + testProvidersPath + "/resources/www",
+		},
+		{
+			SourceID:               "/subscriptions/sub/resourcegroups/SOURCE" + testProvidersPath + "/providerRegistrations/dns",
+			TargetID:               "/subscriptions/sub/resourceGroups/target" + testProvidersPath + "/providerregistrations/dns",
+			IsProviderRegistration: true,
+		},
+	}
+	if len(resourceMoves) != len(expectedResourceMoves) {
+		t.Fatalf("expected %d moves, actual %d", len(expectedResourceMoves), len(resourceMoves))
+	}
+	for i, expectedResourceMove := range expectedResourceMoves {
+		if resourceMoves[i] != expectedResourceMove {
+			t.Fatalf("expected move %d to equal %v, actual %v", i, expectedResourceMove, resourceMoves[i])
+		}
+	}
+}
+
+func TestGetResourceMovesRejectsInvalidMoves(t *testing.T) {
+	testCases := []struct {
+		targetResourceGroup string
+		resources           []string
+		expectedTarget      string
+	}{
+		{
+			"/subscriptions/sub/resourceGroups/target/providers/Microsoft.TerraformOSS",
+			[]string{testSourceResourceGroupID + testProvidersPath + "/resources/www"},
+			"targetResourceGroup",
+		},
+		{
+			testSourceResourceGroupID,
+			[]string{testSourceResourceGroupID + testProvidersPath + "/resources/www"},
+			"targetResourceGroup",
+		},
+		{
+			"/subscriptions/sub/resourceGroups/target",
+			nil,
+			"resources",
+		},
+		{
+			// Only resources and provider registrations can be moved
+			"/subscriptions/sub/resourceGroups/target",
+			[]string{testSourceResourceGroupID + testProvidersPath + "/operationstatus/www"},
+			"resources[0]",
+		},
+		{
+			"/subscriptions/sub/resourceGroups/target",
+			[]string{"/subscriptions/sub/resourceGroups/other" + testProvidersPath + "/resources/www"},
+			"resources[0]",
+		},
+		{
+			"/subscriptions/sub/resourceGroups/target",
+			[]string{
+				testSourceResourceGroupID + testProvidersPath + "/resources/www",
+				testSourceResourceGroupID + testProvidersPath + "/resources/WWW",
+			},
+			"resources[1]",
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, errorResponse := GetResourceMoves(testSourceResourceGroupID, &entities.MoveResourcesDefinition{
+			TargetResourceGroup: testCase.targetResourceGroup,
+			Resources:           testCase.resources,
+		})
+		if errorResponse == nil {
+			t.Fatalf("expected the move of %v to %s to be rejected", testCase.resources, testCase.targetResourceGroup)
+		}
+		if len(errorResponse.Body.Details) != 1 || errorResponse.Body.Details[0].Target != testCase.expectedTarget {
+			t.Fatalf("expected the move of %v to %s to be rejected on %s, actual %v", testCase.resources, testCase.targetResourceGroup, testCase.expectedTarget, errorResponse.Body.Details)
+		}
+	}
+}
+
+func TestIsCrossSubscriptionMove(t *testing.T) {
+	testCases := []struct {
+		targetResourceGroupID string
+		expected              bool
+	}{
+		{"/subscriptions/sub/resourceGroups/target", false},
+		{"/subscriptions/SUB/resourcegroups/target", false},
+		{"/subscriptions/other/resourceGroups/target", true},
+		{"/subscriptions/sub", true},
+	}
+
+	for _, testCase := range testCases {
+		if actual := IsCrossSubscriptionMove(testSourceResourceGroupID, testCase.targetResourceGroupID); actual != testCase.expected {
+			t.Fatalf("expected the move to %s to be cross subscription %t, actual %t", testCase.targetResourceGroupID, testCase.expected, actual)
+		}
+	}
+}
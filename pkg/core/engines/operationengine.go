@@ -0,0 +1,553 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/tracing"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+var (
+	// ErrOperationTimeout is returned when a provider operation exceeds its timeout
+	ErrOperationTimeout = errors.New("the operation did not complete within its timeout")
+	// ErrOperationCanceled is returned when a provider operation is canceled
+	ErrOperationCanceled = errors.New("the operation was canceled")
+	// ErrOperationInterrupted is returned when a provider operation is interrupted by a shutdown
+	ErrOperationInterrupted = errors.New("the operation was interrupted by a service shutdown and can be resumed by repeating the request")
+)
+
+const (
+	// operationStopGracePeriod is how long a stopped provider operation is waited for before it is abandoned
+	operationStopGracePeriod = 30 * time.Second
+	// operationCancelPollInterval is how often the lock of an operation is checked for a cancellation requested
+	// on any replica
+	operationCancelPollInterval = time.Second
+	// SynchronousRetryBudget is how long the transient errors of a provider call are retried while a request waits,
+	// ARM times out synchronous requests after a minute
+	SynchronousRetryBudget = 20 * time.Second
+)
+
+// OperationEngine runs provider operations with timeouts and retries, and tracks the in-flight ones so they can be canceled
+type OperationEngine struct {
+	DefaultTimeout        time.Duration
+	ResourceTypeTimeouts  map[string]*schema.ResourceTimeout
+	ProviderRetryPolicies map[string]RetryPolicy
+
+	lock       sync.Mutex
+	operations map[string]*operation
+	inFlight   sync.WaitGroup
+}
+
+type operation struct {
+	cancel      context.CancelFunc
+	canceled    bool
+	interrupted bool
+}
+
+// NewOperationEngine creates an operation engine
+func NewOperationEngine(defaultTimeout time.Duration, resourceTypeTimeouts map[string]*schema.ResourceTimeout, providerRetryPolicies map[string]RetryPolicy) (operationEngine *OperationEngine) {
+	operationEngine = new(OperationEngine)
+	operationEngine.DefaultTimeout = defaultTimeout
+	operationEngine.ResourceTypeTimeouts = resourceTypeTimeouts
+	operationEngine.ProviderRetryPolicies = providerRetryPolicies
+	operationEngine.operations = make(map[string]*operation)
+	return operationEngine
+}
+
+// ParseResourceTypeTimeouts parses timeouts in the form {resourceType}:{create|update|delete|default}={duration}
+func ParseResourceTypeTimeouts(values []string) (map[string]*schema.ResourceTimeout, error) {
+	resourceTypeTimeouts := make(map[string]*schema.ResourceTimeout)
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("The operation timeout '%s' is not in the form {resourceType}:{operation}={duration}", value)
+		}
+
+		operationAndDuration := strings.SplitN(parts[1], "=", 2)
+		if len(operationAndDuration) != 2 {
+			return nil, fmt.Errorf("The operation timeout '%s' is not in the form {resourceType}:{operation}={duration}", value)
+		}
+
+		duration, err := time.ParseDuration(operationAndDuration[1])
+		if err != nil {
+			return nil, fmt.Errorf("The operation timeout '%s' has an invalid duration: %s", value, err)
+		}
+
+		resourceTimeout, ok := resourceTypeTimeouts[parts[0]]
+		if !ok {
+			resourceTimeout = new(schema.ResourceTimeout)
+			resourceTypeTimeouts[parts[0]] = resourceTimeout
+		}
+
+		switch operationAndDuration[0] {
+		case schema.TimeoutCreate:
+			resourceTimeout.Create = &duration
+		case schema.TimeoutUpdate:
+			resourceTimeout.Update = &duration
+		case schema.TimeoutDelete:
+			resourceTimeout.Delete = &duration
+		case schema.TimeoutDefault:
+			resourceTimeout.Default = &duration
+		default:
+			return nil, fmt.Errorf("The operation timeout '%s' has an unsupported operation '%s'", value, operationAndDuration[0])
+		}
+	}
+
+	return resourceTypeTimeouts, nil
+}
+
+// GetTimeout returns the timeout of an operation (create, update or delete) on a resource type.
+// Configured resource type timeouts win over the timeouts declared by the resource schema,
+// which win over the default timeout.
+func (operationEngine *OperationEngine) GetTimeout(provider *schema.Provider, resourceType, operationName string) time.Duration {
+	if timeout := getResourceTimeout(operationEngine.ResourceTypeTimeouts[resourceType], operationName); timeout != nil {
+		return *timeout
+	}
+
+	if resource, ok := provider.ResourcesMap[resourceType]; ok {
+		if timeout := getResourceTimeout(resource.Timeouts, operationName); timeout != nil {
+			return *timeout
+		}
+	}
+
+	return operationEngine.DefaultTimeout
+}
+
+// ApplyRequest is a provider apply run by the operation engine
+type ApplyRequest struct {
+	ResourceID   string
+	ProviderType string
+	Provider     *schema.Provider
+	Info         *terraform.InstanceInfo
+	State        *terraform.InstanceState
+	Diff         *terraform.InstanceDiff
+	// ResourceConfig is used to diff the refreshed state again before a retry
+	ResourceConfig *terraform.ResourceConfig
+	// OnRetry is called before a failed attempt is retried
+	OnRetry func(attempt int, err error)
+	// Lock is the lock of the resource the operation runs under, a cancellation requested on it cancels the operation
+	Lock *Lock
+	// Synchronous is set when a request waits for the operation, the operation is stopped when the request is canceled
+	// and its transient errors are only retried within SynchronousRetryBudget
+	Synchronous bool
+
+	// Attempts is the number of provider calls made, including the refreshes before retries
+	Attempts int
+	// LastError is the error of the last failed attempt
+	LastError error
+}
+
+// Apply calls provider apply, retrying transient errors, and stops the provider when the operation times out or is canceled.
+// After a failed attempt the resource is refreshed and diffed again, so a partial apply is never applied twice.
+func (operationEngine *OperationEngine) Apply(ctx context.Context, applyRequest *ApplyRequest) (resourceState *terraform.InstanceState, err error) {
+	provider := applyRequest.Provider
+	info := applyRequest.Info
+	state := applyRequest.State
+	diff := applyRequest.Diff
+
+	operationName := schema.TimeoutUpdate
+	if diff.Destroy {
+		operationName = schema.TimeoutDelete
+	} else if state == nil || len(state.ID) == 0 {
+		operationName = schema.TimeoutCreate
+	}
+
+	ctx, span := tracing.Start(ctx, "apply "+info.Type, tracing.SpanKindInternal)
+	span.SetAttribute("terraform.provider", applyRequest.ProviderType)
+	span.SetAttribute("terraform.resource_type", info.Type)
+	span.SetAttribute("terraform.operation", operationName)
+	span.SetAttribute("resource.id", applyRequest.ResourceID)
+	defer func() {
+		span.SetAttribute("terraform.attempts", applyRequest.Attempts)
+		span.RecordError(err)
+		span.End()
+	}()
+
+	// A background operation is only stopped by its timeout, a cancellation or a shutdown, never by the request which started it
+	operationCtx := ctx
+	if !applyRequest.Synchronous {
+		operationCtx = tracing.Detach(ctx)
+	}
+	ctx, cancel := context.WithTimeout(operationCtx, operationEngine.GetTimeout(provider, info.Type, operationName))
+	defer cancel()
+
+	retryDeadline := time.Now().Add(SynchronousRetryBudget)
+
+	op := operationEngine.register(applyRequest.ResourceID, cancel)
+	defer operationEngine.unregister(applyRequest.ResourceID, op)
+
+	if applyRequest.Lock != nil {
+		stopWatching := operationEngine.watchCancelRequest(ctx, applyRequest.Lock, op)
+		defer stopWatching()
+	}
+
+	retryPolicy := operationEngine.GetRetryPolicy(applyRequest.ProviderType)
+	retryBackOff := retryPolicy.NewBackOff()
+	for {
+		applyRequest.Attempts++
+		resourceState, err := operationEngine.run(ctx, op, applyRequest, "Apply", func() (*terraform.InstanceState, error) {
+			return provider.Apply(info, state, diff)
+		})
+		if err == nil {
+			return resourceState, nil
+		}
+
+		applyRequest.LastError = err
+		if resourceState != nil && len(resourceState.ID) > 0 {
+			state = resourceState
+		}
+
+		for {
+			retryAfter, ok := operationEngine.shouldRetry(err, retryPolicy, retryBackOff, applyRequest.Attempts)
+			if !ok {
+				return resourceState, err
+			}
+
+			// A retry which cannot start within the retry budget of a waiting request only delays the failure
+			if applyRequest.Synchronous && time.Now().Add(retryAfter).After(retryDeadline) {
+				return resourceState, err
+			}
+
+			log.Printf("Retrying apply of resource '%s' in %s after attempt %d failed: %s", applyRequest.ResourceID, retryAfter, applyRequest.Attempts, err)
+			if applyRequest.OnRetry != nil {
+				applyRequest.OnRetry(applyRequest.Attempts, err)
+			}
+
+			select {
+			case <-time.After(retryAfter):
+			case <-ctx.Done():
+				return resourceState, operationEngine.operationError(ctx, op)
+			}
+
+			// Refresh and diff again so only what is left of a partial apply is applied
+			if state != nil && len(state.ID) > 0 {
+				refreshedState, refreshErr := operationEngine.run(ctx, op, applyRequest, "Refresh", func() (*terraform.InstanceState, error) {
+					return provider.Refresh(info, state)
+				})
+				if refreshErr != nil {
+					err = refreshErr
+					applyRequest.Attempts++
+					applyRequest.LastError = err
+					continue
+				}
+				state = refreshedState
+			}
+
+			if diff.Destroy {
+				if state == nil || len(state.ID) == 0 {
+					return nil, nil
+				}
+				break
+			}
+
+			if state == nil {
+				state = new(terraform.InstanceState)
+				state.Init()
+			}
+
+			var retryDiff *terraform.InstanceDiff
+			diffState := state
+			_, diffErr := operationEngine.run(ctx, op, applyRequest, "Diff", func() (*terraform.InstanceState, error) {
+				instanceDiff, err := provider.Diff(info, diffState, applyRequest.ResourceConfig)
+				retryDiff = instanceDiff
+				return nil, err
+			})
+			if diffErr != nil {
+				err = diffErr
+				applyRequest.Attempts++
+				applyRequest.LastError = err
+				continue
+			}
+
+			if retryDiff == nil || retryDiff.Empty() {
+				return state, nil
+			}
+			diff = retryDiff
+			break
+		}
+	}
+}
+
+// Refresh calls provider refresh, retrying transient errors until the deadline of ctx
+func (operationEngine *OperationEngine) Refresh(ctx context.Context, providerType string, provider *schema.Provider, info *terraform.InstanceInfo, state *terraform.InstanceState) (*terraform.InstanceState, error) {
+	retryPolicy := operationEngine.GetRetryPolicy(providerType)
+	retryBackOff := retryPolicy.NewBackOff()
+	for attempts := 1; ; attempts++ {
+		_, span := StartProviderSpan(ctx, "Refresh", providerType)
+		span.SetAttribute("terraform.resource_type", info.Type)
+		resourceState, err := provider.Refresh(info, state)
+		span.RecordError(err)
+		span.End()
+		if err == nil {
+			return resourceState, nil
+		}
+
+		retryAfter, ok := operationEngine.shouldRetry(err, retryPolicy, retryBackOff, attempts)
+		if !ok {
+			return nil, err
+		}
+
+		// A retry which cannot complete before the deadline only delays the failure
+		if deadline, hasDeadline := ctx.Deadline(); hasDeadline && time.Now().Add(retryAfter).After(deadline) {
+			return nil, err
+		}
+
+		log.Printf("Retrying refresh of resource '%s' in %s after attempt %d failed: %s", state.ID, retryAfter, attempts, err)
+		select {
+		case <-time.After(retryAfter):
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
+
+// WithSynchronousRetryBudget returns a context bounding the retries of the provider calls a request waits for
+func WithSynchronousRetryBudget(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, SynchronousRetryBudget)
+}
+
+// GetRetryPolicy returns the retry policy of a provider type
+func (operationEngine *OperationEngine) GetRetryPolicy(providerType string) RetryPolicy {
+	if retryPolicy, ok := operationEngine.ProviderRetryPolicies[providerType]; ok {
+		return retryPolicy
+	}
+
+	return DefaultRetryPolicy
+}
+
+// Cancel cancels the in-flight operation of a resource, it returns false if there is none
+func (operationEngine *OperationEngine) Cancel(resourceID string) bool {
+	operationEngine.lock.Lock()
+	defer operationEngine.lock.Unlock()
+
+	op, ok := operationEngine.operations[resourceID]
+	if !ok {
+		return false
+	}
+
+	op.canceled = true
+	op.cancel()
+	return true
+}
+
+// watchCancelRequest cancels an operation once a cancellation is requested on its lock, which may be requested
+// on another replica than the one running the operation. The returned function stops watching.
+func (operationEngine *OperationEngine) watchCancelRequest(ctx context.Context, lock *Lock, op *operation) func() {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(operationCancelPollInterval)
+		defer ticker.Stop()
+
+		for !lock.IsCancelRequested() {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+
+		operationEngine.lock.Lock()
+		op.canceled = true
+		op.cancel()
+		operationEngine.lock.Unlock()
+	}()
+
+	return func() { close(stop) }
+}
+
+// Go runs a background operation which is drained on shutdown
+func (operationEngine *OperationEngine) Go(backgroundOperation func()) {
+	operationEngine.inFlight.Add(1)
+	go func() {
+		defer operationEngine.inFlight.Done()
+		backgroundOperation()
+	}()
+}
+
+// Track registers a synchronous operation of a request so that it is drained on shutdown,
+// the returned function is called once the operation completes
+func (operationEngine *OperationEngine) Track() func() {
+	operationEngine.inFlight.Add(1)
+	return operationEngine.inFlight.Done
+}
+
+// Drain waits for the background operations to complete. The operations still in flight after the timeout
+// are interrupted and waited for until they have stopped their provider and recorded their state.
+func (operationEngine *OperationEngine) Drain(timeout time.Duration) bool {
+	drained := make(chan struct{})
+	go func() {
+		operationEngine.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return true
+	case <-time.After(timeout):
+	}
+
+	operationEngine.lock.Lock()
+	for resourceID, op := range operationEngine.operations {
+		log.Printf("Interrupting the operation of resource '%s'", resourceID)
+		op.interrupted = true
+		op.cancel()
+	}
+	operationEngine.lock.Unlock()
+
+	// The interrupted operations wait up to operationStopGracePeriod for their provider before they record their state
+	select {
+	case <-drained:
+	case <-time.After(2 * operationStopGracePeriod):
+		log.Printf("Background operations did not stop after they were interrupted")
+	}
+
+	return false
+}
+
+// IsInFlight returns whether a resource has an in-flight operation
+func (operationEngine *OperationEngine) IsInFlight(resourceID string) bool {
+	operationEngine.lock.Lock()
+	defer operationEngine.lock.Unlock()
+
+	_, ok := operationEngine.operations[resourceID]
+	return ok
+}
+
+func (operationEngine *OperationEngine) register(resourceID string, cancel context.CancelFunc) *operation {
+	operationEngine.lock.Lock()
+	defer operationEngine.lock.Unlock()
+
+	op := &operation{cancel: cancel}
+	operationEngine.operations[resourceID] = op
+	return op
+}
+
+func (operationEngine *OperationEngine) unregister(resourceID string, op *operation) {
+	operationEngine.lock.Lock()
+	defer operationEngine.lock.Unlock()
+
+	if operationEngine.operations[resourceID] == op {
+		delete(operationEngine.operations, resourceID)
+	}
+}
+
+// run calls a provider function and stops the provider when the operation times out or is canceled
+func (operationEngine *OperationEngine) run(ctx context.Context, op *operation, applyRequest *ApplyRequest, callName string, call func() (*terraform.InstanceState, error)) (resourceState *terraform.InstanceState, err error) {
+	resourceID := applyRequest.ResourceID
+	provider := applyRequest.Provider
+
+	_, span := StartProviderSpan(ctx, callName, applyRequest.ProviderType)
+	span.SetAttribute("terraform.resource_type", applyRequest.Info.Type)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	type callResult struct {
+		state *terraform.InstanceState
+		err   error
+	}
+
+	done := make(chan callResult, 1)
+	go func() {
+		resourceState, err := call()
+		done <- callResult{state: resourceState, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.state, result.err
+	case <-ctx.Done():
+	}
+
+	operationErr := operationEngine.operationError(ctx, op)
+
+	log.Printf("Stopping provider for resource '%s': %s", resourceID, operationErr)
+	if err := provider.Stop(); err != nil {
+		log.Printf("Failed to stop provider for resource '%s': %s", resourceID, err)
+	}
+
+	// Give the provider a chance to return the partial state of the stopped call
+	select {
+	case result := <-done:
+		return result.state, operationErr
+	case <-time.After(operationStopGracePeriod):
+		log.Printf("Abandoned provider call for resource '%s' after it was stopped", resourceID)
+		return nil, operationErr
+	}
+}
+
+// shouldRetry returns how long to wait before retrying a failed attempt, or false if it must not be retried
+func (operationEngine *OperationEngine) shouldRetry(err error, retryPolicy RetryPolicy, retryBackOff backoff.BackOff, attempts int) (time.Duration, bool) {
+	if attempts >= retryPolicy.MaxAttempts {
+		return 0, false
+	}
+
+	providerError := ClassifyProviderError(err)
+	if !providerError.Retryable {
+		return 0, false
+	}
+
+	retryAfter := retryBackOff.NextBackOff()
+	if retryAfter == backoff.Stop {
+		return 0, false
+	}
+
+	if providerError.RetryAfter > retryAfter {
+		retryAfter = providerError.RetryAfter
+	}
+
+	return retryAfter, true
+}
+
+func (operationEngine *OperationEngine) operationError(ctx context.Context, op *operation) error {
+	operationEngine.lock.Lock()
+	defer operationEngine.lock.Unlock()
+
+	if op.interrupted {
+		return ErrOperationInterrupted
+	}
+
+	// The context of a synchronous operation is canceled with its request
+	if op.canceled || ctx.Err() == context.Canceled {
+		return ErrOperationCanceled
+	}
+
+	return ErrOperationTimeout
+}
+
+func getResourceTimeout(resourceTimeout *schema.ResourceTimeout, operationName string) *time.Duration {
+	if resourceTimeout == nil {
+		return nil
+	}
+
+	var timeout *time.Duration
+	switch operationName {
+	case schema.TimeoutCreate:
+		timeout = resourceTimeout.Create
+	case schema.TimeoutUpdate:
+		timeout = resourceTimeout.Update
+	case schema.TimeoutDelete:
+		timeout = resourceTimeout.Delete
+	}
+
+	if timeout == nil {
+		timeout = resourceTimeout.Default
+	}
+
+	return timeout
+}
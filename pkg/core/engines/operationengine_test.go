@@ -0,0 +1,190 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/entities"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// newFailingProvider returns a provider whose resource fails every read with err
+func newFailingProvider(err error, reads *int) *schema.Provider {
+	return &schema.Provider{
+		ResourcesMap: map[string]*schema.Resource{
+			"test_resource": {
+				Schema: map[string]*schema.Schema{
+					"name": {Type: schema.TypeString, Optional: true},
+				},
+				Read: func(d *schema.ResourceData, meta interface{}) error {
+					*reads++
+					return err
+				},
+			},
+		},
+	}
+}
+
+func TestRefreshStopsRetryingAtTheDeadline(t *testing.T) {
+	reads := 0
+	provider := newFailingProvider(errors.New("read tcp: connection reset by peer"), &reads)
+	operationEngine := NewOperationEngine(time.Minute, nil, map[string]RetryPolicy{
+		"test": {MaxAttempts: 10, InitialInterval: 200 * time.Millisecond, MaxInterval: 200 * time.Millisecond},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := operationEngine.Refresh(ctx, "test", provider, &terraform.InstanceInfo{Type: "test_resource"}, &terraform.InstanceState{ID: "id"})
+	if err == nil {
+		t.Fatalf("expected the refresh to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the refresh to stop retrying at the deadline, it took %s", elapsed)
+	}
+	if reads < 1 || reads >= 10 {
+		t.Fatalf("expected the retries to be bounded by the deadline rather than the attempts, actual %d reads", reads)
+	}
+}
+
+func TestRefreshStopsRetryingWhenCanceled(t *testing.T) {
+	reads := 0
+	provider := newFailingProvider(errors.New("read tcp: connection reset by peer"), &reads)
+	operationEngine := NewOperationEngine(time.Minute, nil, map[string]RetryPolicy{
+		"test": {MaxAttempts: 10, InitialInterval: time.Minute, MaxInterval: time.Minute},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := operationEngine.Refresh(ctx, "test", provider, &terraform.InstanceInfo{Type: "test_resource"}, &terraform.InstanceState{ID: "id"})
+	if err == nil {
+		t.Fatalf("expected the refresh to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("expected the refresh to stop when canceled, it took %s", elapsed)
+	}
+	if reads != 1 {
+		t.Fatalf("expected 1 read, actual %d", reads)
+	}
+}
+
+func TestRefreshDoesNotRetryPermanentErrors(t *testing.T) {
+	reads := 0
+	provider := newFailingProvider(errors.New("invalid value for name"), &reads)
+	operationEngine := NewOperationEngine(time.Minute, nil, nil)
+
+	_, err := operationEngine.Refresh(context.Background(), "test", provider, &terraform.InstanceInfo{Type: "test_resource"}, &terraform.InstanceState{ID: "id"})
+	if err == nil {
+		t.Fatalf("expected the refresh to fail")
+	}
+	if reads != 1 {
+		t.Fatalf("expected 1 read, actual %d", reads)
+	}
+}
+
+func TestDrainWaitsForTrackedOperations(t *testing.T) {
+	operationEngine := NewOperationEngine(time.Minute, nil, nil)
+
+	done := operationEngine.Track()
+	time.AfterFunc(100*time.Millisecond, done)
+
+	start := time.Now()
+	if !operationEngine.Drain(10 * time.Second) {
+		t.Fatalf("expected the drain to complete once the tracked operation is done")
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("expected the drain to wait for the tracked operation, it returned after %s", elapsed)
+	}
+}
+
+// newBlockingProvider returns a provider whose resource creation runs until the provider is stopped
+func newBlockingProvider() *schema.Provider {
+	provider := &schema.Provider{}
+	provider.ResourcesMap = map[string]*schema.Resource{
+		"test_resource": {
+			Schema: map[string]*schema.Schema{
+				"name": {Type: schema.TypeString, Optional: true},
+			},
+			Create: func(d *schema.ResourceData, meta interface{}) error {
+				<-provider.StopContext().Done()
+				return errors.New("stopped")
+			},
+			Read:   func(d *schema.ResourceData, meta interface{}) error { return nil },
+			Delete: func(d *schema.ResourceData, meta interface{}) error { return nil },
+		},
+	}
+	return provider
+}
+
+func newCreateRequest(provider *schema.Provider) *ApplyRequest {
+	return &ApplyRequest{
+		ResourceID:   "resource",
+		ProviderType: "test",
+		Provider:     provider,
+		Info:         &terraform.InstanceInfo{Type: "test_resource"},
+		Diff: &terraform.InstanceDiff{Attributes: map[string]*terraform.ResourceAttrDiff{
+			"name": {New: "name"},
+		}},
+	}
+}
+
+func TestApplyIsCanceledByTheCancelRequestOfItsLock(t *testing.T) {
+	operationEngine := NewOperationEngine(time.Minute, nil, nil)
+
+	// The cancellation was requested on another replica and seen when the lock was renewed
+	applyRequest := newCreateRequest(newBlockingProvider())
+	applyRequest.Lock = &Lock{lease: &entities.LeasePackage{CancelRequested: true}}
+
+	_, err := operationEngine.Apply(context.Background(), applyRequest)
+	if err != ErrOperationCanceled {
+		t.Fatalf("expected %s, actual %v", ErrOperationCanceled, err)
+	}
+}
+
+func TestSynchronousApplyStopsWhenItsRequestIsCanceled(t *testing.T) {
+	operationEngine := NewOperationEngine(time.Minute, nil, nil)
+
+	testCases := []struct {
+		synchronous bool
+		stopped     bool
+	}{
+		{true, true},
+		{false, false},
+	}
+
+	for _, testCase := range testCases {
+		applyRequest := newCreateRequest(newBlockingProvider())
+		applyRequest.Synchronous = testCase.synchronous
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		done := make(chan struct{})
+		go func() {
+			operationEngine.Apply(ctx, applyRequest)
+			close(done)
+		}()
+
+		stopped := false
+		select {
+		case <-done:
+			stopped = true
+		case <-time.After(time.Second):
+			operationEngine.Cancel(applyRequest.ResourceID)
+			<-done
+		}
+		if stopped != testCase.stopped {
+			t.Fatalf("expected stopped %v when the request of a synchronous %v apply is canceled, actual %v", testCase.stopped, testCase.synchronous, stopped)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MergeSettings applies a JSON merge patch (RFC 7386) to settings, a null value removes a setting
+// and objects are merged recursively, any other value replaces the setting
+func MergeSettings(settings []byte, patch interface{}) ([]byte, error) {
+	target := map[string]interface{}{}
+	if err := unmarshalSettings(settings, &target); err != nil {
+		return nil, fmt.Errorf("Failed to parse settings: %s", err)
+	}
+
+	// Read the patch back the way the settings are read, so that numbers are kept as written
+	rawPatch, err := json.Marshal(patch)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to serialize settings patch: %s", err)
+	}
+	var typedPatch interface{}
+	if err := unmarshalSettings(rawPatch, &typedPatch); err != nil {
+		return nil, fmt.Errorf("Failed to parse settings patch: %s", err)
+	}
+
+	return json.Marshal(mergePatch(target, typedPatch))
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+
+	return targetObject
+}
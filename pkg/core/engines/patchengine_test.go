@@ -0,0 +1,80 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMergeSettings(t *testing.T) {
+	testCases := []struct {
+		settings         string
+		patch            string
+		expectedSettings string
+	}{
+		{
+			`{"name":"www","ttl":300}`,
+			`{"ttl":600}`,
+			`{"name":"www","ttl":600}`,
+		},
+		{
+			// A null value removes a setting
+			`{"name":"www","proxied":true}`,
+			`{"proxied":null}`,
+			`{"name":"www"}`,
+		},
+		{
+			// Objects are merged recursively
+			`{"metadata":{"labels":{"app":"web","tier":"front"},"name":"web"}}`,
+			`{"metadata":{"labels":{"tier":null,"version":"2"}}}`,
+			`{"metadata":{"labels":{"app":"web","version":"2"},"name":"web"}}`,
+		},
+		{
+			// Lists are replaced rather than merged
+			`{"tags":["a","b"]}`,
+			`{"tags":["c"]}`,
+			`{"tags":["c"]}`,
+		},
+		{
+			// An object replaces a value which is not an object
+			`{"spec":"none"}`,
+			`{"spec":{"replicas":2}}`,
+			`{"spec":{"replicas":2}}`,
+		},
+		{
+			// Numbers are kept as written
+			`{"id":12345678901234567890}`,
+			`{"port":8080}`,
+			`{"id":12345678901234567890,"port":8080}`,
+		},
+		{
+			``,
+			`{"name":"www"}`,
+			`{"name":"www"}`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		var patch interface{}
+		if err := json.Unmarshal([]byte(testCase.patch), &patch); err != nil {
+			t.Fatalf("expected the patch %s to be JSON, actual %s", testCase.patch, err)
+		}
+
+		mergedSettings, err := MergeSettings([]byte(testCase.settings), patch)
+		if err != nil {
+			t.Fatalf("expected %s to be merged into %s, actual %s", testCase.patch, testCase.settings, err)
+		}
+		if string(mergedSettings) != testCase.expectedSettings {
+			t.Fatalf("expected %s merged into %s to equal %s, actual %s", testCase.patch, testCase.settings, testCase.expectedSettings, mergedSettings)
+		}
+	}
+}
+
+func TestMergeSettingsRejectsInvalidSettings(t *testing.T) {
+	if _, err := MergeSettings([]byte(`{"name":`), map[string]interface{}{"name": "www"}); err == nil {
+		t.Fatalf("expected invalid settings to be rejected")
+	}
+}
@@ -9,20 +9,61 @@ import (
 	"TFRP/datadog"
 	"TFRP/kubernetes"
 	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/tracing"
+	"context"
 
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
 )
 
-// GetProvider returns the provider
+// SupportedProviderTypes are the provider types which can be registered
+var SupportedProviderTypes = []string{consts.KubernetesProvider, consts.DatadogProvider, consts.CloudflareProvider}
+
+// GetProvider returns the provider, stripped of the env defaults if providers are tenant isolated
 func GetProvider(providerType string) *schema.Provider {
+	var provider *schema.Provider
 	switch providerType {
 	case consts.KubernetesProvider:
-		return kubernetes.Provider().(*schema.Provider)
+		provider = kubernetes.Provider().(*schema.Provider)
 	case consts.DatadogProvider:
-		return datadog.Provider().(*schema.Provider)
+		provider = datadog.Provider().(*schema.Provider)
 	case consts.CloudflareProvider:
-		return cloudflare.Provider().(*schema.Provider)
+		provider = cloudflare.Provider().(*schema.Provider)
+	default:
+		return nil
+	}
+
+	if TenantIsolatedProviders {
+		return isolateProvider(providerType, provider)
 	}
 
-	return nil
+	return provider
+}
+
+// ConfigureProvider configures a provider in a span of the trace of ctx
+func ConfigureProvider(ctx context.Context, providerType string, provider *schema.Provider, resourceConfig *terraform.ResourceConfig) error {
+	_, span := StartProviderSpan(ctx, "Configure", providerType)
+	defer span.End()
+
+	err := provider.Configure(resourceConfig)
+	span.RecordError(err)
+	return err
+}
+
+// DiffResource calls provider diff in a span of the trace of ctx
+func DiffResource(ctx context.Context, providerType string, provider *schema.Provider, info *terraform.InstanceInfo, state *terraform.InstanceState, resourceConfig *terraform.ResourceConfig) (*terraform.InstanceDiff, error) {
+	_, span := StartProviderSpan(ctx, "Diff", providerType)
+	span.SetAttribute("terraform.resource_type", info.Type)
+	defer span.End()
+
+	diff, err := provider.Diff(info, state, resourceConfig)
+	span.RecordError(err)
+	return diff, err
+}
+
+// StartProviderSpan starts the span of a provider lifecycle call, e.g. provider.Apply
+func StartProviderSpan(ctx context.Context, call string, providerType string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, "provider."+call, tracing.SpanKindClient)
+	span.SetAttribute("terraform.provider", providerType)
+	return ctx, span
 }
@@ -0,0 +1,272 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/apierror"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ProviderErrorClass is the kind of a provider failure
+type ProviderErrorClass string
+
+const (
+	// ProviderErrorClassCredentials means the provider credentials are invalid or lack permissions
+	ProviderErrorClassCredentials ProviderErrorClass = "Credentials"
+	// ProviderErrorClassThrottled means the upstream API throttled the request
+	ProviderErrorClassThrottled ProviderErrorClass = "Throttled"
+	// ProviderErrorClassNotFound means the upstream object does not exist
+	ProviderErrorClassNotFound ProviderErrorClass = "NotFound"
+	// ProviderErrorClassConflict means the upstream object already exists or was changed concurrently
+	ProviderErrorClassConflict ProviderErrorClass = "Conflict"
+	// ProviderErrorClassQuota means an upstream quota or limit was exceeded
+	ProviderErrorClassQuota ProviderErrorClass = "Quota"
+	// ProviderErrorClassTimeout means the upstream call timed out
+	ProviderErrorClassTimeout ProviderErrorClass = "Timeout"
+	// ProviderErrorClassUpstream means the upstream API failed or could not be reached
+	ProviderErrorClassUpstream ProviderErrorClass = "Upstream"
+	// ProviderErrorClassInvalid means the upstream API rejected the settings
+	ProviderErrorClassInvalid ProviderErrorClass = "Invalid"
+)
+
+// ProviderError is a provider failure classified onto an ARM error
+type ProviderError struct {
+	Class      ProviderErrorClass
+	HTTPStatus int
+	Category   apierror.ErrorCategory
+	Code       apierror.ErrorCode
+	Retryable  bool
+	RetryAfter time.Duration
+	Err        error
+}
+
+// httpStatusRegexps match the http status code cloudflare-go and go-datadog-api put in their error messages
+var httpStatusRegexps = []*regexp.Regexp{
+	regexp.MustCompile(`HTTP status (\d{3})`),
+	regexp.MustCompile(`API error (\d{3})`),
+	regexp.MustCompile(`status code:? (\d{3})`),
+}
+
+// ClassifyProviderError maps an error returned by a provider onto an ARM error
+func ClassifyProviderError(err error) *ProviderError {
+	providerError := &ProviderError{Err: err}
+	if err == nil {
+		return providerError
+	}
+
+	switch err {
+	case ErrOperationTimeout:
+		providerError.classify(ProviderErrorClassTimeout)
+		providerError.Code = apierror.ErrorCode(apierror.OperationTimeout)
+		providerError.Retryable = false
+		return providerError
+	case ErrOperationCanceled:
+		providerError.classify(ProviderErrorClassConflict)
+		providerError.Code = apierror.OperationCanceled
+		return providerError
+	case ErrOperationInterrupted:
+		providerError.classify(ProviderErrorClassUpstream)
+		providerError.HTTPStatus = http.StatusServiceUnavailable
+		providerError.Code = apierror.OperationPreempted
+		providerError.Retryable = false
+		return providerError
+	}
+
+	cause := errors.Cause(err)
+	if multiErr, ok := cause.(*multierror.Error); ok && len(multiErr.Errors) > 0 {
+		cause = errors.Cause(multiErr.Errors[0])
+	}
+
+	if k8sStatus, ok := cause.(k8serrors.APIStatus); ok {
+		if seconds, ok := k8serrors.SuggestsClientDelay(cause); ok {
+			providerError.RetryAfter = time.Duration(seconds) * time.Second
+		}
+
+		switch {
+		case k8serrors.IsTimeout(cause), k8serrors.IsServerTimeout(cause):
+			return providerError.classify(ProviderErrorClassTimeout)
+		case k8serrors.IsAlreadyExists(cause), k8serrors.IsConflict(cause):
+			return providerError.classify(ProviderErrorClassConflict)
+		case k8serrors.IsInvalid(cause), k8serrors.IsBadRequest(cause):
+			return providerError.classify(ProviderErrorClassInvalid)
+		}
+
+		return providerError.classifyHTTPStatus(int(k8sStatus.Status().Code), err.Error())
+	}
+
+	if detailedError, ok := cause.(*autorest.DetailedError); ok {
+		cause = *detailedError
+	}
+
+	if detailedError, ok := cause.(autorest.DetailedError); ok {
+		if statusCode, ok := detailedError.StatusCode.(int); ok && statusCode != 0 {
+			return providerError.classifyHTTPStatus(statusCode, err.Error())
+		}
+	}
+
+	if cause == io.EOF || cause == io.ErrUnexpectedEOF {
+		return providerError.classify(ProviderErrorClassUpstream)
+	}
+
+	if cause == context.DeadlineExceeded {
+		return providerError.classify(ProviderErrorClassTimeout)
+	}
+
+	if netError, ok := cause.(net.Error); ok {
+		if netError.Timeout() {
+			return providerError.classify(ProviderErrorClassTimeout)
+		}
+		return providerError.classify(ProviderErrorClassUpstream)
+	}
+
+	message := err.Error()
+	for _, httpStatusRegexp := range httpStatusRegexps {
+		if match := httpStatusRegexp.FindStringSubmatch(message); match != nil {
+			statusCode, _ := strconv.Atoi(match[1])
+			return providerError.classifyHTTPStatus(statusCode, message)
+		}
+	}
+
+	return providerError.classifyMessage(message)
+}
+
+// ToErrorResponse returns the ErrorResponse of a synchronous request failure, a retryable failure carries
+// the back off the upstream API asked for
+func (providerError *ProviderError) ToErrorResponse(message string) *apierror.ErrorResponse {
+	errorResponse := apierror.New(providerError.Category, providerError.Code, message)
+	if providerError.Retryable {
+		errorResponse.RetryAfter = providerError.RetryAfter
+	}
+
+	return errorResponse
+}
+
+// AsyncErrorCode returns the error code recorded on a failed async operation
+func (providerError *ProviderError) AsyncErrorCode() apierror.ErrorCode {
+	switch providerError.Err {
+	case ErrOperationTimeout, ErrOperationCanceled, ErrOperationInterrupted:
+		return providerError.Code
+	}
+
+	if providerError.Category == apierror.InternalError {
+		return apierror.ProvisioningInternalError
+	}
+
+	return apierror.ProvisioningFailed
+}
+
+func (providerError *ProviderError) classifyHTTPStatus(statusCode int, message string) *ProviderError {
+	switch {
+	case statusCode == http.StatusUnauthorized, statusCode == http.StatusForbidden:
+		if isQuotaMessage(message) {
+			return providerError.classify(ProviderErrorClassQuota)
+		}
+		return providerError.classify(ProviderErrorClassCredentials)
+	case statusCode == http.StatusNotFound:
+		return providerError.classify(ProviderErrorClassNotFound)
+	case statusCode == http.StatusConflict:
+		return providerError.classify(ProviderErrorClassConflict)
+	case statusCode == http.StatusTooManyRequests:
+		return providerError.classify(ProviderErrorClassThrottled)
+	case statusCode == http.StatusRequestTimeout, statusCode == http.StatusGatewayTimeout, statusCode == 524:
+		return providerError.classify(ProviderErrorClassTimeout)
+	case statusCode >= http.StatusInternalServerError:
+		return providerError.classify(ProviderErrorClassUpstream)
+	}
+
+	return providerError.classifyMessage(message)
+}
+
+func (providerError *ProviderError) classifyMessage(message string) *ProviderError {
+	message = strings.ToLower(message)
+	switch {
+	case containsAny(message, "too many requests", "rate limit", "throttl"):
+		return providerError.classify(ProviderErrorClassThrottled)
+	case isQuotaMessage(message):
+		return providerError.classify(ProviderErrorClassQuota)
+	case containsAny(message, "unauthorized", "forbidden", "invalid credentials", "authentication", "permission denied", "insufficient permissions"):
+		return providerError.classify(ProviderErrorClassCredentials)
+	case containsAny(message, "timeout", "timed out", "deadline exceeded"):
+		return providerError.classify(ProviderErrorClassTimeout)
+	case containsAny(message, "connection reset", "connection refused", "broken pipe", "no such host", "service unavailable", "service failure", "bad gateway", "unexpected eof"):
+		return providerError.classify(ProviderErrorClassUpstream)
+	case containsAny(message, "already exists", "conflict"):
+		return providerError.classify(ProviderErrorClassConflict)
+	case containsAny(message, "not found", "does not exist"):
+		return providerError.classify(ProviderErrorClassNotFound)
+	}
+
+	return providerError.classify(ProviderErrorClassInvalid)
+}
+
+func (providerError *ProviderError) classify(class ProviderErrorClass) *ProviderError {
+	providerError.Class = class
+	switch class {
+	case ProviderErrorClassCredentials:
+		// ARM treats a 401 of the RP as a failure to authenticate ARM itself, the settings of the caller are invalid instead
+		providerError.HTTPStatus = http.StatusBadRequest
+		providerError.Category = apierror.ClientError
+		providerError.Code = apierror.ProviderCredentialsInvalid
+	case ProviderErrorClassThrottled:
+		providerError.HTTPStatus = http.StatusTooManyRequests
+		providerError.Category = apierror.InternalError
+		providerError.Code = apierror.TooManyRequests
+		providerError.Retryable = true
+	case ProviderErrorClassNotFound:
+		providerError.HTTPStatus = http.StatusNotFound
+		providerError.Category = apierror.ClientError
+		providerError.Code = apierror.NotFound
+	case ProviderErrorClassConflict:
+		providerError.HTTPStatus = http.StatusConflict
+		providerError.Category = apierror.ClientError
+		providerError.Code = apierror.Conflict
+	case ProviderErrorClassQuota:
+		providerError.HTTPStatus = http.StatusConflict
+		providerError.Category = apierror.ClientError
+		providerError.Code = apierror.QuotaExceeded
+	case ProviderErrorClassTimeout:
+		providerError.HTTPStatus = http.StatusGatewayTimeout
+		providerError.Category = apierror.InternalError
+		providerError.Code = apierror.UpstreamTimeout
+		providerError.Retryable = true
+	case ProviderErrorClassUpstream:
+		providerError.HTTPStatus = http.StatusBadGateway
+		providerError.Category = apierror.InternalError
+		providerError.Code = apierror.UpstreamServiceError
+		providerError.Retryable = true
+	default:
+		providerError.HTTPStatus = http.StatusBadRequest
+		providerError.Category = apierror.ClientError
+		providerError.Code = apierror.BadRequest
+	}
+
+	return providerError
+}
+
+func isQuotaMessage(message string) bool {
+	return containsAny(strings.ToLower(message), "quota", "limit exceeded", "exceeded limit")
+}
+
+func containsAny(message string, substrings ...string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(message, substring) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,88 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/apierror"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func TestClassifyProviderError(t *testing.T) {
+	testCases := []struct {
+		err                error
+		expectedHTTPStatus int
+		expectedCode       apierror.ErrorCode
+	}{
+		{
+			errors.New("cloudflare: HTTP status 403: Authentication error"),
+			http.StatusBadRequest,
+			apierror.ProviderCredentialsInvalid,
+		},
+		{
+			errors.New("error creating record: Invalid credentials"),
+			http.StatusBadRequest,
+			apierror.ProviderCredentialsInvalid,
+		},
+		{
+			errors.New("API error 403 Forbidden: quota exceeded"),
+			http.StatusConflict,
+			apierror.QuotaExceeded,
+		},
+		{
+			errors.New("API error 429 Too Many Requests"),
+			http.StatusTooManyRequests,
+			apierror.TooManyRequests,
+		},
+		{
+			errors.New("dial tcp: lookup api.example.com: no such host"),
+			http.StatusBadGateway,
+			apierror.UpstreamServiceError,
+		},
+		{
+			errors.New("record already exists"),
+			http.StatusConflict,
+			apierror.Conflict,
+		},
+		{
+			errors.New("invalid value for ttl"),
+			http.StatusBadRequest,
+			apierror.BadRequest,
+		},
+		{
+			ErrOperationTimeout,
+			http.StatusGatewayTimeout,
+			apierror.ErrorCode(apierror.OperationTimeout),
+		},
+	}
+
+	for _, testCase := range testCases {
+		providerError := ClassifyProviderError(testCase.err)
+		if providerError.HTTPStatus != testCase.expectedHTTPStatus || providerError.Code != testCase.expectedCode {
+			t.Fatalf("expected '%s' to be classified as %d %s, actual %d %s", testCase.err, testCase.expectedHTTPStatus, testCase.expectedCode, providerError.HTTPStatus, providerError.Code)
+		}
+	}
+}
+
+func TestProviderErrorRetryAfter(t *testing.T) {
+	providerError := ClassifyProviderError(k8serrors.NewTimeoutError("slow down", 7))
+	if !providerError.Retryable {
+		t.Fatalf("expected a timeout to be retryable")
+	}
+
+	errorResponse := providerError.ToErrorResponse("timed out")
+	if errorResponse.RetryAfter != 7*time.Second {
+		t.Fatalf("expected the error response to carry a back off of 7s, actual %s", errorResponse.RetryAfter)
+	}
+
+	errorResponse = ClassifyProviderError(errors.New("invalid value for ttl")).ToErrorResponse("invalid")
+	if errorResponse.RetryAfter != 0 {
+		t.Fatalf("expected a non retryable error response to carry no back off, actual %s", errorResponse.RetryAfter)
+	}
+}
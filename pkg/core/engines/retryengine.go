@@ -0,0 +1,78 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/consts"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// RetryPolicy is the retry policy of transient provider errors
+type RetryPolicy struct {
+	MaxAttempts     int
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy of providers without a specific one
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:     3,
+	InitialInterval: 1 * time.Second,
+	MaxInterval:     30 * time.Second,
+}
+
+// DefaultProviderRetryPolicies are the retry policies of the supported providers
+var DefaultProviderRetryPolicies = map[string]RetryPolicy{
+	consts.KubernetesProvider: {MaxAttempts: 3, InitialInterval: 1 * time.Second, MaxInterval: 15 * time.Second},
+	// Cloudflare allows 4 requests per second by default, so back off longer
+	consts.CloudflareProvider: {MaxAttempts: 5, InitialInterval: 2 * time.Second, MaxInterval: 60 * time.Second},
+	consts.DatadogProvider:    {MaxAttempts: 5, InitialInterval: 2 * time.Second, MaxInterval: 60 * time.Second},
+}
+
+// ParseProviderRetryPolicies parses retry policies in the form {providerType}={maxAttempts}
+// and overrides the max attempts of the default provider retry policies
+func ParseProviderRetryPolicies(values []string) (map[string]RetryPolicy, error) {
+	providerRetryPolicies := make(map[string]RetryPolicy)
+	for providerType, retryPolicy := range DefaultProviderRetryPolicies {
+		providerRetryPolicies[providerType] = retryPolicy
+	}
+
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("The retry policy '%s' is not in the form {providerType}={maxAttempts}", value)
+		}
+
+		maxAttempts, err := strconv.Atoi(parts[1])
+		if err != nil || maxAttempts < 1 {
+			return nil, fmt.Errorf("The retry policy '%s' has an invalid max attempts", value)
+		}
+
+		retryPolicy, ok := providerRetryPolicies[parts[0]]
+		if !ok {
+			retryPolicy = DefaultRetryPolicy
+		}
+		retryPolicy.MaxAttempts = maxAttempts
+		providerRetryPolicies[parts[0]] = retryPolicy
+	}
+
+	return providerRetryPolicies, nil
+}
+
+// NewBackOff returns the exponential back off with jitter of a retry policy
+func (retryPolicy RetryPolicy) NewBackOff() backoff.BackOff {
+	exponentialBackOff := backoff.NewExponentialBackOff()
+	exponentialBackOff.InitialInterval = retryPolicy.InitialInterval
+	exponentialBackOff.MaxInterval = retryPolicy.MaxInterval
+	// The number of attempts and the operation timeout bound the retries
+	exponentialBackOff.MaxElapsedTime = 0
+	exponentialBackOff.Reset()
+	return exponentialBackOff
+}
@@ -6,11 +6,20 @@ package engines
 
 import (
 	"TFRP/pkg/core/consts"
+	"bytes"
 	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/arm/keyvault"
 	"github.com/Azure/go-autorest/autorest"
@@ -18,63 +27,383 @@ import (
 	"github.com/Azure/go-autorest/autorest/azure"
 )
 
+const (
+	// SecretEngineCredentialClientSecret authenticates as a service principal with a client secret
+	SecretEngineCredentialClientSecret = "clientsecret"
+	// SecretEngineCredentialCertificate authenticates as a service principal with a certificate
+	SecretEngineCredentialCertificate = "certificate"
+	// SecretEngineCredentialMSI authenticates as the managed identity of the host through IMDS
+	SecretEngineCredentialMSI = "msi"
+
+	// DefaultIMDSTokenEndpoint is the token endpoint of the instance metadata service
+	DefaultIMDSTokenEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+	// imdsAPIVersion is the api version of the instance metadata service token endpoint
+	imdsAPIVersion = "2018-02-01"
+	// tokenRefreshWithin is how long before they expire tokens are refreshed
+	tokenRefreshWithin = 5 * time.Minute
+	// secretRequestTimeout bounds the token and key vault requests of a secret
+	secretRequestTimeout = 30 * time.Second
+)
+
+// SecretEngineOptions configures how the secret engine authenticates with key vault
+type SecretEngineOptions struct {
+	// CredentialSource is one of SecretEngineCredentialClientSecret, SecretEngineCredentialCertificate or SecretEngineCredentialMSI
+	CredentialSource string
+	// ActiveDirectoryEndpoint is the authority of service principals, defaults to the public cloud
+	ActiveDirectoryEndpoint string
+	// KeyVaultResource is the resource tokens are requested for, defaults to consts.KeyVaultResource
+	KeyVaultResource string
+
+	// TenantIDPath, ClientIDPath, ClientSecretPath and CertificatePath are the files of the service principal credentials,
+	// the certificate file contains the PEM certificate and its RSA private key
+	TenantIDPath     string
+	ClientIDPath     string
+	ClientSecretPath string
+	CertificatePath  string
+
+	// IMDSEndpoint is the token endpoint of the instance metadata service, defaults to DefaultIMDSTokenEndpoint
+	IMDSEndpoint string
+	// MSIClientID selects a user assigned identity, the system assigned identity is used if empty
+	MSIClientID string
+}
+
 // SecretEngine is the secret engine
 type SecretEngine struct {
 	TenantID     string
 	ClientID     string
 	ClientSecret string
+	Options      SecretEngineOptions
+
+	lock        sync.Mutex
+	certificate *x509.Certificate
+	privateKey  *rsa.PrivateKey
+	credentials []byte
+	tokens      map[string]adal.OAuthTokenProvider
+}
+
+// imdsToken is a token of the managed identity of the host, refreshed through the instance metadata service
+type imdsToken struct {
+	endpoint string
+	resource string
+	clientID string
+	client   *http.Client
+
+	lock  sync.RWMutex
+	token adal.Token
+}
+
+// NewSecretEngine creates a secret engine and loads its credentials
+func NewSecretEngine(options SecretEngineOptions) (*SecretEngine, error) {
+	if len(options.CredentialSource) == 0 {
+		options.CredentialSource = SecretEngineCredentialClientSecret
+	}
+	if len(options.ActiveDirectoryEndpoint) == 0 {
+		options.ActiveDirectoryEndpoint = azure.PublicCloud.ActiveDirectoryEndpoint
+	}
+	if len(options.KeyVaultResource) == 0 {
+		options.KeyVaultResource = consts.KeyVaultResource
+	}
+	if len(options.TenantIDPath) == 0 {
+		options.TenantIDPath = consts.ServicePrincipalTenantIDPath
+	}
+	if len(options.ClientIDPath) == 0 {
+		options.ClientIDPath = consts.ServicePrincipalClientIDPath
+	}
+	if len(options.ClientSecretPath) == 0 {
+		options.ClientSecretPath = consts.ServicePrincipalClientSecretPath
+	}
+	if len(options.CertificatePath) == 0 {
+		options.CertificatePath = consts.ServicePrincipalCertificatePath
+	}
+	if len(options.IMDSEndpoint) == 0 {
+		options.IMDSEndpoint = DefaultIMDSTokenEndpoint
+	}
+
+	secretEngine := new(SecretEngine)
+	secretEngine.Options = options
+	secretEngine.tokens = make(map[string]adal.OAuthTokenProvider)
+
+	if _, err := secretEngine.Reload(); err != nil {
+		return nil, err
+	}
+
+	return secretEngine, nil
+}
+
+// Reload reads the credential files again, the cached tokens are dropped if the credentials were rotated
+func (secretEngine *SecretEngine) Reload() (bool, error) {
+	var files []string
+	switch secretEngine.Options.CredentialSource {
+	case SecretEngineCredentialClientSecret:
+		files = []string{secretEngine.Options.TenantIDPath, secretEngine.Options.ClientIDPath, secretEngine.Options.ClientSecretPath}
+	case SecretEngineCredentialCertificate:
+		files = []string{secretEngine.Options.TenantIDPath, secretEngine.Options.ClientIDPath, secretEngine.Options.CertificatePath}
+	case SecretEngineCredentialMSI:
+		return false, nil
+	default:
+		return false, fmt.Errorf("The credential source %s is not supported", secretEngine.Options.CredentialSource)
+	}
+
+	contents := make([][]byte, 0, len(files))
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return false, fmt.Errorf("Failed to read credential file %s: %s", file, err)
+		}
+		contents = append(contents, bytes.TrimSpace(content))
+	}
+
+	credentials := bytes.Join(contents, []byte{0})
+
+	secretEngine.lock.Lock()
+	defer secretEngine.lock.Unlock()
+
+	if bytes.Equal(credentials, secretEngine.credentials) {
+		return false, nil
+	}
+
+	tenantID, clientID := string(contents[0]), string(contents[1])
+	clientSecret := ""
+	var certificate *x509.Certificate
+	var privateKey *rsa.PrivateKey
+	if secretEngine.Options.CredentialSource == SecretEngineCredentialCertificate {
+		var err error
+		certificate, privateKey, err = parseCertificate(contents[2])
+		if err != nil {
+			return false, fmt.Errorf("Failed to parse certificate %s: %s", secretEngine.Options.CertificatePath, err)
+		}
+	} else {
+		clientSecret = string(contents[2])
+	}
+
+	secretEngine.TenantID = tenantID
+	secretEngine.ClientID = clientID
+	secretEngine.ClientSecret = clientSecret
+	secretEngine.certificate = certificate
+	secretEngine.privateKey = privateKey
+	secretEngine.credentials = credentials
+	secretEngine.tokens = make(map[string]adal.OAuthTokenProvider)
+
+	return true, nil
+}
+
+// Watch reloads the credential files every interval until stop is closed, so that rotated credentials are picked up
+func (secretEngine *SecretEngine) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		reloaded, err := secretEngine.Reload()
+		if err != nil {
+			// Keep the loaded credentials, the files may be in the middle of a rotation
+			log.Printf("Failed to reload credentials: %s", err)
+			continue
+		}
+		if reloaded {
+			log.Printf("Reloaded rotated credentials")
+		}
+	}
+}
+
+// GetToken returns the cached token of a resource, the token is refreshed before it expires when it is used
+func (secretEngine *SecretEngine) GetToken(resource string) (adal.OAuthTokenProvider, error) {
+	secretEngine.lock.Lock()
+	defer secretEngine.lock.Unlock()
+
+	if token, ok := secretEngine.tokens[resource]; ok {
+		return token, nil
+	}
+
+	var token adal.OAuthTokenProvider
+	switch secretEngine.Options.CredentialSource {
+	case SecretEngineCredentialMSI:
+		token = &imdsToken{
+			endpoint: secretEngine.Options.IMDSEndpoint,
+			resource: resource,
+			clientID: secretEngine.Options.MSIClientID,
+			client:   &http.Client{Timeout: secretRequestTimeout},
+		}
+	default:
+		oauthConfig, err := adal.NewOAuthConfig(secretEngine.Options.ActiveDirectoryEndpoint, secretEngine.TenantID)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create oauth config: %s", err)
+		}
+
+		var spToken *adal.ServicePrincipalToken
+		if secretEngine.Options.CredentialSource == SecretEngineCredentialCertificate {
+			spToken, err = adal.NewServicePrincipalTokenFromCertificate(*oauthConfig, secretEngine.ClientID, secretEngine.certificate, secretEngine.privateKey, resource)
+		} else {
+			spToken, err = adal.NewServicePrincipalToken(*oauthConfig, secretEngine.ClientID, secretEngine.ClientSecret, resource)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create token: %s", err)
+		}
+		spToken.SetRefreshWithin(tokenRefreshWithin)
+		spToken.SetSender(&http.Client{Timeout: secretRequestTimeout})
+		token = spToken
+	}
+
+	secretEngine.tokens[resource] = token
+	return token, nil
 }
 
-// GetSecretEngine creates a secret engine
-func GetSecretEngine() (secretEngine *SecretEngine) {
-	tenantID, err := ioutil.ReadFile(consts.ServicePrincipalTenantIDPath)
+// Probe ensures the key vault token is fresh, it fails once the service can no longer authenticate with key vault
+func (secretEngine *SecretEngine) Probe() error {
+	token, err := secretEngine.GetToken(secretEngine.Options.KeyVaultResource)
 	if err != nil {
-		log.Fatal("Failed to get tenant id: ", err)
+		return err
 	}
 
-	clientID, err := ioutil.ReadFile(consts.ServicePrincipalClientIDPath)
+	refresher, ok := token.(adal.Refresher)
+	if !ok {
+		return nil
+	}
+	if err := refresher.EnsureFresh(); err != nil {
+		return fmt.Errorf("Failed to refresh the key vault token: %s", err)
+	}
+	return nil
+}
+
+// GetSecret returns a secret from key vault, the request is bound to ctx and secretRequestTimeout
+func (secretEngine *SecretEngine) GetSecret(ctx context.Context, vaultBaseURI, secretName, secretVersion string) (string, error) {
+	token, err := secretEngine.GetToken(secretEngine.Options.KeyVaultResource)
 	if err != nil {
-		log.Fatal("Failed to get client id: ", err)
+		return "", err
 	}
 
-	clientSecret, err := ioutil.ReadFile(consts.ServicePrincipalClientSecretPath)
+	vaultsClient := keyvault.NewWithoutDefaults()
+	vaultsClient.Authorizer = autorest.NewBearerAuthorizer(token)
+	// The retries of the client back off regardless of ctx, a request is retried by its caller within its own deadline
+	vaultsClient.RetryAttempts = 0
+	vaultsClient.RetryDuration = 0
+
+	ctx, cancel := context.WithTimeout(ctx, secretRequestTimeout)
+	defer cancel()
+
+	vault, err := vaultsClient.GetSecret(ctx, vaultBaseURI, secretName, secretVersion)
 	if err != nil {
-		log.Fatal("Failed to get client secret: ", err)
+		return "", err
+	}
+	if vault.Value == nil {
+		return "", fmt.Errorf("The secret %s has no value", secretName)
 	}
+	return *vault.Value, nil
+}
 
-	secretEngine = new(SecretEngine)
-	secretEngine.TenantID = string(tenantID)
-	secretEngine.ClientID = string(clientID)
-	secretEngine.ClientSecret = string(clientSecret)
+// OAuthToken returns the access token
+func (token *imdsToken) OAuthToken() string {
+	token.lock.RLock()
+	defer token.lock.RUnlock()
 
-	return secretEngine
+	return token.token.AccessToken
 }
 
-// GetSecretFromKeyVault returns a secret
-func (secretEngine *SecretEngine) GetSecretFromKeyVault(vaultBaseURI, secretName, secretVersion string) string {
-	fmt.Printf("uri %s", vaultBaseURI)
-	fmt.Printf("name %s", secretName)
-	fmt.Printf("version %s", secretVersion)
+// EnsureFresh refreshes the token if it expires within the refresh window
+func (token *imdsToken) EnsureFresh() error {
+	token.lock.RLock()
+	isFresh := !token.token.WillExpireIn(tokenRefreshWithin)
+	token.lock.RUnlock()
 
-	tenantID := secretEngine.TenantID
-	clientID := secretEngine.ClientID
-	clientSecret := secretEngine.ClientSecret
+	if isFresh {
+		return nil
+	}
 
-	oauthConfig, err := adal.NewOAuthConfig(azure.PublicCloud.ActiveDirectoryEndpoint, tenantID)
-	updatedAuthorizeEndpoint, err := url.Parse("https://login.windows.net/" + tenantID + "/oauth2/token")
-	oauthConfig.AuthorizeEndpoint = *updatedAuthorizeEndpoint
-	spToken, err := adal.NewServicePrincipalToken(*oauthConfig, clientID, clientSecret, "https://vault.azure.net")
+	return token.Refresh()
+}
+
+// Refresh requests a new token from the instance metadata service
+func (token *imdsToken) Refresh() error {
+	return token.RefreshExchange(token.resource)
+}
+
+// RefreshExchange requests a new token for a resource from the instance metadata service
+func (token *imdsToken) RefreshExchange(resource string) error {
+	query := url.Values{}
+	query.Set("api-version", imdsAPIVersion)
+	query.Set("resource", resource)
+	if len(token.clientID) > 0 {
+		query.Set("client_id", token.clientID)
+	}
 
+	request, err := http.NewRequest(http.MethodGet, token.endpoint+"?"+query.Encode(), nil)
 	if err != nil {
-		log.Fatal("failed to create token", err)
+		return err
 	}
+	request.Header.Set("Metadata", "true")
 
-	vaultsClient := keyvault.NewWithoutDefaults()
-	vaultsClient.Authorizer = autorest.NewBearerAuthorizer(spToken)
+	response, err := token.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("Failed to request a managed identity token: %s", err)
+	}
+	defer response.Body.Close()
 
-	vault, err := vaultsClient.GetSecret(context.Background(), vaultBaseURI, secretName, secretVersion)
+	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		log.Fatal("Failed to get secret ", err)
+		return fmt.Errorf("Failed to read the managed identity token: %s", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to request a managed identity token: HTTP status %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	refreshedToken := adal.Token{}
+	if err := json.Unmarshal(body, &refreshedToken); err != nil {
+		return fmt.Errorf("Failed to parse the managed identity token: %s", err)
+	}
+
+	token.lock.Lock()
+	defer token.lock.Unlock()
+
+	token.token = refreshedToken
+	return nil
+}
+
+// parseCertificate parses the PEM certificate and the RSA private key of a service principal
+func parseCertificate(content []byte) (*x509.Certificate, *rsa.PrivateKey, error) {
+	var certificate *x509.Certificate
+	var privateKey *rsa.PrivateKey
+	for block, rest := pem.Decode(content); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "CERTIFICATE":
+			if certificate != nil {
+				continue
+			}
+			parsedCertificate, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			certificate = parsedCertificate
+		case "RSA PRIVATE KEY":
+			parsedKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			privateKey = parsedKey
+		case "PRIVATE KEY":
+			parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			rsaKey, ok := parsedKey.(*rsa.PrivateKey)
+			if !ok {
+				return nil, nil, fmt.Errorf("The private key is not a RSA key")
+			}
+			privateKey = rsaKey
+		}
+	}
+
+	if certificate == nil {
+		return nil, nil, fmt.Errorf("No certificate was found")
 	}
-	return *vault.Value
+	if privateKey == nil {
+		return nil, nil, fmt.Errorf("No private key was found")
+	}
+
+	return certificate, privateKey, nil
 }
@@ -0,0 +1,111 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestTokenServer returns a fake instance metadata service issuing the token test-token, or failing with status
+func newTestTokenServer(status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" || len(r.URL.Query().Get("resource")) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		expiresOn := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+		fmt.Fprintf(w, `{"access_token":"test-token","expires_in":"3600","expires_on":"%s","resource":"%s","token_type":"Bearer"}`, expiresOn, r.URL.Query().Get("resource"))
+	}))
+}
+
+func newTestSecretEngine(t *testing.T, tokenEndpoint string) *SecretEngine {
+	secretEngine, err := NewSecretEngine(SecretEngineOptions{
+		CredentialSource: SecretEngineCredentialMSI,
+		IMDSEndpoint:     tokenEndpoint,
+	})
+	if err != nil {
+		t.Fatalf("failed to create the secret engine: %s", err)
+	}
+	return secretEngine
+}
+
+func TestGetSecret(t *testing.T) {
+	tokenServer := newTestTokenServer(http.StatusOK)
+	defer tokenServer.Close()
+
+	vaultServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/secrets/token/v1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"value":"s3cret","id":"https://tenant.vault.azure.net/secrets/token/v1"}`)
+	}))
+	defer vaultServer.Close()
+
+	secretEngine := newTestSecretEngine(t, tokenServer.URL)
+	secret, err := secretEngine.GetSecret(context.Background(), vaultServer.URL, "token", "v1")
+	if err != nil {
+		t.Fatalf("expected the secret to be returned, actual error %s", err)
+	}
+	if secret != "s3cret" {
+		t.Fatalf("expected the secret s3cret, actual %s", secret)
+	}
+}
+
+func TestGetSecretStopsWhenCanceled(t *testing.T) {
+	tokenServer := newTestTokenServer(http.StatusOK)
+	defer tokenServer.Close()
+
+	unblock := make(chan struct{})
+	vaultServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer vaultServer.Close()
+	defer close(unblock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	secretEngine := newTestSecretEngine(t, tokenServer.URL)
+	start := time.Now()
+	_, err := secretEngine.GetSecret(ctx, vaultServer.URL, "token", "")
+	if err == nil {
+		t.Fatalf("expected the secret request to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the secret request to stop with its context, it took %s", elapsed)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	tokenServer := newTestTokenServer(http.StatusOK)
+	defer tokenServer.Close()
+
+	if err := newTestSecretEngine(t, tokenServer.URL).Probe(); err != nil {
+		t.Fatalf("expected the probe to succeed, actual error %s", err)
+	}
+
+	failingTokenServer := newTestTokenServer(http.StatusInternalServerError)
+	defer failingTokenServer.Close()
+
+	if err := newTestSecretEngine(t, failingTokenServer.URL).Probe(); err == nil {
+		t.Fatalf("expected the probe to fail when no token can be refreshed")
+	}
+}
@@ -0,0 +1,245 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/apierror"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SecretReferenceKey is the key of a secret reference in settings,
+	// e.g. {"keyVaultSecretRef": {"vaultUri": "https://myvault.vault.azure.net/", "name": "token", "version": ""}}
+	SecretReferenceKey = "keyVaultSecretRef"
+
+	// fileSecretLatestVersion is the file name of a secret referenced without a version
+	fileSecretLatestVersion = "latest"
+)
+
+// secretNameRegexp and secretVersionRegexp match the names and versions key vault allows
+var secretNameRegexp = regexp.MustCompile(`^[0-9a-zA-Z-]{1,127}$`)
+var secretVersionRegexp = regexp.MustCompile(`^[0-9a-zA-Z]*$`)
+
+// SecretSource returns the secrets referenced by settings
+type SecretSource interface {
+	GetSecret(ctx context.Context, vaultBaseURI, secretName, secretVersion string) (string, error)
+}
+
+// SecretReference is a reference to a key vault secret in settings
+type SecretReference struct {
+	VaultURI string `json:"vaultUri"`
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+}
+
+// SecretReferenceEngine resolves the secret references in settings, resolved settings are only used
+// to configure providers and must never be persisted
+type SecretReferenceEngine struct {
+	SecretSource SecretSource
+	// AllowedVaultHosts are the key vaults which can be referenced, no vault can be referenced if empty
+	// so that callers cannot make the service read the secrets of every vault its identity can access
+	AllowedVaultHosts []string
+	// DeniedVaultHosts are the key vaults which cannot be referenced, e.g. the vaults of the service
+	DeniedVaultHosts []string
+}
+
+// FileSecretSource reads secrets from files for development, a secret is read from {Directory}/{name}/{version}
+// or {Directory}/{name}/latest if the reference has no version
+type FileSecretSource struct {
+	Directory string
+}
+
+// NewSecretReferenceEngine creates a secret reference engine
+func NewSecretReferenceEngine(secretSource SecretSource, allowedVaultURIs, deniedVaultURIs []string) (secretReferenceEngine *SecretReferenceEngine, err error) {
+	secretReferenceEngine = new(SecretReferenceEngine)
+	secretReferenceEngine.SecretSource = secretSource
+
+	secretReferenceEngine.AllowedVaultHosts, err = getVaultHosts(allowedVaultURIs)
+	if err != nil {
+		return nil, err
+	}
+
+	secretReferenceEngine.DeniedVaultHosts, err = getVaultHosts(deniedVaultURIs)
+	if err != nil {
+		return nil, err
+	}
+
+	return secretReferenceEngine, nil
+}
+
+// ResolveSettings returns the settings with their secret references replaced by the secret values
+func (secretReferenceEngine *SecretReferenceEngine) ResolveSettings(ctx context.Context, targetPrefix string, settings []byte) ([]byte, int, *apierror.ErrorResponse) {
+	if !bytes.Contains(settings, []byte(SecretReferenceKey)) {
+		return settings, http.StatusOK, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(settings))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, http.StatusBadRequest, newInvalidParameterError(
+			apierror.NewDetail(apierror.InvalidParameter, targetPrefix, fmt.Sprintf("Failed to parse settings: %s", err)))
+	}
+
+	value, statusCode, errorResponse := secretReferenceEngine.resolve(ctx, targetPrefix, value)
+	if errorResponse != nil {
+		return nil, statusCode, errorResponse
+	}
+
+	resolvedSettings, err := json.Marshal(value)
+	if err != nil {
+		return nil, http.StatusInternalServerError, apierror.New(
+			apierror.InternalError,
+			apierror.InternalOperationError,
+			fmt.Sprintf("Failed to serialize settings: %s", err))
+	}
+
+	return resolvedSettings, http.StatusOK, nil
+}
+
+// GetSecret returns a secret from a file
+func (fileSecretSource *FileSecretSource) GetSecret(ctx context.Context, vaultBaseURI, secretName, secretVersion string) (string, error) {
+	if len(secretVersion) == 0 {
+		secretVersion = fileSecretLatestVersion
+	}
+
+	secret, err := ioutil.ReadFile(filepath.Join(fileSecretSource.Directory, secretName, secretVersion))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("The secret %s with version %s was not found", secretName, secretVersion)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(string(secret), "\n"), nil
+}
+
+func (secretReferenceEngine *SecretReferenceEngine) resolve(ctx context.Context, target string, value interface{}) (interface{}, int, *apierror.ErrorResponse) {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		if reference, ok := typedValue[SecretReferenceKey]; ok {
+			return secretReferenceEngine.resolveReference(ctx, target, typedValue, reference)
+		}
+
+		for key, item := range typedValue {
+			resolvedItem, statusCode, errorResponse := secretReferenceEngine.resolve(ctx, target+"."+key, item)
+			if errorResponse != nil {
+				return nil, statusCode, errorResponse
+			}
+			typedValue[key] = resolvedItem
+		}
+	case []interface{}:
+		for i, item := range typedValue {
+			resolvedItem, statusCode, errorResponse := secretReferenceEngine.resolve(ctx, target+"["+strconv.Itoa(i)+"]", item)
+			if errorResponse != nil {
+				return nil, statusCode, errorResponse
+			}
+			typedValue[i] = resolvedItem
+		}
+	}
+
+	return value, http.StatusOK, nil
+}
+
+func (secretReferenceEngine *SecretReferenceEngine) resolveReference(ctx context.Context, target string, value map[string]interface{}, reference interface{}) (interface{}, int, *apierror.ErrorResponse) {
+	target = target + "." + SecretReferenceKey
+	if len(value) > 1 {
+		return nil, http.StatusBadRequest, newInvalidParameterError(
+			apierror.NewDetail(apierror.InvalidParameter, target, fmt.Sprintf("A secret reference cannot have properties next to '%s'.", SecretReferenceKey)))
+	}
+
+	// Round trip the reference to read it as a struct
+	rawReference, err := json.Marshal(reference)
+	if err != nil {
+		return nil, http.StatusBadRequest, newInvalidParameterError(
+			apierror.NewDetail(apierror.InvalidParameter, target, fmt.Sprintf("The secret reference is invalid: %s", err)))
+	}
+
+	secretReference := SecretReference{}
+	if err := json.Unmarshal(rawReference, &secretReference); err != nil {
+		return nil, http.StatusBadRequest, newInvalidParameterError(
+			apierror.NewDetail(apierror.InvalidParameter, target, fmt.Sprintf("The secret reference is invalid: %s", err)))
+	}
+
+	if detail := secretReferenceEngine.validateReference(target, &secretReference); detail != nil {
+		return nil, http.StatusBadRequest, newInvalidParameterError(*detail)
+	}
+
+	secret, err := secretReferenceEngine.SecretSource.GetSecret(ctx, secretReference.VaultURI, secretReference.Name, secretReference.Version)
+	if err != nil {
+		message := fmt.Sprintf("Failed to resolve the secret %s of vault %s: %s", secretReference.Name, secretReference.VaultURI, err)
+		providerError := ClassifyProviderError(err)
+		if providerError.Category != apierror.ClientError {
+			return nil, providerError.HTTPStatus, providerError.ToErrorResponse(message)
+		}
+
+		return nil, http.StatusBadRequest, newInvalidParameterError(apierror.NewDetail(providerError.Code, target, message))
+	}
+
+	return secret, http.StatusOK, nil
+}
+
+func (secretReferenceEngine *SecretReferenceEngine) validateReference(target string, secretReference *SecretReference) *apierror.Error {
+	vaultURI, err := url.Parse(secretReference.VaultURI)
+	if err != nil || vaultURI.Scheme != "https" || len(vaultURI.Host) == 0 {
+		detail := apierror.NewDetail(apierror.InvalidParameter, target+".vaultUri", fmt.Sprintf("The vault uri '%s' is not a https uri.", secretReference.VaultURI))
+		return &detail
+	}
+
+	if len(secretReferenceEngine.AllowedVaultHosts) == 0 {
+		detail := apierror.NewDetail(apierror.InvalidParameter, target, "Secret references are not enabled, no vault can be referenced.")
+		return &detail
+	}
+
+	vaultHost := strings.ToLower(vaultURI.Host)
+	isAllowed := false
+	for _, allowedVaultHost := range secretReferenceEngine.AllowedVaultHosts {
+		isAllowed = isAllowed || vaultHost == allowedVaultHost
+	}
+	for _, deniedVaultHost := range secretReferenceEngine.DeniedVaultHosts {
+		isAllowed = isAllowed && vaultHost != deniedVaultHost
+	}
+	if !isAllowed {
+		detail := apierror.NewDetail(apierror.InvalidParameter, target+".vaultUri", fmt.Sprintf("The vault '%s' cannot be referenced.", secretReference.VaultURI))
+		return &detail
+	}
+
+	if !secretNameRegexp.MatchString(secretReference.Name) {
+		detail := apierror.NewDetail(apierror.InvalidParameter, target+".name", fmt.Sprintf("The secret name '%s' is invalid.", secretReference.Name))
+		return &detail
+	}
+
+	if !secretVersionRegexp.MatchString(secretReference.Version) {
+		detail := apierror.NewDetail(apierror.InvalidParameter, target+".version", fmt.Sprintf("The secret version '%s' is invalid.", secretReference.Version))
+		return &detail
+	}
+
+	return nil
+}
+
+func getVaultHosts(vaultURIs []string) ([]string, error) {
+	vaultHosts := make([]string, 0, len(vaultURIs))
+	for _, vaultURI := range vaultURIs {
+		parsedVaultURI, err := url.Parse(vaultURI)
+		if err != nil || len(parsedVaultURI.Host) == 0 {
+			return nil, fmt.Errorf("The vault uri '%s' is invalid", vaultURI)
+		}
+		vaultHosts = append(vaultHosts, strings.ToLower(parsedVaultURI.Host))
+	}
+
+	return vaultHosts, nil
+}
@@ -0,0 +1,138 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestSecretReferenceEngine returns an engine reading the secret token of version v1 and latest from a directory
+func newTestSecretReferenceEngine(t *testing.T, allowedVaultURIs []string) (*SecretReferenceEngine, func()) {
+	directory, err := ioutil.TempDir("", "secretreferenceengine")
+	if err != nil {
+		t.Fatalf("failed to create the secret directory: %s", err)
+	}
+	if err := os.MkdirAll(filepath.Join(directory, "token"), 0700); err != nil {
+		t.Fatalf("failed to create the secret directory: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(directory, "token", "latest"), []byte("latest-secret\n"), 0600); err != nil {
+		t.Fatalf("failed to write the secret: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(directory, "token", "v1"), []byte("v1-secret"), 0600); err != nil {
+		t.Fatalf("failed to write the secret: %s", err)
+	}
+
+	secretReferenceEngine, err := NewSecretReferenceEngine(
+		&FileSecretSource{Directory: directory},
+		allowedVaultURIs,
+		[]string{"https://service.vault.azure.net/"})
+	if err != nil {
+		t.Fatalf("failed to create the secret reference engine: %s", err)
+	}
+
+	return secretReferenceEngine, func() { os.RemoveAll(directory) }
+}
+
+func TestResolveSettings(t *testing.T) {
+	secretReferenceEngine, cleanup := newTestSecretReferenceEngine(t, []string{"https://tenant.vault.azure.net/", "https://service.vault.azure.net/"})
+	defer cleanup()
+
+	testCases := []struct {
+		settings         string
+		expectedSettings string
+	}{
+		{
+			`{"token":{"keyVaultSecretRef":{"vaultUri":"https://tenant.vault.azure.net/","name":"token"}}}`,
+			`{"token":"latest-secret"}`,
+		},
+		{
+			`{"spec":[{"token":{"keyVaultSecretRef":{"vaultUri":"https://TENANT.vault.azure.net","name":"token","version":"v1"}}}],"ttl":300}`,
+			`{"spec":[{"token":"v1-secret"}],"ttl":300}`,
+		},
+		{
+			// Settings without references are returned as they are
+			`{"name": "www"}`,
+			`{"name": "www"}`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		resolvedSettings, _, errorResponse := secretReferenceEngine.ResolveSettings(context.Background(), SettingsTarget, []byte(testCase.settings))
+		if errorResponse != nil {
+			t.Fatalf("expected %s to be resolved, actual error %s", testCase.settings, errorResponse.Body.Message)
+		}
+		if string(resolvedSettings) != testCase.expectedSettings {
+			t.Fatalf("expected %s to be resolved to %s, actual %s", testCase.settings, testCase.expectedSettings, resolvedSettings)
+		}
+	}
+}
+
+func TestResolveSettingsRejectsInvalidReferences(t *testing.T) {
+	secretReferenceEngine, cleanup := newTestSecretReferenceEngine(t, []string{"https://tenant.vault.azure.net/", "https://service.vault.azure.net/"})
+	defer cleanup()
+
+	testCases := []struct {
+		settings       string
+		expectedTarget string
+	}{
+		{
+			// The vaults of the service are denied even if they are allowed
+			`{"token":{"keyVaultSecretRef":{"vaultUri":"https://service.vault.azure.net/","name":"token"}}}`,
+			"properties.settings.token.keyVaultSecretRef.vaultUri",
+		},
+		{
+			`{"token":{"keyVaultSecretRef":{"vaultUri":"https://other.vault.azure.net/","name":"token"}}}`,
+			"properties.settings.token.keyVaultSecretRef.vaultUri",
+		},
+		{
+			`{"token":{"keyVaultSecretRef":{"vaultUri":"http://tenant.vault.azure.net/","name":"token"}}}`,
+			"properties.settings.token.keyVaultSecretRef.vaultUri",
+		},
+		{
+			`{"token":{"keyVaultSecretRef":{"vaultUri":"https://tenant.vault.azure.net/","name":"../token"}}}`,
+			"properties.settings.token.keyVaultSecretRef.name",
+		},
+		{
+			`{"token":{"keyVaultSecretRef":{"vaultUri":"https://tenant.vault.azure.net/","name":"token","version":"../v1"}}}`,
+			"properties.settings.token.keyVaultSecretRef.version",
+		},
+		{
+			`{"spec":[{"token":{"keyVaultSecretRef":{"vaultUri":"https://tenant.vault.azure.net/","name":"token"},"ttl":300}}]}`,
+			"properties.settings.spec[0].token.keyVaultSecretRef",
+		},
+		{
+			`{"token":{"keyVaultSecretRef":{"vaultUri":"https://tenant.vault.azure.net/","name":"missing"}}}`,
+			"properties.settings.token.keyVaultSecretRef",
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, statusCode, errorResponse := secretReferenceEngine.ResolveSettings(context.Background(), SettingsTarget, []byte(testCase.settings))
+		if errorResponse == nil {
+			t.Fatalf("expected %s to be rejected", testCase.settings)
+		}
+		if statusCode != http.StatusBadRequest {
+			t.Fatalf("expected %s to be rejected with 400, actual %d", testCase.settings, statusCode)
+		}
+		if len(errorResponse.Body.Details) != 1 || errorResponse.Body.Details[0].Target != testCase.expectedTarget {
+			t.Fatalf("expected %s to be rejected with a detail targeting %s, actual %v", testCase.settings, testCase.expectedTarget, errorResponse.Body.Details)
+		}
+	}
+}
+
+func TestResolveSettingsWithoutAllowedVaults(t *testing.T) {
+	secretReferenceEngine, cleanup := newTestSecretReferenceEngine(t, nil)
+	defer cleanup()
+
+	_, _, errorResponse := secretReferenceEngine.ResolveSettings(context.Background(), SettingsTarget, []byte(`{"token":{"keyVaultSecretRef":{"vaultUri":"https://tenant.vault.azure.net/","name":"token"}}}`))
+	if errorResponse == nil {
+		t.Fatalf("expected references to be rejected when no vault is allowed")
+	}
+}
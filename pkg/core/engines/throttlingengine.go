@@ -0,0 +1,218 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/apierror"
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/metrics"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/juju/ratelimit"
+)
+
+const (
+	// ThrottlingScopeSubscription is the scope of per subscription budgets
+	ThrottlingScopeSubscription = "subscription"
+	// ThrottlingScopeProviderRegistration is the scope of per provider registration budgets
+	ThrottlingScopeProviderRegistration = "providerRegistration"
+
+	// ThrottlingKindRead is the kind of GET requests
+	ThrottlingKindRead = "read"
+	// ThrottlingKindWrite is the kind of PUT, PATCH, POST and DELETE requests
+	ThrottlingKindWrite = "write"
+
+	// throttlingBucketIdleTimeout is how long an unused bucket is kept, a bucket refills within seconds
+	// so an evicted bucket is recreated full as it would have been
+	throttlingBucketIdleTimeout = 10 * time.Minute
+)
+
+// ThrottlingPolicy is the read and write budget of a throttling scope, in requests per second
+type ThrottlingPolicy struct {
+	ReadsPerSecond  float64
+	WritesPerSecond float64
+}
+
+// ThrottlingEngine throttles requests with token buckets per subscription and per provider registration
+type ThrottlingEngine struct {
+	SubscriptionPolicy         ThrottlingPolicy
+	ProviderRegistrationPolicy ThrottlingPolicy
+
+	lock      sync.Mutex
+	buckets   map[string]*throttlingBucket
+	lastSweep time.Time
+}
+
+type throttlingBucket struct {
+	bucket   *ratelimit.Bucket
+	lastUsed time.Time
+}
+
+// NewThrottlingEngine creates a throttling engine
+func NewThrottlingEngine(subscriptionPolicy, providerRegistrationPolicy ThrottlingPolicy) (throttlingEngine *ThrottlingEngine) {
+	throttlingEngine = new(ThrottlingEngine)
+	throttlingEngine.SubscriptionPolicy = subscriptionPolicy
+	throttlingEngine.ProviderRegistrationPolicy = providerRegistrationPolicy
+	throttlingEngine.buckets = make(map[string]*throttlingBucket)
+	throttlingEngine.lastSweep = time.Now()
+	return throttlingEngine
+}
+
+// Filter is the go-restful filter throttling requests per subscription, and per provider registration
+// for requests on provider registrations
+func (throttlingEngine *ThrottlingEngine) Filter(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	kind := GetThrottlingKind(request)
+
+	subscriptionID := GetSubscriptionID(request)
+	if len(subscriptionID) > 0 {
+		if retryAfter, throttled := throttlingEngine.take(ThrottlingScopeSubscription, strings.ToLower(subscriptionID), kind); throttled {
+			WriteThrottledResponse(response, ThrottlingScopeSubscription, retryAfter)
+			return
+		}
+	}
+
+	if len(GetProviderRegistrationName(request)) > 0 {
+		if retryAfter, throttled := throttlingEngine.TakeProviderRegistration(GetFullyQualifiedProviderRegistrationID(request), kind); throttled {
+			WriteThrottledResponse(response, ThrottlingScopeProviderRegistration, retryAfter)
+			return
+		}
+	}
+
+	chain.ProcessFilter(request, response)
+}
+
+// TakeProviderRegistration takes a request from the budget of a provider registration,
+// it returns true and the duration to back off if the budget is exhausted
+func (throttlingEngine *ThrottlingEngine) TakeProviderRegistration(providerRegistrationID, kind string) (time.Duration, bool) {
+	return throttlingEngine.take(ThrottlingScopeProviderRegistration, strings.ToLower(providerRegistrationID), kind)
+}
+
+// WaitProviderRegistration takes a request from the budget of a provider registration, it waits until the budget
+// allows the request rather than rejecting it, so that background work is paced by the budget without failing
+func (throttlingEngine *ThrottlingEngine) WaitProviderRegistration(ctx context.Context, providerRegistrationID, kind string) error {
+	rate := throttlingEngine.getRate(ThrottlingScopeProviderRegistration, kind)
+	if rate <= 0 {
+		return nil
+	}
+
+	bucket := throttlingEngine.getBucket(ThrottlingScopeProviderRegistration+"/"+kind+"/"+strings.ToLower(providerRegistrationID), rate)
+	wait := bucket.Take(1)
+	metrics.AddThrottlingDecision(ThrottlingScopeProviderRegistration, kind, metrics.ThrottlingDecisionAllowed)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TakeResourceProviderRegistration takes a request on a resource from the budget of its provider registration,
+// a resource whose registration is unknown has a budget of its own rather than sharing one with other tenants
+func (throttlingEngine *ThrottlingEngine) TakeResourceProviderRegistration(providerRegistrationID, resourceID, kind string) (time.Duration, bool) {
+	if len(providerRegistrationID) == 0 {
+		return throttlingEngine.take(ThrottlingScopeProviderRegistration, "resource:"+strings.ToLower(resourceID), kind)
+	}
+
+	return throttlingEngine.TakeProviderRegistration(providerRegistrationID, kind)
+}
+
+// GetThrottlingKind returns whether a request is a read or a write
+func GetThrottlingKind(request *restful.Request) string {
+	if request.Request.Method == http.MethodGet || request.Request.Method == http.MethodHead {
+		return ThrottlingKindRead
+	}
+
+	return ThrottlingKindWrite
+}
+
+// WriteThrottledResponse writes a 429 with the Retry-After header
+func WriteThrottledResponse(response *restful.Response, scope string, retryAfter time.Duration) {
+	response.Header().Set(consts.RetryAfterHeader, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	apierror.WriteErrorToResponse(
+		response,
+		http.StatusTooManyRequests,
+		apierror.ClientError,
+		apierror.TooManyRequests,
+		fmt.Sprintf("The request was throttled because the %s request budget of the %s is exhausted. Retry after %s.", scope, scope, retryAfter))
+}
+
+func (throttlingEngine *ThrottlingEngine) take(scope, key, kind string) (time.Duration, bool) {
+	rate := throttlingEngine.getRate(scope, kind)
+	if rate <= 0 {
+		return 0, false
+	}
+
+	bucket := throttlingEngine.getBucket(scope+"/"+kind+"/"+key, rate)
+	if bucket.TakeAvailable(1) == 0 {
+		metrics.AddThrottlingDecision(scope, kind, metrics.ThrottlingDecisionThrottled)
+
+		retryAfter := time.Duration(float64(time.Second) / rate)
+		if retryAfter < time.Second {
+			retryAfter = time.Second
+		}
+		return retryAfter, true
+	}
+
+	metrics.AddThrottlingDecision(scope, kind, metrics.ThrottlingDecisionAllowed)
+	return 0, false
+}
+
+func (throttlingEngine *ThrottlingEngine) getRate(scope, kind string) float64 {
+	policy := throttlingEngine.SubscriptionPolicy
+	if scope == ThrottlingScopeProviderRegistration {
+		policy = throttlingEngine.ProviderRegistrationPolicy
+	}
+
+	if kind == ThrottlingKindRead {
+		return policy.ReadsPerSecond
+	}
+
+	return policy.WritesPerSecond
+}
+
+func (throttlingEngine *ThrottlingEngine) getBucket(key string, rate float64) *ratelimit.Bucket {
+	throttlingEngine.lock.Lock()
+	defer throttlingEngine.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(throttlingEngine.lastSweep) > throttlingBucketIdleTimeout {
+		throttlingEngine.evictIdleBuckets(now)
+	}
+
+	bucket, ok := throttlingEngine.buckets[key]
+	if !ok {
+		// Allow bursts of twice the rate
+		bucket = &throttlingBucket{bucket: ratelimit.NewBucketWithRate(rate, int64(math.Ceil(rate*2)))}
+		throttlingEngine.buckets[key] = bucket
+	}
+	bucket.lastUsed = now
+
+	return bucket.bucket
+}
+
+// evictIdleBuckets removes the buckets unused for the idle timeout, the caller holds the lock
+func (throttlingEngine *ThrottlingEngine) evictIdleBuckets(now time.Time) {
+	for key, bucket := range throttlingEngine.buckets {
+		if now.Sub(bucket.lastUsed) > throttlingBucketIdleTimeout {
+			delete(throttlingEngine.buckets, key)
+		}
+	}
+
+	throttlingEngine.lastSweep = now
+}
@@ -0,0 +1,79 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestThrottlingUnknownProviderRegistrationsByResource(t *testing.T) {
+	throttlingEngine := NewThrottlingEngine(ThrottlingPolicy{}, ThrottlingPolicy{ReadsPerSecond: 1, WritesPerSecond: 1})
+
+	// The burst of a budget is twice its rate
+	for i := 0; i < 2; i++ {
+		if _, throttled := throttlingEngine.TakeResourceProviderRegistration("", "/subscriptions/a/resourceGroups/rg/providers/Microsoft.TerraformOSS/resources/one", ThrottlingKindWrite); throttled {
+			t.Fatalf("expected request %d of resource one to be allowed", i+1)
+		}
+	}
+	if _, throttled := throttlingEngine.TakeResourceProviderRegistration("", "/subscriptions/a/resourceGroups/rg/providers/Microsoft.TerraformOSS/resources/one", ThrottlingKindWrite); !throttled {
+		t.Fatalf("expected the third request of resource one to be throttled")
+	}
+
+	if _, throttled := throttlingEngine.TakeResourceProviderRegistration("", "/subscriptions/b/resourceGroups/rg/providers/Microsoft.TerraformOSS/resources/two", ThrottlingKindWrite); throttled {
+		t.Fatalf("expected a resource of another tenant without a provider registration to have its own budget")
+	}
+}
+
+func TestThrottlingSharesTheBudgetOfAProviderRegistration(t *testing.T) {
+	throttlingEngine := NewThrottlingEngine(ThrottlingPolicy{}, ThrottlingPolicy{ReadsPerSecond: 1, WritesPerSecond: 1})
+	providerRegistrationID := "/subscriptions/a/resourceGroups/rg/providers/Microsoft.TerraformOSS/providerRegistrations/registration"
+
+	throttlingEngine.TakeResourceProviderRegistration(providerRegistrationID, "one", ThrottlingKindRead)
+	throttlingEngine.TakeResourceProviderRegistration(providerRegistrationID, "two", ThrottlingKindRead)
+	if _, throttled := throttlingEngine.TakeProviderRegistration(providerRegistrationID, ThrottlingKindRead); !throttled {
+		t.Fatalf("expected the resources of a provider registration to share its budget")
+	}
+}
+
+func TestThrottlingEvictsIdleBuckets(t *testing.T) {
+	throttlingEngine := NewThrottlingEngine(ThrottlingPolicy{ReadsPerSecond: 1, WritesPerSecond: 1}, ThrottlingPolicy{})
+
+	throttlingEngine.take(ThrottlingScopeSubscription, "idle", ThrottlingKindRead)
+	throttlingEngine.take(ThrottlingScopeSubscription, "active", ThrottlingKindRead)
+	throttlingEngine.buckets[ThrottlingScopeSubscription+"/"+ThrottlingKindRead+"/idle"].lastUsed = time.Now().Add(-2 * throttlingBucketIdleTimeout)
+	throttlingEngine.lastSweep = time.Now().Add(-2 * throttlingBucketIdleTimeout)
+
+	throttlingEngine.take(ThrottlingScopeSubscription, "active", ThrottlingKindRead)
+	if _, ok := throttlingEngine.buckets[ThrottlingScopeSubscription+"/"+ThrottlingKindRead+"/idle"]; ok {
+		t.Fatalf("expected the idle bucket to be evicted")
+	}
+	if _, ok := throttlingEngine.buckets[ThrottlingScopeSubscription+"/"+ThrottlingKindRead+"/active"]; !ok {
+		t.Fatalf("expected the active bucket to be kept")
+	}
+}
+
+func TestThrottlingWaitsForTheBudgetOfAProviderRegistration(t *testing.T) {
+	throttlingEngine := NewThrottlingEngine(ThrottlingPolicy{}, ThrottlingPolicy{ReadsPerSecond: 1, WritesPerSecond: 10})
+	providerRegistrationID := "/subscriptions/a/resourceGroups/rg/providers/Microsoft.TerraformOSS/providerRegistrations/registration"
+
+	// The burst of 20 writes is taken at once, the next 5 writes wait for the budget to refill
+	start := time.Now()
+	for i := 0; i < 25; i++ {
+		if err := throttlingEngine.WaitProviderRegistration(context.Background(), providerRegistrationID, ThrottlingKindWrite); err != nil {
+			t.Fatalf("expected write %d to be allowed, actual %s", i+1, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("expected the writes over the burst to wait, actual %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := throttlingEngine.WaitProviderRegistration(ctx, providerRegistrationID, ThrottlingKindWrite); err != context.Canceled {
+		t.Fatalf("expected %s when the context is cancelled, actual %v", context.Canceled, err)
+	}
+}
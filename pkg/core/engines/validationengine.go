@@ -6,30 +6,59 @@ package engines
 
 import (
 	"TFRP/pkg/core/apierror"
-	"TFRP/pkg/core/consts"
 	"TFRP/pkg/core/entities"
 	"fmt"
+	"regexp"
 	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+const (
+	// LocationTarget is the json path of the location in request content
+	LocationTarget = "location"
+	// PropertiesTarget is the json path of the properties in request content
+	PropertiesTarget = "properties"
+	// ProviderTypeTarget is the json path of the provider type in request content
+	ProviderTypeTarget = PropertiesTarget + ".providerType"
+	// ResourceTypeTarget is the json path of the resource type in request content
+	ResourceTypeTarget = PropertiesTarget + ".resourceType"
+	// SettingsTarget is the json path of the settings in request content
+	SettingsTarget = PropertiesTarget + ".settings"
+	// SoftDeleteRetentionDaysTarget is the json path of the soft delete retention days in request content
+	SoftDeleteRetentionDaysTarget = PropertiesTarget + ".softDeleteRetentionDays"
+	// MaxSoftDeleteRetentionDays is the longest retention of soft deleted resources
+	MaxSoftDeleteRetentionDays = 90
+	// ProviderRegistrationSettingsTarget is the json path of the settings of the provider registration a resource references
+	ProviderRegistrationSettingsTarget = "providerRegistration." + SettingsTarget
 )
 
+// AllowedLocations are the locations resources can be created in, any location if empty
+var AllowedLocations []string
+
+// schemaErrorKeyRegexp matches the attribute key terraform puts in front of schema validation errors,
+// e.g. `"spec.0.port": required field is not set` or `spec.0.port: should be a list`
+var schemaErrorKeyRegexp = regexp.MustCompile(`^"?([a-z0-9_]+(?:\.[a-z0-9_]+)*)"?(?:[:,]| must| \()`)
+
+// schemaIndexRegexp matches the numeric segments of an attribute key
+var schemaIndexRegexp = regexp.MustCompile(`\.([0-9]+)(\.|$)`)
+
 // ValidateProviderRegistrationDefinition validates the provider registration definition
 func ValidateProviderRegistrationDefinition(providerRegistrationDefinition *entities.ProviderRegistrationDefinition) *apierror.ErrorResponse {
 	if providerRegistrationDefinition.Properties == nil {
-		return apierror.New(
-			apierror.ClientError,
-			apierror.BadRequest,
-			fmt.Sprintf("Request content is missing properties."))
+		return newInvalidParameterError(
+			apierror.NewDetail(apierror.InvalidParameter, PropertiesTarget, "Request content is missing properties."))
 	}
 	if len(strings.TrimSpace(providerRegistrationDefinition.Properties.ProviderType)) == 0 {
-		return apierror.New(
-			apierror.ClientError,
-			apierror.BadRequest,
-			fmt.Sprintf("Request content is missing property 'ProviderType'."))
+		return newInvalidParameterError(
+			apierror.NewDetail(apierror.InvalidParameter, ProviderTypeTarget, "Request content is missing property 'ProviderType'."))
 	}
 
-	supportedProviders := []string{consts.KubernetesProvider, consts.DatadogProvider, consts.CloudflareProvider}
 	isSupported := false
-	for _, provider := range supportedProviders {
+	for _, provider := range SupportedProviderTypes {
 		if strings.EqualFold(provider, providerRegistrationDefinition.Properties.ProviderType) {
 			isSupported = true
 			break
@@ -37,29 +66,209 @@ func ValidateProviderRegistrationDefinition(providerRegistrationDefinition *enti
 	}
 
 	if !isSupported {
-		return apierror.New(
-			apierror.ClientError,
-			apierror.BadRequest,
-			fmt.Sprintf("The provider type %s is not supported. Supported providers are %s.", providerRegistrationDefinition.Properties.ProviderType, supportedProviders))
+		return newInvalidParameterError(
+			apierror.NewDetail(
+				apierror.InvalidParameter,
+				ProviderTypeTarget,
+				fmt.Sprintf("The provider type %s is not supported. Supported providers are %s.", providerRegistrationDefinition.Properties.ProviderType, SupportedProviderTypes)))
 	}
 
-	return nil
+	retentionDays := providerRegistrationDefinition.Properties.SoftDeleteRetentionDays
+	if retentionDays < 0 || retentionDays > MaxSoftDeleteRetentionDays {
+		return newInvalidParameterError(
+			apierror.NewDetail(
+				apierror.InvalidParameter,
+				SoftDeleteRetentionDaysTarget,
+				fmt.Sprintf("The soft delete retention %d is not supported. Retention must be between 0 and %d days.", retentionDays, MaxSoftDeleteRetentionDays)))
+	}
+
+	return ValidateLocation(providerRegistrationDefinition.Location)
 }
 
 // ValidateResourceDefinition validates the resource definition
 func ValidateResourceDefinition(resourceDefinition *entities.ResourceDefinition) *apierror.ErrorResponse {
 	if resourceDefinition.Properties == nil {
-		return apierror.New(
-			apierror.ClientError,
-			apierror.BadRequest,
-			fmt.Sprintf("Request content is missing properties."))
+		return newInvalidParameterError(
+			apierror.NewDetail(apierror.InvalidParameter, PropertiesTarget, "Request content is missing properties."))
 	}
 	if len(strings.TrimSpace(resourceDefinition.Properties.ResourceType)) == 0 {
-		return apierror.New(
-			apierror.ClientError,
-			apierror.BadRequest,
-			fmt.Sprintf("Request content is missing property 'ResourceType'."))
+		return newInvalidParameterError(
+			apierror.NewDetail(apierror.InvalidParameter, ResourceTypeTarget, "Request content is missing property 'ResourceType'."))
+	}
+
+	return nil
+}
+
+// ValidateLocation validates that resources can be created in a location
+func ValidateLocation(location string) *apierror.ErrorResponse {
+	if IsLocationAllowed(location) {
+		return nil
+	}
+
+	detail := apierror.NewDetail(
+		apierror.RegionNotSupported,
+		LocationTarget,
+		fmt.Sprintf("The location '%s' is not supported. Supported locations are %s.", location, AllowedLocations))
+	return apierror.NewWithDetails(apierror.ClientError, apierror.RegionNotSupported, detail.Message, []apierror.Error{detail})
+}
+
+// IsLocationAllowed returns whether resources can be created in a location, locations are compared
+// without case and spaces as ARM sends either the name or the display name of a location
+func IsLocationAllowed(location string) bool {
+	if len(AllowedLocations) == 0 {
+		return true
+	}
+
+	for _, allowedLocation := range AllowedLocations {
+		if NormalizeLocation(allowedLocation) == NormalizeLocation(location) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NormalizeLocation returns the name of a location, e.g. westus for West US
+func NormalizeLocation(location string) string {
+	return strings.ToLower(strings.Replace(location, " ", "", -1))
+}
+
+// ValidateTerraformImportDefinition validates the terraform import definition
+func ValidateTerraformImportDefinition(terraformImportDefinition *entities.TerraformImportDefinition) *apierror.ErrorResponse {
+	if len(terraformImportDefinition.State) == 0 {
+		return newInvalidParameterError(
+			apierror.NewDetail(apierror.InvalidParameter, "state", "Request content is missing property 'State'."))
+	}
+	if len(terraformImportDefinition.Resources) == 0 {
+		return newInvalidParameterError(
+			apierror.NewDetail(apierror.InvalidParameter, "resources", "Request content is missing property 'Resources'."))
+	}
+	if len(terraformImportDefinition.Resources) > MaxTerraformImportResources {
+		return newInvalidParameterError(
+			apierror.NewDetail(apierror.InvalidParameter, "resources", fmt.Sprintf("At most %d resources can be imported at once.", MaxTerraformImportResources)))
+	}
+
+	details := []apierror.Error{}
+	resourceNames := make(map[string]bool)
+	for i, resource := range terraformImportDefinition.Resources {
+		target := fmt.Sprintf("resources[%d]", i)
+		if len(strings.TrimSpace(resource.Address)) == 0 {
+			details = append(details, apierror.NewDetail(apierror.InvalidParameter, target+".address", "The resource is missing property 'Address'."))
+		}
+		if len(strings.TrimSpace(resource.ProviderID)) == 0 {
+			details = append(details, apierror.NewDetail(apierror.InvalidParameter, target+".providerId", "The resource is missing property 'ProviderID'."))
+		}
+		if len(strings.TrimSpace(resource.ResourceName)) == 0 || strings.Contains(resource.ResourceName, "/") {
+			details = append(details, apierror.NewDetail(apierror.InvalidParameter, target+".resourceName", fmt.Sprintf("The resource name '%s' is invalid.", resource.ResourceName)))
+		} else if resourceNames[strings.ToLower(resource.ResourceName)] {
+			details = append(details, apierror.NewDetail(apierror.InvalidParameter, target+".resourceName", fmt.Sprintf("The resource name '%s' is mapped more than once.", resource.ResourceName)))
+		}
+		resourceNames[strings.ToLower(resource.ResourceName)] = true
+		if !IsLocationAllowed(resource.Location) {
+			details = append(details, apierror.NewDetail(apierror.RegionNotSupported, target+".location", fmt.Sprintf("The location '%s' is not supported. Supported locations are %s.", resource.Location, AllowedLocations)))
+		}
+	}
+
+	if len(details) > 0 {
+		return newInvalidParameterError(details...)
 	}
 
 	return nil
 }
+
+// ValidateConfig validates the terraform config built from the request content
+func ValidateConfig(cfg *config.Config) *apierror.ErrorResponse {
+	err := cfg.Validate()
+	if err == nil {
+		return nil
+	}
+
+	return newInvalidParameterError(ToErrorDetails(SettingsTarget, flattenErrors(err))...)
+}
+
+// ValidateResourceSettings validates the resource settings against the provider schema
+func ValidateResourceSettings(provider *schema.Provider, resourceType string, resourceConfig *terraform.ResourceConfig) *apierror.ErrorResponse {
+	_, errs := provider.ValidateResource(resourceType, resourceConfig)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return newInvalidParameterError(ToErrorDetails(SettingsTarget, errs)...)
+}
+
+// ToErrorDetails converts terraform validation errors to error details targeting the offending fields under targetPrefix
+func ToErrorDetails(targetPrefix string, errs []error) []apierror.Error {
+	details := make([]apierror.Error, 0, len(errs))
+	for _, err := range errs {
+		details = append(details, apierror.NewDetail(apierror.InvalidParameter, GetErrorTarget(targetPrefix, err), err.Error()))
+	}
+
+	return details
+}
+
+// GetErrorTarget returns the json path of the field a terraform validation error refers to,
+// e.g. `properties.settings.spec[0].port` for `"spec.0.port": required field is not set`
+func GetErrorTarget(targetPrefix string, err error) string {
+	match := schemaErrorKeyRegexp.FindStringSubmatch(err.Error())
+	if match == nil {
+		return targetPrefix
+	}
+
+	key := match[1]
+	for schemaIndexRegexp.MatchString(key) {
+		key = schemaIndexRegexp.ReplaceAllString(key, "[$1]$2")
+	}
+
+	return targetPrefix + "." + key
+}
+
+// PrefixErrorDetails returns the details of an error response with their targets under targetPrefix,
+// an error response without details becomes one detail targeting targetPrefix
+func PrefixErrorDetails(targetPrefix string, errorResponse *apierror.ErrorResponse) []apierror.Error {
+	if len(errorResponse.Body.Details) == 0 {
+		return []apierror.Error{apierror.NewDetail(errorResponse.Body.Code, targetPrefix, errorResponse.Body.Message)}
+	}
+
+	details := make([]apierror.Error, 0, len(errorResponse.Body.Details))
+	for _, detail := range errorResponse.Body.Details {
+		target := targetPrefix
+		if len(detail.Target) > 0 {
+			target = targetPrefix + "." + detail.Target
+		}
+		details = append(details, apierror.NewDetail(detail.Code, target, detail.Message))
+	}
+
+	return details
+}
+
+// NewPreflightError returns the error of a template deployment with the errors of all its resources
+func NewPreflightError(details []apierror.Error) *apierror.ErrorResponse {
+	return newInvalidParameterError(details...)
+}
+
+// IsTemplateExpression returns whether a value is a template expression ARM only evaluates during the deployment,
+// e.g. [reference('registration').id]
+func IsTemplateExpression(value string) bool {
+	return strings.HasPrefix(value, "[") && !strings.HasPrefix(value, "[[") && strings.HasSuffix(value, "]")
+}
+
+func newInvalidParameterError(details ...apierror.Error) *apierror.ErrorResponse {
+	messages := make([]string, 0, len(details))
+	for _, detail := range details {
+		messages = append(messages, detail.Message)
+	}
+
+	return apierror.NewWithDetails(
+		apierror.ClientError,
+		apierror.InvalidParameter,
+		fmt.Sprintf("The request content is invalid: %s", strings.Join(messages, " ")),
+		details)
+}
+
+func flattenErrors(err error) []error {
+	if multiErr, ok := err.(*multierror.Error); ok {
+		return multiErr.Errors
+	}
+
+	return []error{err}
+}
@@ -0,0 +1,97 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package engines
+
+import (
+	"TFRP/pkg/core/apierror"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestGetErrorTarget(t *testing.T) {
+	testCases := []struct {
+		message        string
+		expectedTarget string
+	}{
+		{
+			`"name": required field is not set`,
+			"properties.settings.name",
+		},
+		{
+			`"spec.0.port": required field is not set`,
+			"properties.settings.spec[0].port",
+		},
+		{
+			`spec.0.port: should be a list`,
+			"properties.settings.spec[0].port",
+		},
+		{
+			`metadata.0.labels.1.value, must be a string`,
+			"properties.settings.metadata[0].labels[1].value",
+		},
+		{
+			`"spec.12.container.3": conflicts with spec.12.image`,
+			"properties.settings.spec[12].container[3]",
+		},
+		{
+			`ttl must be between 1 and 3600`,
+			"properties.settings.ttl",
+		},
+		{
+			`record_type (A) is not supported`,
+			"properties.settings.record_type",
+		},
+		{
+			// Errors without an attribute key target the settings
+			`Provider doesn't support resource: azurerm_unknown`,
+			"properties.settings",
+		},
+		{
+			`1 error(s) occurred`,
+			"properties.settings",
+		},
+	}
+
+	for _, testCase := range testCases {
+		target := GetErrorTarget(SettingsTarget, errors.New(testCase.message))
+		if target != testCase.expectedTarget {
+			t.Fatalf("expected the target of '%s' to equal %s, actual %s", testCase.message, testCase.expectedTarget, target)
+		}
+	}
+}
+
+func TestToErrorDetails(t *testing.T) {
+	details := ToErrorDetails(SettingsTarget, []error{
+		fmt.Errorf(`"spec.0.port": required field is not set`),
+		fmt.Errorf(`"name": required field is not set`),
+	})
+
+	if len(details) != 2 {
+		t.Fatalf("expected 2 details, actual %d", len(details))
+	}
+	for _, detail := range details {
+		if detail.Code != apierror.InvalidParameter {
+			t.Fatalf("expected the code of detail %s to equal %s, actual %s", detail.Target, apierror.InvalidParameter, detail.Code)
+		}
+	}
+	if details[0].Target != "properties.settings.spec[0].port" || details[1].Target != "properties.settings.name" {
+		t.Fatalf("expected the targets properties.settings.spec[0].port and properties.settings.name, actual %s and %s", details[0].Target, details[1].Target)
+	}
+}
+
+func TestPrefixErrorDetails(t *testing.T) {
+	errorResponse := newInvalidParameterError(apierror.NewDetail(apierror.InvalidParameter, "properties.settings.name", "missing"))
+	details := PrefixErrorDetails("resources[1]", errorResponse)
+	if len(details) != 1 || details[0].Target != "resources[1].properties.settings.name" {
+		t.Fatalf("expected the target resources[1].properties.settings.name, actual %v", details)
+	}
+
+	errorResponse = apierror.New(apierror.ClientError, apierror.BadRequest, "bad")
+	details = PrefixErrorDetails("resources[1]", errorResponse)
+	if len(details) != 1 || details[0].Target != "resources[1]" || details[0].Message != "bad" {
+		t.Fatalf("expected one detail targeting resources[1], actual %v", details)
+	}
+}
@@ -0,0 +1,26 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package entities
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// AdminAuditRecord is the record of an admin action stored in storage, it is stored before the action runs
+// and completed with the outcome of the action
+type AdminAuditRecord struct {
+	ID         bson.ObjectId `bson:"_id,omitempty"`
+	Time       time.Time
+	Principal  string
+	Action     string
+	ResourceID string `json:",omitempty"`
+	Query      string `json:",omitempty"`
+	Content    string `json:",omitempty"`
+	Completed  bool
+	StatusCode int    `json:",omitempty"`
+	Result     string `json:",omitempty"`
+}
@@ -0,0 +1,43 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package entities
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AdminForceProvisioningStateDefinition is the content of a request forcing the provisioning state of a resource,
+// the error is recorded for the Failed and Canceled states
+type AdminForceProvisioningStateDefinition struct {
+	ProvisioningState string
+	ErrorCode         string
+	ErrorMessage      string
+}
+
+// AdminResourceListResult is the list of resources returned by the admin API
+type AdminResourceListResult struct {
+	Value []AdminResourceSummary
+}
+
+// AdminResourceSummary is the summary of a resource returned by the admin API
+type AdminResourceSummary struct {
+	ResourceID        string
+	ResourceType      string
+	ProviderType      string
+	ProviderID        string
+	ProvisioningState string
+	OperationAttempts int        `json:",omitempty"`
+	InFlight          bool       `json:",omitempty"`
+	LastRefreshedAt   *time.Time `json:",omitempty"`
+}
+
+// AdminResourceDump is the raw config and stored package of a resource, the secret references of the config
+// file are not resolved
+type AdminResourceDump struct {
+	ConfigFile      json.RawMessage `json:",omitempty"`
+	ConfigFileError string          `json:",omitempty"`
+	Resource        ResourcePackage
+}
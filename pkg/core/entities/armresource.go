@@ -0,0 +1,21 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package entities
+
+import "strings"
+
+// getResourceName returns the name of a resource, the last segment of its id
+func getResourceName(resourceID string) string {
+	return resourceID[strings.LastIndex(resourceID, "/")+1:]
+}
+
+// getTags returns the tags of a resource, ARM expects an empty object rather than null
+func getTags(tags map[string]string) map[string]string {
+	if tags == nil {
+		return map[string]string{}
+	}
+
+	return tags
+}
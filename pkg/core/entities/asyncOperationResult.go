@@ -6,12 +6,21 @@ package entities
 
 // AsyncOperationResult is the async operation result
 type AsyncOperationResult struct {
-	Status string `json:",omitempty"`
-	Error  *ExtendedErrorInfo
+	Status     string `json:",omitempty"`
+	Error      *ExtendedErrorInfo
+	Properties *AsyncOperationProperties `json:",omitempty"`
+}
+
+// AsyncOperationProperties is the async operation properties
+type AsyncOperationProperties struct {
+	Attempts  int                    `json:",omitempty"`
+	LastError string                 `json:",omitempty"`
+	Import    *TerraformImportResult `json:",omitempty"`
 }
 
 // ExtendedErrorInfo is the extended error info
 type ExtendedErrorInfo struct {
-	Code    string `json:",omitempty"`
-	Message string `json:",omitempty"`
+	Code    string              `json:",omitempty"`
+	Message string              `json:",omitempty"`
+	Details []ExtendedErrorInfo `json:",omitempty"`
 }
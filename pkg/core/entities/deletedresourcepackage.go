@@ -0,0 +1,69 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package entities
+
+import (
+	"time"
+
+	"TFRP/pkg/core/consts"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DeletedResourcePackage is the package of a soft deleted resource stored in storage, the resource keeps its
+// config and state so that it can be restored until it is purged
+type DeletedResourcePackage struct {
+	ID                 bson.ObjectId `bson:"_id,omitempty"`
+	ResourceID         string        `json:",omitempty"`
+	ProviderID         string        `json:",omitempty"`
+	DeletedTime        time.Time
+	ScheduledPurgeTime time.Time
+	FencingToken       int64 `json:",omitempty"`
+	Resource           ResourcePackage
+}
+
+// DeletedResourcePackageDefinition is the deleted package definition
+type DeletedResourcePackageDefinition struct {
+	ID         string
+	Name       string
+	Type       string
+	Location   string
+	Tags       map[string]string
+	Properties DeletedResourcePackageProperties
+}
+
+// DeletedResourcePackageProperties is the deleted package definition properties
+type DeletedResourcePackageProperties struct {
+	ResourceID         string
+	ResourceType       string
+	ProviderType       string
+	ProviderID         string
+	DeletedTime        time.Time
+	ScheduledPurgeTime time.Time
+}
+
+// DeletedResourcePackageListDefinition is the list of deleted package definitions
+type DeletedResourcePackageListDefinition struct {
+	Value []DeletedResourcePackageDefinition
+}
+
+// ToDefinition returns the definition
+func (deletedResourcePackage *DeletedResourcePackage) ToDefinition() *DeletedResourcePackageDefinition {
+	return &DeletedResourcePackageDefinition{
+		ID:       deletedResourcePackage.ResourceID,
+		Name:     getResourceName(deletedResourcePackage.ResourceID),
+		Type:     consts.TerraformDeletedResourceType,
+		Location: deletedResourcePackage.Resource.Location,
+		Tags:     getTags(deletedResourcePackage.Resource.Tags),
+		Properties: DeletedResourcePackageProperties{
+			ResourceID:         deletedResourcePackage.ResourceID,
+			ResourceType:       deletedResourcePackage.Resource.ResourceType,
+			ProviderType:       deletedResourcePackage.Resource.ProviderType,
+			ProviderID:         deletedResourcePackage.ProviderID,
+			DeletedTime:        deletedResourcePackage.DeletedTime,
+			ScheduledPurgeTime: deletedResourcePackage.ScheduledPurgeTime,
+		},
+	}
+}
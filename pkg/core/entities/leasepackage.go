@@ -0,0 +1,22 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package entities
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// LeasePackage is the lease of a distributed lock stored in storage
+type LeasePackage struct {
+	ID           bson.ObjectId `bson:"_id,omitempty"`
+	LeaseID      string
+	Holder       string
+	FencingToken int64
+	ExpiresAt    time.Time
+	// CancelRequested is set when the operation run under the lease is canceled, the holder sees it when it renews the lease
+	CancelRequested bool
+}
@@ -0,0 +1,34 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package entities
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MoveJournalPackage is the journal of a move stored in storage before the documents are rewritten, it keeps the
+// documents as they were before the move so that a move interrupted half way can be undone
+type MoveJournalPackage struct {
+	ID                        bson.ObjectId `bson:"_id,omitempty"`
+	LockIDs                   []string
+	ProviderRegistrationMoves []ProviderRegistrationPackageMove
+	ResourceMoves             []ResourcePackageMove
+	ReferencingResources      []ResourcePackage
+	CreatedTime               time.Time
+}
+
+// ResourcePackageMove is the move of a resource document to its id in the target resource group
+type ResourcePackageMove struct {
+	ResourcePackage ResourcePackage
+	TargetID        string
+}
+
+// ProviderRegistrationPackageMove is the move of a provider registration document to its id in the target resource group
+type ProviderRegistrationPackageMove struct {
+	ProviderRegistrationPackage ProviderRegistrationPackage
+	TargetID                    string
+}
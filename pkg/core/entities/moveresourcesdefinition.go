@@ -0,0 +1,19 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package entities
+
+// MoveResourcesDefinition is the content ARM posts to validate or run the move of resources to another resource group
+type MoveResourcesDefinition struct {
+	// TargetResourceGroup is the id of the resource group, e.g. /subscriptions/{subscriptionId}/resourceGroups/{resourceGroup}
+	TargetResourceGroup string
+	Resources           []string
+}
+
+// ResourceMove is the move of a resource or provider registration from its id to the id in the target resource group
+type ResourceMove struct {
+	SourceID               string
+	TargetID               string
+	IsProviderRegistration bool
+}
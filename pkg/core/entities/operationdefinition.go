@@ -0,0 +1,30 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package entities
+
+// OperationListResult is the ARM operations discovery response, it lists the operations RBAC roles can grant
+type OperationListResult struct {
+	Value []OperationDefinition `json:"value"`
+}
+
+// OperationDefinition is an operation of the resource provider, e.g. Microsoft.TerraformOSS/resources/write
+type OperationDefinition struct {
+	Name    string           `json:"name"`
+	Display OperationDisplay `json:"display"`
+	Origin  string           `json:"origin,omitempty"`
+}
+
+// OperationDisplay is the localized display information of an operation
+type OperationDisplay struct {
+	Provider    string `json:"provider"`
+	Resource    string `json:"resource"`
+	Operation   string `json:"operation"`
+	Description string `json:"description"`
+}
+
+// LocationListResult is the list of locations resources can be created in
+type LocationListResult struct {
+	Value []string `json:"value"`
+}
@@ -0,0 +1,24 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package entities
+
+import "encoding/json"
+
+// PreflightDefinition is the content ARM posts to validate the resources of a template deployment before running it
+type PreflightDefinition struct {
+	Resources []PreflightResource
+}
+
+// PreflightResource is a resource of a template deployment, its properties are those of the resource type,
+// e.g. ResourceDefinitionProperties for Microsoft.TerraformOSS/resources
+type PreflightResource struct {
+	ID         string
+	Name       string
+	Type       string
+	APIVersion string
+	Location   string
+	Tags       map[string]string
+	Properties json.RawMessage
+}
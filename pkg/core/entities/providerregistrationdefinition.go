@@ -7,6 +7,7 @@ package entities
 // ProviderRegistrationDefinition is provider registration definition
 type ProviderRegistrationDefinition struct {
 	Location   string
+	Tags       map[string]string
 	Properties *PoviderRegistrationProperties
 }
 
@@ -14,4 +15,6 @@ type ProviderRegistrationDefinition struct {
 type PoviderRegistrationProperties struct {
 	ProviderType string
 	Settings     interface{}
+	// SoftDeleteRetentionDays is the number of days deleted resources are kept before they are purged, 0 disables soft delete
+	SoftDeleteRetentionDays int
 }
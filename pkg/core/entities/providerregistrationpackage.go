@@ -4,28 +4,49 @@
 
 package entities
 
-import "gopkg.in/mgo.v2/bson"
+import (
+	"TFRP/pkg/core/consts"
+
+	"gopkg.in/mgo.v2/bson"
+)
 
 // ProviderRegistrationPackage is the package stored in storage
 type ProviderRegistrationPackage struct {
-	ID           bson.ObjectId `bson:"_id,omitempty"`
-	ResourceID   string        `json:",omitempty"`
-	ProviderType string        `json:",omitempty"`
-	Settings     []byte        `json:",omitempty"`
+	ID                      bson.ObjectId     `bson:"_id,omitempty"`
+	Location                string            `json:",omitempty"`
+	Tags                    map[string]string `json:",omitempty"`
+	ResourceID              string            `json:",omitempty"`
+	ProviderType            string            `json:",omitempty"`
+	Settings                []byte            `json:",omitempty"`
+	SoftDeleteRetentionDays int               `json:",omitempty"`
+	// EncryptedSettings are the Settings as stored, encrypted with the key SettingsKeyID, they are never returned
+	EncryptedSettings []byte `json:"-"`
+	SettingsKeyID     string `json:"-"`
 }
 
 // ProviderRegistrationPackageDefinition is the package definition
 type ProviderRegistrationPackageDefinition struct {
+	ID         string `json:",omitempty"`
+	Name       string `json:",omitempty"`
+	Type       string `json:",omitempty"`
+	Location   string `json:",omitempty"`
+	Tags       map[string]string
 	Properties ProviderRegistrationPackage
 }
 
 // ToDefinition returns the definition
 func (providerRegistrationPackage *ProviderRegistrationPackage) ToDefinition() *ProviderRegistrationPackageDefinition {
 	return &ProviderRegistrationPackageDefinition{
+		ID:       providerRegistrationPackage.ResourceID,
+		Name:     getResourceName(providerRegistrationPackage.ResourceID),
+		Type:     consts.TerraformProviderRegistrationType,
+		Location: providerRegistrationPackage.Location,
+		Tags:     getTags(providerRegistrationPackage.Tags),
 		Properties: ProviderRegistrationPackage{
-			ID:           providerRegistrationPackage.ID,
-			ResourceID:   providerRegistrationPackage.ResourceID,
-			ProviderType: providerRegistrationPackage.ProviderType,
+			ID:                      providerRegistrationPackage.ID,
+			ResourceID:              providerRegistrationPackage.ResourceID,
+			ProviderType:            providerRegistrationPackage.ProviderType,
+			SoftDeleteRetentionDays: providerRegistrationPackage.SoftDeleteRetentionDays,
 		},
 	}
 }
@@ -7,9 +7,22 @@ package entities
 // ResourceDefinition is the resource definition
 type ResourceDefinition struct {
 	Location   string
+	Tags       map[string]string
 	Properties *ResourceDefinitionProperties
 }
 
+// ResourcePatchDefinition is the resource definition of a PATCH, the tags replace the stored tags if set
+// and the settings are merged into the stored settings
+type ResourcePatchDefinition struct {
+	Tags       map[string]string
+	Properties *ResourcePatchDefinitionProperties
+}
+
+// ResourcePatchDefinitionProperties is the resource patch definition properties
+type ResourcePatchDefinitionProperties struct {
+	Settings interface{}
+}
+
 // ResourceDefinitionProperties is the resouce definition properties
 type ResourceDefinitionProperties struct {
 	ProviderID   string
@@ -0,0 +1,66 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package entities
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ResourceEventData is the data of the events published on the state changes of a resource
+type ResourceEventData struct {
+	ResourceID        string `json:"resourceId"`
+	ResourceType      string `json:"resourceType"`
+	ProviderID        string `json:"providerId"`
+	ProvisioningState string `json:"provisioningState"`
+	ErrorCode         string `json:"errorCode,omitempty"`
+	ErrorMessage      string `json:"errorMessage,omitempty"`
+}
+
+// ResourceEvent is an event published on the state change of a resource
+type ResourceEvent struct {
+	ID        string
+	EventType string
+	Time      time.Time
+	Data      ResourceEventData
+}
+
+// EventGridEvent is an event in the Event Grid schema
+type EventGridEvent struct {
+	ID              string            `json:"id"`
+	Topic           string            `json:"topic,omitempty"`
+	Subject         string            `json:"subject"`
+	EventType       string            `json:"eventType"`
+	EventTime       time.Time         `json:"eventTime"`
+	Data            ResourceEventData `json:"data"`
+	DataVersion     string            `json:"dataVersion"`
+	MetadataVersion string            `json:"metadataVersion"`
+}
+
+// CloudEvent is an event in the structured JSON format of CloudEvents 1.0
+type CloudEvent struct {
+	SpecVersion     string            `json:"specversion"`
+	Type            string            `json:"type"`
+	Source          string            `json:"source"`
+	ID              string            `json:"id"`
+	Time            time.Time         `json:"time"`
+	Subject         string            `json:"subject"`
+	DataContentType string            `json:"datacontenttype"`
+	Data            ResourceEventData `json:"data"`
+}
+
+// DeadLetterEventPackage is an event which could not be delivered to a webhook, stored in storage
+type DeadLetterEventPackage struct {
+	ID         bson.ObjectId `bson:"_id,omitempty"`
+	EventID    string
+	EventType  string
+	ResourceID string
+	Webhook    string
+	Content    string
+	Attempts   int
+	LastError  string
+	Time       time.Time
+}
@@ -6,6 +6,7 @@ package entities
 
 import (
 	"TFRP/pkg/core/consts"
+	"time"
 
 	"github.com/hashicorp/terraform/terraform"
 	"gopkg.in/mgo.v2/bson"
@@ -13,30 +14,47 @@ import (
 
 // ResourcePackage is the package stored in storag
 type ResourcePackage struct {
-	ID                       bson.ObjectId `bson:"_id,omitempty"`
-	Location                 string        `json:",omitempty"`
-	ResourceID               string        `json:",omitempty"`
-	StateID                  string        `json:",omitempty"`
-	State                    *terraform.InstanceState
-	ProvisioningState        string `json:",omitempty"`
-	ProvisioningErrorCode    string `json:",omitempty"`
-	ProvisioningErrorMessage string `json:",omitempty"`
-	Config                   string `json:",omitempty"`
-	ResourceType             string `json:",omitempty"`
-	ProviderType             string `json:",omitempty"`
+	ID                          bson.ObjectId     `bson:"_id,omitempty"`
+	Location                    string            `json:",omitempty"`
+	Tags                        map[string]string `json:",omitempty"`
+	ResourceID                  string            `json:",omitempty"`
+	StateID                     string            `json:",omitempty"`
+	State                       *terraform.InstanceState
+	SchemaVersion               int        `json:",omitempty"`
+	ProvisioningState           string     `json:",omitempty"`
+	ProvisioningErrorCode       string     `json:",omitempty"`
+	ProvisioningErrorDetailCode string     `json:",omitempty"`
+	ProvisioningErrorMessage    string     `json:",omitempty"`
+	OperationAttempts           int        `json:",omitempty"`
+	OperationLastError          string     `json:",omitempty"`
+	Resumable                   bool       `json:",omitempty"`
+	Config                      string     `json:",omitempty"`
+	ResourceName                string     `json:",omitempty"`
+	Settings                    []byte     `json:",omitempty"`
+	ResourceType                string     `json:",omitempty"`
+	ProviderType                string     `json:",omitempty"`
+	ProviderID                  string     `json:",omitempty"`
+	FencingToken                int64      `json:",omitempty"`
+	LastRefreshedAt             *time.Time `json:",omitempty"`
 }
 
 // ResourcePackageDefinition is the package definition
 type ResourcePackageDefinition struct {
+	ID         string
+	Name       string
 	Type       string
 	Location   string
+	Tags       map[string]string
 	Properties ResourcePackage
 }
 
 // ToDefinition returns the definition
 func (resourcePackage *ResourcePackage) ToDefinition() *ResourcePackageDefinition {
 	return &ResourcePackageDefinition{
+		ID:       resourcePackage.ResourceID,
+		Name:     getResourceName(resourcePackage.ResourceID),
 		Location: resourcePackage.Location,
+		Tags:     getTags(resourcePackage.Tags),
 		Type:     consts.TerraformResourceType,
 		Properties: ResourcePackage{
 			ID:                resourcePackage.ID,
@@ -46,17 +64,37 @@ func (resourcePackage *ResourcePackage) ToDefinition() *ResourcePackageDefinitio
 			ProvisioningState: resourcePackage.ProvisioningState,
 			ResourceType:      resourcePackage.ResourceType,
 			ProviderType:      resourcePackage.ProviderType,
+			ProviderID:        resourcePackage.ProviderID,
+			LastRefreshedAt:   resourcePackage.LastRefreshedAt,
 		},
 	}
 }
 
 // ToAsyncOperationResult returns the AsyncOperationResult
 func (resourcePackage *ResourcePackage) ToAsyncOperationResult() *AsyncOperationResult {
-	return &AsyncOperationResult{
+	asyncOperationResult := &AsyncOperationResult{
 		Status: resourcePackage.ProvisioningState,
 		Error: &ExtendedErrorInfo{
 			Code:    resourcePackage.ProvisioningErrorCode,
 			Message: resourcePackage.ProvisioningErrorMessage,
 		},
 	}
+
+	if resourcePackage.OperationAttempts > 0 {
+		asyncOperationResult.Properties = &AsyncOperationProperties{
+			Attempts:  resourcePackage.OperationAttempts,
+			LastError: resourcePackage.OperationLastError,
+		}
+	}
+
+	if len(resourcePackage.ProvisioningErrorDetailCode) > 0 {
+		asyncOperationResult.Error.Details = []ExtendedErrorInfo{
+			{
+				Code:    resourcePackage.ProvisioningErrorDetailCode,
+				Message: resourcePackage.ProvisioningErrorMessage,
+			},
+		}
+	}
+
+	return asyncOperationResult
 }
@@ -0,0 +1,35 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package entities
+
+// Settings re-encryption statuses
+const (
+	SettingsReEncryptionStatusReEncrypted = "ReEncrypted"
+	SettingsReEncryptionStatusFailed      = "Failed"
+)
+
+// SettingsReEncryptionReport is the report of the re-encryption of the settings of provider registrations
+type SettingsReEncryptionReport struct {
+	KeyID                 string
+	Counts                map[string]int
+	ProviderRegistrations []SettingsReEncryptionResult
+}
+
+// SettingsReEncryptionResult is the result of the re-encryption of one provider registration
+type SettingsReEncryptionResult struct {
+	ResourceID string
+	Status     string
+	Error      string `json:",omitempty"`
+}
+
+// Add adds the result of a provider registration to the report
+func (settingsReEncryptionReport *SettingsReEncryptionReport) Add(settingsReEncryptionResult SettingsReEncryptionResult) {
+	if settingsReEncryptionReport.Counts == nil {
+		settingsReEncryptionReport.Counts = make(map[string]int)
+	}
+
+	settingsReEncryptionReport.Counts[settingsReEncryptionResult.Status]++
+	settingsReEncryptionReport.ProviderRegistrations = append(settingsReEncryptionReport.ProviderRegistrations, settingsReEncryptionResult)
+}
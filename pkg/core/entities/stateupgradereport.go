@@ -0,0 +1,42 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package entities
+
+// State upgrade statuses
+const (
+	StateUpgradeStatusUpToDate     = "UpToDate"
+	StateUpgradeStatusWouldUpgrade = "WouldUpgrade"
+	StateUpgradeStatusUpgraded     = "Upgraded"
+	StateUpgradeStatusSkipped      = "Skipped"
+	StateUpgradeStatusFailed       = "Failed"
+)
+
+// StateUpgradeReport is the report of a bulk state upgrade
+type StateUpgradeReport struct {
+	DryRun    bool
+	Counts    map[string]int
+	Resources []StateUpgradeResult
+}
+
+// StateUpgradeResult is the result of the state upgrade of one resource
+type StateUpgradeResult struct {
+	ResourceID        string
+	ResourceType      string `json:",omitempty"`
+	ProviderType      string `json:",omitempty"`
+	FromSchemaVersion int
+	ToSchemaVersion   int
+	Status            string
+	Error             string `json:",omitempty"`
+}
+
+// Add adds the result of a resource to the report
+func (stateUpgradeReport *StateUpgradeReport) Add(stateUpgradeResult StateUpgradeResult) {
+	if stateUpgradeReport.Counts == nil {
+		stateUpgradeReport.Counts = make(map[string]int)
+	}
+
+	stateUpgradeReport.Counts[stateUpgradeResult.Status]++
+	stateUpgradeReport.Resources = append(stateUpgradeReport.Resources, stateUpgradeResult)
+}
@@ -0,0 +1,15 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package entities
+
+import "encoding/json"
+
+// TerraformExport is the terraform configuration and state of the resources of a resource group,
+// the fields are named after the files they are written to
+type TerraformExport struct {
+	Configuration    json.RawMessage `json:"main.tf.json"`
+	State            json.RawMessage `json:"terraform.tfstate"`
+	SkippedResources []string        `json:"skippedResources,omitempty"`
+}
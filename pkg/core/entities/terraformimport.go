@@ -0,0 +1,91 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package entities
+
+import (
+	"encoding/json"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Terraform import statuses
+const (
+	TerraformImportStatusImported = "Imported"
+	TerraformImportStatusNotFound = "NotFound"
+	TerraformImportStatusConflict = "Conflict"
+	TerraformImportStatusFailed   = "Failed"
+)
+
+// TerraformImportOperationPackage is the asynchronous operation of a terraform import stored in storage,
+// the results of the resources are added as they are imported
+type TerraformImportOperationPackage struct {
+	ID               bson.ObjectId `bson:"_id,omitempty"`
+	OperationID      string
+	Status           string
+	ErrorCode        string `json:",omitempty"`
+	ErrorMessage     string `json:",omitempty"`
+	Result           TerraformImportResult
+	CreatedTime      time.Time
+	LastModifiedTime time.Time
+}
+
+// TerraformImportDefinition is the terraform state to import and the mapping of its resources to resources of the resource group
+type TerraformImportDefinition struct {
+	State     json.RawMessage
+	Resources []TerraformImportResource
+}
+
+// TerraformImportResource maps a resource address of the terraform state to a resource
+type TerraformImportResource struct {
+	Address      string
+	ResourceName string
+	ProviderID   string
+	Location     string
+	Tags         map[string]string
+	// Settings are the settings of the resource, they are read from the state if empty
+	Settings interface{}
+}
+
+// TerraformImportResult is the result of a terraform import
+type TerraformImportResult struct {
+	Counts    map[string]int
+	Resources []TerraformImportResourceResult
+}
+
+// TerraformImportResourceResult is the import result of one resource
+type TerraformImportResourceResult struct {
+	Address    string
+	ResourceID string `json:",omitempty"`
+	Status     string
+	Error      string `json:",omitempty"`
+}
+
+// Add adds the result of a resource to the import result
+func (terraformImportResult *TerraformImportResult) Add(terraformImportResourceResult TerraformImportResourceResult) {
+	if terraformImportResult.Counts == nil {
+		terraformImportResult.Counts = make(map[string]int)
+	}
+
+	terraformImportResult.Counts[terraformImportResourceResult.Status]++
+	terraformImportResult.Resources = append(terraformImportResult.Resources, terraformImportResourceResult)
+}
+
+// ToAsyncOperationResult returns the AsyncOperationResult of a terraform import, the properties hold the results
+// of the resources imported so far
+func (terraformImportOperationPackage *TerraformImportOperationPackage) ToAsyncOperationResult() *AsyncOperationResult {
+	asyncOperationResult := &AsyncOperationResult{
+		Status: terraformImportOperationPackage.Status,
+		Error: &ExtendedErrorInfo{
+			Code:    terraformImportOperationPackage.ErrorCode,
+			Message: terraformImportOperationPackage.ErrorMessage,
+		},
+		Properties: &AsyncOperationProperties{
+			Import: &terraformImportOperationPackage.Result,
+		},
+	}
+
+	return asyncOperationResult
+}
@@ -0,0 +1,48 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package metrics
+
+import (
+	"expvar"
+	"strings"
+	"time"
+)
+
+// Metrics are exported as expvars under /debug/vars of the insecure address
+var (
+	// ThrottlingDecisions counts throttling decisions by scope, request kind and decision
+	ThrottlingDecisions = expvar.NewMap("throttlingDecisions")
+	// TLSCertificateNotAfter is the expiry of the served TLS certificate in unix seconds
+	TLSCertificateNotAfter = expvar.NewInt("tlsCertificateNotAfter")
+	// TLSCertificateExpiresInSeconds is how long until the served TLS certificate expires
+	TLSCertificateExpiresInSeconds = expvar.Func(func() interface{} {
+		return TLSCertificateNotAfter.Value() - time.Now().Unix()
+	})
+)
+
+func init() {
+	expvar.Publish("tlsCertificateExpiresInSeconds", TLSCertificateExpiresInSeconds)
+}
+
+const (
+	// ThrottlingDecisionAllowed is the decision of a request within budget
+	ThrottlingDecisionAllowed = "allowed"
+	// ThrottlingDecisionThrottled is the decision of a request over budget
+	ThrottlingDecisionThrottled = "throttled"
+)
+
+// AddThrottlingDecision counts a throttling decision
+func AddThrottlingDecision(scope, kind, decision string) {
+	ThrottlingDecisions.Add(getKey(scope, kind, decision), 1)
+}
+
+// SetTLSCertificateExpiry records the expiry of the served TLS certificate
+func SetTLSCertificateExpiry(notAfter time.Time) {
+	TLSCertificateNotAfter.Set(notAfter.Unix())
+}
+
+func getKey(parts ...string) string {
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,40 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package storage
+
+import (
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/entities"
+	"context"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// AdminAuditDataProvider is the data provider of the audit records of the admin API
+type AdminAuditDataProvider struct {
+	BaseDataProvider
+}
+
+// NewAdminAuditDataProvider creates a new admin audit data provider
+func NewAdminAuditDataProvider(database, password string) (adminAuditDataProvider *AdminAuditDataProvider) {
+	adminAuditDataProvider = new(AdminAuditDataProvider)
+	adminAuditDataProvider.Database = database
+	adminAuditDataProvider.Password = password
+	return adminAuditDataProvider
+}
+
+// InsertRecord inserts a record into collection, a record with an id replaces the stored record
+func (adminAuditDataProvider *AdminAuditDataProvider) InsertRecord(ctx context.Context, doc *entities.AdminAuditRecord) error {
+	if len(doc.ID) == 0 {
+		doc.ID = bson.NewObjectId()
+	}
+
+	return adminAuditDataProvider.Insert(ctx, consts.AdminAuditCollectionName, bson.M{"_id": doc.ID}, doc)
+}
+
+// EnsureIndexes creates the index on the resource ids of the records
+func (adminAuditDataProvider *AdminAuditDataProvider) EnsureIndexes() error {
+	return adminAuditDataProvider.EnsureIndex(consts.AdminAuditCollectionName, "resourceid")
+}
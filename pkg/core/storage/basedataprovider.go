@@ -5,14 +5,23 @@
 package storage
 
 import (
+	"TFRP/pkg/core/tracing"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"log"
 	"net"
+	"strings"
 	"time"
 
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
+// ErrStaleFencingToken is returned when a resource was written by the holder of a newer lock
+var ErrStaleFencingToken = errors.New("The resource was modified by the holder of a newer lock")
+
 // BaseDataProvider is the base struc of all data providers
 type BaseDataProvider struct {
 	Database string
@@ -20,7 +29,10 @@ type BaseDataProvider struct {
 }
 
 // Insert inserts a doc into collection
-func (baseDataProvider *BaseDataProvider) Insert(collectionName string, id interface{}, doc interface{}) error {
+func (baseDataProvider *BaseDataProvider) Insert(ctx context.Context, collectionName string, id interface{}, doc interface{}) (err error) {
+	span := baseDataProvider.startSpan(ctx, "upsert", collectionName)
+	defer endSpan(span, &err)
+
 	// Get session
 	session, err := baseDataProvider.getDocDBSession()
 	if err != nil {
@@ -43,8 +55,71 @@ func (baseDataProvider *BaseDataProvider) Insert(collectionName string, id inter
 	return err
 }
 
+// InsertFenced inserts a doc into collection unless the stored doc was written with a newer fencing token, it returns
+// ErrStaleFencingToken if the fencing token is stale. The stored doc is only replaced if its token is not newer and
+// the doc is only inserted if none is stored, so that a stale write cannot duplicate a doc whether the id is unique or not.
+func (baseDataProvider *BaseDataProvider) InsertFenced(ctx context.Context, collectionName string, id bson.M, fencingToken int64, doc interface{}) (err error) {
+	span := baseDataProvider.startSpan(ctx, "update", collectionName)
+	defer endSpan(span, &err)
+
+	// Get session
+	session, err := baseDataProvider.getDocDBSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	// SetSafe changes the session safety mode.
+	// If the safe parameter is nil, the session is put in unsafe mode, and writes become fire-and-forget,
+	// without error checking. The unsafe mode is faster since operations won't hold on waiting for a confirmation.
+	// http://godoc.org/labix.org/v2/mgo#Session.SetMode.
+	session.SetSafe(&mgo.Safe{})
+
+	// get collection
+	collection := session.DB(baseDataProvider.Database).C(collectionName)
+
+	fencedQuery := bson.M{
+		"$or": []bson.M{
+			{"fencingtoken": bson.M{"$lte": fencingToken}},
+			{"fencingtoken": bson.M{"$exists": false}},
+		},
+	}
+	for key, value := range id {
+		fencedQuery[key] = value
+	}
+
+	err = collection.Update(fencedQuery, doc)
+	if err != mgo.ErrNotFound {
+		return err
+	}
+
+	// The doc is either written with a newer fencing token or not stored yet
+	count, err := collection.Find(id).Count()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrStaleFencingToken
+	}
+
+	err = collection.Insert(doc)
+	if mgo.IsDup(err) {
+		// Another writer inserted the doc first, it is replaced only if that writer's token is not newer
+		err = collection.Update(fencedQuery, doc)
+		if err == mgo.ErrNotFound {
+			return ErrStaleFencingToken
+		}
+	}
+
+	return err
+}
+
 // Find returns a doc from collection
-func (baseDataProvider *BaseDataProvider) Find(collectionName string, qurey interface{}, result interface{}) error {
+func (baseDataProvider *BaseDataProvider) Find(ctx context.Context, collectionName string, qurey interface{}, result interface{}) (err error) {
+	span := baseDataProvider.startSpan(ctx, "find", collectionName)
+	defer endSpan(span, &err)
+
 	// Get session
 	session, err := baseDataProvider.getDocDBSession()
 	if err != nil {
@@ -67,8 +142,139 @@ func (baseDataProvider *BaseDataProvider) Find(collectionName string, qurey inte
 	return err
 }
 
+// FindAll returns all docs matching the query from collection
+func (baseDataProvider *BaseDataProvider) FindAll(ctx context.Context, collectionName string, qurey interface{}, result interface{}) (err error) {
+	span := baseDataProvider.startSpan(ctx, "find", collectionName)
+	defer endSpan(span, &err)
+
+	// Get session
+	session, err := baseDataProvider.getDocDBSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	// SetSafe changes the session safety mode.
+	// If the safe parameter is nil, the session is put in unsafe mode, and writes become fire-and-forget,
+	// without error checking. The unsafe mode is faster since operations won't hold on waiting for a confirmation.
+	// http://godoc.org/labix.org/v2/mgo#Session.SetMode.
+	session.SetSafe(&mgo.Safe{})
+
+	// get collection
+	collection := session.DB(baseDataProvider.Database).C(collectionName)
+
+	err = collection.Find(qurey).All(result)
+
+	return err
+}
+
+// FindAndModify atomically applies the change to the doc matching the query and returns the doc
+func (baseDataProvider *BaseDataProvider) FindAndModify(ctx context.Context, collectionName string, qurey interface{}, change mgo.Change, result interface{}) (err error) {
+	span := baseDataProvider.startSpan(ctx, "findAndModify", collectionName)
+	defer endSpan(span, &err)
+
+	// Get session
+	session, err := baseDataProvider.getDocDBSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	// SetSafe changes the session safety mode.
+	// If the safe parameter is nil, the session is put in unsafe mode, and writes become fire-and-forget,
+	// without error checking. The unsafe mode is faster since operations won't hold on waiting for a confirmation.
+	// http://godoc.org/labix.org/v2/mgo#Session.SetMode.
+	session.SetSafe(&mgo.Safe{})
+
+	// get collection
+	collection := session.DB(baseDataProvider.Database).C(collectionName)
+
+	_, err = collection.Find(qurey).Apply(change, result)
+
+	return err
+}
+
+// RemoveAll deletes all docs matching the query from collection
+func (baseDataProvider *BaseDataProvider) RemoveAll(ctx context.Context, collectionName string, qurey interface{}) (err error) {
+	span := baseDataProvider.startSpan(ctx, "delete", collectionName)
+	defer endSpan(span, &err)
+
+	// Get session
+	session, err := baseDataProvider.getDocDBSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	// SetSafe changes the session safety mode.
+	// If the safe parameter is nil, the session is put in unsafe mode, and writes become fire-and-forget,
+	// without error checking. The unsafe mode is faster since operations won't hold on waiting for a confirmation.
+	// http://godoc.org/labix.org/v2/mgo#Session.SetMode.
+	session.SetSafe(&mgo.Safe{})
+
+	// get collection
+	collection := session.DB(baseDataProvider.Database).C(collectionName)
+
+	_, err = collection.RemoveAll(qurey)
+
+	return err
+}
+
+// EnsureUniqueIndex creates a unique index on the keys of collection if it does not exist
+func (baseDataProvider *BaseDataProvider) EnsureUniqueIndex(collectionName string, keys ...string) error {
+	// Get session
+	session, err := baseDataProvider.getDocDBSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	// get collection
+	collection := session.DB(baseDataProvider.Database).C(collectionName)
+
+	return collection.EnsureIndex(mgo.Index{Key: keys, Unique: true, Background: true})
+}
+
+// EnsureUniqueIndexOrIndex creates a unique index on the keys of collection, or a plain index if the unique index cannot
+// be created. Cosmos DB only creates unique indexes on empty collections, so collections which were filled before
+// the unique index was introduced keep a plain index. Fenced writes do not rely on the unique index.
+func (baseDataProvider *BaseDataProvider) EnsureUniqueIndexOrIndex(collectionName string, keys ...string) error {
+	err := baseDataProvider.EnsureUniqueIndex(collectionName, keys...)
+	if err == nil {
+		return nil
+	}
+
+	log.Printf("WARNING: Failed to create the unique index on %s of collection %s, a plain index is created instead: %s. "+
+		"Documents first inserted concurrently may be duplicated, "+
+		"migrate the documents to a new collection with the unique index to prevent it.", strings.Join(keys, ","), collectionName, err)
+	return baseDataProvider.EnsureIndex(collectionName, keys...)
+}
+
+// EnsureIndex creates an index on the keys of collection if it does not exist
+func (baseDataProvider *BaseDataProvider) EnsureIndex(collectionName string, keys ...string) error {
+	// Get session
+	session, err := baseDataProvider.getDocDBSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	// get collection
+	collection := session.DB(baseDataProvider.Database).C(collectionName)
+
+	return collection.EnsureIndex(mgo.Index{Key: keys, Background: true})
+}
+
 // Remove deletes a doc from collection
-func (baseDataProvider *BaseDataProvider) Remove(collectionName string, qurey interface{}) error {
+func (baseDataProvider *BaseDataProvider) Remove(ctx context.Context, collectionName string, qurey interface{}) (err error) {
+	span := baseDataProvider.startSpan(ctx, "delete", collectionName)
+	defer endSpan(span, &err)
+
 	// Get session
 	session, err := baseDataProvider.getDocDBSession()
 	if err != nil {
@@ -91,6 +297,42 @@ func (baseDataProvider *BaseDataProvider) Remove(collectionName string, qurey in
 	return err
 }
 
+// Ping checks the storage is reachable
+func (baseDataProvider *BaseDataProvider) Ping() error {
+	// Get session
+	session, err := baseDataProvider.getDocDBSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	return session.Ping()
+}
+
+// startSpan traces a storage call on a collection, the span includes dialing the session
+func (baseDataProvider *BaseDataProvider) startSpan(ctx context.Context, operation string, collectionName string) *tracing.Span {
+	_, span := tracing.Start(ctx, operation+" "+collectionName, tracing.SpanKindClient)
+	span.SetAttribute("db.system", "mongodb")
+	span.SetAttribute("db.name", baseDataProvider.Database)
+	span.SetAttribute("db.operation", operation)
+	span.SetAttribute("db.mongodb.collection", collectionName)
+	return span
+}
+
+// IsNotFound returns whether an error is returned because no doc matches the query
+func IsNotFound(err error) bool {
+	return err == mgo.ErrNotFound
+}
+
+// endSpan ends the span of a storage call, a doc which is not found is not an error of the call
+func endSpan(span *tracing.Span, err *error) {
+	if *err != mgo.ErrNotFound {
+		span.RecordError(*err)
+	}
+	span.End()
+}
+
 func (baseDataProvider *BaseDataProvider) getDocDBSession() (*mgo.Session, error) {
 	// DialInfo holds options for establishing a session with a MongoDB cluster.
 	dialInfo := &mgo.DialInfo{
@@ -0,0 +1,40 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package storage
+
+import (
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/entities"
+	"context"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DeadLetterEventDataProvider is the data provider of the events which could not be delivered
+type DeadLetterEventDataProvider struct {
+	BaseDataProvider
+}
+
+// NewDeadLetterEventDataProvider creates a new dead letter event data provider
+func NewDeadLetterEventDataProvider(database, password string) (deadLetterEventDataProvider *DeadLetterEventDataProvider) {
+	deadLetterEventDataProvider = new(DeadLetterEventDataProvider)
+	deadLetterEventDataProvider.Database = database
+	deadLetterEventDataProvider.Password = password
+	return deadLetterEventDataProvider
+}
+
+// InsertPackage inserts a dead lettered event into collection
+func (deadLetterEventDataProvider *DeadLetterEventDataProvider) InsertPackage(ctx context.Context, doc *entities.DeadLetterEventPackage) error {
+	if len(doc.ID) == 0 {
+		doc.ID = bson.NewObjectId()
+	}
+
+	return deadLetterEventDataProvider.Insert(ctx, consts.DeadLetterEventCollectionName, bson.M{"_id": doc.ID}, doc)
+}
+
+// EnsureIndexes creates the index on the resource ids of the dead lettered events
+func (deadLetterEventDataProvider *DeadLetterEventDataProvider) EnsureIndexes() error {
+	return deadLetterEventDataProvider.EnsureIndex(consts.DeadLetterEventCollectionName, "resourceid")
+}
@@ -0,0 +1,82 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package storage
+
+import (
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/entities"
+	"context"
+	"regexp"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DeletedResourceDataProvider is the data provider of the soft deleted resources
+type DeletedResourceDataProvider struct {
+	BaseDataProvider
+}
+
+// NewDeletedResourceDataProvider creates a new deleted resource data provider
+func NewDeletedResourceDataProvider(database, password string) (deletedResourceDataProvider *DeletedResourceDataProvider) {
+	deletedResourceDataProvider = new(DeletedResourceDataProvider)
+	deletedResourceDataProvider.Database = database
+	deletedResourceDataProvider.Password = password
+	return deletedResourceDataProvider
+}
+
+// InsertPackage inserts a doc into collection
+func (deletedResourceDataProvider *DeletedResourceDataProvider) InsertPackage(ctx context.Context, doc *entities.DeletedResourcePackage) error {
+	return deletedResourceDataProvider.Insert(ctx, consts.DeletedResourceCollectionName, bson.M{"resourceid": doc.ResourceID}, doc)
+}
+
+// InsertFencedPackage inserts a doc into collection unless a holder of a newer lease on the resource wrote it,
+// it returns ErrStaleFencingToken if the fencing token is stale
+func (deletedResourceDataProvider *DeletedResourceDataProvider) InsertFencedPackage(ctx context.Context, doc *entities.DeletedResourcePackage, fencingToken int64) error {
+	doc.FencingToken = fencingToken
+	return deletedResourceDataProvider.InsertFenced(ctx, consts.DeletedResourceCollectionName, bson.M{"resourceid": doc.ResourceID}, fencingToken, doc)
+}
+
+// FindPackage returns a doc from colletion
+func (deletedResourceDataProvider *DeletedResourceDataProvider) FindPackage(ctx context.Context, resourceID string, result interface{}) error {
+	return deletedResourceDataProvider.Find(ctx, consts.DeletedResourceCollectionName, bson.M{"resourceid": resourceID}, result)
+}
+
+// RemovePackage deletes a doc from collection
+func (deletedResourceDataProvider *DeletedResourceDataProvider) RemovePackage(ctx context.Context, resourceID string) error {
+	return deletedResourceDataProvider.Remove(ctx, consts.DeletedResourceCollectionName, bson.M{"resourceid": resourceID})
+}
+
+// FindPackagesByResourceIDPrefix returns the docs of the deleted resources whose ids start with a prefix, ignoring case, from collection
+func (deletedResourceDataProvider *DeletedResourceDataProvider) FindPackagesByResourceIDPrefix(ctx context.Context, resourceIDPrefix string, result interface{}) error {
+	query := bson.M{"resourceid": bson.RegEx{Pattern: "^" + regexp.QuoteMeta(resourceIDPrefix), Options: "i"}}
+	return deletedResourceDataProvider.FindAll(ctx, consts.DeletedResourceCollectionName, query, result)
+}
+
+// FindPackagesByProviderID returns the docs of the deleted resources referencing a provider registration from collection
+func (deletedResourceDataProvider *DeletedResourceDataProvider) FindPackagesByProviderID(ctx context.Context, providerID string, result interface{}) error {
+	return deletedResourceDataProvider.FindAll(ctx, consts.DeletedResourceCollectionName, bson.M{"providerid": providerID}, result)
+}
+
+// FindExpiredPackages returns the docs of the deleted resources whose retention expired by a time from collection
+func (deletedResourceDataProvider *DeletedResourceDataProvider) FindExpiredPackages(ctx context.Context, now time.Time, result interface{}) error {
+	return deletedResourceDataProvider.FindAll(ctx, consts.DeletedResourceCollectionName, bson.M{"scheduledpurgetime": bson.M{"$lte": now}}, result)
+}
+
+// EnsureIndexes creates the unique index on resource ids, a plain one if the collection cannot have it,
+// the reverse index from provider registrations and the index on the purge times
+func (deletedResourceDataProvider *DeletedResourceDataProvider) EnsureIndexes() error {
+	err := deletedResourceDataProvider.EnsureUniqueIndexOrIndex(consts.DeletedResourceCollectionName, "resourceid")
+	if err != nil {
+		return err
+	}
+
+	err = deletedResourceDataProvider.EnsureIndex(consts.DeletedResourceCollectionName, "providerid")
+	if err != nil {
+		return err
+	}
+
+	return deletedResourceDataProvider.EnsureIndex(consts.DeletedResourceCollectionName, "scheduledpurgetime")
+}
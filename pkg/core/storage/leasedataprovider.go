@@ -0,0 +1,146 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package storage
+
+import (
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/entities"
+	"context"
+	"errors"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrLeaseHeld is returned when a lease is held by another holder
+var ErrLeaseHeld = errors.New("The lease is held by another holder")
+
+// ErrLeaseLost is returned when a lease expired and was taken by another holder
+var ErrLeaseLost = errors.New("The lease was lost")
+
+// LeaseDataProvider is the data provider of distributed lock leases
+type LeaseDataProvider struct {
+	BaseDataProvider
+}
+
+// NewLeaseDataProvider creates a new lease data provider
+func NewLeaseDataProvider(database, password string) (leaseDataProvider *LeaseDataProvider) {
+	leaseDataProvider = new(LeaseDataProvider)
+	leaseDataProvider.Database = database
+	leaseDataProvider.Password = password
+	return leaseDataProvider
+}
+
+// EnsureIndexes creates the unique index on lease ids which makes taking a lease atomic
+func (leaseDataProvider *LeaseDataProvider) EnsureIndexes() error {
+	return leaseDataProvider.EnsureUniqueIndex(consts.LeaseCollectionName, "leaseid")
+}
+
+// AcquireLease takes a lease which is free or expired and increments its fencing token,
+// it returns ErrLeaseHeld if the lease is held by another holder
+func (leaseDataProvider *LeaseDataProvider) AcquireLease(ctx context.Context, leaseID, holder string, ttl time.Duration) (*entities.LeasePackage, error) {
+	now := time.Now().UTC()
+	query := bson.M{
+		"leaseid":   leaseID,
+		"expiresat": bson.M{"$lte": now},
+	}
+	change := mgo.Change{
+		Update: bson.M{
+			"$set": bson.M{"holder": holder, "expiresat": now.Add(ttl), "cancelrequested": false},
+			"$inc": bson.M{"fencingtoken": 1},
+		},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+
+	// A held lease does not match the query and the upsert fails on the unique lease id index
+	lease := entities.LeasePackage{}
+	err := leaseDataProvider.FindAndModify(ctx, consts.LeaseCollectionName, query, change, &lease)
+	if mgo.IsDup(err) {
+		return nil, ErrLeaseHeld
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &lease, nil
+}
+
+// BreakLease takes a lease whoever holds it and increments its fencing token, the previous holder loses the lease
+// and its writes are fenced off
+func (leaseDataProvider *LeaseDataProvider) BreakLease(ctx context.Context, leaseID, holder string, ttl time.Duration) (*entities.LeasePackage, error) {
+	change := mgo.Change{
+		Update: bson.M{
+			"$set": bson.M{"holder": holder, "expiresat": time.Now().UTC().Add(ttl), "cancelrequested": false},
+			"$inc": bson.M{"fencingtoken": 1},
+		},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+
+	lease := entities.LeasePackage{}
+	err := leaseDataProvider.FindAndModify(ctx, consts.LeaseCollectionName, bson.M{"leaseid": leaseID}, change, &lease)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lease, nil
+}
+
+// RenewLease extends a lease held by the holder, it returns ErrLeaseLost if the lease was taken by another holder
+func (leaseDataProvider *LeaseDataProvider) RenewLease(ctx context.Context, lease *entities.LeasePackage, ttl time.Duration) error {
+	query := bson.M{
+		"leaseid":      lease.LeaseID,
+		"holder":       lease.Holder,
+		"fencingtoken": lease.FencingToken,
+	}
+	change := mgo.Change{
+		Update:    bson.M{"$set": bson.M{"expiresat": time.Now().UTC().Add(ttl)}},
+		ReturnNew: true,
+	}
+
+	err := leaseDataProvider.FindAndModify(ctx, consts.LeaseCollectionName, query, change, lease)
+	if err == mgo.ErrNotFound {
+		return ErrLeaseLost
+	}
+
+	return err
+}
+
+// RequestCancel asks the holder of a lease to cancel the operation it runs under the lease, whichever replica holds it
+func (leaseDataProvider *LeaseDataProvider) RequestCancel(ctx context.Context, leaseID string) error {
+	change := mgo.Change{
+		Update: bson.M{"$set": bson.M{"cancelrequested": true}},
+	}
+
+	err := leaseDataProvider.FindAndModify(ctx, consts.LeaseCollectionName, bson.M{"leaseid": leaseID}, change, &entities.LeasePackage{})
+	if err == mgo.ErrNotFound {
+		// The lease was never taken, there is nothing to cancel
+		return nil
+	}
+
+	return err
+}
+
+// ReleaseLease releases a lease held by the holder
+func (leaseDataProvider *LeaseDataProvider) ReleaseLease(ctx context.Context, lease *entities.LeasePackage) error {
+	query := bson.M{
+		"leaseid":      lease.LeaseID,
+		"holder":       lease.Holder,
+		"fencingtoken": lease.FencingToken,
+	}
+	change := mgo.Change{
+		Update: bson.M{"$set": bson.M{"expiresat": time.Time{}}},
+	}
+
+	// The lease document is kept so that the next holder gets a greater fencing token
+	err := leaseDataProvider.FindAndModify(ctx, consts.LeaseCollectionName, query, change, &entities.LeasePackage{})
+	if err == mgo.ErrNotFound {
+		return ErrLeaseLost
+	}
+
+	return err
+}
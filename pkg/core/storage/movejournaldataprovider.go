@@ -0,0 +1,50 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package storage
+
+import (
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/entities"
+	"context"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MoveJournalDataProvider is the data provider of the journals of the moves being applied
+type MoveJournalDataProvider struct {
+	BaseDataProvider
+}
+
+// NewMoveJournalDataProvider creates a new move journal data provider
+func NewMoveJournalDataProvider(database, password string) (moveJournalDataProvider *MoveJournalDataProvider) {
+	moveJournalDataProvider = new(MoveJournalDataProvider)
+	moveJournalDataProvider.Database = database
+	moveJournalDataProvider.Password = password
+	return moveJournalDataProvider
+}
+
+// InsertPackage inserts a doc into collection
+func (moveJournalDataProvider *MoveJournalDataProvider) InsertPackage(ctx context.Context, doc *entities.MoveJournalPackage) error {
+	if len(doc.ID) == 0 {
+		doc.ID = bson.NewObjectId()
+	}
+
+	return moveJournalDataProvider.Insert(ctx, consts.MoveJournalCollectionName, bson.M{"_id": doc.ID}, doc)
+}
+
+// FindPackage returns a doc from collection
+func (moveJournalDataProvider *MoveJournalDataProvider) FindPackage(ctx context.Context, id bson.ObjectId, result interface{}) error {
+	return moveJournalDataProvider.Find(ctx, consts.MoveJournalCollectionName, bson.M{"_id": id}, result)
+}
+
+// FindAllPackages returns the docs of all journals from collection
+func (moveJournalDataProvider *MoveJournalDataProvider) FindAllPackages(ctx context.Context, result interface{}) error {
+	return moveJournalDataProvider.FindAll(ctx, consts.MoveJournalCollectionName, bson.M{}, result)
+}
+
+// RemovePackage deletes a doc from collection
+func (moveJournalDataProvider *MoveJournalDataProvider) RemovePackage(ctx context.Context, id bson.ObjectId) error {
+	return moveJournalDataProvider.Remove(ctx, consts.MoveJournalCollectionName, bson.M{"_id": id})
+}
@@ -7,34 +7,118 @@ package storage
 import (
 	"TFRP/pkg/core/consts"
 	"TFRP/pkg/core/entities"
+	"context"
+	"fmt"
 
 	"gopkg.in/mgo.v2/bson"
 )
 
+// SettingsCipher encrypts the settings of provider registrations at rest
+type SettingsCipher interface {
+	CurrentKeyID() string
+	Encrypt(plaintext []byte) (keyID string, ciphertext []byte, err error)
+	Decrypt(keyID string, ciphertext []byte) ([]byte, error)
+}
+
 // ProviderRegistrationDataProvider is the base struc of all data provider
 type ProviderRegistrationDataProvider struct {
 	BaseDataProvider
+	// SettingsCipher encrypts the settings of the stored docs, they are stored in plaintext if nil
+	SettingsCipher SettingsCipher
 }
 
 // NewProviderRegistrationDataProvider creates a new provider registration data provider
-func NewProviderRegistrationDataProvider(database, password string) (providerRegistrationDataProvider *ProviderRegistrationDataProvider) {
+func NewProviderRegistrationDataProvider(database, password string, settingsCipher SettingsCipher) (providerRegistrationDataProvider *ProviderRegistrationDataProvider) {
 	providerRegistrationDataProvider = new(ProviderRegistrationDataProvider)
 	providerRegistrationDataProvider.Database = database
 	providerRegistrationDataProvider.Password = password
+	providerRegistrationDataProvider.SettingsCipher = settingsCipher
 	return providerRegistrationDataProvider
 }
 
-// InsertPackage inserts a doc into collection
-func (providerRegistrationDataProvider *ProviderRegistrationDataProvider) InsertPackage(doc *entities.ProviderRegistrationPackage) error {
-	return providerRegistrationDataProvider.Insert(consts.ProviderRegistrationCollectionName, bson.M{"resourceid": doc.ResourceID}, doc)
+// InsertPackage inserts a doc into collection, its settings are stored encrypted
+func (providerRegistrationDataProvider *ProviderRegistrationDataProvider) InsertPackage(ctx context.Context, doc *entities.ProviderRegistrationPackage) error {
+	encryptedDoc, err := providerRegistrationDataProvider.EncryptPackage(doc)
+	if err != nil {
+		return err
+	}
+
+	return providerRegistrationDataProvider.Insert(ctx, consts.ProviderRegistrationCollectionName, bson.M{"resourceid": doc.ResourceID}, encryptedDoc)
 }
 
-// FindPackage returns a doc from collection
-func (providerRegistrationDataProvider *ProviderRegistrationDataProvider) FindPackage(resourceID string, result interface{}) error {
-	return providerRegistrationDataProvider.Find(consts.ProviderRegistrationCollectionName, bson.M{"resourceid": resourceID}, result)
+// FindPackage returns a doc from collection with its settings decrypted
+func (providerRegistrationDataProvider *ProviderRegistrationDataProvider) FindPackage(ctx context.Context, resourceID string, result *entities.ProviderRegistrationPackage) error {
+	err := providerRegistrationDataProvider.Find(ctx, consts.ProviderRegistrationCollectionName, bson.M{"resourceid": resourceID}, result)
+	if err != nil {
+		return err
+	}
+
+	return providerRegistrationDataProvider.decryptPackage(result)
+}
+
+// FindPackagesToReEncrypt returns the ids of the docs whose settings are stored in plaintext or encrypted with a previous key
+func (providerRegistrationDataProvider *ProviderRegistrationDataProvider) FindPackagesToReEncrypt(ctx context.Context) ([]string, error) {
+	if providerRegistrationDataProvider.SettingsCipher == nil {
+		return nil, fmt.Errorf("no settings encryption key is configured")
+	}
+
+	docs := []entities.ProviderRegistrationPackage{}
+	err := providerRegistrationDataProvider.FindAll(ctx, consts.ProviderRegistrationCollectionName, bson.M{}, &docs)
+	if err != nil {
+		return nil, err
+	}
+
+	resourceIDs := []string{}
+	for _, doc := range docs {
+		if len(doc.Settings) > 0 ||
+			(len(doc.EncryptedSettings) > 0 && doc.SettingsKeyID != providerRegistrationDataProvider.SettingsCipher.CurrentKeyID()) {
+			resourceIDs = append(resourceIDs, doc.ResourceID)
+		}
+	}
+
+	return resourceIDs, nil
+}
+
+// EncryptPackage returns a copy of a doc with its settings encrypted with the current key, as they are stored.
+// A doc whose settings are already encrypted is returned as is
+func (providerRegistrationDataProvider *ProviderRegistrationDataProvider) EncryptPackage(doc *entities.ProviderRegistrationPackage) (*entities.ProviderRegistrationPackage, error) {
+	if providerRegistrationDataProvider.SettingsCipher == nil || len(doc.Settings) == 0 {
+		return doc, nil
+	}
+
+	keyID, encryptedSettings, err := providerRegistrationDataProvider.SettingsCipher.Encrypt(doc.Settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt the settings of provider registration '%s': %s", doc.ResourceID, err)
+	}
+
+	encryptedDoc := *doc
+	encryptedDoc.Settings = nil
+	encryptedDoc.EncryptedSettings = encryptedSettings
+	encryptedDoc.SettingsKeyID = keyID
+	return &encryptedDoc, nil
+}
+
+// decryptPackage decrypts the settings of a doc in place, the settings of docs stored before they were encrypted are read as is
+func (providerRegistrationDataProvider *ProviderRegistrationDataProvider) decryptPackage(doc *entities.ProviderRegistrationPackage) error {
+	if len(doc.EncryptedSettings) == 0 {
+		return nil
+	}
+	if providerRegistrationDataProvider.SettingsCipher == nil {
+		return fmt.Errorf("the settings of provider registration '%s' are encrypted with key '%s' but no settings encryption key is configured", doc.ResourceID, doc.SettingsKeyID)
+	}
+
+	settings, err := providerRegistrationDataProvider.SettingsCipher.Decrypt(doc.SettingsKeyID, doc.EncryptedSettings)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt the settings of provider registration '%s': %s", doc.ResourceID, err)
+	}
+
+	doc.Settings = settings
+	doc.EncryptedSettings = nil
+	doc.SettingsKeyID = ""
+	return nil
 }
 
 // RemovePackage deletes a doc from collection
-func (providerRegistrationDataProvider *ProviderRegistrationDataProvider) RemovePackage(resourceID string) error {
-	return providerRegistrationDataProvider.Remove(consts.ProviderRegistrationCollectionName, bson.M{"resourceid": resourceID})
+func (providerRegistrationDataProvider *ProviderRegistrationDataProvider) RemovePackage(ctx context.Context, resourceID string) error {
+	return providerRegistrationDataProvider.Remove(ctx, consts.ProviderRegistrationCollectionName, bson.M{"resourceid": resourceID})
 }
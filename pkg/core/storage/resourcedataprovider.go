@@ -7,6 +7,8 @@ package storage
 import (
 	"TFRP/pkg/core/consts"
 	"TFRP/pkg/core/entities"
+	"context"
+	"regexp"
 
 	"gopkg.in/mgo.v2/bson"
 )
@@ -25,16 +27,81 @@ func NewResourceDataProvider(database, password string) (resourceDataProvider *R
 }
 
 // InsertPackage inserts a doc into collection
-func (resourceDataProvider *ResourceDataProvider) InsertPackage(doc *entities.ResourcePackage) error {
-	return resourceDataProvider.Insert(consts.ResourceCollectionName, bson.M{"resourceid": doc.ResourceID}, doc)
+func (resourceDataProvider *ResourceDataProvider) InsertPackage(ctx context.Context, doc *entities.ResourcePackage) error {
+	return resourceDataProvider.Insert(ctx, consts.ResourceCollectionName, bson.M{"resourceid": doc.ResourceID}, doc)
 }
 
 // FindPackage returns a doc from colletion
-func (resourceDataProvider *ResourceDataProvider) FindPackage(resourceID string, result interface{}) error {
-	return resourceDataProvider.Find(consts.ResourceCollectionName, bson.M{"resourceid": resourceID}, result)
+func (resourceDataProvider *ResourceDataProvider) FindPackage(ctx context.Context, resourceID string, result interface{}) error {
+	return resourceDataProvider.Find(ctx, consts.ResourceCollectionName, bson.M{"resourceid": resourceID}, result)
 }
 
 // RemovePackage deletes a doc from collection
-func (resourceDataProvider *ResourceDataProvider) RemovePackage(resourceID string) error {
-	return resourceDataProvider.Remove(consts.ResourceCollectionName, bson.M{"resourceid": resourceID})
+func (resourceDataProvider *ResourceDataProvider) RemovePackage(ctx context.Context, resourceID string) error {
+	return resourceDataProvider.Remove(ctx, consts.ResourceCollectionName, bson.M{"resourceid": resourceID})
+}
+
+// FindAllPackages returns the docs of all resources from collection
+func (resourceDataProvider *ResourceDataProvider) FindAllPackages(ctx context.Context, result interface{}) error {
+	return resourceDataProvider.FindAll(ctx, consts.ResourceCollectionName, bson.M{}, result)
+}
+
+// FindPackagesByResourceIDPrefix returns the docs of the resources whose ids start with a prefix, ignoring case, from collection
+func (resourceDataProvider *ResourceDataProvider) FindPackagesByResourceIDPrefix(ctx context.Context, resourceIDPrefix string, result interface{}) error {
+	query := bson.M{"resourceid": bson.RegEx{Pattern: "^" + regexp.QuoteMeta(resourceIDPrefix), Options: "i"}}
+	return resourceDataProvider.FindAll(ctx, consts.ResourceCollectionName, query, result)
+}
+
+// FindPackagesByProviderID returns the docs of the resources referencing a provider registration from collection
+func (resourceDataProvider *ResourceDataProvider) FindPackagesByProviderID(ctx context.Context, providerID string, result interface{}) error {
+	return resourceDataProvider.FindAll(ctx, consts.ResourceCollectionName, bson.M{"providerid": providerID}, result)
+}
+
+// FindPackagesByStateID returns the docs of the resources of a type and provider registration managing the infrastructure
+// with a state id from collection
+func (resourceDataProvider *ResourceDataProvider) FindPackagesByStateID(ctx context.Context, providerID, resourceType, stateID string, result interface{}) error {
+	query := bson.M{"providerid": providerID, "resourcetype": resourceType, "stateid": stateID}
+	return resourceDataProvider.FindAll(ctx, consts.ResourceCollectionName, query, result)
+}
+
+// FindPackagesByProvisioningState returns the docs of the resources in a provisioning state from collection
+func (resourceDataProvider *ResourceDataProvider) FindPackagesByProvisioningState(ctx context.Context, provisioningState string, result interface{}) error {
+	return resourceDataProvider.FindAll(ctx, consts.ResourceCollectionName, bson.M{"provisioningstate": provisioningState}, result)
+}
+
+// FindPackagesByFilter returns the docs of the resources in a provisioning state and referencing a provider registration,
+// ignoring case, from collection, an empty filter matches any resource
+func (resourceDataProvider *ResourceDataProvider) FindPackagesByFilter(ctx context.Context, provisioningState, providerID string, result interface{}) error {
+	query := bson.M{}
+	if len(provisioningState) > 0 {
+		query["provisioningstate"] = bson.RegEx{Pattern: "^" + regexp.QuoteMeta(provisioningState) + "$", Options: "i"}
+	}
+	if len(providerID) > 0 {
+		query["providerid"] = bson.RegEx{Pattern: "^" + regexp.QuoteMeta(providerID) + "$", Options: "i"}
+	}
+
+	return resourceDataProvider.FindAll(ctx, consts.ResourceCollectionName, query, result)
+}
+
+// InsertFencedPackage inserts a doc into collection unless a holder of a newer lease on the resource wrote it,
+// it returns ErrStaleFencingToken if the fencing token is stale
+func (resourceDataProvider *ResourceDataProvider) InsertFencedPackage(ctx context.Context, doc *entities.ResourcePackage, fencingToken int64) error {
+	doc.FencingToken = fencingToken
+	return resourceDataProvider.InsertFenced(ctx, consts.ResourceCollectionName, bson.M{"resourceid": doc.ResourceID}, fencingToken, doc)
+}
+
+// EnsureIndexes creates the unique index on resource ids, a plain one if the collection cannot have it,
+// the reverse index from provider registrations to the resources referencing them and the index on state ids
+func (resourceDataProvider *ResourceDataProvider) EnsureIndexes() error {
+	err := resourceDataProvider.EnsureUniqueIndexOrIndex(consts.ResourceCollectionName, "resourceid")
+	if err != nil {
+		return err
+	}
+
+	err = resourceDataProvider.EnsureIndex(consts.ResourceCollectionName, "providerid")
+	if err != nil {
+		return err
+	}
+
+	return resourceDataProvider.EnsureIndex(consts.ResourceCollectionName, "stateid")
 }
@@ -0,0 +1,51 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package storage
+
+import (
+	"TFRP/pkg/core/consts"
+	"TFRP/pkg/core/entities"
+	"context"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TerraformImportOperationDataProvider is the data provider of the asynchronous terraform imports
+type TerraformImportOperationDataProvider struct {
+	BaseDataProvider
+}
+
+// NewTerraformImportOperationDataProvider creates a new terraform import operation data provider
+func NewTerraformImportOperationDataProvider(database, password string) (terraformImportOperationDataProvider *TerraformImportOperationDataProvider) {
+	terraformImportOperationDataProvider = new(TerraformImportOperationDataProvider)
+	terraformImportOperationDataProvider.Database = database
+	terraformImportOperationDataProvider.Password = password
+	return terraformImportOperationDataProvider
+}
+
+// InsertPackage inserts a doc into collection
+func (terraformImportOperationDataProvider *TerraformImportOperationDataProvider) InsertPackage(ctx context.Context, doc *entities.TerraformImportOperationPackage) error {
+	return terraformImportOperationDataProvider.Insert(ctx, consts.TerraformImportOperationCollectionName, bson.M{"operationid": doc.OperationID}, doc)
+}
+
+// FindPackage returns a doc from collection
+func (terraformImportOperationDataProvider *TerraformImportOperationDataProvider) FindPackage(ctx context.Context, operationID string, result interface{}) error {
+	return terraformImportOperationDataProvider.Find(ctx, consts.TerraformImportOperationCollectionName, bson.M{"operationid": operationID}, result)
+}
+
+// FindPackagesByStatus returns the docs of the imports in a status from collection
+func (terraformImportOperationDataProvider *TerraformImportOperationDataProvider) FindPackagesByStatus(ctx context.Context, status string, result interface{}) error {
+	return terraformImportOperationDataProvider.FindAll(ctx, consts.TerraformImportOperationCollectionName, bson.M{"status": status}, result)
+}
+
+// EnsureIndexes creates the index on the operation ids and the index on the statuses of the imports
+func (terraformImportOperationDataProvider *TerraformImportOperationDataProvider) EnsureIndexes() error {
+	err := terraformImportOperationDataProvider.EnsureUniqueIndexOrIndex(consts.TerraformImportOperationCollectionName, "operationid")
+	if err != nil {
+		return err
+	}
+
+	return terraformImportOperationDataProvider.EnsureIndex(consts.TerraformImportOperationCollectionName, "status")
+}
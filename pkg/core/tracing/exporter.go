@@ -0,0 +1,340 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// otlpTracesPath is the path of the traces of an OTLP/HTTP collector
+	otlpTracesPath = "/v1/traces"
+	// exportQueueSize is how many ended spans wait for export before new spans are dropped
+	exportQueueSize = 4096
+	// exportBatchSize is how many spans are exported in a request at most
+	exportBatchSize = 512
+	// exportInterval is how often the queued spans are exported
+	exportInterval = 5 * time.Second
+	// exportTimeout is the timeout of an export request
+	exportTimeout = 10 * time.Second
+	// instrumentationScope is the name of the instrumentation the spans are recorded by
+	instrumentationScope = "TFRP"
+)
+
+// exporter batches the ended spans and posts them to an OTLP/HTTP collector in the JSON encoding
+type exporter struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	sampleRatio float64
+	hostName    string
+	client      *http.Client
+	queue       chan *Span
+	dropped     int64
+	stop        chan struct{}
+	stopped     chan struct{}
+}
+
+// currentExporter holds the configured *exporter, spans are not recorded while it is unset
+var currentExporter atomic.Value
+
+// Configure exports the spans to an OTLP/HTTP collector, e.g. http://localhost:4318, headers are in the form {name}={value}.
+// The traces started by the service are sampled with a ratio from 0 to 1, the traces of callers follow their sampled flag.
+func Configure(endpoint string, serviceName string, headers []string, sampleRatio float64) error {
+	if sampleRatio < 0 || sampleRatio > 1 {
+		return fmt.Errorf("The sample ratio %g is not between 0 and 1", sampleRatio)
+	}
+
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || (endpointURL.Scheme != "https" && endpointURL.Scheme != "http") || len(endpointURL.Host) == 0 {
+		return fmt.Errorf("The OTLP endpoint '%s' is not an absolute http or https url", endpoint)
+	}
+	if !strings.HasSuffix(endpointURL.Path, otlpTracesPath) {
+		endpointURL.Path = strings.TrimSuffix(endpointURL.Path, "/") + otlpTracesPath
+	}
+
+	exportHeaders := make(map[string]string)
+	for _, header := range headers {
+		parts := strings.SplitN(header, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return fmt.Errorf("The OTLP header '%s' is not in the form {name}={value}", header)
+		}
+		exportHeaders[parts[0]] = parts[1]
+	}
+
+	hostName, _ := os.Hostname()
+	spanExporter := &exporter{
+		endpoint:    endpointURL.String(),
+		headers:     exportHeaders,
+		serviceName: serviceName,
+		sampleRatio: sampleRatio,
+		hostName:    hostName,
+		client:      &http.Client{Timeout: exportTimeout},
+		queue:       make(chan *Span, exportQueueSize),
+		stop:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	go spanExporter.run()
+
+	currentExporter.Store(spanExporter)
+	return nil
+}
+
+// IsEnabled returns whether spans are recorded
+func IsEnabled() bool {
+	return getExporter() != nil
+}
+
+// Shutdown exports the queued spans, spans ended after the shutdown are dropped
+func Shutdown(timeout time.Duration) {
+	spanExporter := getExporter()
+	if spanExporter == nil {
+		return
+	}
+
+	close(spanExporter.stop)
+	select {
+	case <-spanExporter.stopped:
+	case <-time.After(timeout):
+		log.Printf("Spans were not exported within %s", timeout)
+	}
+}
+
+func getExporter() *exporter {
+	spanExporter, _ := currentExporter.Load().(*exporter)
+	return spanExporter
+}
+
+// export queues an ended span, the span is dropped if the queue is full
+func export(span *Span) {
+	spanExporter := getExporter()
+	if spanExporter == nil {
+		return
+	}
+
+	select {
+	case spanExporter.queue <- span:
+	default:
+		atomic.AddInt64(&spanExporter.dropped, 1)
+	}
+}
+
+func (spanExporter *exporter) run() {
+	defer close(spanExporter.stopped)
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-spanExporter.stop:
+			spanExporter.flush()
+			return
+		case <-ticker.C:
+			spanExporter.flush()
+		}
+	}
+}
+
+// flush exports the queued spans in batches
+func (spanExporter *exporter) flush() {
+	if dropped := atomic.SwapInt64(&spanExporter.dropped, 0); dropped > 0 {
+		log.Printf("Dropped %d spans as the export queue was full", dropped)
+	}
+
+	for {
+		batch := make([]*Span, 0, exportBatchSize)
+	collect:
+		for len(batch) < exportBatchSize {
+			select {
+			case span := <-spanExporter.queue:
+				batch = append(batch, span)
+			default:
+				break collect
+			}
+		}
+
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := spanExporter.post(batch); err != nil {
+			log.Printf("Failed to export %d spans: %s", len(batch), err)
+		}
+
+		if len(batch) < exportBatchSize {
+			return
+		}
+	}
+}
+
+func (spanExporter *exporter) post(batch []*Span) error {
+	content, err := json.Marshal(spanExporter.toRequest(batch))
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, spanExporter.endpoint, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range spanExporter.headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := spanExporter.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	ioutil.ReadAll(response.Body)
+
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("The collector responded with status %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+// The OTLP/HTTP JSON encoding of an export request, ids are hex encoded and 64 bit integers are strings
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+const (
+	// otlpStatusUnset and otlpStatusError are the OTLP status codes of spans
+	otlpStatusUnset = 0
+	otlpStatusError = 2
+)
+
+func (spanExporter *exporter) toRequest(batch []*Span) *otlpExportRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, span := range batch {
+		spans = append(spans, span.toOTLP())
+	}
+
+	return &otlpExportRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{
+					newOTLPAttribute("service.name", spanExporter.serviceName),
+					newOTLPAttribute("host.name", spanExporter.hostName),
+				},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: instrumentationScope},
+				Spans: spans,
+			}},
+		}},
+	}
+}
+
+func (span *Span) toOTLP() otlpSpan {
+	span.lock.Lock()
+	defer span.lock.Unlock()
+
+	otlp := otlpSpan{
+		TraceID:           hex.EncodeToString(span.traceID[:]),
+		SpanID:            hex.EncodeToString(span.spanID[:]),
+		Name:              span.name,
+		Kind:              span.kind,
+		StartTimeUnixNano: strconv.FormatInt(span.startTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.endTime.UnixNano(), 10),
+		Status:            otlpStatus{Code: otlpStatusUnset},
+	}
+	if span.parentSpanID != [8]byte{} {
+		otlp.ParentSpanID = hex.EncodeToString(span.parentSpanID[:])
+	}
+	if span.statusError {
+		otlp.Status = otlpStatus{Code: otlpStatusError, Message: span.statusMessage}
+	}
+
+	for key, value := range span.attributes {
+		otlp.Attributes = append(otlp.Attributes, newOTLPAttribute(key, value))
+	}
+
+	return otlp
+}
+
+func newOTLPAttribute(key string, value interface{}) otlpAttribute {
+	attribute := otlpAttribute{Key: key}
+	switch typedValue := value.(type) {
+	case bool:
+		attribute.Value.BoolValue = &typedValue
+	case int:
+		intValue := strconv.Itoa(typedValue)
+		attribute.Value.IntValue = &intValue
+	case int64:
+		intValue := strconv.FormatInt(typedValue, 10)
+		attribute.Value.IntValue = &intValue
+	case string:
+		attribute.Value.StringValue = &typedValue
+	default:
+		stringValue := fmt.Sprint(typedValue)
+		attribute.Value.StringValue = &stringValue
+	}
+
+	return attribute
+}
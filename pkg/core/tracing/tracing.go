@@ -0,0 +1,274 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package tracing
+
+import (
+	"TFRP/pkg/core/consts"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+// Spans are only recorded once an exporter is configured, the tracing functions are no-ops otherwise
+// and the spans they return are nil, which are safe to use
+
+// SpanKind is the OTLP kind of a span
+type SpanKind int
+
+const (
+	// SpanKindInternal is an operation within the service
+	SpanKindInternal SpanKind = 1
+	// SpanKindServer is a request served by the service
+	SpanKindServer SpanKind = 2
+	// SpanKindClient is a call to storage or a provider
+	SpanKindClient SpanKind = 3
+)
+
+const (
+	// CorrelationIDAttribute is the attribute of the ARM correlation id, every span of a request carries it
+	CorrelationIDAttribute = "ms.correlation_request_id"
+	// TraceParentHeader is the W3C trace context header a caller propagates its trace with
+	TraceParentHeader = "traceparent"
+
+	// traceFlagSampled is the trace flag of the traceparent header set when the caller records the trace
+	traceFlagSampled = 0x01
+)
+
+// Span is a timed operation of a trace, it is exported when it ends
+type Span struct {
+	traceID      [16]byte
+	spanID       [8]byte
+	parentSpanID [8]byte
+	name         string
+	kind         SpanKind
+	sampled      bool
+	startTime    time.Time
+	endTime      time.Time
+
+	lock          sync.Mutex
+	attributes    map[string]interface{}
+	statusError   bool
+	statusMessage string
+	ended         bool
+}
+
+type contextKey int
+
+const (
+	spanContextKey contextKey = iota
+	correlationIDContextKey
+)
+
+// Start starts a span which is a child of the span of ctx, or the root of a new trace if ctx has no span
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if !IsEnabled() {
+		return ctx, nil
+	}
+
+	parent, hasParent := ctx.Value(spanContextKey).(*Span)
+	if hasParent && parent == nil {
+		return ctx, nil
+	}
+
+	span := newSpan(name, kind)
+	if hasParent {
+		span.traceID = parent.traceID
+		span.parentSpanID = parent.spanID
+		span.sampled = parent.sampled
+	} else {
+		rand.Read(span.traceID[:])
+		span.sampled = isTraceSampled(span.traceID, getExporter().sampleRatio)
+	}
+
+	if correlationID := CorrelationID(ctx); len(correlationID) > 0 {
+		span.attributes[CorrelationIDAttribute] = correlationID
+	}
+
+	return context.WithValue(ctx, spanContextKey, span), span
+}
+
+// Detach returns a context for a background operation which continues the trace and correlation id of ctx
+// without being canceled with ctx
+func Detach(ctx context.Context) context.Context {
+	detached := context.Background()
+	if span, ok := ctx.Value(spanContextKey).(*Span); ok {
+		detached = context.WithValue(detached, spanContextKey, span)
+	}
+	if correlationID := CorrelationID(ctx); len(correlationID) > 0 {
+		detached = WithCorrelationID(detached, correlationID)
+	}
+
+	return detached
+}
+
+// WithoutSpans returns a context whose calls are not traced, e.g. the heartbeats of background loops
+func WithoutSpans(ctx context.Context) context.Context {
+	return context.WithValue(ctx, spanContextKey, (*Span)(nil))
+}
+
+// WithCorrelationID returns a context whose spans carry a correlation id
+func WithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return context.WithValue(ctx, correlationIDContextKey, correlationID)
+}
+
+// CorrelationID returns the correlation id of ctx
+func CorrelationID(ctx context.Context) string {
+	correlationID, _ := ctx.Value(correlationIDContextKey).(string)
+	return correlationID
+}
+
+// Filter traces a request in a server span, the trace of the caller is continued if it sent a traceparent header.
+// The context of the http request carries the span and the ARM correlation id to the controllers.
+func Filter(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	ctx := request.Request.Context()
+	if correlationID := request.HeaderParameter(consts.RequestCorrelationIDHeader); len(correlationID) > 0 {
+		ctx = WithCorrelationID(ctx, correlationID)
+	}
+
+	if IsEnabled() {
+		if parent := parseTraceParent(request.HeaderParameter(TraceParentHeader)); parent != nil {
+			ctx = context.WithValue(ctx, spanContextKey, parent)
+		}
+	}
+
+	ctx, span := Start(ctx, request.Request.Method, SpanKindServer)
+	span.SetAttribute("http.request.method", request.Request.Method)
+	span.SetAttribute("url.path", request.Request.URL.Path)
+	request.Request = request.Request.WithContext(ctx)
+
+	chain.ProcessFilter(request, response)
+
+	span.SetAttribute("http.response.status_code", response.StatusCode())
+	if response.StatusCode() >= http.StatusInternalServerError {
+		span.SetError(http.StatusText(response.StatusCode()))
+	}
+	span.End()
+}
+
+// SetAttribute sets an attribute of a span, values are strings, ints, int64s or bools
+func (span *Span) SetAttribute(key string, value interface{}) {
+	if span == nil {
+		return
+	}
+
+	span.lock.Lock()
+	defer span.lock.Unlock()
+
+	span.attributes[key] = value
+}
+
+// RecordError marks a span as failed with an error, a nil error is ignored
+func (span *Span) RecordError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+
+	span.SetError(err.Error())
+}
+
+// SetError marks a span as failed
+func (span *Span) SetError(message string) {
+	if span == nil {
+		return
+	}
+
+	span.lock.Lock()
+	defer span.lock.Unlock()
+
+	span.statusError = true
+	span.statusMessage = message
+}
+
+// End ends a span and queues it for export, a span is only exported once and only if its trace is sampled
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+
+	span.lock.Lock()
+	if span.ended {
+		span.lock.Unlock()
+		return
+	}
+	span.ended = true
+	span.endTime = time.Now()
+	span.lock.Unlock()
+
+	if span.sampled {
+		export(span)
+	}
+}
+
+// TraceParent returns the W3C traceparent header value of a span, the sampled flag is propagated so that
+// the callees record the same traces
+func (span *Span) TraceParent() string {
+	if span == nil {
+		return ""
+	}
+
+	flags := "00"
+	if span.sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(span.traceID[:]), hex.EncodeToString(span.spanID[:]), flags)
+}
+
+func newSpan(name string, kind SpanKind) *Span {
+	span := &Span{
+		name:       name,
+		kind:       kind,
+		startTime:  time.Now(),
+		attributes: make(map[string]interface{}),
+	}
+	rand.Read(span.spanID[:])
+	return span
+}
+
+// parseTraceParent returns the remote parent of a W3C traceparent header value, {version}-{traceId}-{parentId}-{flags}
+func parseTraceParent(traceParent string) *Span {
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return nil
+	}
+
+	parent := &Span{ended: true}
+	if _, err := hex.Decode(parent.traceID[:], []byte(parts[1])); err != nil || parent.traceID == [16]byte{} {
+		return nil
+	}
+	if _, err := hex.Decode(parent.spanID[:], []byte(parts[2])); err != nil || parent.spanID == [8]byte{} {
+		return nil
+	}
+
+	// The trace of a caller is recorded if the caller recorded it
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return nil
+	}
+	parent.sampled = flags[0]&traceFlagSampled != 0
+
+	return parent
+}
+
+// isTraceSampled returns whether a new trace is sampled, the decision is taken from the trace id so that
+// it is the same wherever it is taken
+func isTraceSampled(traceID [16]byte, sampleRatio float64) bool {
+	if sampleRatio >= 1 {
+		return true
+	}
+	if sampleRatio <= 0 {
+		return false
+	}
+
+	return binary.BigEndian.Uint64(traceID[8:])>>1 < uint64(sampleRatio*(1<<63))
+}
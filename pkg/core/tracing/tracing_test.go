@@ -0,0 +1,106 @@
+//------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.  All rights reserved.
+//------------------------------------------------------------
+
+package tracing
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	testCases := []struct {
+		traceParent string
+		valid       bool
+		sampled     bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"", false, false},
+	}
+
+	for _, testCase := range testCases {
+		parent := parseTraceParent(testCase.traceParent)
+		if (parent != nil) != testCase.valid {
+			t.Fatalf("expected valid %v for '%s', actual %v", testCase.valid, testCase.traceParent, parent != nil)
+		}
+		if parent != nil && parent.sampled != testCase.sampled {
+			t.Fatalf("expected sampled %v for '%s', actual %v", testCase.sampled, testCase.traceParent, parent.sampled)
+		}
+	}
+}
+
+func TestIsTraceSampled(t *testing.T) {
+	low := [16]byte{8: 0x10}
+	high := [16]byte{8: 0xf0}
+
+	testCases := []struct {
+		traceID     [16]byte
+		sampleRatio float64
+		expected    bool
+	}{
+		{low, 1, true},
+		{high, 1, true},
+		{low, 0, false},
+		{high, 0, false},
+		{low, 0.5, true},
+		{high, 0.5, false},
+	}
+
+	for _, testCase := range testCases {
+		if actual := isTraceSampled(testCase.traceID, testCase.sampleRatio); actual != testCase.expected {
+			t.Fatalf("expected %v for ratio %g and trace %x, actual %v", testCase.expected, testCase.sampleRatio, testCase.traceID, actual)
+		}
+	}
+}
+
+func TestSampledFlagIsPropagated(t *testing.T) {
+	spanExporter := &exporter{sampleRatio: 1, queue: make(chan *Span, 10)}
+	currentExporter.Store(spanExporter)
+	defer currentExporter.Store((*exporter)(nil))
+
+	testCases := []struct {
+		sampled       bool
+		expectedFlags string
+	}{
+		{true, "-01"},
+		{false, "-00"},
+	}
+
+	for _, testCase := range testCases {
+		parent := parseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7" + testCase.expectedFlags)
+		ctx := context.WithValue(context.Background(), spanContextKey, parent)
+
+		_, span := Start(ctx, "child", SpanKindInternal)
+		if span.sampled != testCase.sampled {
+			t.Fatalf("expected sampled %v, actual %v", testCase.sampled, span.sampled)
+		}
+		if traceParent := span.TraceParent(); !strings.HasSuffix(traceParent, testCase.expectedFlags) {
+			t.Fatalf("expected a traceparent ending with %s, actual %s", testCase.expectedFlags, traceParent)
+		}
+
+		span.End()
+		exported := len(spanExporter.queue) > 0
+		if exported != testCase.sampled {
+			t.Fatalf("expected exported %v, actual %v", testCase.sampled, exported)
+		}
+		if exported {
+			<-spanExporter.queue
+		}
+	}
+}
+
+func TestConfigureRejectsInvalidSampleRatio(t *testing.T) {
+	for _, sampleRatio := range []float64{-0.1, 1.5} {
+		if err := Configure("http://localhost:4318", "TFRP", nil, sampleRatio); err == nil {
+			t.Fatalf("expected an error for the sample ratio %g, actual nil", sampleRatio)
+		}
+	}
+}
@@ -8,11 +8,21 @@ import (
 	"TFRP/pkg/core/consts"
 	"TFRP/pkg/core/controllers"
 	"TFRP/pkg/core/engines"
+	"TFRP/pkg/core/entities"
 	"TFRP/pkg/core/storage"
+	"TFRP/pkg/core/tracing"
+	"context"
 	"crypto/tls"
-	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	restful "github.com/emicklei/go-restful"
 	"github.com/spf13/pflag"
@@ -21,42 +31,166 @@ import (
 var (
 	addr       = pflag.String("insecure-address", ":8080", "The <host>:<port> for insecure (HTTP) serving")
 	secureAddr = pflag.String("secure-address", ":443", "The <host>:<port> for secure (HTTPS) serving")
+
+	defaultOperationTimeout       = pflag.Duration("default-operation-timeout", 30*time.Minute, "The timeout of provider create/update/delete operations")
+	shutdownTimeout               = pflag.Duration("shutdown-timeout", 60*time.Second, "How long background operations are drained on shutdown before they are interrupted")
+	operationTimeouts             = pflag.StringSlice("operation-timeouts", []string{}, "Timeouts of provider operations per resource type, in the form {resourceType}:{create|update|delete|default}={duration}")
+	providerRetryPolicies         = pflag.StringSlice("provider-retry-policies", []string{}, "Max attempts of transient provider failures per provider type, in the form {providerType}={maxAttempts}")
+	subscriptionReadRate          = pflag.Float64("subscription-read-rate", 50, "The GET requests per second allowed per subscription, 0 disables throttling")
+	subscriptionWriteRate         = pflag.Float64("subscription-write-rate", 10, "The PUT/PATCH/POST/DELETE requests per second allowed per subscription, 0 disables throttling")
+	providerRegistrationReadRate  = pflag.Float64("provider-registration-read-rate", 10, "The GET requests per second allowed per provider registration, 0 disables throttling")
+	providerRegistrationWriteRate = pflag.Float64("provider-registration-write-rate", 2, "The PUT/PATCH/POST/DELETE requests per second allowed per provider registration, 0 disables throttling")
+	leaseTTL                      = pflag.Duration("lease-ttl", 30*time.Second, "How long the lock of a resource or the leadership of a periodic job is held by a replica which stops renewing it")
+	orphanedOperationScanInterval = pflag.Duration("orphaned-operation-scan-interval", 5*time.Minute, "How often the leader replica fails the operations left accepted and undoes the moves left journaled by stopped replicas")
+	resourceFreshnessWindow       = pflag.Duration("resource-freshness-window", time.Minute, "How long GET serves the stored state of a resource before it refreshes it in the background, 0 refreshes the state on every GET")
+	resourceMaxStaleness          = pflag.Duration("resource-max-staleness", 15*time.Minute, "How old the stored state of a resource can be for GET to serve it while it is refreshed in the background, an older state is refreshed before it is served, 0 serves a stale state of any age")
+	deletedResourcePurgeInterval  = pflag.Duration("deleted-resource-purge-interval", time.Hour, "How often the leader replica purges the deleted resources whose retention expired")
+	secretReferenceSource         = pflag.String("secret-reference-source", "keyvault", "Where the secret references of settings are resolved, keyvault or file for development")
+	secretReferenceDirectory      = pflag.String("secret-reference-directory", "", "The directory secret references are read from with the file source, as {directory}/{name}/{version|latest}")
+	secretReferenceVaults         = pflag.StringSlice("secret-reference-vaults", []string{}, "The uris of the key vaults settings can reference, the vaults of the service excepted. Secret references are rejected if empty")
+	credentialSource              = pflag.String("credential-source", engines.SecretEngineCredentialClientSecret, "How the service authenticates with key vault, clientsecret, certificate or msi")
+	activeDirectoryEndpoint       = pflag.String("active-directory-endpoint", "", "The authority of the service principal, defaults to the public cloud")
+	imdsEndpoint                  = pflag.String("imds-endpoint", engines.DefaultIMDSTokenEndpoint, "The token endpoint of the instance metadata service used with the msi credential source")
+	msiClientID                   = pflag.String("msi-client-id", "", "The client id of the user assigned identity used with the msi credential source, the system assigned identity is used if empty")
+	credentialWatchInterval       = pflag.Duration("credential-watch-interval", time.Minute, "How often the credential files are checked for rotated credentials, 0 disables watching")
+	tlsCertificateSource          = pflag.String("tls-certificate-source", "keyvault", "Where the TLS certificate is read from, keyvault or file")
+	tlsCertificateFile            = pflag.String("tls-certificate-file", "", "The PEM certificate chain served with the file certificate source")
+	tlsPrivateKeyFile             = pflag.String("tls-private-key-file", "", "The PEM private key served with the file certificate source")
+	tlsCertificateReloadInterval  = pflag.Duration("tls-certificate-reload-interval", 10*time.Minute, "How often the TLS certificate is checked for rotation, 0 disables reloading")
+	tlsVerifyChain                = pflag.Bool("tls-verify-chain", true, "Verify the chain of the TLS certificate against the system roots before serving it")
+	tlsMinVersion                 = pflag.String("tls-min-version", "1.2", "The minimum TLS version, 1.0, 1.1, 1.2 or 1.3")
+	tlsMaxVersion                 = pflag.String("tls-max-version", "1.2", "The maximum TLS version, 1.0, 1.1, 1.2 or 1.3, the highest supported version if empty")
+	upgradeStates                 = pflag.Bool("upgrade-states", false, "Migrate the stored states of all resources to the schema versions of the current providers, print the report and exit")
+	upgradeStatesDryRun           = pflag.Bool("upgrade-states-dry-run", false, "Report the stored states --upgrade-states would migrate without storing them, and exit")
+	locations                     = pflag.StringSlice("locations", []string{}, "The locations resources can be created in, any location if empty")
+	adminCertificateThumbprints   = pflag.StringSlice("admin-certificate-thumbprints", []string{}, "The SHA-1 thumbprints of the client certificates allowed to call the admin API over HTTPS, the admin API is disabled if empty")
+	eventWebhooks                 = pflag.StringSlice("event-webhooks", []string{}, "The http or https urls the events on the state changes of resources are delivered to, events are not published if empty")
+	eventSchema                   = pflag.String("event-schema", engines.EventSchemaCloudEvents, "The schema of the delivered events, cloudevents or eventgrid")
+	eventMaxAttempts              = pflag.Int("event-max-attempts", engines.DefaultEventRetryPolicy.MaxAttempts, "How many times the delivery of an event to a webhook is attempted before it is dead lettered")
+	eventSigningSecretSource      = pflag.String("event-signing-secret-source", "keyvault", "Where the HMAC secret the delivered events are signed with is read from, keyvault or file")
+	eventSigningSecretFile        = pflag.String("event-signing-secret-file", "", "The file the signing secret is read from with the file source")
+	settingsEncryptionKeySource   = pflag.String("settings-encryption-key-source", "keyvault", "Where the keys the settings of provider registrations are encrypted with at rest are read from, keyvault or file")
+	settingsEncryptionKeyFile     = pflag.String("settings-encryption-key-file", "", "The file the settings encryption keys are read from with the file source, one {keyId}={base64 key} per line, the first key encrypts")
+	eventSinkAddress              = pflag.String("event-sink-address", "", "The <host>:<port> of a local webhook which verifies and logs the delivered events for development, disabled if empty")
+	otlpEndpoint                  = pflag.String("otlp-endpoint", "", "The http or https url of the OTLP/HTTP collector spans are exported to, e.g. http://localhost:4318, spans are not recorded if empty")
+	otlpHeaders                   = pflag.StringSlice("otlp-headers", []string{}, "The headers sent to the OTLP collector, in the form {name}={value}")
+	otlpServiceName               = pflag.String("otlp-service-name", "TFRP", "The service name of the exported spans")
+	otlpSampleRatio               = pflag.Float64("otlp-sample-ratio", 1, "The ratio from 0 to 1 of the traces started by the service which are recorded, the traces of callers are recorded if the caller sampled them")
+	tenantIsolatedProviders       = pflag.Bool("tenant-isolated-providers", true, "Ignore the environment and local files of the server when configuring providers, and reject provider settings referencing local paths")
+)
+
+const (
+	// shutdownServerTimeout is how long in-flight requests are waited for when the servers shut down
+	shutdownServerTimeout = 10 * time.Second
 )
 
 func main() {
 	pflag.Parse()
 
-	secretEngine := engines.GetSecretEngine()
+	engines.TenantIsolatedProviders = *tenantIsolatedProviders
+	engines.AllowedLocations = *locations
+	engines.ResourceFreshnessWindow = *resourceFreshnessWindow
+	engines.ResourceMaxStaleness = *resourceMaxStaleness
+	engines.AdminCertificateThumbprints = *adminCertificateThumbprints
+
+	if len(*otlpEndpoint) > 0 {
+		if err := tracing.Configure(*otlpEndpoint, *otlpServiceName, *otlpHeaders, *otlpSampleRatio); err != nil {
+			log.Fatal("Invalid OTLP configuration: ", err)
+		}
+	}
+
+	secretEngine, err := engines.NewSecretEngine(engines.SecretEngineOptions{
+		CredentialSource:        *credentialSource,
+		ActiveDirectoryEndpoint: *activeDirectoryEndpoint,
+		IMDSEndpoint:            *imdsEndpoint,
+		MSIClientID:             *msiClientID,
+	})
+	if err != nil {
+		log.Fatal("Failed to create secret engine: ", err)
+	}
 
-	initRoutes(secretEngine)
+	healthManager := controllers.NewHealthManager()
+	stopJobs := make(chan struct{})
+	if *credentialWatchInterval > 0 {
+		go secretEngine.Watch(*credentialWatchInterval, stopJobs)
+	}
+	operationEngine := initRoutes(secretEngine, healthManager, stopJobs)
+
+	certificateEngine, err := engines.NewCertificateEngine(getCertificateSource(secretEngine), *tlsVerifyChain)
+	if err != nil {
+		log.Fatal("Failed to load TLS certificate: ", err)
+	}
+	if *tlsCertificateReloadInterval > 0 {
+		go certificateEngine.Watch(*tlsCertificateReloadInterval, stopJobs)
+	}
+
+	insecureServer := &http.Server{
+		Addr: *addr,
+	}
 
-	httpServer := &http.Server{
-		Addr:      ":443",
-		TLSConfig: getTLSConfig(secretEngine),
+	secureServer := &http.Server{
+		Addr:      *secureAddr,
+		TLSConfig: getTLSConfig(certificateEngine),
 	}
 
 	go func() {
-		log.Fatal(http.ListenAndServe(":8080", nil))
+		if err := insecureServer.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
 	}()
-	log.Fatal(httpServer.ListenAndServeTLS("", ""))
+
+	go func() {
+		if err := secureServer.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	log.Printf("Received signal %s, shutting down", <-signals)
+
+	shutdown(healthManager, operationEngine, stopJobs, insecureServer, secureServer)
 }
 
-func getTLSConfig(secretEngine *engines.SecretEngine) (config *tls.Config) {
-	certPem, err := base64.StdEncoding.DecodeString(secretEngine.GetSecretFromKeyVault(consts.SslCertKVBaseURI, consts.SslCertKVSecretName, consts.SslCertKVSecretVersion))
-	keyPem, err := base64.StdEncoding.DecodeString(secretEngine.GetSecretFromKeyVault(consts.SslPrivatekeyKVBaseURI, consts.SslPrivatekeyKVSecretName, consts.SslPrivatekeyKVSecretVersion))
-	if err != nil {
-		log.Fatal("Failed to decode certs: %v", err)
+// shutdown stops accepting writes and the periodic jobs, drains the background operations and then shuts down the servers
+func shutdown(healthManager *controllers.HealthManager, operationEngine *engines.OperationEngine, stopJobs chan struct{}, servers ...*http.Server) {
+	healthManager.StartDraining()
+	close(stopJobs)
+
+	if !operationEngine.Drain(*shutdownTimeout) {
+		log.Printf("Background operations did not complete within %s and were marked as resumable", *shutdownTimeout)
 	}
 
-	cert, err := tls.X509KeyPair(certPem, keyPem)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownServerTimeout)
+	defer cancel()
+
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Failed to shut down server %s: %s", server.Addr, err)
+		}
+	}
+
+	tracing.Shutdown(shutdownServerTimeout)
+}
+
+func getTLSConfig(certificateEngine *engines.CertificateEngine) (config *tls.Config) {
+	minVersion, err := engines.ParseTLSVersion(*tlsMinVersion)
+	if err != nil {
+		log.Fatal(err)
+	}
+	maxVersion, err := engines.ParseTLSVersion(*tlsMaxVersion)
 	if err != nil {
-		log.Fatal("Cannot load X509 key pair: %v", err)
+		log.Fatal(err)
 	}
+	if maxVersion != 0 && maxVersion < minVersion {
+		log.Fatalf("The maximum TLS version %s is lower than the minimum TLS version %s", *tlsMaxVersion, *tlsMinVersion)
+	}
+
+	tlsConfig := &tls.Config{GetCertificate: certificateEngine.GetCertificate}
 
-	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
 	// ciphersuite requirements:
 	// https://requirements.azurewebsites.net/Requirements/Details/6417#guide
-	// they have to follow the order in above requirement page
+	// they have to follow the order in above requirement page, they do not apply to TLS 1.3
 	tlsConfig.CipherSuites = []uint16{
 		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
 		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
@@ -65,31 +199,362 @@ func getTLSConfig(secretEngine *engines.SecretEngine) (config *tls.Config) {
 		tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
 		tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
 	}
+	// Admin clients authenticate with certificates pinned by thumbprint, other clients are not asked for one
+	if engines.IsAdminEnabled() {
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	}
+
 	tlsConfig.PreferServerCipherSuites = true
-	tlsConfig.MinVersion = tls.VersionTLS12
-	tlsConfig.MaxVersion = tls.VersionTLS12
+	tlsConfig.MinVersion = minVersion
+	tlsConfig.MaxVersion = maxVersion
 
 	return tlsConfig
 }
 
-func initRoutes(secretEngine *engines.SecretEngine) {
-	storagePassword := secretEngine.GetSecretFromKeyVault(consts.StoragePasswordKVBaseURI, consts.StoragePasswordKVSecretName, consts.StoragePasswordKVSecretVersion)
-	providerRegistrationDataProvider := storage.NewProviderRegistrationDataProvider(consts.StorageDatabase, storagePassword)
+// getCertificateSource returns the source of the TLS certificate
+func getCertificateSource(secretEngine *engines.SecretEngine) engines.CertificateSource {
+	var certificateSource engines.CertificateSource
+	switch *tlsCertificateSource {
+	case "keyvault":
+		certificateSource = &engines.KeyVaultCertificateSource{
+			SecretEngine:     secretEngine,
+			CertVaultBaseURI: consts.SslCertKVBaseURI,
+			CertSecretName:   consts.SslCertKVSecretName,
+			KeyVaultBaseURI:  consts.SslPrivatekeyKVBaseURI,
+			KeySecretName:    consts.SslPrivatekeyKVSecretName,
+		}
+	case "file":
+		certificateSource = &engines.FileCertificateSource{CertPath: *tlsCertificateFile, KeyPath: *tlsPrivateKeyFile}
+	default:
+		log.Fatal("Invalid TLS certificate source: ", *tlsCertificateSource)
+	}
+
+	return certificateSource
+}
+
+func initRoutes(secretEngine *engines.SecretEngine, healthManager *controllers.HealthManager, stopJobs <-chan struct{}) *engines.OperationEngine {
+	storagePassword, err := secretEngine.GetSecret(context.Background(), consts.StoragePasswordKVBaseURI, consts.StoragePasswordKVSecretName, consts.StoragePasswordKVSecretVersion)
+	if err != nil {
+		log.Fatal("Failed to get storage password: ", err)
+	}
+	providerRegistrationDataProvider := storage.NewProviderRegistrationDataProvider(consts.StorageDatabase, storagePassword, getEncryptionEngine(secretEngine))
 	resourceDataProvider := storage.NewResourceDataProvider(consts.StorageDatabase, storagePassword)
-	providerRegistrationManager := controllers.NewProviderRegistrationManager(providerRegistrationDataProvider)
-	resourceManager := controllers.NewResourceManager(providerRegistrationDataProvider, resourceDataProvider)
+	deletedResourceDataProvider := storage.NewDeletedResourceDataProvider(consts.StorageDatabase, storagePassword)
+	leaseDataProvider := storage.NewLeaseDataProvider(consts.StorageDatabase, storagePassword)
+	adminAuditDataProvider := storage.NewAdminAuditDataProvider(consts.StorageDatabase, storagePassword)
+	deadLetterEventDataProvider := storage.NewDeadLetterEventDataProvider(consts.StorageDatabase, storagePassword)
+	terraformImportOperationDataProvider := storage.NewTerraformImportOperationDataProvider(consts.StorageDatabase, storagePassword)
+	moveJournalDataProvider := storage.NewMoveJournalDataProvider(consts.StorageDatabase, storagePassword)
+
+	resourceTypeTimeouts, err := engines.ParseResourceTypeTimeouts(*operationTimeouts)
+	if err != nil {
+		log.Fatal("Invalid operation timeouts: ", err)
+	}
+
+	retryPolicies, err := engines.ParseProviderRetryPolicies(*providerRetryPolicies)
+	if err != nil {
+		log.Fatal("Invalid provider retry policies: ", err)
+	}
+
+	operationEngine := engines.NewOperationEngine(*defaultOperationTimeout, resourceTypeTimeouts, retryPolicies)
+	throttlingEngine := engines.NewThrottlingEngine(
+		engines.ThrottlingPolicy{ReadsPerSecond: *subscriptionReadRate, WritesPerSecond: *subscriptionWriteRate},
+		engines.ThrottlingPolicy{ReadsPerSecond: *providerRegistrationReadRate, WritesPerSecond: *providerRegistrationWriteRate})
+	lockEngine := engines.NewLockEngine(leaseDataProvider, *leaseTTL)
+	secretReferenceEngine := getSecretReferenceEngine(secretEngine)
+	eventEngine := getEventEngine(secretEngine, deadLetterEventDataProvider)
+	providerRegistrationManager := controllers.NewProviderRegistrationManager(providerRegistrationDataProvider, resourceDataProvider, deletedResourceDataProvider, operationEngine, lockEngine, secretReferenceEngine, eventEngine)
+	resourceManager := controllers.NewResourceManager(providerRegistrationDataProvider, resourceDataProvider, deletedResourceDataProvider, terraformImportOperationDataProvider, moveJournalDataProvider, operationEngine, throttlingEngine, lockEngine, secretReferenceEngine, eventEngine)
+	adminManager := controllers.NewAdminManager(resourceManager, adminAuditDataProvider)
+
+	// Locks are only exclusive once the unique indexes exist
+	err = resourceDataProvider.EnsureIndexes()
+	if err != nil {
+		log.Fatal("Failed to create the indexes of resources: ", err)
+	}
+	err = deletedResourceDataProvider.EnsureIndexes()
+	if err != nil {
+		log.Fatal("Failed to create the indexes of deleted resources: ", err)
+	}
+	err = leaseDataProvider.EnsureIndexes()
+	if err != nil {
+		log.Fatal("Failed to create the indexes of leases: ", err)
+	}
+	err = adminAuditDataProvider.EnsureIndexes()
+	if err != nil {
+		log.Fatal("Failed to create the indexes of admin audit records: ", err)
+	}
+	err = deadLetterEventDataProvider.EnsureIndexes()
+	if err != nil {
+		log.Fatal("Failed to create the indexes of dead letter events: ", err)
+	}
+	err = terraformImportOperationDataProvider.EnsureIndexes()
+	if err != nil {
+		log.Fatal("Failed to create the indexes of terraform import operations: ", err)
+	}
+
+	if *upgradeStates || *upgradeStatesDryRun {
+		runStateUpgrade(resourceManager, *upgradeStatesDryRun)
+	}
+
+	go lockEngine.RunLeaderElection("recoverOrphanedOperations", *orphanedOperationScanInterval, resourceManager.RecoverOrphanedOperations, stopJobs)
+	go lockEngine.RunLeaderElection("recoverInterruptedMoves", *orphanedOperationScanInterval, resourceManager.RecoverInterruptedMoves, stopJobs)
+	go lockEngine.RunLeaderElection("purgeDeletedResources", *deletedResourcePurgeInterval, resourceManager.PurgeDeletedResources, stopJobs)
+	if eventEngine.IsEnabled() {
+		go eventEngine.Run(stopJobs)
+	}
+
+	// Every request is traced, the span and the correlation id reach the controllers in the context of the request
+	restful.Filter(tracing.Filter)
 
 	webService := new(restful.WebService)
 	webService.
 		Path(consts.SubscriptionsURLPrefix).
 		Consumes(restful.MIME_JSON).
-		Produces(restful.MIME_JSON)
+		Produces(restful.MIME_JSON).
+		Filter(healthManager.Filter).
+		Filter(throttlingEngine.Filter)
 
 	addSubscriptionOperationRoutes(webService)
 	addProvidersOperationRoutes(webService, providerRegistrationManager)
 	addResourcesOperationRoutes(webService, resourceManager)
 
 	restful.Add(webService)
+
+	addOperationsRoutes()
+	addAdminRoutes(adminManager)
+
+	healthManager.AddReadinessCheck("storage", providerRegistrationDataProvider.Ping)
+	// A token which can no longer be refreshed means the rotated credentials of the service were not picked up
+	healthManager.AddReadinessCheck("secrets", secretEngine.Probe)
+	addHealthRoutes(healthManager)
+
+	return operationEngine
+}
+
+// runStateUpgrade upgrades the stored states after a provider bump, prints the report and exits
+func runStateUpgrade(resourceManager *controllers.ResourceManager, dryRun bool) {
+	stateUpgradeReport := resourceManager.UpgradeStates(context.Background(), dryRun)
+
+	report, err := json.MarshalIndent(stateUpgradeReport, "", "  ")
+	if err != nil {
+		log.Fatal("Failed to serialize the state upgrade report: ", err)
+	}
+	fmt.Println(string(report))
+
+	if stateUpgradeReport.Counts[entities.StateUpgradeStatusFailed] > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// getSecretReferenceEngine creates the engine resolving the secret references of settings,
+// the vaults of the service can never be referenced
+func getSecretReferenceEngine(secretEngine *engines.SecretEngine) *engines.SecretReferenceEngine {
+	var secretSource engines.SecretSource
+	switch *secretReferenceSource {
+	case "keyvault":
+		secretSource = secretEngine
+	case "file":
+		secretSource = &engines.FileSecretSource{Directory: *secretReferenceDirectory}
+	default:
+		log.Fatal("Invalid secret reference source: ", *secretReferenceSource)
+	}
+
+	secretReferenceEngine, err := engines.NewSecretReferenceEngine(
+		secretSource,
+		*secretReferenceVaults,
+		[]string{consts.StoragePasswordKVBaseURI, consts.SslCertKVBaseURI, consts.SslPrivatekeyKVBaseURI, consts.EventSigningSecretKVBaseURI, consts.SettingsEncryptionKeysKVBaseURI})
+	if err != nil {
+		log.Fatal("Invalid secret reference vaults: ", err)
+	}
+	if len(*secretReferenceVaults) == 0 {
+		log.Printf("No secret reference vaults are configured, settings referencing secrets are rejected")
+	}
+
+	return secretReferenceEngine
+}
+
+func getEventEngine(secretEngine *engines.SecretEngine, deadLetterEventDataProvider *storage.DeadLetterEventDataProvider) *engines.EventEngine {
+	signingSecret := ""
+	if len(*eventWebhooks) > 0 || len(*eventSinkAddress) > 0 {
+		signingSecret = getEventSigningSecret(secretEngine)
+	}
+
+	retryPolicy := engines.DefaultEventRetryPolicy
+	retryPolicy.MaxAttempts = *eventMaxAttempts
+	if retryPolicy.MaxAttempts < 1 {
+		log.Fatal("Invalid event max attempts: ", *eventMaxAttempts)
+	}
+
+	eventEngine, err := engines.NewEventEngine(*eventWebhooks, *eventSchema, signingSecret, retryPolicy, deadLetterEventDataProvider)
+	if err != nil {
+		log.Fatal("Invalid event configuration: ", err)
+	}
+	eventEngine.ShutdownTimeout = *shutdownTimeout
+
+	if len(*eventSinkAddress) > 0 {
+		go func() {
+			log.Printf("Serving the event sink on %s", *eventSinkAddress)
+			if err := http.ListenAndServe(*eventSinkAddress, engines.NewEventSink(signingSecret)); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	return eventEngine
+}
+
+func getEventSigningSecret(secretEngine *engines.SecretEngine) string {
+	switch *eventSigningSecretSource {
+	case "keyvault":
+		signingSecret, err := secretEngine.GetSecret(context.Background(), consts.EventSigningSecretKVBaseURI, consts.EventSigningSecretKVSecretName, "")
+		if err != nil {
+			log.Fatal("Failed to get event signing secret: ", err)
+		}
+		return signingSecret
+	case "file":
+		signingSecret, err := ioutil.ReadFile(*eventSigningSecretFile)
+		if err != nil {
+			log.Fatal("Failed to read event signing secret: ", err)
+		}
+		return strings.TrimSpace(string(signingSecret))
+	default:
+		log.Fatal("Invalid event signing secret source: ", *eventSigningSecretSource)
+	}
+
+	return ""
+}
+
+// getEncryptionEngine creates the engine encrypting the settings of provider registrations at rest
+func getEncryptionEngine(secretEngine *engines.SecretEngine) *engines.EncryptionEngine {
+	var keys string
+	switch *settingsEncryptionKeySource {
+	case "keyvault":
+		secret, err := secretEngine.GetSecret(context.Background(), consts.SettingsEncryptionKeysKVBaseURI, consts.SettingsEncryptionKeysKVSecretName, "")
+		if err != nil {
+			log.Fatal("Failed to get settings encryption keys: ", err)
+		}
+		keys = secret
+	case "file":
+		secret, err := ioutil.ReadFile(*settingsEncryptionKeyFile)
+		if err != nil {
+			log.Fatal("Failed to read settings encryption keys: ", err)
+		}
+		keys = string(secret)
+	default:
+		log.Fatal("Invalid settings encryption key source: ", *settingsEncryptionKeySource)
+	}
+
+	encryptionEngine, err := engines.NewEncryptionEngine(keys)
+	if err != nil {
+		log.Fatal("Invalid settings encryption keys: ", err)
+	}
+
+	return encryptionEngine
+}
+
+func addHealthRoutes(healthManager *controllers.HealthManager) {
+	webService := new(restful.WebService)
+	webService.Produces(restful.MIME_JSON)
+
+	webService.Route(webService.
+		GET(consts.HealthRoute).
+		To(healthManager.GetHealthController).
+		Doc("Liveness probe").
+		Operation(consts.GetHealthControllerName))
+
+	webService.Route(webService.
+		GET(consts.ReadinessRoute).
+		To(healthManager.GetReadinessController).
+		Doc("Readiness probe").
+		Operation(consts.GetReadinessControllerName))
+
+	restful.Add(webService)
+}
+
+func addOperationsRoutes() {
+	webService := new(restful.WebService)
+	webService.
+		Path(consts.ProvidersURLPrefix).
+		Produces(restful.MIME_JSON)
+
+	webService.Route(webService.
+		GET(consts.OperationsRoute).
+		To(controllers.GetOperationsController).
+		Doc("List the operations of the resource provider").
+		Operation(consts.GetOperationsControllerName).
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
+
+	restful.Add(webService)
+}
+
+func addAdminRoutes(adminManager *controllers.AdminManager) {
+	webService := new(restful.WebService)
+	webService.
+		Path(consts.AdminURLPrefix).
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON).
+		Filter(adminManager.Filter)
+
+	webService.Route(webService.
+		GET(consts.AdminResourcesRoute).
+		To(adminManager.GetAdminResourcesController).
+		Doc("List the resources by provisioning state or provider registration").
+		Operation(consts.GetAdminResourcesControllerName).
+		Param(webService.QueryParameter(consts.ProvisioningStateParameterName, "Provisioning state of the resources").DataType("string")).
+		Param(webService.QueryParameter(consts.ProviderIDParameterName, "Provider registration of the resources").DataType("string")))
+
+	webService.Route(webService.
+		GET(consts.AdminResourceRoute).
+		To(adminManager.GetAdminResourceController).
+		Doc("Dump the raw config and state of a resource").
+		Operation(consts.GetAdminResourceControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceNameParameter, "Name of resource").DataType("string")))
+
+	webService.Route(webService.
+		POST(consts.AdminForceProvisioningStateRoute).
+		To(adminManager.PostAdminForceProvisioningStateController).
+		Doc("Force the provisioning state of a resource").
+		Operation(consts.PostAdminForceProvisioningStateControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceNameParameter, "Name of resource").DataType("string")))
+
+	webService.Route(webService.
+		POST(consts.AdminClearAcceptedRoute).
+		To(adminManager.PostAdminClearAcceptedController).
+		Doc("Fail the stuck Accepted operation of a resource and break its lock").
+		Operation(consts.PostAdminClearAcceptedControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceNameParameter, "Name of resource").DataType("string")))
+
+	webService.Route(webService.
+		POST(consts.AdminRefreshRoute).
+		To(adminManager.PostAdminRefreshController).
+		Doc("Refresh the state of a resource").
+		Operation(consts.PostAdminRefreshControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceNameParameter, "Name of resource").DataType("string")))
+
+	webService.Route(webService.
+		POST(consts.AdminUpgradeStatesRoute).
+		To(adminManager.PostAdminUpgradeStatesController).
+		Doc("Migrate the stored states of all resources to the schema versions of the current providers").
+		Operation(consts.PostAdminUpgradeStatesControllerName).
+		Param(webService.QueryParameter(consts.DryRunParameterName, "Report the states which would be upgraded without storing them").DataType("boolean")))
+
+	webService.Route(webService.
+		POST(consts.AdminReEncryptSettingsRoute).
+		To(adminManager.PostAdminReEncryptSettingsController).
+		Doc("Re-encrypt the settings of the provider registrations stored in plaintext or with a previous key").
+		Operation(consts.PostAdminReEncryptSettingsControllerName))
+
+	restful.Add(webService)
 }
 
 func addProvidersOperationRoutes(webService *restful.WebService, providerRegistrationManager *controllers.ProviderRegistrationManager) {
@@ -111,7 +576,8 @@ func addProvidersOperationRoutes(webService *restful.WebService, providerRegistr
 		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
 		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
 		Param(webService.PathParameter(consts.PathProviderRegistrationParameter, "Name of provider registration").DataType("string")).
-		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")).
+		Param(webService.QueryParameter(consts.TestConnectionParameterName, "Test the connection with the settings").DataType("boolean")))
 
 	webService.Route(webService.
 		DELETE(consts.ProviderRegistrationOperationRoute).
@@ -121,7 +587,8 @@ func addProvidersOperationRoutes(webService *restful.WebService, providerRegistr
 		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
 		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
 		Param(webService.PathParameter(consts.PathProviderRegistrationParameter, "Name of provider registration").DataType("string")).
-		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")).
+		Param(webService.QueryParameter(consts.CascadeParameterName, "Delete the resources referencing the provider registration").DataType("boolean")))
 
 	webService.Route(webService.
 		POST(consts.ProviderRegistrationListSettingsRoute).
@@ -132,6 +599,16 @@ func addProvidersOperationRoutes(webService *restful.WebService, providerRegistr
 		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
 		Param(webService.PathParameter(consts.PathProviderRegistrationParameter, "Name of provider registration").DataType("string")).
 		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
+
+	webService.Route(webService.
+		POST(consts.ProviderRegistrationTestConnectionRoute).
+		To(providerRegistrationManager.PostProviderRegistrationTestConnection).
+		Doc("Test the connection of a provider registration").
+		Operation(consts.PostProviderRegistrationTestConnectionControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
+		Param(webService.PathParameter(consts.PathProviderRegistrationParameter, "Name of provider registration").DataType("string")).
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
 }
 
 func addResourcesOperationRoutes(webService *restful.WebService, resourceManager *controllers.ResourceManager) {
@@ -143,7 +620,8 @@ func addResourcesOperationRoutes(webService *restful.WebService, resourceManager
 		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
 		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
 		Param(webService.PathParameter(consts.PathResourceNameParameter, "Name of resource").DataType("string")).
-		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")).
+		Param(webService.QueryParameter(consts.RefreshParameterName, "Refresh the state of the resource instead of serving the stored state").DataType("boolean")))
 
 	webService.Route(webService.
 		PUT(consts.ResourceOperationRoute).
@@ -155,6 +633,16 @@ func addResourcesOperationRoutes(webService *restful.WebService, resourceManager
 		Param(webService.PathParameter(consts.PathResourceNameParameter, "Name of resource").DataType("string")).
 		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
 
+	webService.Route(webService.
+		PATCH(consts.ResourceOperationRoute).
+		To(resourceManager.PatchResourceController).
+		Doc("Update the tags and settings of a resource").
+		Operation(consts.PatchResourceControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceNameParameter, "Name of resource").DataType("string")).
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
+
 	webService.Route(webService.
 		DELETE(consts.ResourceOperationRoute).
 		To(resourceManager.DeleteResourceController).
@@ -165,6 +653,91 @@ func addResourcesOperationRoutes(webService *restful.WebService, resourceManager
 		Param(webService.PathParameter(consts.PathResourceNameParameter, "Name of resource").DataType("string")).
 		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
 
+	webService.Route(webService.
+		POST(consts.ExportTerraformRoute).
+		To(resourceManager.PostExportTerraformController).
+		Doc("Export the resources of a resource group as terraform configuration and state").
+		Operation(consts.PostExportTerraformControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
+
+	webService.Route(webService.
+		POST(consts.ImportTerraformRoute).
+		To(resourceManager.PostImportTerraformController).
+		Doc("Import the resources of a terraform state into a resource group").
+		Operation(consts.PostImportTerraformControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
+
+	webService.Route(webService.
+		POST(consts.PreflightRoute).
+		To(resourceManager.PostPreflightController).
+		Doc("Validate the resources of a template deployment").
+		Operation(consts.PostPreflightControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
+		Param(webService.PathParameter(consts.PathDeploymentNameParameter, "Name of template deployment").DataType("string")).
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
+
+	webService.Route(webService.
+		POST(consts.ValidateMoveResourcesRoute).
+		To(resourceManager.PostValidateMoveResourcesController).
+		Doc("Validate the move of resources to another resource group").
+		Operation(consts.PostValidateMoveResourcesControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
+
+	webService.Route(webService.
+		POST(consts.MoveResourcesRoute).
+		To(resourceManager.PostMoveResourcesController).
+		Doc("Move resources to another resource group").
+		Operation(consts.PostMoveResourcesControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
+
+	webService.Route(webService.
+		GET(consts.DeletedResourcesRoute).
+		To(resourceManager.GetDeletedResourcesController).
+		Doc("List the deleted resources of a resource group").
+		Operation(consts.GetDeletedResourcesControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
+
+	webService.Route(webService.
+		GET(consts.DeletedResourceOperationRoute).
+		To(resourceManager.GetDeletedResourceController).
+		Doc("Get a deleted resource").
+		Operation(consts.GetDeletedResourceControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceNameParameter, "Name of resource").DataType("string")).
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
+
+	webService.Route(webService.
+		DELETE(consts.DeletedResourceOperationRoute).
+		To(resourceManager.PurgeDeletedResourceController).
+		Doc("Purge a deleted resource").
+		Operation(consts.PurgeDeletedResourceControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceNameParameter, "Name of resource").DataType("string")).
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
+
+	webService.Route(webService.
+		POST(consts.DeletedResourceRestoreRoute).
+		To(resourceManager.PostRestoreDeletedResourceController).
+		Doc("Restore a deleted resource").
+		Operation(consts.PostRestoreDeletedResourceControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceNameParameter, "Name of resource").DataType("string")).
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
+
 	webService.Route(webService.
 		GET(consts.OperationStatusRoute).
 		To(resourceManager.GetOperationStatusController).
@@ -174,6 +747,16 @@ func addResourcesOperationRoutes(webService *restful.WebService, resourceManager
 		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
 		Param(webService.PathParameter(consts.PathOperationStatusParameter, "Name of resource").DataType("string")).
 		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
+
+	webService.Route(webService.
+		POST(consts.OperationCancelRoute).
+		To(resourceManager.CancelOperationController).
+		Doc("Cancel an in-flight resource operation").
+		Operation(consts.CancelOperationControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "Identifier of customer subscription").DataType("string")).
+		Param(webService.PathParameter(consts.PathResourceGroupNameParameter, "Name of resource group").DataType("string")).
+		Param(webService.PathParameter(consts.PathOperationStatusParameter, "Name of resource").DataType("string")).
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
 }
 
 func addSubscriptionOperationRoutes(webService *restful.WebService) {
@@ -191,4 +774,12 @@ func addSubscriptionOperationRoutes(webService *restful.WebService) {
 		Doc("Put or update a subscription").
 		Operation(consts.PutSubscriptionControllerName).
 		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "identifier of the subscription").DataType("string")))
+
+	webService.Route(webService.
+		GET(consts.LocationsRoute).
+		To(controllers.GetLocationsController).
+		Doc("List the locations resources can be created in").
+		Operation(consts.GetLocationsControllerName).
+		Param(webService.PathParameter(consts.PathSubscriptionIDParameter, "identifier of the subscription").DataType("string")).
+		Param(webService.QueryParameter(consts.RequestAPIVersionParameterName, "API Version").DataType("string")))
 }